@@ -0,0 +1,641 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// AccessRule 为 --allow/--deny 中的一条规则，按点号分隔的部分数区分对象：
+// `table` 匹配任意数据库中的表，`schema.table` 匹配指定库的表（`billing.*` 即整个库），
+// `schema.table.column` 匹配列。各部分都支持 `*` 和 `?` 通配符，不区分大小写
+type AccessRule struct {
+	Pattern string
+
+	schema *regexp.Regexp
+	table  *regexp.Regexp
+	column *regexp.Regexp
+	// wholeSchema 表示规则覆盖整个数据库（表部分为 `*` 且不限定列）
+	wholeSchema bool
+}
+
+// AccessList 实现 flag.Value，可重复指定，每次也可用逗号分隔多条规则
+type AccessList []AccessRule
+
+func (l *AccessList) String() string {
+	if l == nil {
+		return ""
+	}
+
+	patterns := make([]string, len(*l))
+	for i, rule := range *l {
+		patterns[i] = rule.Pattern
+	}
+
+	return strings.Join(patterns, ",")
+}
+
+func (l *AccessList) Set(value string) error {
+	for _, pattern := range strings.Split(value, ",") {
+		rule, err := ParseAccessRule(strings.TrimSpace(pattern))
+		if err != nil {
+			return err
+		}
+		*l = append(*l, rule)
+	}
+
+	return nil
+}
+
+var (
+	AllowObjects AccessList
+	DenyObjects  AccessList
+)
+
+// ParseAccessRule 解析 `table`、`schema.table` 或 `schema.table.column` 形式的规则
+func ParseAccessRule(pattern string) (AccessRule, error) {
+	parts := strings.Split(pattern, ".")
+	for _, part := range parts {
+		if part == "" {
+			return AccessRule{}, fmt.Errorf("无效的访问规则: %q", pattern)
+		}
+	}
+
+	rule := AccessRule{Pattern: pattern}
+	switch len(parts) {
+	case 1:
+		rule.table = globPattern(parts[0])
+	case 2:
+		rule.schema, rule.table = globPattern(parts[0]), globPattern(parts[1])
+		rule.wholeSchema = parts[1] == "*"
+	case 3:
+		rule.schema, rule.table, rule.column = globPattern(parts[0]), globPattern(parts[1]), globPattern(parts[2])
+	default:
+		return AccessRule{}, fmt.Errorf("无效的访问规则: %q（格式为 table、schema.table 或 schema.table.column）", pattern)
+	}
+
+	return rule, nil
+}
+
+// literalPattern 返回按字面匹配（不区分大小写）的规则部分，名称中的 `*`、`?` 不作为通配符
+func literalPattern(name string) *regexp.Regexp {
+	return regexp.MustCompile("(?is)^" + regexp.QuoteMeta(name) + "$")
+}
+
+func accessEnabled() bool {
+	return len(AllowObjects) > 0 || len(DenyObjects) > 0
+}
+
+func (r AccessRule) matchesTable(schema, table string) bool {
+	return (r.schema == nil || r.schema.MatchString(schema)) && r.table.MatchString(table)
+}
+
+// needsSchema 判断是否有规则限定了库名，此时未写库名的表需要先确定当前数据库
+func needsSchema() bool {
+	for _, list := range []AccessList{AllowObjects, DenyObjects} {
+		for _, rule := range list {
+			if rule.schema != nil {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// SchemaAccessible 判断数据库是否可见：没有被整体拒绝，且配置了允许列表时至少有一条规则可能落在该库中
+func SchemaAccessible(schema string) bool {
+	for _, rule := range DenyObjects {
+		if rule.wholeSchema && rule.schema.MatchString(schema) {
+			return false
+		}
+	}
+	if len(AllowObjects) == 0 {
+		return true
+	}
+	for _, rule := range AllowObjects {
+		if rule.schema == nil || rule.schema.MatchString(schema) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TableAccessible 判断表是否允许访问，拒绝列表优先于允许列表
+func TableAccessible(schema, table string) bool {
+	if !SchemaAccessible(schema) {
+		return false
+	}
+	for _, rule := range DenyObjects {
+		if rule.column == nil && rule.matchesTable(schema, table) {
+			return false
+		}
+	}
+	if len(AllowObjects) == 0 {
+		return true
+	}
+	for _, rule := range AllowObjects {
+		if rule.matchesTable(schema, table) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowsColumnsOnly 判断表是否只通过 schema.table.column 规则允许了部分列
+func allowsColumnsOnly(schema, table string) bool {
+	only := false
+	for _, rule := range AllowObjects {
+		if !rule.matchesTable(schema, table) {
+			continue
+		}
+		if rule.column == nil {
+			return false
+		}
+		only = true
+	}
+
+	return only
+}
+
+// columnRestricted 判断表中是否有不允许访问的列
+func columnRestricted(schema, table string) bool {
+	for _, rule := range DenyObjects {
+		if rule.column != nil && rule.matchesTable(schema, table) {
+			return true
+		}
+	}
+
+	return allowsColumnsOnly(schema, table)
+}
+
+func columnAccessible(schema, table, column string) bool {
+	for _, rule := range DenyObjects {
+		if rule.column != nil && rule.matchesTable(schema, table) && rule.column.MatchString(column) {
+			return false
+		}
+	}
+	if !allowsColumnsOnly(schema, table) {
+		return true
+	}
+	for _, rule := range AllowObjects {
+		if rule.column != nil && rule.matchesTable(schema, table) && rule.column.MatchString(column) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckSchemaAccess 检查数据库是否允许访问
+func CheckSchemaAccess(schema string) error {
+	if accessEnabled() && !SchemaAccessible(schema) {
+		return fmt.Errorf("禁止访问数据库 %s", QuoteIdentifier(schema))
+	}
+
+	return nil
+}
+
+// CheckTableAccess 检查表是否允许访问，并检查给定的列；列为 `*` 表示需要读写整行
+func CheckTableAccess(ref TableRef, columns ...string) error {
+	if !accessEnabled() {
+		return nil
+	}
+
+	if ref.Schema == "" && needsSchema() {
+		schema, err := currentDatabase()
+		if err != nil {
+			return err
+		}
+		ref.Schema = schema
+	}
+
+	if !TableAccessible(ref.Schema, ref.Name) {
+		return fmt.Errorf("禁止访问表 %s", ref.QuotedName())
+	}
+	for _, column := range columns {
+		if column == "*" && columnRestricted(ref.Schema, ref.Name) {
+			return fmt.Errorf("表 %s 包含禁止访问的列，不能读写整行", ref.QuotedName())
+		}
+		if column != "*" && !columnAccessible(ref.Schema, ref.Name, column) {
+			return fmt.Errorf("禁止访问列 %s.%s", ref.QuotedName(), QuoteIdentifier(column))
+		}
+	}
+
+	return nil
+}
+
+// currentDatabase 返回未写库名的表所属的数据库：use_database 选择的数据库，尚未切换时为 DSN 中的数据库。
+// 不查询 DATABASE()，避免结果取决于从连接池中取到的连接
+func currentDatabase() (string, error) {
+	if name, _ := SelectedDatabase(); name != "" {
+		return name, nil
+	}
+
+	cfg, err := mysql.ParseDSN(DSN)
+	if err != nil {
+		return "", fmt.Errorf("解析 DSN 失败: %v", err)
+	}
+
+	return cfg.DBName, nil
+}
+
+// CheckQueryAccess 解析 SQL 中引用的数据库、表和列，任何一个不允许访问时返回错误
+func CheckQueryAccess(query string) error {
+	return checkQueryAccess(query, currentDatabase)
+}
+
+// checkQueryAccess 与 CheckQueryAccess 相同，database 返回未写库名的表所属的数据库，
+// 用于在专用连接上（例如恢复转储时）执行的语句
+func checkQueryAccess(query string, database func() (string, error)) error {
+	if !accessEnabled() {
+		return nil
+	}
+
+	// PREPARE 中的 SQL 是字符串字面量，需要单独解析
+	for _, stmt := range SplitStatements(query) {
+		text, known, ok := PreparedSQL(TokenizeSQL(stmt))
+		if !ok {
+			continue
+		}
+		if !known {
+			return fmt.Errorf("配置了访问控制时，PREPARE 只能使用字符串字面量作为语句")
+		}
+		if err := checkQueryAccess(text, database); err != nil {
+			return err
+		}
+	}
+
+	tokens := TokenizeSQL(query)
+	refs, schemas := accessReferences(tokens)
+	for _, schema := range schemas {
+		if err := CheckSchemaAccess(schema); err != nil {
+			return err
+		}
+	}
+
+	current := ""
+	resolved := false
+	for i := range refs {
+		if refs[i].Schema == "" && needsSchema() {
+			if !resolved {
+				name, err := database()
+				if err != nil {
+					return err
+				}
+				current, resolved = name, true
+			}
+			refs[i].Schema = current
+		}
+		if !TableAccessible(refs[i].Schema, refs[i].Name) {
+			return fmt.Errorf("禁止访问表 %s", TableRef{Schema: refs[i].Schema, Name: refs[i].Name}.QuotedName())
+		}
+	}
+
+	restricted := []TableRef{}
+	for _, ref := range refs {
+		if columnRestricted(ref.Schema, ref.Name) {
+			restricted = append(restricted, ref)
+		}
+	}
+	if len(restricted) == 0 {
+		return nil
+	}
+
+	// 只允许部分列的表需要知道实际有哪些列，才能区分列名和别名等其他标识符
+	tableColumns := map[string]map[string]bool{}
+	isColumn := func(t TableRef, column string) (bool, error) {
+		if !allowsColumnsOnly(t.Schema, t.Name) {
+			return true, nil
+		}
+		key := strings.ToLower(t.Schema + "." + t.Name)
+		if tableColumns[key] == nil {
+			columns, err := GetImportColumns(t.Schema, t.Name)
+			if err != nil {
+				return false, err
+			}
+			tableColumns[key] = map[string]bool{}
+			for _, col := range columns {
+				tableColumns[key][strings.ToLower(col.Name)] = true
+			}
+		}
+		return tableColumns[key][strings.ToLower(column)], nil
+	}
+
+	for _, use := range accessColumnUses(tokens) {
+		for _, t := range restricted {
+			if use.Qualifier != "" && !t.Matches(use.Qualifier) && !strings.EqualFold(t.Name, use.Qualifier) {
+				continue
+			}
+			name := TableRef{Schema: t.Schema, Name: t.Name}.QuotedName()
+			if use.Column == "*" {
+				return fmt.Errorf("表 %s 包含禁止访问的列，不能使用 *，请明确列出需要的列", name)
+			}
+			ok, err := isColumn(t, use.Column)
+			if err != nil {
+				return err
+			}
+			if ok && !columnAccessible(t.Schema, t.Name, use.Column) {
+				return fmt.Errorf("禁止访问列 %s.%s", name, QuoteIdentifier(use.Column))
+			}
+		}
+	}
+
+	return nil
+}
+
+// accessReferences 找出语句引用的表和数据库。除 SELECT/UPDATE/DELETE 的 FROM 和 JOIN 外，
+// 还包括 INSERT/REPLACE ... INTO、各类 ... TABLE 语句、外键 REFERENCES、SHOW 以及 USE/CREATE DATABASE 等
+func accessReferences(tokens []SQLToken) ([]TableRef, []string) {
+	refs := []TableRef{}
+	schemas := []string{}
+	if len(tokens) == 0 {
+		return refs, schemas
+	}
+
+	addTable := func(i int) int {
+		parts, next := readQualifiedName(tokens, i)
+		if parts == nil || len(parts) > 2 {
+			return next
+		}
+		ref := TableRef{Name: parts[len(parts)-1]}
+		if len(parts) == 2 {
+			ref.Schema = parts[0]
+		}
+		refs = append(refs, ref)
+		return next
+	}
+	skipIfExists := func(i int) int {
+		for i < len(tokens) && tokens[i].IsKeyword("IF", "NOT", "EXISTS") {
+			i++
+		}
+		return i
+	}
+
+	first := tokens[0]
+	switch {
+	case first.IsKeyword("SHOW"):
+		return showReferences(tokens)
+	case first.IsKeyword("USE") && len(tokens) > 1:
+		return refs, append(schemas, tokens[1].Value)
+	case first.IsKeyword("DESC", "DESCRIBE", "EXPLAIN") && len(tokens) > 1 && tokens[1].IsIdent():
+		addTable(1)
+		return refs, schemas
+	case first.IsKeyword("RENAME"):
+		// RENAME TABLE a TO b, c TO d 中的名称都是表
+		for i := 1; i < len(tokens); i++ {
+			if tokens[i].IsIdent() {
+				i = addTable(i) - 1
+			}
+		}
+		return refs, schemas
+	}
+
+	for _, scope := range analyzeTokens(tokens) {
+		refs = append(refs, scope.Tables...)
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch {
+		case tok.IsKeyword("INTO") && i+1 < len(tokens) && !tokens[i+1].IsKeyword("OUTFILE", "DUMPFILE"):
+			addTable(i + 1)
+		case tok.IsKeyword("TABLE", "TRUNCATE"):
+			j := skipIfExists(i + 1)
+			for j < len(tokens) && tokens[j].IsIdent() {
+				j = addTable(j)
+				if j < len(tokens) && tokens[j].IsKeyword("LIKE") {
+					j++
+				} else if j < len(tokens) && tokens[j].Is(TokenPunct, ",") {
+					j++
+				} else {
+					break
+				}
+			}
+		case tok.IsKeyword("REFERENCES"):
+			addTable(i + 1)
+		case tok.IsKeyword("DATABASE", "SCHEMA"):
+			j := skipIfExists(i + 1)
+			if j < len(tokens) && tokens[j].IsIdent() {
+				schemas = append(schemas, tokens[j].Value)
+			}
+		}
+	}
+
+	// 排除 WITH 定义的公用表表达式
+	ctes := cteNames(tokens)
+	result := []TableRef{}
+	for _, ref := range refs {
+		if ref.Schema == "" && ctes[strings.ToLower(ref.Name)] {
+			continue
+		}
+		result = append(result, ref)
+	}
+
+	return result, schemas
+}
+
+// showReferences 处理 SHOW 语句：SHOW TABLES/TRIGGERS/EVENTS/TABLE STATUS 的 FROM/IN 后面是数据库，
+// SHOW COLUMNS/INDEX 等的第一个 FROM/IN 后面是表，第二个是该表所在的数据库
+func showReferences(tokens []SQLToken) ([]TableRef, []string) {
+	refs := []TableRef{}
+	schemas := []string{}
+
+	listsSchema := false
+	targets := []string{}
+	var qualified []string
+	for i := 1; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch {
+		case tok.IsKeyword("TABLES", "TRIGGERS", "EVENTS", "STATUS") && len(targets) == 0:
+			listsSchema = true
+		case tok.IsKeyword("CREATE") && i+1 < len(tokens) && tokens[i+1].IsKeyword("DATABASE", "SCHEMA"):
+			if i+2 < len(tokens) {
+				schemas = append(schemas, tokens[i+2].Value)
+			}
+			return refs, schemas
+		case tok.IsKeyword("TABLE") && i > 0 && tokens[i-1].IsKeyword("CREATE"):
+			parts, _ := readQualifiedName(tokens, i+1)
+			if len(parts) == 1 || len(parts) == 2 {
+				ref := TableRef{Name: parts[len(parts)-1]}
+				if len(parts) == 2 {
+					ref.Schema = parts[0]
+				}
+				refs = append(refs, ref)
+			}
+			return refs, schemas
+		case tok.IsKeyword("FROM", "IN") && i+1 < len(tokens):
+			parts, next := readQualifiedName(tokens, i+1)
+			if parts == nil {
+				continue
+			}
+			if len(targets) == 0 {
+				qualified = parts
+			}
+			targets = append(targets, strings.Join(parts, "."))
+			i = next - 1
+		case tok.IsKeyword("LIKE", "WHERE"):
+			i = len(tokens)
+		}
+	}
+
+	if len(targets) == 0 {
+		return refs, schemas
+	}
+	if listsSchema {
+		return refs, append(schemas, targets[0])
+	}
+
+	ref := TableRef{Name: qualified[len(qualified)-1]}
+	if len(qualified) == 2 {
+		ref.Schema = qualified[0]
+	}
+	if len(targets) > 1 {
+		ref.Schema = targets[1]
+	}
+
+	return append(refs, ref), schemas
+}
+
+// cteNames 返回语句中 WITH 定义的公用表表达式名称（小写）
+func cteNames(tokens []SQLToken) map[string]bool {
+	names := map[string]bool{}
+	for i := 0; i < len(tokens); i++ {
+		if !tokens[i].IsKeyword("WITH") {
+			continue
+		}
+		j := i + 1
+		if j < len(tokens) && tokens[j].IsKeyword("RECURSIVE") {
+			j++
+		}
+		for j < len(tokens) && tokens[j].IsIdent() {
+			names[strings.ToLower(tokens[j].Value)] = true
+			j++
+			if j < len(tokens) && tokens[j].Is(TokenPunct, "(") {
+				j = matchParen(tokens, j) + 1
+			}
+			if j < len(tokens) && tokens[j].IsKeyword("AS") {
+				j++
+			}
+			if j < len(tokens) && tokens[j].Is(TokenPunct, "(") {
+				j = matchParen(tokens, j) + 1
+			}
+			if j >= len(tokens) || !tokens[j].Is(TokenPunct, ",") {
+				break
+			}
+			j++
+		}
+		i = j
+	}
+
+	return names
+}
+
+// accessColumnUses 找出语句中可能引用列的标识符。`*` 和 `t.*` 记为列 `*`，
+// 没有列清单的 INSERT/REPLACE 会写入整行，也记为目标表的 `*`
+func accessColumnUses(tokens []SQLToken) []ColumnRef {
+	uses := []ColumnRef{}
+	into := false
+	for i := 0; i < len(tokens); {
+		tok := tokens[i]
+		if tok.Is(TokenOperator, "*") && i > 0 && (tokens[i-1].IsKeyword("SELECT", "DISTINCT", "ALL", "DISTINCTROW") || tokens[i-1].Is(TokenPunct, ",")) {
+			uses = append(uses, ColumnRef{Column: "*"})
+			i++
+			continue
+		}
+		// MySQL 8 的 `TABLE t` 等价于 SELECT * FROM t
+		if tableStatementAt(tokens, i, into) {
+			parts, next := readQualifiedName(tokens, i+1)
+			if parts != nil {
+				uses = append(uses, ColumnRef{Qualifier: parts[len(parts)-1], Column: "*"})
+			}
+			i = max(next, i+1)
+			continue
+		}
+		if tok.IsKeyword("INTO") && i+1 < len(tokens) {
+			into = true
+			parts, next := readQualifiedName(tokens, i+1)
+			if parts != nil && (next >= len(tokens) || !(tokens[next].Is(TokenPunct, "(") || tokens[next].IsKeyword("SET"))) {
+				uses = append(uses, ColumnRef{Qualifier: parts[len(parts)-1], Column: "*"})
+			}
+			i = max(next, i+1)
+			continue
+		}
+
+		parts, next := readQualifiedName(tokens, i)
+		if parts == nil {
+			i++
+			continue
+		}
+		if next >= len(tokens) || !tokens[next].Is(TokenPunct, "(") {
+			uses = append(uses, columnRefFromParts(parts))
+		}
+		i = next
+	}
+
+	return uses
+}
+
+// tableStatementAt 判断 tokens[i] 是否为 `TABLE t` 语句的开头：位于语句或子查询开头、集合运算之后，
+// 或者（into 为 true 时）位于 INSERT INTO 的目标表之后
+func tableStatementAt(tokens []SQLToken, i int, into bool) bool {
+	if !tokens[i].IsKeyword("TABLE") {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+
+	prev := tokens[i-1]
+	if prev.Is(TokenPunct, "(") || prev.IsKeyword("UNION", "INTERSECT", "EXCEPT", "ALL", "DISTINCT", "AS") {
+		return true
+	}
+
+	return into && (prev.IsIdent() || prev.Is(TokenPunct, ")"))
+}
+
+// HandleListDatabase 列出数据库，隐藏不允许访问的库
+func HandleListDatabase(route string) (string, error) {
+	result, headers, err := DoQuery("SHOW DATABASES", StatementTypeNoExplainCheck, route, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if accessEnabled() && len(headers) > 0 {
+		visible := []map[string]interface{}{}
+		for _, row := range result {
+			if SchemaAccessible(fmt.Sprintf("%v", row[headers[0]])) {
+				visible = append(visible, row)
+			}
+		}
+		result = visible
+	}
+
+	return MapToCSV(result, headers)
+}
+
+// HandleListTable 列出当前数据库中的表，隐藏不允许访问的表
+func HandleListTable(route string) (string, error) {
+	result, headers, err := DoQuery("SHOW TABLES", StatementTypeNoExplainCheck, route, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if accessEnabled() && len(headers) > 0 {
+		// 结果列名为 Tables_in_<数据库>
+		schema := strings.TrimPrefix(headers[0], "Tables_in_")
+		visible := []map[string]interface{}{}
+		for _, row := range result {
+			if TableAccessible(schema, fmt.Sprintf("%v", row[headers[0]])) {
+				visible = append(visible, row)
+			}
+		}
+		result = visible
+	}
+
+	return MapToCSV(result, headers)
+}
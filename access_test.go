@@ -0,0 +1,210 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupAccess(t *testing.T, allow, deny []string) {
+	originalAllow, originalDeny := AllowObjects, DenyObjects
+	AllowObjects, DenyObjects = nil, nil
+	t.Cleanup(func() { AllowObjects, DenyObjects = originalAllow, originalDeny })
+
+	for _, pattern := range allow {
+		if err := AllowObjects.Set(pattern); err != nil {
+			t.Fatalf("解析访问规则失败: %v", err)
+		}
+	}
+	for _, pattern := range deny {
+		if err := DenyObjects.Set(pattern); err != nil {
+			t.Fatalf("解析访问规则失败: %v", err)
+		}
+	}
+}
+
+func TestParseAccessRule(t *testing.T) {
+	for _, pattern := range []string{"", "a..b", "a.b.c.d", ".users"} {
+		_, err := ParseAccessRule(pattern)
+		assert.Error(t, err, pattern)
+	}
+
+	var list AccessList
+	assert.NoError(t, list.Set("users_credentials, billing.*"))
+	assert.Equal(t, "users_credentials,billing.*", list.String())
+}
+
+func TestCheckQueryAccessDeny(t *testing.T) {
+	setupAccess(t, nil, []string{"users_credentials", "billing.*", "shop.users.ssn"})
+
+	tests := []struct {
+		name    string
+		query   string
+		current string
+		err     string
+	}{
+		{"plain table", "SELECT id FROM orders", "shop", ""},
+		{"denied table", "SELECT * FROM users_credentials", "shop", "禁止访问表 `shop`.`users_credentials`"},
+		{"denied table in join", "SELECT o.id FROM orders o JOIN `billing`.`invoices` i ON i.order_id = o.id", "shop", "禁止访问表 `billing`.`invoices`"},
+		{"denied schema as current database", "SELECT id FROM invoices", "billing", "禁止访问表 `billing`.`invoices`"},
+		{"subquery", "SELECT id FROM orders WHERE user_id IN (SELECT user_id FROM users_credentials)", "shop", "users_credentials"},
+		{"insert", "INSERT INTO users_credentials (user_id) VALUES (1)", "shop", "users_credentials"},
+		{"drop list", "DROP TABLE IF EXISTS orders, users_credentials", "shop", "users_credentials"},
+		{"foreign key", "ALTER TABLE orders ADD FOREIGN KEY (uid) REFERENCES users_credentials (id)", "shop", "users_credentials"},
+		{"rename", "RENAME TABLE orders TO billing.orders", "shop", "`billing`.`orders`"},
+		{"show tables", "SHOW TABLES FROM billing", "shop", "禁止访问数据库 `billing`"},
+		{"show columns", "SHOW COLUMNS FROM users_credentials", "shop", "users_credentials"},
+		{"show create table", "SHOW CREATE TABLE billing.invoices", "shop", "`billing`.`invoices`"},
+		{"use", "USE billing", "shop", "禁止访问数据库 `billing`"},
+		{"cte shadows table name", "WITH users_credentials AS (SELECT 1 AS id) SELECT id FROM users_credentials", "shop", ""},
+		{"allowed columns", "SELECT id, name FROM users WHERE name LIKE 'a%'", "shop", ""},
+		{"denied column", "SELECT id, ssn FROM users", "shop", "禁止访问列 `shop`.`users`.`ssn`"},
+		{"denied column by alias", "SELECT u.id FROM users u WHERE u.ssn = '1'", "shop", "`ssn`"},
+		{"denied column in function", "SELECT MD5(ssn) FROM users", "shop", "`ssn`"},
+		{"star", "SELECT * FROM users", "shop", "不能使用 *"},
+		{"qualified star", "SELECT o.*, u.* FROM orders o JOIN users u ON u.id = o.user_id", "shop", "不能使用 *"},
+		{"star of other table", "SELECT o.*, u.name FROM orders o JOIN users u ON u.id = o.user_id", "shop", ""},
+		{"count star", "SELECT COUNT(*) FROM users", "shop", ""},
+		{"insert without columns", "INSERT INTO users VALUES (1, 'a', '123')", "shop", "不能使用 *"},
+		{"same column in other schema", "SELECT ssn FROM crm.users", "shop", ""},
+		{"table statement", "TABLE users", "shop", "不能使用 *"},
+		{"table statement in union", "SELECT id, name, email FROM crm.users UNION TABLE shop.users", "shop", "不能使用 *"},
+		{"insert from table statement", "INSERT INTO crm.users TABLE shop.users", "shop", "不能使用 *"},
+		{"create table is not a table statement", "CREATE TABLE users_copy (id INT)", "shop", ""},
+		{"prepared denied table", "PREPARE s FROM 'SELECT * FROM users_credentials'", "shop", "users_credentials"},
+		{"prepared denied column", "PREPARE s FROM 'SELECT ssn FROM ' 'users'", "shop", "`ssn`"},
+		{"prepared allowed", "PREPARE s FROM 'SELECT id FROM orders WHERE id = ?'", "shop", ""},
+		{"prepared from variable", "PREPARE s FROM @sql", "shop", "字符串字面量"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkQueryAccess(tt.query, func() (string, error) { return tt.current, nil })
+
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+			}
+		})
+	}
+}
+
+func TestBeforeImageTableDenied(t *testing.T) {
+	setupAccess(t, nil, nil)
+	setupBeforeImage(t, BeforeImageTable, 100)
+	DenyObjects = append(DenyObjects, beforeImageAccessRule())
+
+	current := func() (string, error) { return "mcp", nil }
+	for _, query := range []string{
+		"SELECT row_data FROM mcp._mcp_before_images",
+		"UPDATE _mcp_before_images SET row_data = '{}' WHERE operation_id = 'x'",
+		"INSERT INTO `mcp`.`_MCP_BEFORE_IMAGES` (operation_id, row_data) VALUES ('x', '{}')",
+	} {
+		err := checkQueryAccess(query, current)
+		if assert.Error(t, err, query) {
+			assert.Contains(t, err.Error(), "禁止访问表", query)
+		}
+	}
+	assert.NoError(t, checkQueryAccess("SELECT id FROM shop.orders", current))
+}
+
+func TestCheckQueryAccessAllow(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+	setupAccess(t, []string{"shop.orders", "shop.users.id", "shop.users.name"}, nil)
+
+	current := func() (string, error) { return "shop", nil }
+
+	t.Run("table not in allow list", func(t *testing.T) {
+		err := checkQueryAccess("SELECT * FROM customers", current)
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "禁止访问表 `shop`.`customers`")
+	})
+
+	t.Run("allowed columns", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("FROM information_schema.COLUMNS").WithArgs("shop", "users").WillReturnRows(importColumnRows())
+
+		// 调用 checkQueryAccess
+		err := checkQueryAccess("SELECT u.id, u.name AS n FROM users u ORDER BY n", current)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("column not in allow list", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("FROM information_schema.COLUMNS").WithArgs("shop", "users").WillReturnRows(importColumnRows())
+
+		// 调用 checkQueryAccess
+		err := checkQueryAccess("SELECT id, age FROM users", current)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "禁止访问列 `shop`.`users`.`age`")
+	})
+
+	t.Run("schema visibility", func(t *testing.T) {
+		assert.True(t, SchemaAccessible("shop"))
+		assert.False(t, SchemaAccessible("billing"))
+		assert.False(t, SchemaAccessible("information_schema"))
+	})
+}
+
+func TestHandleListWithAccess(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+	setupAccess(t, nil, []string{"users_credentials", "billing.*"})
+
+	t.Run("list databases", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("SHOW DATABASES").WillReturnRows(sqlmock.NewRows([]string{"Database"}).AddRow("billing").AddRow("shop"))
+
+		// 调用 HandleListDatabase
+		result, err := HandleListDatabase(RouteAuto)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, "Database\nshop\n", result)
+	})
+
+	t.Run("list tables", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("SHOW TABLES").WillReturnRows(sqlmock.NewRows([]string{"Tables_in_shop"}).AddRow("orders").AddRow("users_credentials"))
+
+		// 调用 HandleListTable
+		result, err := HandleListTable(RouteAuto)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, "Tables_in_shop\norders\n", result)
+	})
+
+	t.Run("desc denied table", func(t *testing.T) {
+		// 设置模拟预期
+		SelectDatabase("shop")
+		defer SelectDatabase("")
+
+		// 调用 HandleDescTable
+		_, err := HandleDescTable("users_credentials", RouteAuto)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "禁止访问表")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("use denied database", func(t *testing.T) {
+		// 调用 HandleUseDatabase
+		_, err := HandleUseDatabase("billing")
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "禁止访问数据库")
+	})
+}
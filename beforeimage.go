@@ -0,0 +1,672 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	BeforeImageTable = "table"
+	BeforeImageFile  = "file"
+
+	beforeImageTableName = "_mcp_before_images"
+	beforeImageFileDir   = "before_images"
+)
+
+var (
+	// BeforeImage 为 UPDATE/DELETE 执行前备份受影响行的方式，为空表示不备份
+	BeforeImage        string
+	BeforeImageMaxRows int64 = 10000
+	// BeforeImageSchema 为前镜像表所在的数据库，默认使用 DSN 中的数据库
+	BeforeImageSchema string
+
+	beforeImageTableMu    sync.Mutex
+	beforeImageTableReady bool
+)
+
+// WriteStatement 是可以创建前镜像的单表 UPDATE 或 DELETE 语句
+type WriteStatement struct {
+	Kind  string
+	Table TableRef
+	// Alias 为语句中表的别名（含 AS），用于拼接查询受影响行的 SELECT
+	Alias string
+	// Tail 为从 WHERE/ORDER BY/LIMIT 开始到语句结尾的原始文本
+	Tail       string
+	HasLimit   bool
+	SetColumns []string
+}
+
+// BeforeImageRecord 是一次操作执行前受影响行的副本
+type BeforeImageRecord struct {
+	OperationID string                   `json:"operation_id"`
+	Schema      string                   `json:"schema"`
+	Table       string                   `json:"table"`
+	Statement   string                   `json:"statement"`
+	CreatedAt   string                   `json:"created_at"`
+	Columns     []string                 `json:"columns"`
+	Rows        []map[string]interface{} `json:"rows"`
+}
+
+// ParseWriteStatement 解析单表 UPDATE/DELETE，多表语句返回错误
+func ParseWriteStatement(query string) (*WriteStatement, error) {
+	statements := SplitStatements(query)
+	if len(statements) != 1 {
+		return nil, fmt.Errorf("只能为单条语句创建前镜像")
+	}
+	query = statements[0]
+	runes := []rune(query)
+	tokens := TokenizeSQL(query)
+
+	if len(tokens) == 0 || !tokens[0].IsKeyword("UPDATE", "DELETE") {
+		return nil, fmt.Errorf("只能为 UPDATE 或 DELETE 语句创建前镜像")
+	}
+
+	stmt := &WriteStatement{Kind: strings.ToUpper(tokens[0].Value)}
+	i := 1
+	for i < len(tokens) && tokens[i].IsKeyword("LOW_PRIORITY", "QUICK", "IGNORE") {
+		i++
+	}
+	if stmt.Kind == StatementTypeDelete {
+		if i >= len(tokens) || !tokens[i].IsKeyword("FROM") {
+			return nil, fmt.Errorf("不支持为多表 DELETE 创建前镜像")
+		}
+		i++
+	}
+
+	parts, next := readQualifiedName(tokens, i)
+	if parts == nil || len(parts) > 2 {
+		return nil, fmt.Errorf("无法解析语句中的表名")
+	}
+	stmt.Table = TableRef{Name: parts[len(parts)-1]}
+	if len(parts) == 2 {
+		stmt.Table.Schema = parts[0]
+	}
+	i = next
+
+	aliasStart := i
+	if i < len(tokens) && tokens[i].IsKeyword("AS") {
+		i++
+	}
+	if i < len(tokens) && tokens[i].IsIdent() {
+		i++
+		stmt.Alias = string(runes[tokens[aliasStart].Start:tokens[i-1].End])
+	}
+
+	tail := len(tokens)
+	if stmt.Kind == StatementTypeUpdate {
+		if i >= len(tokens) || !tokens[i].IsKeyword("SET") {
+			return nil, fmt.Errorf("不支持为多表 UPDATE 创建前镜像")
+		}
+		i++
+
+		depth := 0
+		for j := i; j < len(tokens) && tail == len(tokens); j++ {
+			tok := tokens[j]
+			switch {
+			case tok.Is(TokenPunct, "("):
+				depth++
+			case tok.Is(TokenPunct, ")"):
+				depth--
+			case depth > 0:
+			case tok.IsKeyword("WHERE", "ORDER", "LIMIT"):
+				tail = j
+			case j == i || tokens[j-1].Is(TokenPunct, ","):
+				// 赋值左侧的列
+				if parts, _ := readQualifiedName(tokens, j); parts != nil {
+					stmt.SetColumns = append(stmt.SetColumns, parts[len(parts)-1])
+				}
+			}
+		}
+	} else if i < len(tokens) {
+		if !tokens[i].IsKeyword("WHERE", "ORDER", "LIMIT") {
+			return nil, fmt.Errorf("不支持为多表 DELETE 创建前镜像")
+		}
+		tail = i
+	}
+
+	if tail < len(tokens) {
+		stmt.Tail = strings.TrimSpace(string(runes[tokens[tail].Start:]))
+		for _, tok := range tokens[tail:] {
+			if tok.IsKeyword("LIMIT") {
+				stmt.HasLimit = true
+			}
+		}
+	}
+
+	return stmt, nil
+}
+
+// SelectQuery 返回查询语句将要修改的行的 SELECT
+func (s *WriteStatement) SelectQuery(limit int64) string {
+	query := "SELECT * FROM " + s.Table.QuotedName()
+	if s.Alias != "" {
+		query += " " + s.Alias
+	}
+	if s.Tail != "" {
+		query += " " + s.Tail
+	}
+	if !s.HasLimit && limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+
+	return query + " FOR UPDATE"
+}
+
+func newOperationID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return time.Now().Format("20060102150405") + "-" + hex.EncodeToString(b)
+}
+
+// beforeImageTable 返回带库名的前镜像表。连接池中各连接的当前数据库可能不同，
+// 不写库名时建表、写入和撤销可能落在不同的库中
+func beforeImageTable() string {
+	return TableRef{Schema: BeforeImageSchema, Name: beforeImageTableName}.QuotedName()
+}
+
+// beforeImageAccessRule 返回拒绝访问前镜像表的规则。前镜像保存着修改前的行，撤销时按原样写回，
+// 不能通过查询工具读取或伪造
+func beforeImageAccessRule() AccessRule {
+	return AccessRule{
+		Pattern: beforeImageTable(),
+		schema:  literalPattern(BeforeImageSchema),
+		table:   literalPattern(beforeImageTableName),
+	}
+}
+
+// beforeImageExclusions 返回不写入前镜像的列：禁止访问的列和脱敏策略匹配的列。
+// 前镜像保存在数据库或数据目录中，不应保存这些列的原始值
+func beforeImageExclusions(ref TableRef, cols []string) (map[string]bool, error) {
+	excluded := map[string]bool{}
+	masker, err := NewResultMasker("SELECT "+quoteColumns(cols)+" FROM "+ref.QuotedName(), cols)
+	if err != nil {
+		return nil, err
+	}
+	for i, col := range cols {
+		if masker.Masked(i) || (accessEnabled() && !columnAccessible(ref.Schema, ref.Name, col)) {
+			excluded[strings.ToLower(col)] = true
+		}
+	}
+
+	return excluded, nil
+}
+
+// EnsureBeforeImageTable 创建前镜像表，成功后不再重复执行。
+// 启动时调用一次，启动时未能连接数据库的在第一次备份前补建
+func EnsureBeforeImageTable(db *sqlx.DB) error {
+	beforeImageTableMu.Lock()
+	defer beforeImageTableMu.Unlock()
+
+	if beforeImageTableReady {
+		return nil
+	}
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS " + beforeImageTable() + " (" +
+		"id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY, " +
+		"operation_id VARCHAR(64) NOT NULL COMMENT '操作 ID', " +
+		"table_schema VARCHAR(64) NOT NULL COMMENT '被修改表所在的数据库', " +
+		"table_name VARCHAR(64) NOT NULL COMMENT '被修改的表', " +
+		"statement LONGTEXT NOT NULL COMMENT '执行的语句', " +
+		"row_data LONGTEXT NOT NULL COMMENT '修改前的行（JSON）', " +
+		"created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '备份时间', " +
+		"undone_at TIMESTAMP NULL DEFAULT NULL COMMENT '撤销时间', " +
+		"KEY idx_operation_id (operation_id)" +
+		") COMMENT = 'UPDATE/DELETE 执行前的行备份'"); err != nil {
+		return fmt.Errorf("创建前镜像表失败: %v", err)
+	}
+	beforeImageTableReady = true
+
+	return nil
+}
+
+// ExecWithBeforeImage 在同一个事务中锁定并备份受影响的行，再执行 UPDATE/DELETE
+func ExecWithBeforeImage(query string) (sql.Result, string, error) {
+	stmt, err := ParseWriteStatement(query)
+	if err != nil {
+		return nil, "", fmt.Errorf("%v，可关闭 --before-image 后执行", err)
+	}
+
+	if stmt.Kind == StatementTypeUpdate {
+		if err := checkUpdateKey(stmt); err != nil {
+			return nil, "", err
+		}
+	}
+
+	db, err := GetDB()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if BeforeImage == BeforeImageTable {
+		if err := EnsureBeforeImageTable(db); err != nil {
+			return nil, "", err
+		}
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, "", err
+	}
+	defer tx.Rollback()
+
+	record, err := captureBeforeImage(tx, stmt, query)
+	if err != nil {
+		return nil, "", err
+	}
+
+	path := ""
+	switch BeforeImage {
+	case BeforeImageTable:
+		if err := storeBeforeImageRows(tx, record); err != nil {
+			return nil, "", err
+		}
+	case BeforeImageFile:
+		if path, err = writeBeforeImageFile(record); err != nil {
+			return nil, "", err
+		}
+	}
+
+	result, err := tx.Exec(query)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		if path != "" {
+			os.Remove(path)
+		}
+		return nil, "", err
+	}
+
+	return result, record.OperationID, nil
+}
+
+// checkUpdateKey 撤销 UPDATE 时按主键覆盖原来的行，因此表必须有主键且语句不能修改主键
+func checkUpdateKey(stmt *WriteStatement) error {
+	indexes, err := GetTableIndexes(stmt.Table.Schema, stmt.Table.Name)
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range indexes {
+		if idx.Name != "PRIMARY" {
+			continue
+		}
+		for _, col := range stmt.SetColumns {
+			if containsFold(idx.Columns, col) {
+				return fmt.Errorf("语句修改了主键列 %s，无法创建可撤销的前镜像", col)
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("表 %s 没有主键，无法创建可撤销的前镜像", stmt.Table.Name)
+}
+
+func captureBeforeImage(tx *sqlx.Tx, stmt *WriteStatement, query string) (*BeforeImageRecord, error) {
+	schema := stmt.Table.Schema
+	if schema == "" {
+		if err := tx.Get(&schema, "SELECT DATABASE()"); err != nil {
+			return nil, err
+		}
+	}
+
+	// 多读一行，用于判断是否超过上限
+	var limit int64
+	if BeforeImageMaxRows > 0 {
+		limit = BeforeImageMaxRows + 1
+	}
+	rows, err := tx.Queryx(stmt.SelectQuery(limit))
+	if err != nil {
+		return nil, fmt.Errorf("读取受影响的行失败: %v", err)
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	ref := TableRef{Schema: schema, Name: stmt.Table.Name}
+	excluded, err := beforeImageExclusions(ref, cols)
+	if err != nil {
+		return nil, err
+	}
+	if len(excluded) > 0 {
+		if err := checkExcludedColumns(ref, stmt, excluded); err != nil {
+			return nil, err
+		}
+	}
+
+	record := &BeforeImageRecord{
+		OperationID: newOperationID(),
+		Schema:      schema,
+		Table:       stmt.Table.Name,
+		Statement:   query,
+		CreatedAt:   time.Now().Format("2006-01-02 15:04:05"),
+		Columns:     []string{},
+		Rows:        []map[string]interface{}{},
+	}
+	for _, col := range cols {
+		if !excluded[strings.ToLower(col)] {
+			record.Columns = append(record.Columns, col)
+		}
+	}
+
+	for rows.Next() {
+		values, err := rows.SliceScan()
+		if err != nil {
+			return nil, err
+		}
+		if BeforeImageMaxRows > 0 && int64(len(record.Rows)) >= BeforeImageMaxRows {
+			return nil, fmt.Errorf("语句影响的行数超过前镜像上限 %d，拒绝执行。请缩小 WHERE 范围或调大 --before-image-max-rows", BeforeImageMaxRows)
+		}
+
+		row := map[string]interface{}{}
+		for i, col := range cols {
+			if !excluded[strings.ToLower(col)] {
+				row[col] = beforeImageValue(values[i])
+			}
+		}
+		record.Rows = append(record.Rows, row)
+	}
+
+	return record, rows.Err()
+}
+
+// checkExcludedColumns 确认不写入前镜像的列不影响撤销：撤销时按主键写回，UPDATE 修改的列必须能够恢复
+func checkExcludedColumns(ref TableRef, stmt *WriteStatement, excluded map[string]bool) error {
+	for _, col := range stmt.SetColumns {
+		if excluded[strings.ToLower(col)] {
+			return fmt.Errorf("语句修改了受访问控制或脱敏策略限制的列 %s，前镜像不保存该列，无法撤销", col)
+		}
+	}
+
+	indexes, err := GetTableIndexes(ref.Schema, ref.Name)
+	if err != nil {
+		return err
+	}
+	for _, idx := range indexes {
+		if idx.Name != "PRIMARY" {
+			continue
+		}
+		for _, col := range idx.Columns {
+			if excluded[strings.ToLower(col)] {
+				return fmt.Errorf("主键列 %s 受访问控制或脱敏策略限制，前镜像不保存该列，无法撤销", col)
+			}
+		}
+	}
+
+	return nil
+}
+
+// beforeImageValue 把扫描出的值转换为可以写入 JSON 的形式，非 UTF-8 的二进制数据用 base64 保存
+func beforeImageValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		if utf8.Valid(v) {
+			return string(v)
+		}
+		return map[string]string{"base64": base64.StdEncoding.EncodeToString(v)}
+	case time.Time:
+		return v.Format(exportTimeLayout)
+	default:
+		return v
+	}
+}
+
+func storeBeforeImageRows(tx *sqlx.Tx, record *BeforeImageRecord) error {
+	for _, row := range record.Rows {
+		data, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO "+beforeImageTable()+" (operation_id, table_schema, table_name, statement, row_data) VALUES (?, ?, ?, ?, ?)",
+			record.OperationID, record.Schema, record.Table, record.Statement, string(data)); err != nil {
+			return fmt.Errorf("保存前镜像失败: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func writeBeforeImageFile(record *BeforeImageRecord) (string, error) {
+	path, err := ResolveDataPath(filepath.Join(beforeImageFileDir, record.OperationID+".json"))
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	file, err := CreateDataFile(path)
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(path)
+		return "", fmt.Errorf("保存前镜像失败: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("保存前镜像失败: %v", err)
+	}
+
+	return path, nil
+}
+
+// HandleUndoOperation 把前镜像中的行写回原表：被删除的行重新插入，被更新的行按主键覆盖
+func HandleUndoOperation(operationID string) (string, error) {
+	switch BeforeImage {
+	case BeforeImageTable, BeforeImageFile:
+	default:
+		return "", fmt.Errorf("未启用前镜像，请使用 --before-image 指定 table 或 file")
+	}
+
+	db, err := GetDB()
+	if err != nil {
+		return "", err
+	}
+
+	var record *BeforeImageRecord
+	if BeforeImage == BeforeImageTable {
+		record, err = loadBeforeImageRows(db, operationID)
+	} else {
+		record, err = loadBeforeImageFile(operationID)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := CheckTableAccess(TableRef{Schema: record.Schema, Name: record.Table}, "*"); err != nil {
+		return "", err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if err := restoreBeforeImage(tx, record); err != nil {
+		return "", err
+	}
+
+	if BeforeImage == BeforeImageTable {
+		if _, err := tx.Exec("UPDATE "+beforeImageTable()+" SET undone_at = CURRENT_TIMESTAMP WHERE operation_id = ?", operationID); err != nil {
+			return "", err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("提交事务失败: %v", err)
+	}
+	Cache.Invalidate([]TableRef{{Schema: record.Schema, Name: record.Table}})
+
+	if BeforeImage == BeforeImageFile {
+		path, _ := ResolveDataPath(filepath.Join(beforeImageFileDir, operationID+".json"))
+		os.Rename(path, path+".undone")
+	}
+
+	return fmt.Sprintf("已撤销操作 %s，恢复了表 %s 的 %d 行", operationID, TableRef{Schema: record.Schema, Name: record.Table}.QuotedName(), len(record.Rows)), nil
+}
+
+func loadBeforeImageRows(db *sqlx.DB, operationID string) (*BeforeImageRecord, error) {
+	rows := []struct {
+		Schema    string         `db:"table_schema"`
+		Table     string         `db:"table_name"`
+		Statement string         `db:"statement"`
+		RowData   string         `db:"row_data"`
+		UndoneAt  sql.NullString `db:"undone_at"`
+	}{}
+	if err := db.Select(&rows, "SELECT table_schema, table_name, statement, row_data, undone_at FROM "+beforeImageTable()+" WHERE operation_id = ? ORDER BY id", operationID); err != nil {
+		return nil, fmt.Errorf("读取前镜像失败: %v", err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("操作 %s 不存在或没有备份的行", operationID)
+	}
+	if rows[0].UndoneAt.Valid {
+		return nil, fmt.Errorf("操作 %s 已于 %s 撤销", operationID, rows[0].UndoneAt.String)
+	}
+
+	record := &BeforeImageRecord{OperationID: operationID, Schema: rows[0].Schema, Table: rows[0].Table, Statement: rows[0].Statement}
+	for _, r := range rows {
+		row, err := decodeBeforeImageRow([]byte(r.RowData))
+		if err != nil {
+			return nil, err
+		}
+		record.Rows = append(record.Rows, row)
+	}
+
+	return record, nil
+}
+
+func loadBeforeImageFile(operationID string) (*BeforeImageRecord, error) {
+	if strings.ContainsAny(operationID, `/\`) {
+		return nil, fmt.Errorf("无效的操作 ID: %s", operationID)
+	}
+
+	path, err := ResolveDataPath(filepath.Join(beforeImageFileDir, operationID+".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if _, statErr := os.Stat(path + ".undone"); statErr == nil {
+			return nil, fmt.Errorf("操作 %s 已撤销", operationID)
+		}
+		return nil, fmt.Errorf("操作 %s 不存在", operationID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取前镜像失败: %v", err)
+	}
+
+	record := &BeforeImageRecord{}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	if err := decoder.Decode(record); err != nil {
+		return nil, fmt.Errorf("解析前镜像失败: %v", err)
+	}
+	if len(record.Rows) == 0 {
+		return nil, fmt.Errorf("操作 %s 没有备份的行", operationID)
+	}
+
+	return record, nil
+}
+
+func decodeBeforeImageRow(data []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+
+	row := map[string]interface{}{}
+	if err := decoder.Decode(&row); err != nil {
+		return nil, fmt.Errorf("解析前镜像失败: %v", err)
+	}
+
+	return row, nil
+}
+
+// restoreBeforeImage 用 INSERT ... ON DUPLICATE KEY UPDATE 写回原来的行。
+// 不使用 REPLACE，避免先删除行时触发外键的级联删除
+func restoreBeforeImage(tx *sqlx.Tx, record *BeforeImageRecord) error {
+	columns, err := GetImportColumns(record.Schema, record.Table)
+	if err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		return fmt.Errorf("表 %s 不存在", record.Table)
+	}
+
+	// 禁止访问和脱敏的列没有保存在前镜像中：被删除的行恢复时使用默认值，被更新的行保留当前值
+	all := []string{}
+	for _, col := range columns {
+		all = append(all, col.Name)
+	}
+	excluded, err := beforeImageExclusions(TableRef{Schema: record.Schema, Name: record.Table}, all)
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	updates := []string{}
+	for _, col := range columns {
+		if isGeneratedColumn(col.Extra) || excluded[strings.ToLower(col.Name)] {
+			continue
+		}
+		names = append(names, col.Name)
+		updates = append(updates, fmt.Sprintf("%s = VALUES(%s)", QuoteIdentifier(col.Name), QuoteIdentifier(col.Name)))
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON DUPLICATE KEY UPDATE %s",
+		TableRef{Schema: record.Schema, Name: record.Table}.QuotedName(), quoteColumns(names),
+		strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", "), strings.Join(updates, ", "))
+	if err := CheckStatementPolicy(query); err != nil {
+		return err
+	}
+
+	for i, row := range record.Rows {
+		args := make([]interface{}, len(names))
+		for j, name := range names {
+			value, ok := row[name]
+			if !ok {
+				return fmt.Errorf("前镜像缺少列 %s，表结构可能已变更", name)
+			}
+			args[j] = restoreValue(value)
+		}
+		if _, err := tx.Exec(query, args...); err != nil {
+			return fmt.Errorf("恢复第 %d 行失败，已回滚: %v", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+func restoreValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		return v.String()
+	case map[string]interface{}:
+		if encoded, ok := v["base64"].(string); ok {
+			if data, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+				return data
+			}
+		}
+		return v
+	default:
+		return v
+	}
+}
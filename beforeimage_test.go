@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupBeforeImage(t *testing.T, mode string, maxRows int64) {
+	originalMode, originalMax, originalSchema := BeforeImage, BeforeImageMaxRows, BeforeImageSchema
+	BeforeImage, BeforeImageMaxRows, BeforeImageSchema = mode, maxRows, "mcp"
+	beforeImageTableReady = false
+	t.Cleanup(func() {
+		BeforeImage, BeforeImageMaxRows, BeforeImageSchema = originalMode, originalMax, originalSchema
+		beforeImageTableReady = false
+	})
+}
+
+func expectPrimaryKey(mock sqlmock.Sqlmock, table string) {
+	mock.ExpectQuery("FROM information_schema.STATISTICS").WithArgs("", table).
+		WillReturnRows(sqlmock.NewRows([]string{"INDEX_NAME", "NON_UNIQUE", "SEQ_IN_INDEX", "COLUMN_NAME"}).AddRow("PRIMARY", 0, 1, "id"))
+}
+
+func TestParseWriteStatement(t *testing.T) {
+	t.Run("update with alias", func(t *testing.T) {
+		stmt, err := ParseWriteStatement("UPDATE LOW_PRIORITY shop.users AS u SET u.name = 'a,b', age = (SELECT 1 WHERE 1) WHERE u.id IN (1, 2) ORDER BY id LIMIT 5;")
+
+		assert.NoError(t, err)
+		assert.Equal(t, TableRef{Schema: "shop", Name: "users"}, stmt.Table)
+		assert.Equal(t, "AS u", stmt.Alias)
+		assert.Equal(t, []string{"name", "age"}, stmt.SetColumns)
+		assert.Equal(t, "WHERE u.id IN (1, 2) ORDER BY id LIMIT 5", stmt.Tail)
+		assert.Equal(t, "SELECT * FROM `shop`.`users` AS u WHERE u.id IN (1, 2) ORDER BY id LIMIT 5 FOR UPDATE", stmt.SelectQuery(101))
+	})
+
+	t.Run("delete without where", func(t *testing.T) {
+		stmt, err := ParseWriteStatement("DELETE FROM logs")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "SELECT * FROM `logs` LIMIT 11 FOR UPDATE", stmt.SelectQuery(11))
+	})
+
+	t.Run("multi table statements", func(t *testing.T) {
+		for _, query := range []string{
+			"UPDATE a JOIN b ON a.id = b.id SET a.x = 1",
+			"UPDATE a, b SET a.x = b.x",
+			"DELETE a FROM a JOIN b ON a.id = b.id",
+			"DELETE FROM a USING a JOIN b",
+		} {
+			_, err := ParseWriteStatement(query)
+			assert.Error(t, err, query)
+		}
+	})
+}
+
+func TestExecWithBeforeImage(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	t.Run("table mode", func(t *testing.T) {
+		setupBeforeImage(t, BeforeImageTable, 100)
+
+		// 设置模拟预期
+		expectPrimaryKey(mock, "users")
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS `mcp`.`_mcp_before_images`").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT DATABASE\\(\\)").WillReturnRows(sqlmock.NewRows([]string{"db"}).AddRow("shop"))
+		mock.ExpectQuery("SELECT \\* FROM `users` WHERE id = 1 LIMIT 101 FOR UPDATE").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow([]byte("1"), []byte("alice")))
+		mock.ExpectExec("INSERT INTO `mcp`.`_mcp_before_images`").
+			WithArgs(sqlmock.AnyArg(), "shop", "users", "UPDATE users SET name = 'bob' WHERE id = 1", `{"id":"1","name":"alice"}`).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE users SET name = 'bob' WHERE id = 1").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		// 调用 HandleExec
+		result, err := HandleExec("UPDATE users SET name = 'bob' WHERE id = 1", StatementTypeUpdate)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Regexp(t, "^1 rows affected, operation id: \\d{14}-[0-9a-f]{8}$", result)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		// 前镜像表只创建一次
+		expectPrimaryKey(mock, "users")
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT DATABASE\\(\\)").WillReturnRows(sqlmock.NewRows([]string{"db"}).AddRow("shop"))
+		mock.ExpectQuery("SELECT \\* FROM `users` WHERE id = 2 LIMIT 101 FOR UPDATE").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow([]byte("2"), []byte("carol")))
+		mock.ExpectExec("INSERT INTO `mcp`.`_mcp_before_images`").WillReturnResult(sqlmock.NewResult(2, 1))
+		mock.ExpectExec("UPDATE users SET name = 'dan' WHERE id = 2").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		_, err = HandleExec("UPDATE users SET name = 'dan' WHERE id = 2", StatementTypeUpdate)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("refuses primary key change", func(t *testing.T) {
+		setupBeforeImage(t, BeforeImageTable, 100)
+
+		// 设置模拟预期
+		expectPrimaryKey(mock, "users")
+
+		// 调用 HandleExec
+		_, err := HandleExec("UPDATE users SET id = id + 10", StatementTypeUpdate)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "修改了主键列 id")
+	})
+
+	t.Run("excludes masked columns", func(t *testing.T) {
+		setupBeforeImage(t, BeforeImageTable, 100)
+		setupMasking(t, `{"rules": [{"column": "ssn", "strategy": "redact"}]}`)
+
+		// 设置模拟预期：脱敏的列不写入前镜像
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS `mcp`.`_mcp_before_images`").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT DATABASE\\(\\)").WillReturnRows(sqlmock.NewRows([]string{"db"}).AddRow("shop"))
+		mock.ExpectQuery("SELECT \\* FROM `users` WHERE id = 1 LIMIT 101 FOR UPDATE").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "ssn"}).AddRow([]byte("1"), []byte("alice"), []byte("123-45-6789")))
+		mock.ExpectQuery("FROM information_schema.STATISTICS").WithArgs("shop", "users").
+			WillReturnRows(sqlmock.NewRows([]string{"INDEX_NAME", "NON_UNIQUE", "SEQ_IN_INDEX", "COLUMN_NAME"}).AddRow("PRIMARY", 0, 1, "id"))
+		mock.ExpectExec("INSERT INTO `mcp`.`_mcp_before_images`").
+			WithArgs(sqlmock.AnyArg(), "shop", "users", "DELETE FROM users WHERE id = 1", `{"id":"1","name":"alice"}`).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("DELETE FROM users WHERE id = 1").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		// 调用 HandleExec
+		_, err := HandleExec("DELETE FROM users WHERE id = 1", StatementTypeDelete)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("refuses update of masked column", func(t *testing.T) {
+		setupBeforeImage(t, BeforeImageFile, 100)
+		setupDataDir(t)
+		setupMasking(t, `{"rules": [{"column": "ssn", "strategy": "redact"}]}`)
+
+		// 设置模拟预期
+		expectPrimaryKey(mock, "users")
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT DATABASE\\(\\)").WillReturnRows(sqlmock.NewRows([]string{"db"}).AddRow("shop"))
+		mock.ExpectQuery("SELECT \\* FROM `users` WHERE id = 1 LIMIT 101 FOR UPDATE").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "ssn"}).AddRow([]byte("1"), []byte("123-45-6789")))
+		mock.ExpectRollback()
+
+		// 调用 HandleExec
+		_, err := HandleExec("UPDATE users SET ssn = NULL WHERE id = 1", StatementTypeUpdate)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "前镜像不保存该列")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("refuses too many rows", func(t *testing.T) {
+		setupBeforeImage(t, BeforeImageFile, 1)
+		setupDataDir(t)
+
+		// 设置模拟预期
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT DATABASE\\(\\)").WillReturnRows(sqlmock.NewRows([]string{"db"}).AddRow("shop"))
+		mock.ExpectQuery("SELECT \\* FROM `logs` LIMIT 2 FOR UPDATE").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+		mock.ExpectRollback()
+
+		// 调用 HandleExec
+		_, err := HandleExec("DELETE FROM logs", StatementTypeDelete)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "超过前镜像上限 1")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestHandleUndoOperation(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	columns := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"COLUMN_NAME", "DATA_TYPE", "COLUMN_TYPE", "IS_NULLABLE", "COLUMN_DEFAULT", "EXTRA", "CHARACTER_MAXIMUM_LENGTH"}).
+			AddRow("id", "int", "int", "NO", nil, "auto_increment", nil).
+			AddRow("name", "varchar", "varchar(20)", "YES", nil, "", 20).
+			AddRow("upper_name", "varchar", "varchar(20)", "YES", nil, "STORED GENERATED", 20)
+	}
+	restoreQuery := "INSERT INTO `shop`.`users` \\(`id`, `name`\\) VALUES \\(\\?, \\?\\) ON DUPLICATE KEY UPDATE `id` = VALUES\\(`id`\\), `name` = VALUES\\(`name`\\)"
+
+	t.Run("file mode", func(t *testing.T) {
+		setupBeforeImage(t, BeforeImageFile, 100)
+		dir := setupDataDir(t)
+
+		data, _ := json.Marshal(BeforeImageRecord{
+			OperationID: "20240101000000-abcd1234",
+			Schema:      "shop",
+			Table:       "users",
+			Columns:     []string{"id", "name", "upper_name"},
+			Rows:        []map[string]interface{}{{"id": "1", "name": "alice", "upper_name": "ALICE"}, {"id": "2", "name": nil, "upper_name": nil}},
+		})
+		os.MkdirAll(filepath.Join(dir, beforeImageFileDir), 0o755)
+		writeDataFile(t, dir, filepath.Join(beforeImageFileDir, "20240101000000-abcd1234.json"), string(data))
+
+		// 设置模拟预期
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM information_schema.COLUMNS").WithArgs("shop", "users").WillReturnRows(columns())
+		mock.ExpectExec(restoreQuery).WithArgs("1", "alice").WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(restoreQuery).WithArgs("2", nil).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		// 调用 HandleUndoOperation
+		result, err := HandleUndoOperation("20240101000000-abcd1234")
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, "已撤销操作 20240101000000-abcd1234，恢复了表 `shop`.`users` 的 2 行", result)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		// 再次撤销
+		_, err = HandleUndoOperation("20240101000000-abcd1234")
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "已撤销")
+	})
+
+	t.Run("table mode", func(t *testing.T) {
+		setupBeforeImage(t, BeforeImageTable, 100)
+
+		// 设置模拟预期
+		mock.ExpectQuery("FROM `mcp`.`_mcp_before_images` WHERE operation_id = \\?").WithArgs("op-1").
+			WillReturnRows(sqlmock.NewRows([]string{"table_schema", "table_name", "statement", "row_data", "undone_at"}).
+				AddRow("shop", "users", "DELETE FROM users", `{"id":1,"name":{"base64":"/w=="}}`, nil))
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM information_schema.COLUMNS").WillReturnRows(columns())
+		mock.ExpectExec(restoreQuery).WithArgs("1", []byte{0xff}).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE `mcp`.`_mcp_before_images` SET undone_at").WithArgs("op-1").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		// 调用 HandleUndoOperation
+		_, err := HandleUndoOperation("op-1")
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("unknown operation", func(t *testing.T) {
+		setupBeforeImage(t, BeforeImageTable, 100)
+
+		// 设置模拟预期
+		mock.ExpectQuery("FROM `mcp`.`_mcp_before_images`").WillReturnRows(sqlmock.NewRows([]string{"table_schema", "table_name", "statement", "row_data", "undone_at"}))
+
+		// 调用 HandleUndoOperation
+		_, err := HandleUndoOperation("missing")
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "不存在")
+	})
+
+	t.Run("statement policy", func(t *testing.T) {
+		setupBeforeImage(t, BeforeImageTable, 100)
+		policy, _ := ParseStatementPolicy([]byte(`{"deny": ["INSERT"]}`))
+		original := Statements
+		Statements = policy
+		defer func() { Statements = original }()
+
+		// 设置模拟预期
+		mock.ExpectQuery("FROM `mcp`.`_mcp_before_images`").
+			WillReturnRows(sqlmock.NewRows([]string{"table_schema", "table_name", "statement", "row_data", "undone_at"}).
+				AddRow("shop", "users", "DELETE FROM users", `{"id":1,"name":"alice"}`, nil))
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM information_schema.COLUMNS").WillReturnRows(columns())
+		mock.ExpectRollback()
+
+		// 调用 HandleUndoOperation
+		_, err := HandleUndoOperation("op-2")
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "语句策略禁止执行 INSERT 语句")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 查询缓存参数，QueryCacheTTL 为 0 时不缓存
+var (
+	QueryCacheTTL      time.Duration
+	QueryCacheEntries  int
+	QueryCacheMaxBytes int64
+
+	Cache *QueryCache
+)
+
+// volatileFunctions 为每次执行结果可能不同的函数，使用它们的查询不缓存
+var volatileFunctions = map[string]bool{
+	"NOW": true, "SYSDATE": true, "CURDATE": true, "CURTIME": true, "CURRENT_DATE": true,
+	"CURRENT_TIME": true, "CURRENT_TIMESTAMP": true, "LOCALTIME": true, "LOCALTIMESTAMP": true,
+	"UTC_DATE": true, "UTC_TIME": true, "UTC_TIMESTAMP": true, "UNIX_TIMESTAMP": true,
+	"RAND": true, "UUID": true, "UUID_SHORT": true, "CONNECTION_ID": true, "LAST_INSERT_ID": true,
+	"FOUND_ROWS": true, "ROW_COUNT": true, "SLEEP": true, "GET_LOCK": true, "IS_FREE_LOCK": true,
+	"IS_USED_LOCK": true, "RELEASE_LOCK": true, "USER": true, "CURRENT_USER": true,
+	"SESSION_USER": true, "SYSTEM_USER": true, "DATABASE": true, "SCHEMA": true,
+}
+
+// volatileKeywords 为可以不带括号使用的时间和账户函数
+var volatileKeywords = map[string]bool{
+	"CURRENT_DATE": true, "CURRENT_TIME": true, "CURRENT_TIMESTAMP": true, "LOCALTIME": true,
+	"LOCALTIMESTAMP": true, "UTC_DATE": true, "UTC_TIME": true, "UTC_TIMESTAMP": true, "CURRENT_USER": true,
+}
+
+// volatileSchemas 中的表反映服务器的实时状态，也不会因为 DDL 或写入而失效，查询它们的结果不缓存
+var volatileSchemas = map[string]bool{
+	"information_schema": true, "performance_schema": true, "mysql": true, "sys": true,
+}
+
+type cacheEntry struct {
+	key      string
+	value    string
+	tables   []TableRef
+	storedAt time.Time
+}
+
+// QueryCache 按连接、当前数据库、规范化后的 SQL 和参数缓存只读工具的结果。
+// 条目超过 TTL 后失效，条目数或总大小超过上限时淘汰最久未使用的条目
+type QueryCache struct {
+	TTL        time.Duration
+	MaxEntries int
+	MaxBytes   int64
+
+	mu       sync.Mutex
+	database string
+	entries  map[string]*list.Element
+	lru      *list.List
+	bytes    int64
+	now      func() time.Time
+}
+
+// NewQueryCache 创建查询缓存，database 为连接的初始数据库
+func NewQueryCache(ttl time.Duration, maxEntries int, maxBytes int64, database string) *QueryCache {
+	return &QueryCache{
+		TTL:        ttl,
+		MaxEntries: maxEntries,
+		MaxBytes:   maxBytes,
+		database:   database,
+		entries:    map[string]*list.Element{},
+		lru:        list.New(),
+	}
+}
+
+func (c *QueryCache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+
+	return time.Now()
+}
+
+// UseDatabase 记录 use_database 切换后的当前数据库，之后的缓存键和未写库名的表都按该数据库解析
+func (c *QueryCache) UseDatabase(name string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.database = name
+}
+
+// key 返回缓存键和解析了数据库名的表，调用前需要持有锁
+func (c *QueryCache) key(connection, kind, normalized string, tables []TableRef) (string, []TableRef) {
+	resolved := make([]TableRef, 0, len(tables))
+	for _, t := range tables {
+		if t.Schema == "" {
+			t.Schema = c.database
+		}
+		resolved = append(resolved, TableRef{Schema: strings.ToLower(t.Schema), Name: strings.ToLower(t.Name)})
+	}
+
+	return strings.Join([]string{connection, c.database, kind, normalized}, "\x00"), resolved
+}
+
+// Get 返回未过期的缓存结果及其缓存时长
+func (c *QueryCache) Get(connection, kind, normalized string) (string, time.Duration, bool) {
+	if c == nil {
+		return "", 0, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key, _ := c.key(connection, kind, normalized, nil)
+	elem, ok := c.entries[key]
+	if !ok {
+		return "", 0, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	age := c.clock().Sub(entry.storedAt)
+	if age >= c.TTL {
+		c.remove(elem)
+		return "", 0, false
+	}
+	c.lru.MoveToFront(elem)
+
+	return entry.value, age, true
+}
+
+// Put 缓存结果，tables 为结果依赖的表，任何一个表被写入时该结果失效。
+// 单个结果超过 MaxBytes 时不缓存
+func (c *QueryCache) Put(connection, kind, normalized, value string, tables []TableRef) {
+	if c == nil || (c.MaxBytes > 0 && int64(len(value)) > c.MaxBytes) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key, resolved := c.key(connection, kind, normalized, tables)
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+
+	entry := &cacheEntry{key: key, value: value, tables: resolved, storedAt: c.clock()}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.bytes += int64(len(value))
+
+	for c.lru.Len() > 0 && ((c.MaxEntries > 0 && c.lru.Len() > c.MaxEntries) || (c.MaxBytes > 0 && c.bytes > c.MaxBytes)) {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *QueryCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= int64(len(entry.value))
+}
+
+// Invalidate 删除依赖任一给定表的结果，未写库名的表按当前数据库解析
+func (c *QueryCache) Invalidate(tables []TableRef) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, targets := c.key("", "", "", tables)
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		for _, t := range elem.Value.(*cacheEntry).tables {
+			if containsTable(targets, t) {
+				c.remove(elem)
+				break
+			}
+		}
+		elem = next
+	}
+}
+
+// containsTable 判断 tables 中是否有 t。不知道当前数据库时未写库名的表没有库名，这时只比较表名
+func containsTable(tables []TableRef, t TableRef) bool {
+	for _, candidate := range tables {
+		if candidate.Name == t.Name && (candidate.Schema == t.Schema || candidate.Schema == "" || t.Schema == "") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Clear 清空缓存
+func (c *QueryCache) Clear() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = map[string]*list.Element{}
+	c.lru.Init()
+	c.bytes = 0
+}
+
+// InvalidateWrite 在写语句执行后删除受影响的结果：INSERT/UPDATE/DELETE 等只删除引用了语句中的表的结果，
+// DDL、SET 以及无法确定目标表的语句清空整个缓存
+func (c *QueryCache) InvalidateWrite(query string) {
+	if c == nil {
+		return
+	}
+
+	tokens := TokenizeSQL(query)
+	switch StatementKind(tokens) {
+	case "INSERT", "UPDATE", "DELETE", "REPLACE", "TRUNCATE", "LOAD DATA":
+		if refs, _ := accessReferences(tokens); len(refs) > 0 {
+			c.Invalidate(refs)
+			return
+		}
+	}
+
+	c.Clear()
+}
+
+// normalizeSQL 去掉注释、多余的空白和末尾的分号，并把关键字转为大写，
+// 使只有格式不同的查询使用同一个缓存键
+func normalizeSQL(tokens []SQLToken) string {
+	parts := make([]string, 0, len(tokens))
+	for _, tok := range tokens {
+		text := tok.Text
+		if tok.Kind == TokenWord && reservedWords[strings.ToUpper(text)] {
+			text = strings.ToUpper(text)
+		}
+		parts = append(parts, text)
+	}
+
+	return strings.TrimSuffix(strings.Join(parts, " "), " ;")
+}
+
+// cacheableQuery 判断 read_query 的查询能否缓存，返回规范化后的 SQL 和结果依赖的表。
+// 只缓存不带锁的 SELECT，不缓存使用变量、时间和随机数等函数或查询系统库的语句
+func cacheableQuery(query string) (string, []TableRef, bool) {
+	tokens := TokenizeSQL(query)
+	switch StatementKind(tokens) {
+	case "SELECT", "TABLE", "VALUES":
+	default:
+		return "", nil, false
+	}
+	if lockingRead(query) {
+		return "", nil, false
+	}
+
+	for _, feature := range StatementFeatures(tokens) {
+		if !strings.HasSuffix(feature, "()") || volatileFunctions[strings.TrimSuffix(feature, "()")] {
+			return "", nil, false
+		}
+	}
+	for _, tok := range tokens {
+		if tok.Kind == TokenWord && volatileKeywords[strings.ToUpper(tok.Value)] {
+			return "", nil, false
+		}
+	}
+
+	refs, _ := accessReferences(tokens)
+	for _, ref := range refs {
+		if volatileSchemas[strings.ToLower(ref.Schema)] {
+			return "", nil, false
+		}
+	}
+
+	return normalizeSQL(tokens), refs, true
+}
+
+// cacheConnection 返回缓存键中的连接：主库和副本的结果分别缓存
+func cacheConnection(route string) string {
+	if route == RoutePrimary || Replicas == nil || len(Replicas.Replicas) == 0 {
+		return RoutePrimary
+	}
+
+	return RouteReplica
+}
+
+// cachedNote 为来自缓存的结果追加的说明
+func cachedNote(age time.Duration) string {
+	return fmt.Sprintf("\n（结果来自缓存，%v 前查询）", age.Round(time.Second))
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupQueryCache 创建查询缓存并设置为全局缓存
+func setupQueryCache(t *testing.T, database string) *QueryCache {
+	original := Cache
+	Cache = NewQueryCache(time.Minute, 100, 1<<20, database)
+	t.Cleanup(func() { Cache = original })
+
+	return Cache
+}
+
+func TestCacheableQuery(t *testing.T) {
+	t.Run("normalized", func(t *testing.T) {
+		a, tables, ok := cacheableQuery("select id, name\n  from users /* 最近 */ where id = 1;")
+		assert.True(t, ok)
+		b, _, _ := cacheableQuery("SELECT id, name FROM users WHERE id = 1")
+		assert.Equal(t, a, b)
+		assert.Equal(t, []TableRef{{Name: "users"}}, tables)
+
+		c, _, _ := cacheableQuery("SELECT id, name FROM users WHERE id = 2")
+		assert.NotEqual(t, a, c)
+		d, _, _ := cacheableQuery("SELECT id, name FROM users WHERE name = 'FROM'")
+		e, _, _ := cacheableQuery("SELECT id, name FROM users WHERE name = 'from'")
+		assert.NotEqual(t, d, e)
+	})
+
+	for _, query := range []string{
+		"SELECT * FROM users FOR UPDATE",
+		"SELECT NOW()",
+		"SELECT * FROM orders WHERE created_at > CURRENT_DATE",
+		"SELECT * FROM users ORDER BY RAND() LIMIT 1",
+		"SELECT @@version",
+		"SELECT * FROM information_schema.PROCESSLIST",
+		"SHOW PROCESSLIST",
+		"UPDATE users SET name = 'a'",
+	} {
+		_, _, ok := cacheableQuery(query)
+		assert.False(t, ok, query)
+	}
+
+	_, _, ok := cacheableQuery("SELECT COUNT(*), MAX(id) FROM `user`")
+	assert.True(t, ok)
+}
+
+func TestQueryCache(t *testing.T) {
+	t.Run("expires after ttl", func(t *testing.T) {
+		cache := NewQueryCache(time.Minute, 10, 0, "shop")
+		now := time.Now()
+		cache.now = func() time.Time { return now }
+
+		cache.Put(RoutePrimary, "read_query", "SELECT 1", "1", nil)
+		now = now.Add(30 * time.Second)
+		value, age, ok := cache.Get(RoutePrimary, "read_query", "SELECT 1")
+		assert.True(t, ok)
+		assert.Equal(t, "1", value)
+		assert.Equal(t, 30*time.Second, age)
+
+		now = now.Add(30 * time.Second)
+		_, _, ok = cache.Get(RoutePrimary, "read_query", "SELECT 1")
+		assert.False(t, ok)
+	})
+
+	t.Run("keyed by connection and database", func(t *testing.T) {
+		cache := NewQueryCache(time.Minute, 10, 0, "shop")
+		cache.Put(RoutePrimary, "read_query", "SELECT * FROM users", "shop users", nil)
+
+		_, _, ok := cache.Get(RouteReplica, "read_query", "SELECT * FROM users")
+		assert.False(t, ok)
+
+		cache.UseDatabase("crm")
+		_, _, ok = cache.Get(RoutePrimary, "read_query", "SELECT * FROM users")
+		assert.False(t, ok)
+
+		cache.UseDatabase("shop")
+		_, _, ok = cache.Get(RoutePrimary, "read_query", "SELECT * FROM users")
+		assert.True(t, ok)
+	})
+
+	t.Run("evicts least recently used", func(t *testing.T) {
+		cache := NewQueryCache(time.Minute, 2, 10, "shop")
+		cache.Put(RoutePrimary, "read_query", "a", "aaa", nil)
+		cache.Put(RoutePrimary, "read_query", "b", "bbb", nil)
+		cache.Get(RoutePrimary, "read_query", "a")
+		cache.Put(RoutePrimary, "read_query", "c", "ccc", nil)
+
+		_, _, ok := cache.Get(RoutePrimary, "read_query", "b")
+		assert.False(t, ok)
+		_, _, ok = cache.Get(RoutePrimary, "read_query", "a")
+		assert.True(t, ok)
+
+		// 总大小超过 MaxBytes 时也会淘汰，单个结果超过上限时不缓存
+		cache.Put(RoutePrimary, "read_query", "d", "dddddd", nil)
+		_, _, ok = cache.Get(RoutePrimary, "read_query", "c")
+		assert.False(t, ok)
+		cache.Put(RoutePrimary, "read_query", "e", strings.Repeat("e", 11), nil)
+		_, _, ok = cache.Get(RoutePrimary, "read_query", "e")
+		assert.False(t, ok)
+	})
+
+	t.Run("invalidates by table", func(t *testing.T) {
+		cache := NewQueryCache(time.Minute, 10, 0, "shop")
+		cache.Put(RoutePrimary, "read_query", "users", "1", []TableRef{{Name: "Users"}})
+		cache.Put(RoutePrimary, "read_query", "orders", "2", []TableRef{{Schema: "shop", Name: "orders"}})
+		cache.Put(RoutePrimary, "read_query", "crm users", "3", []TableRef{{Schema: "crm", Name: "users"}})
+
+		cache.InvalidateWrite("UPDATE users SET name = 'a' WHERE id = 1")
+		_, _, ok := cache.Get(RoutePrimary, "read_query", "users")
+		assert.False(t, ok)
+		_, _, ok = cache.Get(RoutePrimary, "read_query", "orders")
+		assert.True(t, ok)
+		_, _, ok = cache.Get(RoutePrimary, "read_query", "crm users")
+		assert.True(t, ok)
+
+		cache.InvalidateWrite("ALTER TABLE crm.accounts ADD COLUMN note TEXT")
+		_, _, ok = cache.Get(RoutePrimary, "read_query", "orders")
+		assert.False(t, ok)
+	})
+
+	t.Run("unknown database", func(t *testing.T) {
+		cache := NewQueryCache(time.Minute, 10, 0, "")
+		cache.Put(RoutePrimary, "desc_table", "`users`", "CREATE TABLE", []TableRef{{Schema: "shop", Name: "users"}})
+
+		cache.InvalidateWrite("DELETE FROM users WHERE id = 1")
+		_, _, ok := cache.Get(RoutePrimary, "desc_table", "`users`")
+		assert.False(t, ok)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		var cache *QueryCache
+		cache.Put(RoutePrimary, "read_query", "SELECT 1", "1", nil)
+		_, _, ok := cache.Get(RoutePrimary, "read_query", "SELECT 1")
+		assert.False(t, ok)
+		cache.InvalidateWrite("DELETE FROM users")
+	})
+}
+
+func TestHandleQueryCache(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+	setupQueryCache(t, "shop")
+
+	users := func() *sqlmock.Rows { return sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "alice") }
+
+	// 设置模拟预期
+	mock.ExpectQuery("SELECT id, name FROM users").WillReturnRows(users())
+
+	// 调用 HandleQuery
+	first, err := HandleQuery("SELECT id, name FROM users", StatementTypeNoExplainCheck, RouteAuto, nil)
+	assert.NoError(t, err)
+	second, err := HandleQuery("select id, name from users;", StatementTypeNoExplainCheck, RouteAuto, nil)
+
+	// 验证结果
+	assert.NoError(t, err)
+	assert.Equal(t, first+cachedNote(0), second)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	t.Run("unrelated write keeps cache", func(t *testing.T) {
+		mock.ExpectExec("INSERT INTO orders").WillReturnResult(sqlmock.NewResult(1, 1))
+		_, err := HandleExec("INSERT INTO orders (user_id) VALUES (1)", StatementTypeInsert)
+		assert.NoError(t, err)
+
+		result, err := HandleQuery("SELECT id, name FROM users", StatementTypeNoExplainCheck, RouteAuto, nil)
+		assert.NoError(t, err)
+		assert.Contains(t, result, "结果来自缓存")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("write invalidates", func(t *testing.T) {
+		mock.ExpectExec("UPDATE users").WillReturnError(assert.AnError)
+		_, err := HandleExec("UPDATE users SET name = 'bob' WHERE id = 1", StatementTypeUpdate)
+		assert.Error(t, err)
+
+		mock.ExpectQuery("SELECT id, name FROM users").WillReturnRows(users())
+		result, err := HandleQuery("SELECT id, name FROM users", StatementTypeNoExplainCheck, RouteAuto, nil)
+		assert.NoError(t, err)
+		assert.NotContains(t, result, "结果来自缓存")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("desc_table", func(t *testing.T) {
+		mock.ExpectQuery("FROM information_schema.TABLES").
+			WithArgs("", "users").
+			WillReturnRows(sqlmock.NewRows([]string{"TABLE_SCHEMA", "TABLE_NAME"}).AddRow("shop", "users"))
+		mock.ExpectQuery("SHOW CREATE TABLE `shop`.`users`").
+			WillReturnRows(sqlmock.NewRows([]string{"Table", "Create Table"}).AddRow("users", "CREATE TABLE `users` (`id` int)"))
+
+		_, err := HandleDescTable("users", RouteAuto)
+		assert.NoError(t, err)
+		result, err := HandleDescTable("users", RouteAuto)
+		assert.NoError(t, err)
+		assert.Contains(t, result, "结果来自缓存")
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		normalized, _, _ := cacheableQuery("SELECT id, name FROM users")
+		_, _, ok := Cache.Get(RoutePrimary, "read_query", normalized)
+		assert.True(t, ok)
+
+		// DDL 清空整个缓存
+		mock.ExpectExec("ALTER TABLE users").WillReturnResult(sqlmock.NewResult(0, 0))
+		_, err = HandleExec("ALTER TABLE users ADD COLUMN email varchar(255)", StatementTypeNoExplainCheck)
+		assert.NoError(t, err)
+		_, _, ok = Cache.Get(RoutePrimary, "desc_table", "`users`")
+		assert.False(t, ok)
+		_, _, ok = Cache.Get(RoutePrimary, "read_query", normalized)
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ConnectionFlag 解析可重复的 `--connection name=DSN` 参数
+type ConnectionFlag map[string]string
+
+func (c ConnectionFlag) String() string {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ",")
+}
+
+func (c ConnectionFlag) Set(value string) error {
+	name, dsn, ok := strings.Cut(value, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.TrimSpace(dsn) == "" {
+		return fmt.Errorf("连接配置格式应为 name=DSN")
+	}
+
+	if _, exists := c[name]; exists {
+		return fmt.Errorf("连接 %s 重复定义", name)
+	}
+
+	c[name] = dsn
+
+	return nil
+}
+
+var (
+	Connections = ConnectionFlag{}
+
+	namedDBs   = map[string]*sqlx.DB{}
+	namedDBsMu sync.Mutex
+)
+
+// GetNamedDB 返回指定名称的连接，名称为空时返回默认连接
+func GetNamedDB(name string) (*sqlx.DB, error) {
+	if name == "" {
+		return GetDB()
+	}
+
+	namedDBsMu.Lock()
+	defer namedDBsMu.Unlock()
+
+	if db, ok := namedDBs[name]; ok {
+		return db, nil
+	}
+
+	dsn, ok := Connections[name]
+	if !ok {
+		return nil, fmt.Errorf("未定义的连接: %s", name)
+	}
+
+	db, err := openDB(dsn, nil, false)
+	if err != nil {
+		return nil, fmt.Errorf("建立数据库连接 %s 失败: %v", name, err)
+	}
+
+	namedDBs[name] = db
+
+	return db, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionFlag(t *testing.T) {
+	t.Run("parses name and dsn", func(t *testing.T) {
+		c := ConnectionFlag{}
+
+		assert.NoError(t, c.Set("prod=user:pass@tcp(db:3306)/app?parseTime=true"))
+		assert.NoError(t, c.Set("staging=user:pass@tcp(staging:3306)/app"))
+
+		assert.Equal(t, "user:pass@tcp(db:3306)/app?parseTime=true", c["prod"])
+		assert.Equal(t, "prod,staging", c.String())
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		c := ConnectionFlag{}
+
+		assert.Error(t, c.Set("no-separator"))
+		assert.Error(t, c.Set("=dsn"))
+	})
+
+	t.Run("duplicate name", func(t *testing.T) {
+		c := ConnectionFlag{}
+
+		assert.NoError(t, c.Set("a=dsn1"))
+		err := c.Set("a=dsn2")
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "重复定义")
+	})
+}
+
+func TestGetNamedDB(t *testing.T) {
+	t.Run("empty name returns default connection", func(t *testing.T) {
+		_, _, cleanup := setupMockDB(t)
+		defer cleanup()
+
+		db, err := GetNamedDB("")
+
+		assert.NoError(t, err)
+		assert.Equal(t, DB, db)
+	})
+
+	t.Run("undefined connection", func(t *testing.T) {
+		_, err := GetNamedDB("nope")
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "未定义的连接")
+	})
+}
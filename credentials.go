@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 短期凭据参数。Credentials 为根据参数创建的提供者，未配置时为 nil，使用 DSN 中的静态密码
+var (
+	CredentialCommand string
+	CredentialFile    string
+	CredentialTTL     time.Duration
+
+	Credentials CredentialProvider
+)
+
+// Credential 是建立连接时使用的用户名和密码，User 为空时沿用 DSN 中的用户名，
+// ExpiresAt 为零值表示没有明确的过期时间
+type Credential struct {
+	User      string    `json:"user"`
+	Password  string    `json:"password"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CredentialProvider 在每次建立新连接前被调用，返回当前有效的凭据。
+// 实现需要可以并发调用
+type CredentialProvider interface {
+	Credential(ctx context.Context) (Credential, error)
+}
+
+// parseCredential 解析凭据命令的输出或凭据文件的内容：以 `{` 开头时按 JSON 解析
+// `user`、`password` 和 RFC 3339 格式的 `expires_at`，否则整段内容（去掉首尾空白）作为密码
+func parseCredential(data []byte) (Credential, error) {
+	data = bytes.TrimSpace(data)
+
+	cred := Credential{Password: string(data)}
+	if bytes.HasPrefix(data, []byte("{")) {
+		cred = Credential{}
+		if err := json.Unmarshal(data, &cred); err != nil {
+			return Credential{}, fmt.Errorf("解析凭据失败: %v", err)
+		}
+	}
+
+	if len(cred.Password) == 0 {
+		return Credential{}, errors.New("凭据中没有密码")
+	}
+
+	return cred, nil
+}
+
+// CommandCredentials 每次调用时执行命令获取凭据，例如
+// `aws rds generate-db-auth-token` 或从 Vault 读取动态密码的脚本
+type CommandCredentials struct {
+	Command string
+}
+
+func (p *CommandCredentials) Credential(ctx context.Context) (Credential, error) {
+	var stderr bytes.Buffer
+	cmd := shellCommand(ctx, p.Command)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return Credential{}, fmt.Errorf("执行凭据命令失败: %v %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return parseCredential(output)
+}
+
+// FileCredentials 从文件读取凭据，文件的修改时间或大小变化后重新读取，
+// 适用于由 Vault Agent 等工具定期改写的文件
+type FileCredentials struct {
+	Path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	cred    Credential
+}
+
+func (p *FileCredentials) Credential(ctx context.Context) (Credential, error) {
+	info, err := os.Stat(p.Path)
+	if err != nil {
+		return Credential{}, fmt.Errorf("读取凭据文件失败: %v", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.cred.Password) > 0 && info.ModTime().Equal(p.modTime) && info.Size() == p.size {
+		return p.cred, nil
+	}
+
+	data, err := os.ReadFile(p.Path)
+	if err != nil {
+		return Credential{}, fmt.Errorf("读取凭据文件失败: %v", err)
+	}
+	cred, err := parseCredential(data)
+	if err != nil {
+		return Credential{}, fmt.Errorf("凭据文件 %s: %v", p.Path, err)
+	}
+
+	p.cred, p.modTime, p.size = cred, info.ModTime(), info.Size()
+
+	return cred, nil
+}
+
+// CachedCredentials 缓存 Provider 返回的凭据，在过期前提前刷新：剩余有效期少于
+// 总有效期的五分之一（最多一分钟）时重新获取。凭据没有过期时间时按 TTL 计算，
+// TTL 为 0 则一直使用。刷新失败但旧凭据尚未过期时继续使用旧凭据
+type CachedCredentials struct {
+	Provider CredentialProvider
+	TTL      time.Duration
+
+	now       func() time.Time
+	mu        sync.Mutex
+	cred      Credential
+	refreshAt time.Time
+}
+
+func (p *CachedCredentials) Credential(ctx context.Context) (Credential, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if p.now != nil {
+		now = p.now()
+	}
+
+	cached := len(p.cred.Password) > 0
+	if cached && (p.refreshAt.IsZero() || now.Before(p.refreshAt)) {
+		return p.cred, nil
+	}
+
+	cred, err := p.Provider.Credential(ctx)
+	if err != nil {
+		if cached && (p.cred.ExpiresAt.IsZero() || now.Before(p.cred.ExpiresAt)) {
+			log.Printf("刷新凭据失败，继续使用尚未过期的凭据: %v", err)
+			return p.cred, nil
+		}
+		return Credential{}, err
+	}
+
+	if cred.ExpiresAt.IsZero() && p.TTL > 0 {
+		cred.ExpiresAt = now.Add(p.TTL)
+	}
+	p.cred = cred
+	p.refreshAt = time.Time{}
+	if !cred.ExpiresAt.IsZero() {
+		p.refreshAt = cred.ExpiresAt.Add(-min(cred.ExpiresAt.Sub(now)/5, time.Minute))
+	}
+
+	return cred, nil
+}
+
+// NewCredentialProvider 根据 --credential-command 或 --credential-file 创建凭据提供者，都未指定时返回 nil
+func NewCredentialProvider() (CredentialProvider, error) {
+	switch {
+	case len(CredentialCommand) > 0 && len(CredentialFile) > 0:
+		return nil, errors.New("--credential-command 和 --credential-file 不能同时指定")
+	case (len(CredentialCommand) > 0 || len(CredentialFile) > 0) && (len(PassFile) > 0 || len(PassCommand) > 0):
+		return nil, errors.New("--credential-command、--credential-file 不能与 --pass-file、--pass-command 同时指定")
+	case len(CredentialCommand) > 0:
+		return &CachedCredentials{Provider: &CommandCredentials{Command: CredentialCommand}, TTL: CredentialTTL}, nil
+	case len(CredentialFile) > 0:
+		return &FileCredentials{Path: CredentialFile}, nil
+	}
+
+	return nil, nil
+}
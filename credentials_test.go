@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCredentials 依次返回预设的凭据或错误，并记录调用次数
+type fakeCredentials struct {
+	mu      sync.Mutex
+	results []Credential
+	errs    []error
+	calls   int
+}
+
+func (p *fakeCredentials) Credential(ctx context.Context) (Credential, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i := min(p.calls, len(p.results)-1)
+	p.calls++
+	if i < len(p.errs) && p.errs[i] != nil {
+		return Credential{}, p.errs[i]
+	}
+
+	return p.results[i], nil
+}
+
+func TestParseCredential(t *testing.T) {
+	cred, err := parseCredential([]byte("  token-123\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, Credential{Password: "token-123"}, cred)
+
+	cred, err = parseCredential([]byte(`{"user": "v-app-x1", "password": "p", "expires_at": "2026-10-19T12:00:00Z"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "v-app-x1", cred.User)
+	assert.Equal(t, "p", cred.Password)
+	assert.Equal(t, time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC), cred.ExpiresAt)
+
+	for _, data := range []string{"", "\n", `{"user": "x"}`, `{"password": `} {
+		_, err := parseCredential([]byte(data))
+		assert.Error(t, err, data)
+	}
+}
+
+func TestCachedCredentials(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	t.Run("refreshes before expiry", func(t *testing.T) {
+		fake := &fakeCredentials{results: []Credential{
+			{Password: "first", ExpiresAt: now.Add(15 * time.Minute)},
+			{Password: "second", ExpiresAt: now.Add(30 * time.Minute)},
+		}}
+		cached := &CachedCredentials{Provider: fake, now: clock}
+
+		// 调用 Credential
+		cred, err := cached.Credential(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "first", cred.Password)
+
+		// 距过期还有两分钟，仍在刷新窗口之外
+		now = now.Add(13 * time.Minute)
+		cred, _ = cached.Credential(context.Background())
+		assert.Equal(t, "first", cred.Password)
+
+		// 距过期不到一分钟，提前刷新
+		now = now.Add(90 * time.Second)
+		cred, _ = cached.Credential(context.Background())
+
+		// 验证结果
+		assert.Equal(t, "second", cred.Password)
+		assert.Equal(t, 2, fake.calls)
+	})
+
+	t.Run("ttl for credentials without expiry", func(t *testing.T) {
+		fake := &fakeCredentials{results: []Credential{{Password: "a"}, {Password: "b"}}}
+		cached := &CachedCredentials{Provider: fake, TTL: 10 * time.Minute, now: clock}
+
+		cred, _ := cached.Credential(context.Background())
+		assert.Equal(t, "a", cred.Password)
+		now = now.Add(9*time.Minute + 30*time.Second)
+		cred, _ = cached.Credential(context.Background())
+		assert.Equal(t, "b", cred.Password)
+	})
+
+	t.Run("keeps unexpired credential when refresh fails", func(t *testing.T) {
+		fake := &fakeCredentials{
+			results: []Credential{{Password: "a", ExpiresAt: now.Add(5 * time.Minute)}, {}, {}},
+			errs:    []error{nil, errors.New("vault sealed"), errors.New("vault sealed")},
+		}
+		cached := &CachedCredentials{Provider: fake, now: clock}
+
+		cached.Credential(context.Background())
+		now = now.Add(4*time.Minute + 30*time.Second)
+		cred, err := cached.Credential(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "a", cred.Password)
+
+		// 旧凭据过期后返回错误
+		now = now.Add(time.Minute)
+		_, err = cached.Credential(context.Background())
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "vault sealed")
+	})
+}
+
+func TestCommandCredentials(t *testing.T) {
+	t.Run("plain output", func(t *testing.T) {
+		provider := &CommandCredentials{Command: "echo rds-token"}
+
+		// 调用 Credential
+		cred, err := provider.Credential(context.Background())
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, "rds-token", cred.Password)
+	})
+
+	t.Run("json output", func(t *testing.T) {
+		provider := &CommandCredentials{Command: `printf '{"user":"v-app","password":"dyn"}'`}
+
+		// 调用 Credential
+		cred, err := provider.Credential(context.Background())
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, Credential{User: "v-app", Password: "dyn"}, cred)
+	})
+
+	t.Run("command fails", func(t *testing.T) {
+		provider := &CommandCredentials{Command: "echo expired session >&2; exit 1"}
+
+		// 调用 Credential
+		_, err := provider.Credential(context.Background())
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "expired session")
+	})
+}
+
+func TestFileCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mysql-creds")
+	assert.NoError(t, os.WriteFile(path, []byte("old-secret\n"), 0600))
+	provider := &FileCredentials{Path: path}
+
+	cred, err := provider.Credential(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "old-secret", cred.Password)
+
+	// 文件被改写后重新读取
+	assert.NoError(t, os.WriteFile(path, []byte(`{"user":"rotated","password":"new-secret"}`), 0600))
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(path, later, later))
+
+	cred, err = provider.Credential(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Credential{User: "rotated", Password: "new-secret"}, cred)
+
+	assert.NoError(t, os.Remove(path))
+	_, err = provider.Credential(context.Background())
+	assert.Error(t, err)
+}
+
+func TestOpenDBUsesCredentialProvider(t *testing.T) {
+	dialed := 0
+	mysql.RegisterDialContext("credtest", func(ctx context.Context, addr string) (net.Conn, error) {
+		dialed++
+		return nil, errors.New("no server")
+	})
+
+	t.Run("provider consulted before dialing", func(t *testing.T) {
+		fake := &fakeCredentials{results: []Credential{{Password: "token"}}}
+
+		// 调用 openDB
+		_, err := openDB("app@credtest(db:3306)/shop", fake, false)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Equal(t, 1, fake.calls)
+		assert.Equal(t, 1, dialed)
+	})
+
+	t.Run("provider error", func(t *testing.T) {
+		fake := &fakeCredentials{results: []Credential{{}}, errs: []error{errors.New("helper not found")}}
+
+		// 调用 openDB
+		_, err := openDB("app@credtest(db:3306)/shop", fake, false)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "获取数据库凭据失败")
+		assert.Equal(t, 1, dialed)
+	})
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	DiagnosisOK       = "ok"
+	DiagnosisWarning  = "warning"
+	DiagnosisCritical = "critical"
+)
+
+// 诊断阈值
+const (
+	connectionUsageWarning  = 0.75
+	connectionUsageCritical = 0.9
+	threadsRunningWarning   = 32
+	bufferPoolHitWarning    = 0.99
+	bufferPoolHitCritical   = 0.95
+	slowQueriesPerHour      = 60
+	tmpDiskTableRatio       = 0.25
+	replicationLagWarning   = 30 * time.Second
+	replicationLagCritical  = 5 * time.Minute
+	recentRestart           = time.Hour
+)
+
+// Diagnosis 是健康报告中的一项指标
+type Diagnosis struct {
+	Metric string
+	Value  string
+	Level  string
+	Note   string
+}
+
+// HandleDiagnoseServer 汇总版本、运行时间以及 SHOW GLOBAL STATUS/VARIABLES 中的关键指标和复制延迟，
+// 返回健康报告：先列出超过阈值的异常，再以 CSV 列出所有指标
+func HandleDiagnoseServer() (string, error) {
+	db, err := GetDB()
+	if err != nil {
+		return "", err
+	}
+
+	var version string
+	if err := db.Get(&version, "SELECT VERSION()"); err != nil {
+		return "", fmt.Errorf("读取服务器版本失败: %v", err)
+	}
+	status, err := readGlobalValues(db, "SHOW GLOBAL STATUS")
+	if err != nil {
+		return "", err
+	}
+	variables, err := readGlobalValues(db, "SHOW GLOBAL VARIABLES")
+	if err != nil {
+		return "", err
+	}
+
+	items := DiagnoseStatus(status, variables)
+	items = append(items, diagnoseReplication("复制延迟", db))
+	if Replicas != nil {
+		for _, r := range Replicas.Replicas {
+			db, _, err := Replicas.status(r)
+			if err != nil && db == nil {
+				items = append(items, Diagnosis{"副本 " + r.Name, "不可用", DiagnosisCritical, err.Error()})
+				continue
+			}
+			items = append(items, diagnoseReplication("副本 "+r.Name+" 复制延迟", db))
+		}
+	}
+
+	uptime, _ := statusInt(status, "Uptime")
+	return formatDiagnosis(version, time.Duration(uptime)*time.Second, items)
+}
+
+// readGlobalValues 读取 SHOW GLOBAL STATUS 或 SHOW GLOBAL VARIABLES 的结果
+func readGlobalValues(db *sqlx.DB, query string) (map[string]string, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("执行 %s 失败: %v", query, err)
+	}
+	defer rows.Close()
+
+	values := map[string]string{}
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, fmt.Errorf("读取 %s 结果失败: %v", query, err)
+		}
+		values[name] = value
+	}
+
+	return values, rows.Err()
+}
+
+func statusInt(values map[string]string, name string) (int64, bool) {
+	v, err := strconv.ParseInt(values[name], 10, 64)
+	return v, err == nil
+}
+
+func percent(ratio float64) string {
+	return strconv.FormatFloat(ratio*100, 'f', 1, 64) + "%"
+}
+
+// DiagnoseStatus 根据全局状态和变量计算各项指标，缺少的状态变量对应的指标会被跳过
+func DiagnoseStatus(status, variables map[string]string) []Diagnosis {
+	items := []Diagnosis{}
+
+	if uptime, ok := statusInt(status, "Uptime"); ok && time.Duration(uptime)*time.Second < recentRestart {
+		items = append(items, Diagnosis{"运行时间", formatUptime(time.Duration(uptime) * time.Second), DiagnosisWarning, "服务器最近重启过，累计指标的参考价值有限"})
+	}
+
+	connected, ok1 := statusInt(status, "Threads_connected")
+	maxConnections, ok2 := statusInt(variables, "max_connections")
+	if ok1 && ok2 && maxConnections > 0 {
+		usage := float64(connected) / float64(maxConnections)
+		item := Diagnosis{"连接使用率", fmt.Sprintf("%s（%d/%d）", percent(usage), connected, maxConnections), DiagnosisOK, ""}
+		if maxUsed, ok := statusInt(status, "Max_used_connections"); ok {
+			item.Note = fmt.Sprintf("历史最高 %d", maxUsed)
+		}
+		switch {
+		case usage >= connectionUsageCritical:
+			item.Level, item.Note = DiagnosisCritical, "接近 max_connections，新连接可能被拒绝"
+		case usage >= connectionUsageWarning:
+			item.Level = DiagnosisWarning
+		}
+		items = append(items, item)
+	}
+
+	if refused, ok := statusInt(status, "Connection_errors_max_connections"); ok && refused > 0 {
+		items = append(items, Diagnosis{"超过最大连接数被拒绝", strconv.FormatInt(refused, 10), DiagnosisWarning, "有连接因超过 max_connections 被拒绝"})
+	}
+
+	if running, ok := statusInt(status, "Threads_running"); ok {
+		item := Diagnosis{"运行中的线程", strconv.FormatInt(running, 10), DiagnosisOK, ""}
+		if running > threadsRunningWarning {
+			item.Level, item.Note = DiagnosisWarning, "并发执行的语句较多，可以用 show_processlist 查看"
+		}
+		items = append(items, item)
+	}
+
+	reads, ok1 := statusInt(status, "Innodb_buffer_pool_reads")
+	requests, ok2 := statusInt(status, "Innodb_buffer_pool_read_requests")
+	if ok1 && ok2 && requests > 0 {
+		hit := 1 - float64(reads)/float64(requests)
+		item := Diagnosis{"缓冲池命中率", percent(hit), DiagnosisOK, ""}
+		notes := []string{}
+		if size, ok := statusInt(variables, "innodb_buffer_pool_size"); ok {
+			notes = append(notes, fmt.Sprintf("innodb_buffer_pool_size = %d MiB", size>>20))
+		}
+		switch {
+		case hit < bufferPoolHitCritical:
+			item.Level = DiagnosisCritical
+			notes = append(notes, "大量读取需要访问磁盘，考虑增大缓冲池")
+		case hit < bufferPoolHitWarning:
+			item.Level = DiagnosisWarning
+			notes = append(notes, "考虑增大缓冲池")
+		}
+		item.Note = strings.Join(notes, "，")
+		items = append(items, item)
+	}
+
+	if slow, ok := statusInt(status, "Slow_queries"); ok {
+		item := Diagnosis{"慢查询", strconv.FormatInt(slow, 10), DiagnosisOK, ""}
+		if uptime, ok := statusInt(status, "Uptime"); ok && uptime > 0 {
+			rate := float64(slow) / (float64(uptime) / 3600)
+			item.Value = fmt.Sprintf("%d（每小时 %.1f 条）", slow, rate)
+			if rate > slowQueriesPerHour {
+				item.Level = DiagnosisWarning
+			}
+		}
+		notes := []string{}
+		if v, ok := variables["long_query_time"]; ok {
+			notes = append(notes, "long_query_time = "+v)
+		}
+		if strings.EqualFold(variables["slow_query_log"], "OFF") {
+			notes = append(notes, "慢查询日志未开启")
+		}
+		item.Note = strings.Join(notes, "，")
+		items = append(items, item)
+	}
+
+	disk, ok1 := statusInt(status, "Created_tmp_disk_tables")
+	tmp, ok2 := statusInt(status, "Created_tmp_tables")
+	if ok1 && ok2 && tmp > 0 {
+		ratio := float64(disk) / float64(tmp)
+		item := Diagnosis{"磁盘临时表比例", fmt.Sprintf("%s（%d/%d）", percent(ratio), disk, tmp), DiagnosisOK, ""}
+		if ratio > tmpDiskTableRatio {
+			item.Level, item.Note = DiagnosisWarning, "较多排序或分组需要在磁盘上创建临时表"
+		}
+		items = append(items, item)
+	}
+
+	if waits, ok := statusInt(status, "Innodb_row_lock_current_waits"); ok {
+		item := Diagnosis{"InnoDB 行锁等待", strconv.FormatInt(waits, 10), DiagnosisOK, ""}
+		total, _ := statusInt(status, "Innodb_row_lock_waits")
+		avg, _ := statusInt(status, "Innodb_row_lock_time_avg")
+		item.Note = fmt.Sprintf("累计等待 %d 次，平均 %d ms", total, avg)
+		if waits > 0 {
+			item.Level = DiagnosisWarning
+			item.Note += "，当前有事务在等待行锁"
+		}
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// diagnoseReplication 检查 db 的复制延迟，没有配置复制时为 ok
+func diagnoseReplication(metric string, db *sqlx.DB) Diagnosis {
+	lag, err := ReplicationLag(db)
+	switch {
+	case errors.Is(err, errNoReplication):
+		return Diagnosis{metric, "未配置复制", DiagnosisOK, ""}
+	case errors.Is(err, errReplicationStopped):
+		return Diagnosis{metric, "复制未运行", DiagnosisCritical, "复制线程已停止，查看 SHOW REPLICA STATUS 中的错误"}
+	case err != nil:
+		return Diagnosis{metric, "未知", DiagnosisWarning, err.Error()}
+	case lag >= replicationLagCritical:
+		return Diagnosis{metric, lag.String(), DiagnosisCritical, "副本明显落后于主库"}
+	case lag >= replicationLagWarning:
+		return Diagnosis{metric, lag.String(), DiagnosisWarning, ""}
+	}
+
+	return Diagnosis{metric, lag.String(), DiagnosisOK, ""}
+}
+
+func formatUptime(d time.Duration) string {
+	days := int64(d / (24 * time.Hour))
+	hours := int64(d/time.Hour) % 24
+	minutes := int64(d/time.Minute) % 60
+	if days > 0 {
+		return fmt.Sprintf("%d 天 %d 小时", days, hours)
+	}
+	if hours > 0 {
+		return fmt.Sprintf("%d 小时 %d 分钟", hours, minutes)
+	}
+
+	return fmt.Sprintf("%d 分钟", minutes)
+}
+
+// formatDiagnosis 生成报告：版本和运行时间、按严重程度排列的异常，以及所有指标的 CSV
+func formatDiagnosis(version string, uptime time.Duration, items []Diagnosis) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "MySQL %s，已运行 %s\n", version, formatUptime(uptime))
+
+	anomalies := []string{}
+	for _, level := range []string{DiagnosisCritical, DiagnosisWarning} {
+		for _, item := range items {
+			if item.Level != level {
+				continue
+			}
+			line := fmt.Sprintf("[%s] %s: %s", item.Level, item.Metric, item.Value)
+			if item.Note != "" {
+				line += "，" + item.Note
+			}
+			anomalies = append(anomalies, line)
+		}
+	}
+	if len(anomalies) == 0 {
+		b.WriteString("未发现异常\n")
+	} else {
+		fmt.Fprintf(&b, "发现 %d 个异常:\n%s\n", len(anomalies), strings.Join(anomalies, "\n"))
+	}
+
+	rows := []map[string]interface{}{}
+	for _, item := range items {
+		rows = append(rows, map[string]interface{}{"metric": item.Metric, "value": item.Value, "level": item.Level, "note": item.Note})
+	}
+	table, err := MapToCSV(rows, []string{"metric", "value", "level", "note"})
+	if err != nil {
+		return "", err
+	}
+
+	return b.String() + "\n" + table, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func globalRows(values map[string]string) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"Variable_name", "Value"})
+	for name, value := range values {
+		rows.AddRow(name, value)
+	}
+	return rows
+}
+
+// findDiagnosis 返回指定指标，不存在时返回零值
+func findDiagnosis(items []Diagnosis, metric string) Diagnosis {
+	for _, item := range items {
+		if item.Metric == metric {
+			return item
+		}
+	}
+	return Diagnosis{}
+}
+
+func TestDiagnoseStatus(t *testing.T) {
+	t.Run("healthy", func(t *testing.T) {
+		// 调用 DiagnoseStatus
+		items := DiagnoseStatus(map[string]string{
+			"Uptime":                           "864000",
+			"Threads_connected":                "20",
+			"Max_used_connections":             "45",
+			"Threads_running":                  "3",
+			"Innodb_buffer_pool_reads":         "10",
+			"Innodb_buffer_pool_read_requests": "100000",
+			"Slow_queries":                     "24",
+			"Created_tmp_disk_tables":          "5",
+			"Created_tmp_tables":               "100",
+			"Innodb_row_lock_current_waits":    "0",
+			"Innodb_row_lock_waits":            "12",
+			"Innodb_row_lock_time_avg":         "3",
+		}, map[string]string{
+			"max_connections":         "151",
+			"innodb_buffer_pool_size": "134217728",
+			"long_query_time":         "10.000000",
+			"slow_query_log":          "ON",
+		})
+
+		// 验证结果
+		for _, item := range items {
+			assert.Equal(t, DiagnosisOK, item.Level, item.Metric)
+		}
+		assert.Equal(t, Diagnosis{"连接使用率", "13.2%（20/151）", DiagnosisOK, "历史最高 45"}, findDiagnosis(items, "连接使用率"))
+		assert.Equal(t, Diagnosis{"缓冲池命中率", "100.0%", DiagnosisOK, "innodb_buffer_pool_size = 128 MiB"}, findDiagnosis(items, "缓冲池命中率"))
+		assert.Equal(t, "24（每小时 0.1 条）", findDiagnosis(items, "慢查询").Value)
+		assert.Equal(t, "累计等待 12 次，平均 3 ms", findDiagnosis(items, "InnoDB 行锁等待").Note)
+		assert.Empty(t, findDiagnosis(items, "运行时间").Metric)
+	})
+
+	t.Run("anomalies", func(t *testing.T) {
+		// 调用 DiagnoseStatus
+		items := DiagnoseStatus(map[string]string{
+			"Uptime":                            "1800",
+			"Threads_connected":                 "140",
+			"Connection_errors_max_connections": "7",
+			"Threads_running":                   "50",
+			"Innodb_buffer_pool_reads":          "2000",
+			"Innodb_buffer_pool_read_requests":  "10000",
+			"Slow_queries":                      "100",
+			"Created_tmp_disk_tables":           "40",
+			"Created_tmp_tables":                "100",
+			"Innodb_row_lock_current_waits":     "2",
+		}, map[string]string{
+			"max_connections": "151",
+			"slow_query_log":  "OFF",
+		})
+
+		// 验证结果
+		assert.Equal(t, DiagnosisWarning, findDiagnosis(items, "运行时间").Level)
+		assert.Equal(t, DiagnosisCritical, findDiagnosis(items, "连接使用率").Level)
+		assert.Equal(t, DiagnosisWarning, findDiagnosis(items, "超过最大连接数被拒绝").Level)
+		assert.Equal(t, DiagnosisWarning, findDiagnosis(items, "运行中的线程").Level)
+		assert.Equal(t, Diagnosis{"缓冲池命中率", "80.0%", DiagnosisCritical, "大量读取需要访问磁盘，考虑增大缓冲池"}, findDiagnosis(items, "缓冲池命中率"))
+		assert.Equal(t, Diagnosis{"慢查询", "100（每小时 200.0 条）", DiagnosisWarning, "慢查询日志未开启"}, findDiagnosis(items, "慢查询"))
+		assert.Equal(t, DiagnosisWarning, findDiagnosis(items, "磁盘临时表比例").Level)
+		assert.Equal(t, DiagnosisWarning, findDiagnosis(items, "InnoDB 行锁等待").Level)
+	})
+
+	t.Run("missing status", func(t *testing.T) {
+		// 调用 DiagnoseStatus
+		items := DiagnoseStatus(map[string]string{}, map[string]string{})
+
+		// 验证结果
+		assert.Empty(t, items)
+	})
+}
+
+func TestHandleDiagnoseServer(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	t.Run("healthy primary", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("SELECT VERSION\\(\\)").WillReturnRows(sqlmock.NewRows([]string{"VERSION()"}).AddRow("8.0.36"))
+		mock.ExpectQuery("SHOW GLOBAL STATUS").WillReturnRows(globalRows(map[string]string{
+			"Uptime":            "90061",
+			"Threads_connected": "10",
+			"Threads_running":   "2",
+		}))
+		mock.ExpectQuery("SHOW GLOBAL VARIABLES").WillReturnRows(globalRows(map[string]string{"max_connections": "100"}))
+		mock.ExpectQuery("SHOW REPLICA STATUS").WillReturnRows(sqlmock.NewRows([]string{"Seconds_Behind_Source"}))
+
+		// 调用 HandleDiagnoseServer
+		result, err := HandleDiagnoseServer()
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, "MySQL 8.0.36，已运行 1 天 1 小时\n未发现异常\n\n"+
+			"metric,value,level,note\n"+
+			"连接使用率,10.0%（10/100）,ok,\n"+
+			"运行中的线程,2,ok,\n"+
+			"复制延迟,未配置复制,ok,\n", result)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("lagging replica", func(t *testing.T) {
+		_, mocks := setupReplicas(t, ReplicaStrategyRoundRobin, 0, 2)
+		// 设置模拟预期
+		mock.ExpectQuery("SELECT VERSION\\(\\)").WillReturnRows(sqlmock.NewRows([]string{"VERSION()"}).AddRow("8.0.36"))
+		mock.ExpectQuery("SHOW GLOBAL STATUS").WillReturnRows(globalRows(map[string]string{"Uptime": "600"}))
+		mock.ExpectQuery("SHOW GLOBAL VARIABLES").WillReturnRows(globalRows(map[string]string{}))
+		mock.ExpectQuery("SHOW REPLICA STATUS").WillReturnRows(sqlmock.NewRows([]string{"Seconds_Behind_Source"}))
+		expectLag(mocks[0], "45")
+		expectLag(mocks[1], nil)
+
+		// 调用 HandleDiagnoseServer
+		result, err := HandleDiagnoseServer()
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "已运行 10 分钟\n发现 3 个异常:\n"+
+			"[critical] 副本 replica2:3306 复制延迟: 复制未运行，复制线程已停止，查看 SHOW REPLICA STATUS 中的错误\n"+
+			"[warning] 运行时间: 10 分钟，服务器最近重启过，累计指标的参考价值有限\n"+
+			"[warning] 副本 replica1:3306 复制延迟: 45s\n")
+		for _, m := range mocks {
+			assert.NoError(t, m.ExpectationsWereMet())
+		}
+	})
+
+	t.Run("status error", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("SELECT VERSION\\(\\)").WillReturnRows(sqlmock.NewRows([]string{"VERSION()"}).AddRow("8.0.36"))
+		mock.ExpectQuery("SHOW GLOBAL STATUS").WillReturnError(assert.AnError)
+
+		// 调用 HandleDiagnoseServer
+		_, err := HandleDiagnoseServer()
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "执行 SHOW GLOBAL STATUS 失败")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestFormatUptime(t *testing.T) {
+	assert.Equal(t, "3 天 4 小时", formatUptime(76*time.Hour+30*time.Minute))
+	assert.Equal(t, "2 小时 5 分钟", formatUptime(2*time.Hour+5*time.Minute))
+	assert.Equal(t, "0 分钟", formatUptime(30*time.Second))
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	TLSModeDisabled       = "disabled"
+	TLSModePreferred      = "preferred"
+	TLSModeRequired       = "required"
+	TLSModeVerifyCA       = "verify-ca"
+	TLSModeVerifyIdentity = "verify-identity"
+
+	// tlsConfigName 和 serverPubKeyName 是向驱动注册 TLS 配置和服务器公钥时使用的名称
+	tlsConfigName    = "go-mcp-mysql"
+	serverPubKeyName = "go-mcp-mysql"
+)
+
+// 默认连接的传输与认证选项，未指定时保持驱动的默认行为
+var (
+	Socket        string
+	TLSMode       string
+	TLSCA         string
+	TLSCert       string
+	TLSKey        string
+	TLSServerName string
+	ServerPubKey  string
+	PassFile      string
+	PassCommand   string
+)
+
+// BuildDSN 根据命令行参数生成默认连接的 DSN。指定了 --dsn 时在其基础上应用 SSH 隧道、TLS、
+// 服务器公钥和密码选项，否则由 --host、--port 或 --socket 等参数生成
+func BuildDSN() (string, error) {
+	var cfg *mysql.Config
+	if len(DSN) > 0 {
+		parsed, err := mysql.ParseDSN(DSN)
+		if err != nil {
+			return "", fmt.Errorf("解析 DSN 失败: %v", err)
+		}
+		cfg = parsed
+	} else {
+		cfg = mysql.NewConfig()
+		cfg.User = User
+		cfg.Passwd = Pass
+		cfg.DBName = Db
+		cfg.ParseTime = true
+		cfg.Loc = time.Local
+		cfg.Net = "tcp"
+		cfg.Addr = net.JoinHostPort(Host, strconv.Itoa(Port))
+		if len(Socket) > 0 {
+			cfg.Net = "unix"
+			cfg.Addr = Socket
+		}
+	}
+
+	if len(SSHHost) > 0 {
+		if cfg.Net != "tcp" {
+			return "", fmt.Errorf("SSH 隧道只支持 TCP 连接，不支持 %s", cfg.Net)
+		}
+		cfg.Net = sshNetwork
+	}
+
+	password, err := readPassword()
+	if err != nil {
+		return "", err
+	}
+	if password != nil {
+		cfg.Passwd = *password
+	}
+
+	if err := applyTLS(cfg); err != nil {
+		return "", err
+	}
+
+	if len(ServerPubKey) > 0 {
+		key, err := loadServerPubKey(ServerPubKey)
+		if err != nil {
+			return "", err
+		}
+		mysql.RegisterServerPubKey(serverPubKeyName, key)
+		cfg.ServerPubKey = serverPubKeyName
+	}
+
+	return cfg.FormatDSN(), nil
+}
+
+// readPassword 从 --pass-file 或 --pass-command 读取密码，都未指定时返回 nil。
+// 末尾的换行符会被去掉
+func readPassword() (*string, error) {
+	if len(PassFile) > 0 && len(PassCommand) > 0 {
+		return nil, errors.New("--pass-file 和 --pass-command 不能同时指定")
+	}
+
+	var data []byte
+	switch {
+	case len(PassFile) > 0:
+		content, err := os.ReadFile(PassFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取密码文件失败: %v", err)
+		}
+		data = content
+
+	case len(PassCommand) > 0:
+		var stderr bytes.Buffer
+		cmd := shellCommand(context.Background(), PassCommand)
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("执行密码命令失败: %v %s", err, strings.TrimSpace(stderr.String()))
+		}
+		data = output
+
+	default:
+		return nil, nil
+	}
+
+	password := strings.TrimRight(string(data), "\r\n")
+
+	return &password, nil
+}
+
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// applyTLS 按 --tls-mode 设置 cfg 的 TLS 配置，含义与 MySQL 客户端的 --ssl-mode 相同：
+//   - disabled：不加密
+//   - preferred：服务器支持时加密，不校验证书
+//   - required：必须加密，指定 CA 时校验证书链，否则不校验
+//   - verify-ca：必须加密并校验证书链，不校验主机名
+//   - verify-identity：必须加密，校验证书链和主机名
+func applyTLS(cfg *mysql.Config) error {
+	if len(TLSMode) == 0 {
+		if len(TLSCA) > 0 || len(TLSCert) > 0 || len(TLSKey) > 0 || len(TLSServerName) > 0 {
+			return errors.New("指定 TLS 证书或服务器名称时需要同时指定 --tls-mode")
+		}
+		return nil
+	}
+
+	if TLSMode == TLSModeDisabled {
+		cfg.TLSConfig = "false"
+		cfg.AllowFallbackToPlaintext = false
+		return nil
+	}
+
+	tlsConfig, err := buildTLSConfig(TLSMode, cfg)
+	if err != nil {
+		return err
+	}
+	if err := mysql.RegisterTLSConfig(tlsConfigName, tlsConfig); err != nil {
+		return fmt.Errorf("注册 TLS 配置失败: %v", err)
+	}
+
+	cfg.TLSConfig = tlsConfigName
+	cfg.AllowFallbackToPlaintext = TLSMode == TLSModePreferred
+
+	return nil
+}
+
+func buildTLSConfig(mode string, cfg *mysql.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if len(TLSCert) > 0 || len(TLSKey) > 0 {
+		if len(TLSCert) == 0 || len(TLSKey) == 0 {
+			return nil, errors.New("--tls-cert 和 --tls-key 需要同时指定")
+		}
+		cert, err := tls.LoadX509KeyPair(TLSCert, TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("读取客户端证书失败: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	var roots *x509.CertPool
+	if len(TLSCA) > 0 {
+		pemData, err := os.ReadFile(TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("读取 CA 证书失败: %v", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pemData) {
+			return nil, fmt.Errorf("CA 文件 %s 中没有有效的证书", TLSCA)
+		}
+	}
+
+	switch mode {
+	case TLSModePreferred:
+		tlsConfig.InsecureSkipVerify = true
+
+	case TLSModeRequired:
+		tlsConfig.InsecureSkipVerify = true
+		if roots != nil {
+			tlsConfig.VerifyPeerCertificate = verifyChain(roots)
+		}
+
+	case TLSModeVerifyCA:
+		// 跳过标准校验中的主机名检查，自行校验证书链；未指定 CA 时使用系统证书
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = verifyChain(roots)
+
+	case TLSModeVerifyIdentity:
+		tlsConfig.RootCAs = roots
+		tlsConfig.ServerName = TLSServerName
+		if len(tlsConfig.ServerName) == 0 && cfg.Net != "unix" {
+			host, _, err := net.SplitHostPort(cfg.Addr)
+			if err != nil {
+				host = cfg.Addr
+			}
+			tlsConfig.ServerName = host
+		}
+		if len(tlsConfig.ServerName) == 0 {
+			return nil, errors.New("通过 Unix 套接字连接时 verify-identity 需要指定 --tls-server-name")
+		}
+
+	default:
+		return nil, fmt.Errorf("无效的 --tls-mode: %s（可选 disabled、preferred、required、verify-ca、verify-identity）", mode)
+	}
+
+	return tlsConfig, nil
+}
+
+// verifyChain 返回只校验证书链、不校验主机名的 VerifyPeerCertificate 函数，roots 为 nil 时使用系统证书
+func verifyChain(roots *x509.CertPool) func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("服务器没有提供证书")
+		}
+
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("解析服务器证书失败: %v", err)
+			}
+			certs[i] = cert
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+		if _, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates}); err != nil {
+			return fmt.Errorf("校验服务器证书失败: %v", err)
+		}
+
+		return nil
+	}
+}
+
+// loadServerPubKey 读取 PEM 格式的 RSA 公钥，用于 caching_sha2_password 和 sha256_password
+// 在非加密连接上传输密码
+func loadServerPubKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取服务器公钥失败: %v", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("服务器公钥 %s 不是 PEM 格式", path)
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("解析服务器公钥失败: %v", err)
+		}
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("服务器公钥 %s 不是 RSA 公钥", path)
+		}
+		return rsaKey, nil
+
+	case "RSA PUBLIC KEY":
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("解析服务器公钥失败: %v", err)
+		}
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("服务器公钥 %s 的类型 %s 不受支持", path, block.Type)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+// resetConnectionOptions 在测试结束后恢复连接相关的全局参数
+func resetConnectionOptions(t *testing.T) {
+	saved := []string{DSN, Host, User, Pass, Db, Socket, TLSMode, TLSCA, TLSCert, TLSKey, TLSServerName, ServerPubKey, PassFile, PassCommand}
+	savedPort := Port
+	Host, User, Port = "localhost", "root", 3306
+	DSN, Pass, Db, Socket, TLSMode, TLSCA, TLSCert, TLSKey, TLSServerName, ServerPubKey, PassFile, PassCommand = "", "", "", "", "", "", "", "", "", "", "", ""
+
+	t.Cleanup(func() {
+		DSN, Host, User, Pass, Db, Socket, TLSMode, TLSCA, TLSCert, TLSKey, TLSServerName, ServerPubKey, PassFile, PassCommand =
+			saved[0], saved[1], saved[2], saved[3], saved[4], saved[5], saved[6], saved[7], saved[8], saved[9], saved[10], saved[11], saved[12], saved[13]
+		Port = savedPort
+	})
+}
+
+// testCertificate 生成证书，parent 为 nil 时生成自签名的 CA 证书
+func testCertificate(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成密钥失败: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("生成证书失败: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("解析证书失败: %v", err)
+	}
+
+	return cert, key, der
+}
+
+func writePEM(t *testing.T, path, blockType string, data []byte) {
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0600); err != nil {
+		t.Fatalf("写入 %s 失败: %v", path, err)
+	}
+}
+
+func TestBuildDSN(t *testing.T) {
+	t.Run("host and port", func(t *testing.T) {
+		resetConnectionOptions(t)
+		Pass, Db = "p@ss:word", "shop"
+
+		// 调用 BuildDSN
+		dsn, err := BuildDSN()
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, "root:p@ss:word@tcp(localhost:3306)/shop?loc=Local&parseTime=true", dsn)
+	})
+
+	t.Run("unix socket", func(t *testing.T) {
+		resetConnectionOptions(t)
+		Socket = "/var/run/mysqld/mysqld.sock"
+
+		// 调用 BuildDSN
+		dsn, err := BuildDSN()
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, "root@unix(/var/run/mysqld/mysqld.sock)/?loc=Local&parseTime=true", dsn)
+	})
+
+	t.Run("password from file overrides dsn", func(t *testing.T) {
+		resetConnectionOptions(t)
+		DSN = "app:old@tcp(db:3306)/shop"
+		PassFile = filepath.Join(t.TempDir(), "password")
+		assert.NoError(t, os.WriteFile(PassFile, []byte("s3cret\n"), 0600))
+
+		// 调用 BuildDSN
+		dsn, err := BuildDSN()
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, "app:s3cret@tcp(db:3306)/shop", dsn)
+	})
+
+	t.Run("password from command", func(t *testing.T) {
+		resetConnectionOptions(t)
+		PassCommand = "printf 'from-vault\\r\\n'"
+
+		// 调用 BuildDSN
+		dsn, err := BuildDSN()
+
+		// 验证结果
+		assert.NoError(t, err)
+		cfg, err := mysql.ParseDSN(dsn)
+		assert.NoError(t, err)
+		assert.Equal(t, "from-vault", cfg.Passwd)
+	})
+
+	t.Run("password command fails", func(t *testing.T) {
+		resetConnectionOptions(t)
+		PassCommand = "echo denied >&2; exit 3"
+
+		// 调用 BuildDSN
+		_, err := BuildDSN()
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "denied")
+	})
+
+	t.Run("conflicting options", func(t *testing.T) {
+		resetConnectionOptions(t)
+		PassFile, PassCommand = "a", "b"
+		_, err := BuildDSN()
+		assert.Error(t, err)
+
+		resetConnectionOptions(t)
+		TLSCA = "ca.pem"
+		_, err = BuildDSN()
+		assert.Error(t, err)
+
+		resetConnectionOptions(t)
+		TLSMode = "always"
+		_, err = BuildDSN()
+		assert.Error(t, err)
+
+		resetConnectionOptions(t)
+		Socket, TLSMode = "/tmp/mysql.sock", TLSModeVerifyIdentity
+		_, err = BuildDSN()
+		assert.Error(t, err)
+	})
+}
+
+func TestBuildDSNTLS(t *testing.T) {
+	dir := t.TempDir()
+	caCert, caKey, caDER := testCertificate(t, "Test CA", nil, nil)
+	_, clientKey, clientDER := testCertificate(t, "client", caCert, caKey)
+	clientKeyDER, err := x509.MarshalECPrivateKey(clientKey)
+	assert.NoError(t, err)
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", caDER)
+	writePEM(t, filepath.Join(dir, "client.pem"), "CERTIFICATE", clientDER)
+	writePEM(t, filepath.Join(dir, "client-key.pem"), "EC PRIVATE KEY", clientKeyDER)
+
+	build := func(t *testing.T, mode string) *mysql.Config {
+		TLSMode = mode
+		dsn, err := BuildDSN()
+		assert.NoError(t, err)
+		cfg, err := mysql.ParseDSN(dsn)
+		assert.NoError(t, err)
+		return cfg
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		resetConnectionOptions(t)
+		DSN = "root@tcp(db:3306)/?tls=preferred"
+		cfg := build(t, TLSModeDisabled)
+		assert.Nil(t, cfg.TLS)
+		assert.False(t, cfg.AllowFallbackToPlaintext)
+	})
+
+	t.Run("preferred", func(t *testing.T) {
+		resetConnectionOptions(t)
+		cfg := build(t, TLSModePreferred)
+		assert.True(t, cfg.TLS.InsecureSkipVerify)
+		assert.True(t, cfg.AllowFallbackToPlaintext)
+	})
+
+	t.Run("required with client certificate", func(t *testing.T) {
+		resetConnectionOptions(t)
+		TLSCert, TLSKey = filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+		cfg := build(t, TLSModeRequired)
+		assert.True(t, cfg.TLS.InsecureSkipVerify)
+		assert.Nil(t, cfg.TLS.VerifyPeerCertificate)
+		assert.Len(t, cfg.TLS.Certificates, 1)
+		assert.False(t, cfg.AllowFallbackToPlaintext)
+	})
+
+	t.Run("verify-ca ignores host name", func(t *testing.T) {
+		resetConnectionOptions(t)
+		TLSCA = filepath.Join(dir, "ca.pem")
+		cfg := build(t, TLSModeVerifyCA)
+		assert.True(t, cfg.TLS.InsecureSkipVerify)
+
+		_, _, trusted := testCertificate(t, "other-host", caCert, caKey)
+		_, _, untrusted := testCertificate(t, "localhost", nil, nil)
+		assert.NoError(t, cfg.TLS.VerifyPeerCertificate([][]byte{trusted}, nil))
+		assert.Error(t, cfg.TLS.VerifyPeerCertificate([][]byte{untrusted}, nil))
+	})
+
+	t.Run("verify-identity", func(t *testing.T) {
+		resetConnectionOptions(t)
+		Host, TLSCA = "db.internal", filepath.Join(dir, "ca.pem")
+		cfg := build(t, TLSModeVerifyIdentity)
+		assert.False(t, cfg.TLS.InsecureSkipVerify)
+		assert.Equal(t, "db.internal", cfg.TLS.ServerName)
+		assert.NotNil(t, cfg.TLS.RootCAs)
+
+		TLSServerName = "mysql.example.com"
+		cfg = build(t, TLSModeVerifyIdentity)
+		assert.Equal(t, "mysql.example.com", cfg.TLS.ServerName)
+	})
+
+	t.Run("invalid CA file", func(t *testing.T) {
+		resetConnectionOptions(t)
+		TLSMode, TLSCA = TLSModeVerifyCA, filepath.Join(dir, "client-key.pem")
+		_, err := BuildDSN()
+		assert.Error(t, err)
+	})
+}
+
+func TestLoadServerPubKey(t *testing.T) {
+	dir := t.TempDir()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	pkix, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.NoError(t, err)
+	writePEM(t, filepath.Join(dir, "pkix.pem"), "PUBLIC KEY", pkix)
+	writePEM(t, filepath.Join(dir, "pkcs1.pem"), "RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&key.PublicKey))
+
+	for _, name := range []string{"pkix.pem", "pkcs1.pem"} {
+		loaded, err := loadServerPubKey(filepath.Join(dir, name))
+		assert.NoError(t, err, name)
+		assert.True(t, key.PublicKey.Equal(loaded), name)
+	}
+
+	t.Run("registered with the driver", func(t *testing.T) {
+		resetConnectionOptions(t)
+		ServerPubKey = filepath.Join(dir, "pkix.pem")
+
+		// 调用 BuildDSN
+		dsn, err := BuildDSN()
+
+		// 验证结果
+		assert.NoError(t, err)
+		cfg, err := mysql.ParseDSN(dsn)
+		assert.NoError(t, err)
+		assert.Equal(t, serverPubKeyName, cfg.ServerPubKey)
+	})
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DumpResult 汇总一次转储写入的内容
+type DumpResult struct {
+	Path   string
+	Tables int
+	Rows   int64
+	Size   int64
+	Views  []string
+	Denied []string
+	// Masked 为按脱敏策略替换了部分列的表
+	Masked []string
+}
+
+// HandleDumpTable 转储单张表。指定 where 时只导出匹配的行，文件中不包含表结构，
+// 并使用 REPLACE 语句，恢复时只覆盖这些行而不会删除表中的其他数据
+func HandleDumpTable(table, where, fileName, compression string) (string, error) {
+	ref, err := ParseTableName(table)
+	if err != nil {
+		return "", err
+	}
+	if where != "" {
+		if err := checkDumpWhere(where); err != nil {
+			return "", err
+		}
+	}
+	query := "SELECT * FROM " + ref.QuotedName()
+	if where != "" {
+		query += " WHERE " + where
+	}
+	if err := CheckStatementPolicy(query); err != nil {
+		return "", err
+	}
+	if err := CheckQueryAccess(query); err != nil {
+		return "", err
+	}
+
+	if fileName == "" {
+		fileName = defaultDumpFileName(ref.Name, compression)
+	}
+
+	result, err := writeDump(fileName, compression, ref.Schema, []TableRef{ref}, where)
+	if err != nil {
+		return "", err
+	}
+
+	return result.String(), nil
+}
+
+// HandleDumpDatabase 转储数据库中的全部基表，database 为空时使用当前数据库
+func HandleDumpDatabase(database, fileName, compression string) (string, error) {
+	db, err := GetDB()
+	if err != nil {
+		return "", err
+	}
+
+	if database != "" {
+		if database, err = ParseSchemaName(database); err != nil {
+			return "", err
+		}
+	}
+	schema := database
+	if accessEnabled() && schema == "" {
+		if schema, err = currentDatabase(); err != nil {
+			return "", err
+		}
+	}
+	if err := CheckSchemaAccess(schema); err != nil {
+		return "", err
+	}
+
+	tables := []struct {
+		Name string `db:"TABLE_NAME"`
+		Type string `db:"TABLE_TYPE"`
+	}{}
+	if err := db.Select(&tables, "SELECT TABLE_NAME, TABLE_TYPE FROM information_schema.TABLES WHERE TABLE_SCHEMA = COALESCE(NULLIF(?, ''), DATABASE()) ORDER BY TABLE_NAME", database); err != nil {
+		return "", fmt.Errorf("读取表列表失败: %v", err)
+	}
+	if len(tables) == 0 {
+		return "", fmt.Errorf("数据库 %s 不存在或没有表", database)
+	}
+
+	refs := []TableRef{}
+	views := []string{}
+	denied := []string{}
+	for _, t := range tables {
+		if t.Type != "BASE TABLE" {
+			views = append(views, t.Name)
+			continue
+		}
+		if err := CheckTableAccess(TableRef{Schema: schema, Name: t.Name}, "*"); err != nil {
+			denied = append(denied, t.Name)
+			continue
+		}
+		refs = append(refs, TableRef{Schema: database, Name: t.Name})
+	}
+
+	if fileName == "" {
+		name := database
+		if name == "" {
+			name = "database"
+		}
+		fileName = defaultDumpFileName(name, compression)
+	}
+
+	result, err := writeDump(fileName, compression, database, refs, "")
+	if err != nil {
+		return "", err
+	}
+	result.Views = views
+	result.Denied = denied
+
+	return result.String(), nil
+}
+
+func (r *DumpResult) String() string {
+	result := fmt.Sprintf("文件: %s\n表数: %d\n行数: %d\n大小: %d 字节", r.Path, r.Tables, r.Rows, r.Size)
+	if len(r.Views) > 0 {
+		result += fmt.Sprintf("\n未转储的视图: %s", strings.Join(r.Views, ", "))
+	}
+	if len(r.Denied) > 0 {
+		result += fmt.Sprintf("\n未转储的禁止访问的表: %s", strings.Join(r.Denied, ", "))
+	}
+	if len(r.Masked) > 0 {
+		result += fmt.Sprintf("\n已脱敏的表（恢复后这些列不是原始数据）: %s", strings.Join(r.Masked, ", "))
+	}
+
+	return result
+}
+
+func defaultDumpFileName(name, compression string) string {
+	fileName := fmt.Sprintf("dump_%s_%s.sql", name, time.Now().Format("20060102_150405"))
+	switch strings.ToLower(compression) {
+	case CompressionGzip:
+		fileName += ".gz"
+	case CompressionZstd:
+		fileName += ".zst"
+	}
+
+	return fileName
+}
+
+// checkDumpWhere 确保 where 只是一个条件表达式，不能借此拼接其他语句
+func checkDumpWhere(where string) error {
+	for _, tok := range TokenizeSQL(where) {
+		if tok.Is(TokenPunct, ";") || tok.IsKeyword("INTO", "UNION") {
+			return fmt.Errorf("where 只能是条件表达式: %s", where)
+		}
+	}
+
+	return nil
+}
+
+// writeDump 在一个 WITH CONSISTENT SNAPSHOT 的只读事务中依次读取各表，保证多张表的数据属于同一时间点。
+// 生成的文件与 mysqldump 的输出兼容，可以用 mysql 客户端或 restore_dump 回放
+func writeDump(fileName, compression, schema string, tables []TableRef, where string) (*DumpResult, error) {
+	compression = strings.ToLower(compression)
+	if compression == "" {
+		compression = CompressionNone
+	}
+	if err := checkExportOptions(ExportFormatSQL, compression); err != nil {
+		return nil, err
+	}
+
+	path, err := ResolveDataPath(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := GetDB()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// 连接会回到连接池，结束后恢复会话时区；隔离级别只对下一个事务生效，无需恢复
+	var timeZone string
+	if err := conn.GetContext(ctx, &timeZone, "SELECT @@SESSION.time_zone"); err != nil {
+		return nil, err
+	}
+	defer conn.ExecContext(ctx, "SET SESSION time_zone = ?", timeZone)
+
+	for _, stmt := range []string{
+		"SET SESSION time_zone = '+00:00'",
+		"SET TRANSACTION ISOLATION LEVEL REPEATABLE READ",
+		"START TRANSACTION WITH CONSISTENT SNAPSHOT, READ ONLY",
+	} {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return nil, fmt.Errorf("开启一致性快照失败: %v", err)
+		}
+	}
+	defer conn.ExecContext(ctx, "ROLLBACK")
+
+	file, err := CreateDataFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DumpResult{Path: path}
+	err = func() error {
+		compressor, err := newCompressor(file, compression)
+		if err != nil {
+			return err
+		}
+		out := bufio.NewWriter(compressor)
+
+		writeDumpHeader(out, schema)
+		for _, ref := range tables {
+			rows, masked, err := dumpTable(ctx, conn, out, ref, where)
+			if err != nil {
+				return fmt.Errorf("转储表 %s 失败: %v", ref.Name, err)
+			}
+			if masked {
+				result.Masked = append(result.Masked, ref.Name)
+			}
+			result.Tables++
+			result.Rows += rows
+		}
+		writeDumpFooter(out)
+
+		if err := out.Flush(); err != nil {
+			return fmt.Errorf("写入文件失败: %v", err)
+		}
+		if err := compressor.Close(); err != nil {
+			return fmt.Errorf("写入文件失败: %v", err)
+		}
+		return nil
+	}()
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("关闭文件失败: %v", closeErr)
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	result.Size = info.Size()
+
+	return result, nil
+}
+
+func writeDumpHeader(out *bufio.Writer, schema string) {
+	fmt.Fprintf(out, "-- go-mcp-mysql dump\n--\n-- Database: %s\n-- Dump started on %s\n\n", dumpComment(schema), time.Now().Format("2006-01-02 15:04:05"))
+	out.WriteString("/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;\n")
+	out.WriteString("/*!50503 SET NAMES utf8mb4 */;\n")
+	out.WriteString("/*!40103 SET @OLD_TIME_ZONE=@@TIME_ZONE */;\n")
+	out.WriteString("/*!40103 SET TIME_ZONE='+00:00' */;\n")
+	// 关闭外键检查，表可以按任意顺序恢复，REPLACE 也不会触发级联删除
+	out.WriteString("/*!40014 SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0 */;\n")
+	out.WriteString("/*!40101 SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO' */;\n")
+	out.WriteString("/*!40111 SET @OLD_SQL_NOTES=@@SQL_NOTES, SQL_NOTES=0 */;\n")
+}
+
+func writeDumpFooter(out *bufio.Writer) {
+	out.WriteString("\n/*!40101 SET SQL_MODE=@OLD_SQL_MODE */;\n")
+	out.WriteString("/*!40014 SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS */;\n")
+	out.WriteString("/*!40103 SET TIME_ZONE=@OLD_TIME_ZONE */;\n")
+	out.WriteString("/*!40101 SET CHARACTER_SET_CLIENT=@OLD_CHARACTER_SET_CLIENT */;\n")
+	out.WriteString("/*!40111 SET SQL_NOTES=@OLD_SQL_NOTES */;\n\n")
+	fmt.Fprintf(out, "-- Dump completed on %s\n", time.Now().Format("2006-01-02 15:04:05"))
+}
+
+// dumpTable 写出一张表的结构和数据，返回行数以及数据是否经过脱敏。生成列由服务器计算，不会出现在 INSERT 中
+func dumpTable(ctx context.Context, conn *sqlx.Conn, out *bufio.Writer, ref TableRef, where string) (int64, bool, error) {
+	// 列定义与数据在同一个快照连接上读取，避免与导出的数据不一致
+	columns, err := queryImportColumns(ctx, conn, ref.Schema, ref.Name)
+	if err != nil {
+		return 0, false, err
+	}
+	if len(columns) == 0 {
+		return 0, false, fmt.Errorf("表不存在")
+	}
+
+	names := []string{}
+	for _, col := range columns {
+		if !isGeneratedColumn(col.Extra) {
+			names = append(names, col.Name)
+		}
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s", quoteColumns(names), ref.QuotedName())
+	if where != "" {
+		query += " WHERE " + where
+	}
+	// 脱敏策略同样作用于转储的数据，脱敏后的转储不能用于完整恢复
+	masker, err := NewResultMasker(query, names)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if where == "" {
+		var name, ddl string
+		if err := conn.QueryRowxContext(ctx, "SHOW CREATE TABLE "+ref.QuotedName()).Scan(&name, &ddl); err != nil {
+			return 0, false, err
+		}
+
+		fmt.Fprintf(out, "\n--\n-- Table structure for table %s\n--\n\n", dumpComment(QuoteIdentifier(ref.Name)))
+		fmt.Fprintf(out, "DROP TABLE IF EXISTS %s;\n%s;\n", QuoteIdentifier(ref.Name), ddl)
+	}
+
+	if where != "" {
+		fmt.Fprintf(out, "\n--\n-- Dumping data for table %s\n-- WHERE:  %s\n--\n\n", dumpComment(QuoteIdentifier(ref.Name)), dumpComment(where))
+	} else {
+		fmt.Fprintf(out, "\n--\n-- Dumping data for table %s\n--\n\n", dumpComment(QuoteIdentifier(ref.Name)))
+	}
+
+	rows, err := conn.QueryxContext(ctx, query)
+	if err != nil {
+		return 0, false, err
+	}
+	defer rows.Close()
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return 0, false, err
+	}
+	for i := range types {
+		if masker.Masked(i) {
+			// 脱敏后的值都是字符串
+			types[i] = nil
+		}
+	}
+
+	w := newTextExportWriter(out, ExportFormatSQL, ref.Name)
+	if where != "" {
+		w.verb = "REPLACE"
+	}
+	if err := w.WriteHeader(names, types); err != nil {
+		return 0, false, err
+	}
+
+	var count int64
+	values := make([]interface{}, len(names))
+	dest := make([]interface{}, len(names))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return count, false, err
+		}
+		masker.MaskRow(values)
+		if err := w.WriteRow(values); err != nil {
+			return count, false, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, false, err
+	}
+
+	return count, masker != nil, w.Close()
+}
+
+// HandleRestoreDump 在一个独占连接上逐条回放数据目录中的 SQL 文件。
+// 表结构语句会隐式提交，失败时之前的语句无法回滚
+func HandleRestoreDump(fileName, database string) (string, error) {
+	path, err := ResolveDataPath(fileName)
+	if err != nil {
+		return "", err
+	}
+	if database != "" {
+		if database, err = ParseSchemaName(database); err != nil {
+			return "", err
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("打开转储文件失败: %v", err)
+	}
+	defer file.Close()
+
+	reader, err := newDecompressor(file, fileName)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	db, err := GetDB()
+	if err != nil {
+		return "", err
+	}
+
+	ctx := context.Background()
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	// 回放会修改连接的当前数据库和 FOREIGN_KEY_CHECKS、SQL_MODE、TIME_ZONE 等会话变量，
+	// 中途失败时文件末尾的恢复语句不会执行，因此结束后丢弃该连接，不放回连接池
+	defer conn.Raw(func(any) error { return driver.ErrBadConn })
+
+	if database != "" {
+		if err := CheckSchemaAccess(database); err != nil {
+			return "", err
+		}
+		if _, err := conn.ExecContext(ctx, "USE "+QuoteIdentifier(database)); err != nil {
+			return "", fmt.Errorf("切换到数据库 %s 失败: %v", database, err)
+		}
+	}
+
+	// 回放失败时之前的语句已经生效，无论成功与否都清空查询缓存
+	defer Cache.Clear()
+
+	count := 0
+	var affected int64
+	// 转储中的 USE 会切换连接的当前数据库，未写库名的表按该连接的当前数据库检查
+	connDatabase := func() (string, error) {
+		var name string
+		if err := conn.GetContext(ctx, &name, "SELECT IFNULL(DATABASE(), '')"); err != nil {
+			return "", fmt.Errorf("读取当前数据库失败: %v", err)
+		}
+		return name, nil
+	}
+
+	err = ReadStatements(reader, func(stmt string) error {
+		count++
+		if err := CheckStatementPolicy(stmt); err != nil {
+			return fmt.Errorf("第 %d 条语句被拒绝（之前的语句已生效）: %v\n%s", count, err, abbreviate(stmt, 200))
+		}
+		if err := checkQueryAccess(stmt, connDatabase); err != nil {
+			return fmt.Errorf("第 %d 条语句被拒绝（之前的语句已生效）: %v\n%s", count, err, abbreviate(stmt, 200))
+		}
+		result, err := conn.ExecContext(ctx, stmt)
+		if err != nil {
+			return fmt.Errorf("第 %d 条语句执行失败（之前的语句已生效）: %v\n%s", count, err, abbreviate(stmt, 200))
+		}
+		if n, err := result.RowsAffected(); err == nil {
+			affected += n
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("已执行 %d 条语句，影响 %d 行", count, affected), nil
+}
+
+// dumpComment 去掉换行，避免名称或条件中的换行让注释之后的内容被当作语句执行
+func dumpComment(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+func abbreviate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+
+	return string(runes[:n]) + "..."
+}
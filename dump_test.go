@@ -0,0 +1,185 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func expectDumpSnapshot(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("SELECT @@SESSION.time_zone").WillReturnRows(sqlmock.NewRows([]string{"tz"}).AddRow("SYSTEM"))
+	mock.ExpectExec("SET SESSION time_zone = '\\+00:00'").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SET TRANSACTION ISOLATION LEVEL REPEATABLE READ").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("START TRANSACTION WITH CONSISTENT SNAPSHOT, READ ONLY").WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func expectDumpRelease(mock sqlmock.Sqlmock) {
+	mock.ExpectExec("ROLLBACK").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SET SESSION time_zone = \\?").WithArgs("SYSTEM").WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func dumpColumnRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"COLUMN_NAME", "DATA_TYPE", "COLUMN_TYPE", "IS_NULLABLE", "COLUMN_DEFAULT", "EXTRA", "CHARACTER_MAXIMUM_LENGTH"}).
+		AddRow("id", "int", "int", "NO", nil, "auto_increment", nil).
+		AddRow("name", "varchar", "varchar(20)", "YES", nil, "", 20).
+		AddRow("upper_name", "varchar", "varchar(20)", "YES", nil, "VIRTUAL GENERATED", 20)
+}
+
+func TestHandleDumpTable(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+	dir := setupDataDir(t)
+
+	t.Run("full table", func(t *testing.T) {
+		// 设置模拟预期
+		expectDumpSnapshot(mock)
+		mock.ExpectQuery("FROM information_schema.COLUMNS").WithArgs("", "users").WillReturnRows(dumpColumnRows())
+		mock.ExpectQuery("SHOW CREATE TABLE `users`").WillReturnRows(sqlmock.NewRows([]string{"Table", "Create Table"}).
+			AddRow("users", "CREATE TABLE `users` (\n  `id` int NOT NULL\n)"))
+		mock.ExpectQuery("SELECT `id`, `name` FROM `users`$").WillReturnRows(mock.NewRowsWithColumnDefinition(
+			mock.NewColumn("id").OfType("INT", int64(0)),
+			mock.NewColumn("name").OfType("VARCHAR", ""),
+		).AddRow([]byte("1"), []byte("it's")).AddRow([]byte("2"), nil))
+		expectDumpRelease(mock)
+
+		// 调用 HandleDumpTable
+		result, err := HandleDumpTable("users", "", "users.sql", "")
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "表数: 1\n行数: 2")
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		content, _ := os.ReadFile(filepath.Join(dir, "users.sql"))
+		assert.Contains(t, string(content), "DROP TABLE IF EXISTS `users`;\nCREATE TABLE `users` (\n  `id` int NOT NULL\n);\n")
+		assert.Contains(t, string(content), "INSERT INTO `users` (`id`, `name`) VALUES\n(1, 'it''s'),\n(2, NULL);\n")
+		assert.Contains(t, string(content), "FOREIGN_KEY_CHECKS=0")
+	})
+
+	t.Run("rows matching where", func(t *testing.T) {
+		// 设置模拟预期
+		expectDumpSnapshot(mock)
+		mock.ExpectQuery("FROM information_schema.COLUMNS").WithArgs("shop", "users").WillReturnRows(dumpColumnRows())
+		mock.ExpectQuery("SELECT `id`, `name` FROM `shop`.`users` WHERE id > 10").WillReturnRows(mock.NewRowsWithColumnDefinition(
+			mock.NewColumn("id").OfType("INT", int64(0)),
+			mock.NewColumn("name").OfType("VARCHAR", ""),
+		).AddRow([]byte("11"), []byte("k")))
+		expectDumpRelease(mock)
+
+		// 调用 HandleDumpTable
+		_, err := HandleDumpTable("shop.users", "id > 10", "backup.sql", "")
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		content, _ := os.ReadFile(filepath.Join(dir, "backup.sql"))
+		assert.NotContains(t, string(content), "DROP TABLE")
+		assert.Contains(t, string(content), "REPLACE INTO `users` (`id`, `name`) VALUES\n(11, 'k');\n")
+	})
+
+	t.Run("masked columns", func(t *testing.T) {
+		setupMasking(t, `{"rules": [{"column": "users.name", "strategy": "redact"}]}`)
+
+		// 设置模拟预期
+		expectDumpSnapshot(mock)
+		mock.ExpectQuery("FROM information_schema.COLUMNS").WithArgs("", "users").WillReturnRows(dumpColumnRows())
+		mock.ExpectQuery("SELECT `id`, `name` FROM `users` WHERE id = 1").WillReturnRows(mock.NewRowsWithColumnDefinition(
+			mock.NewColumn("id").OfType("INT", int64(0)),
+			mock.NewColumn("name").OfType("VARCHAR", ""),
+		).AddRow([]byte("1"), []byte("alice")))
+		expectDumpRelease(mock)
+
+		// 调用 HandleDumpTable
+		result, err := HandleDumpTable("users", "id = 1", "masked.sql", "")
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "已脱敏的表（恢复后这些列不是原始数据）: users")
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		content, _ := os.ReadFile(filepath.Join(dir, "masked.sql"))
+		assert.Contains(t, string(content), "(1, '[REDACTED]');\n")
+		assert.NotContains(t, string(content), "alice")
+	})
+
+	t.Run("rejects statements in where", func(t *testing.T) {
+		_, err := HandleDumpTable("users", "1=1; DROP TABLE users", "", "")
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "where 只能是条件表达式")
+	})
+}
+
+func TestHandleDumpDatabase(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+	setupDataDir(t)
+
+	// 设置模拟预期
+	mock.ExpectQuery("FROM information_schema.TABLES").WithArgs("shop").WillReturnRows(sqlmock.NewRows([]string{"TABLE_NAME", "TABLE_TYPE"}).
+		AddRow("orders", "BASE TABLE").
+		AddRow("order_view", "VIEW"))
+	expectDumpSnapshot(mock)
+	mock.ExpectQuery("FROM information_schema.COLUMNS").WithArgs("shop", "orders").WillReturnRows(dumpColumnRows())
+	mock.ExpectQuery("SHOW CREATE TABLE `shop`.`orders`").WillReturnRows(sqlmock.NewRows([]string{"Table", "Create Table"}).AddRow("orders", "CREATE TABLE `orders` (`id` int)"))
+	mock.ExpectQuery("SELECT `id`, `name` FROM `shop`.`orders`").WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+	expectDumpRelease(mock)
+
+	// 调用 HandleDumpDatabase
+	result, err := HandleDumpDatabase("shop", "shop.sql.gz", "gzip")
+
+	// 验证结果
+	assert.NoError(t, err)
+	assert.Contains(t, result, "表数: 1\n行数: 0")
+	assert.Contains(t, result, "未转储的视图: order_view")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleRestoreDump(t *testing.T) {
+	dir := setupDataDir(t)
+
+	writeDataFile(t, dir, "users.sql", "-- go-mcp-mysql dump\n/*!40101 SET NAMES utf8mb4 */;\nDROP TABLE IF EXISTS `users`;\nCREATE TABLE `users` (\n  `id` int COMMENT 'a;b'\n);\nINSERT INTO `users` (`id`) VALUES\n(1),\n(2);\n")
+
+	t.Run("replays statements", func(t *testing.T) {
+		_, mock, cleanup := setupMockDB(t)
+		defer cleanup()
+
+		// 设置模拟预期：回放结束后连接被丢弃，不放回连接池
+		mock.ExpectExec("USE `shop`").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("SET NAMES utf8mb4").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("DROP TABLE IF EXISTS `users`").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("CREATE TABLE `users`").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO `users`").WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectClose()
+
+		// 调用 HandleRestoreDump
+		result, err := HandleRestoreDump("users.sql", "shop")
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, "已执行 4 条语句，影响 2 行", result)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("reports failing statement", func(t *testing.T) {
+		_, mock, cleanup := setupMockDB(t)
+		defer cleanup()
+
+		// 设置模拟预期：失败时会话变量可能没有恢复，连接同样被丢弃
+		mock.ExpectExec("SET NAMES utf8mb4").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("DROP TABLE IF EXISTS `users`").WillReturnError(assert.AnError)
+		mock.ExpectClose()
+
+		// 调用 HandleRestoreDump
+		_, err := HandleRestoreDump("users.sql", "")
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "第 2 条语句执行失败")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	ExplainMaxFullScanRows int64
+	ExplainMaxExaminedRows int64
+	ExplainMaxFilesortRows int64
+)
+
+// ExplainRows 返回该行执行计划预计扫描的行数，无法解析时返回 0
+func (r ExplainResult) ExplainRows() int64 {
+	if r.Rows == nil {
+		return 0
+	}
+
+	n, err := strconv.ParseInt(*r.Rows, 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return n
+}
+
+func (r ExplainResult) tableName() string {
+	if r.Table == nil {
+		return "<unknown>"
+	}
+
+	return *r.Table
+}
+
+// CheckExplainType 检查执行计划的语句类型是否与预期一致，支持 JOIN 和子查询产生的多行计划
+func CheckExplainType(result []ExplainResult, expect string) error {
+	if len(result) == 0 {
+		return fmt.Errorf("无法检查查询计划，拒绝执行")
+	}
+
+	match := true
+	switch expect {
+	case StatementTypeInsert, StatementTypeUpdate, StatementTypeDelete:
+		// 多表写入或带派生表的写入中，语句本身不一定是第一行，
+		// 只要有一行是预期的写入类型且没有其他写入类型即可
+		match = false
+		for _, row := range result {
+			if row.SelectType == nil {
+				continue
+			}
+			switch *row.SelectType {
+			case expect:
+				match = true
+			case StatementTypeInsert, StatementTypeUpdate, StatementTypeDelete:
+				return fmt.Errorf("查询计划不符合预期模式，拒绝执行")
+			}
+		}
+	default:
+		// for SELECT type query, the select_type will be multiple values
+		// here we check that no row is INSERT, UPDATE or DELETE
+		for _, row := range result {
+			if row.SelectType == nil {
+				continue
+			}
+			for _, typ := range []string{StatementTypeInsert, StatementTypeUpdate, StatementTypeDelete} {
+				if *row.SelectType == typ {
+					match = false
+				}
+			}
+		}
+	}
+
+	if !match {
+		return fmt.Errorf("查询计划不符合预期模式，拒绝执行")
+	}
+
+	return nil
+}
+
+// CheckExplainPolicies 按照配置的规则检查执行计划质量，返回的错误说明触发了哪条规则
+func CheckExplainPolicies(result []ExplainResult) error {
+	for _, row := range result {
+		rows := row.ExplainRows()
+
+		if ExplainMaxFullScanRows > 0 && row.Type != nil && *row.Type == "ALL" && rows > ExplainMaxFullScanRows {
+			return fmt.Errorf("查询计划违反规则 explain-max-full-scan-rows: 表 %s 全表扫描预计 %d 行，超过上限 %d，拒绝执行", row.tableName(), rows, ExplainMaxFullScanRows)
+		}
+
+		if ExplainMaxFilesortRows > 0 && row.Extra != nil && strings.Contains(*row.Extra, "Using filesort") && rows > ExplainMaxFilesortRows {
+			return fmt.Errorf("查询计划违反规则 explain-max-filesort-rows: 表 %s 使用文件排序且预计 %d 行，超过上限 %d，拒绝执行", row.tableName(), rows, ExplainMaxFilesortRows)
+		}
+	}
+
+	if ExplainMaxExaminedRows > 0 {
+		if examined := EstimateExaminedRows(result); examined > ExplainMaxExaminedRows {
+			return fmt.Errorf("查询计划违反规则 explain-max-examined-rows: 预计检查 %d 行，超过上限 %d，拒绝执行", examined, ExplainMaxExaminedRows)
+		}
+	}
+
+	return nil
+}
+
+// EstimateExaminedRows 估算整个语句检查的行数。同一 id 的表以嵌套循环连接，
+// 因此取各表行数的乘积；不同 id 的子查询相互独立，结果相加
+func EstimateExaminedRows(result []ExplainResult) int64 {
+	order := []string{}
+	groups := map[string]int64{}
+	for _, row := range result {
+		id := ""
+		if row.Id != nil {
+			id = *row.Id
+		}
+
+		rows := row.ExplainRows()
+		if rows == 0 {
+			continue
+		}
+
+		product, exists := groups[id]
+		if !exists {
+			order = append(order, id)
+			groups[id] = rows
+			continue
+		}
+		if product > math.MaxInt64/rows {
+			groups[id] = math.MaxInt64
+			continue
+		}
+		groups[id] = product * rows
+	}
+
+	var total int64
+	for _, id := range order {
+		if total > math.MaxInt64-groups[id] {
+			return math.MaxInt64
+		}
+		total += groups[id]
+	}
+
+	return total
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var explainColumns = []string{"id", "select_type", "table", "partitions", "type", "possible_keys", "key", "key_len", "ref", "rows", "filtered", "Extra"}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestHandleExplainMultiRow(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	originalWithExplainCheck := WithExplainCheck
+	WithExplainCheck = true
+	defer func() { WithExplainCheck = originalWithExplainCheck }()
+
+	t.Run("join query", func(t *testing.T) {
+		// 设置模拟预期
+		explainRows := sqlmock.NewRows(explainColumns).
+			AddRow("1", "SIMPLE", "orders", nil, "ALL", nil, nil, nil, nil, "100", "100.00", nil).
+			AddRow("1", "SIMPLE", "users", nil, "eq_ref", "PRIMARY", "PRIMARY", "4", "orders.user_id", "1", "100.00", nil)
+
+		mock.ExpectQuery("EXPLAIN").WillReturnRows(explainRows)
+
+		// 调用 HandleExplain
+		err := HandleExplain(DB, "SELECT * FROM orders JOIN users ON users.id = orders.user_id", StatementTypeSelect)
+
+		// 验证结果
+		assert.NoError(t, err)
+	})
+
+	t.Run("update with subquery", func(t *testing.T) {
+		// 设置模拟预期
+		explainRows := sqlmock.NewRows(explainColumns).
+			AddRow("1", "UPDATE", "users", nil, "range", "PRIMARY", "PRIMARY", "4", nil, "10", "100.00", "Using where").
+			AddRow("2", "SUBQUERY", "orders", nil, "ref", "idx_status", "idx_status", "4", "const", "10", "100.00", nil)
+
+		mock.ExpectQuery("EXPLAIN").WillReturnRows(explainRows)
+
+		// 调用 HandleExplain
+		err := HandleExplain(DB, "UPDATE users SET vip = 1 WHERE id IN (SELECT user_id FROM orders WHERE status = 1)", StatementTypeUpdate)
+
+		// 验证结果
+		assert.NoError(t, err)
+	})
+
+	t.Run("full scan rule", func(t *testing.T) {
+		originalMax := ExplainMaxFullScanRows
+		ExplainMaxFullScanRows = 1000
+		defer func() { ExplainMaxFullScanRows = originalMax }()
+
+		// 设置模拟预期
+		explainRows := sqlmock.NewRows(explainColumns).
+			AddRow("1", "SIMPLE", "orders", nil, "ALL", nil, nil, nil, nil, "50000", "10.00", "Using where").
+			AddRow("1", "SIMPLE", "users", nil, "eq_ref", "PRIMARY", "PRIMARY", "4", "orders.user_id", "1", "100.00", nil)
+
+		mock.ExpectQuery("EXPLAIN").WillReturnRows(explainRows)
+
+		// 调用 HandleExplain
+		err := HandleExplain(DB, "SELECT * FROM orders JOIN users ON users.id = orders.user_id WHERE orders.note = 'x'", StatementTypeSelect)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "explain-max-full-scan-rows")
+		assert.Contains(t, err.Error(), "orders")
+	})
+}
+
+func TestCheckExplainType(t *testing.T) {
+	t.Run("empty plan", func(t *testing.T) {
+		err := CheckExplainType([]ExplainResult{}, StatementTypeSelect)
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "无法检查查询计划")
+	})
+
+	t.Run("select containing write row", func(t *testing.T) {
+		result := []ExplainResult{
+			{Id: strPtr("1"), SelectType: strPtr("PRIMARY")},
+			{Id: strPtr("2"), SelectType: strPtr("DELETE")},
+		}
+
+		err := CheckExplainType(result, StatementTypeSelect)
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "查询计划不符合预期模式")
+	})
+
+	t.Run("delete expected but got select", func(t *testing.T) {
+		result := []ExplainResult{
+			{Id: strPtr("1"), SelectType: strPtr("SIMPLE")},
+		}
+
+		err := CheckExplainType(result, StatementTypeDelete)
+
+		assert.Error(t, err)
+	})
+
+	t.Run("update with derived table first", func(t *testing.T) {
+		result := []ExplainResult{
+			{Id: strPtr("1"), SelectType: strPtr("PRIMARY")},
+			{Id: strPtr("1"), SelectType: strPtr("UPDATE")},
+			{Id: strPtr("2"), SelectType: strPtr("DERIVED")},
+		}
+
+		err := CheckExplainType(result, StatementTypeUpdate)
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("update containing delete row", func(t *testing.T) {
+		result := []ExplainResult{
+			{Id: strPtr("1"), SelectType: strPtr("UPDATE")},
+			{Id: strPtr("1"), SelectType: strPtr("DELETE")},
+		}
+
+		err := CheckExplainType(result, StatementTypeUpdate)
+
+		assert.Error(t, err)
+	})
+}
+
+func TestCheckExplainPolicies(t *testing.T) {
+	originalFullScan := ExplainMaxFullScanRows
+	originalExamined := ExplainMaxExaminedRows
+	originalFilesort := ExplainMaxFilesortRows
+	defer func() {
+		ExplainMaxFullScanRows = originalFullScan
+		ExplainMaxExaminedRows = originalExamined
+		ExplainMaxFilesortRows = originalFilesort
+	}()
+
+	reset := func() {
+		ExplainMaxFullScanRows = 0
+		ExplainMaxExaminedRows = 0
+		ExplainMaxFilesortRows = 0
+	}
+
+	t.Run("no rules configured", func(t *testing.T) {
+		reset()
+		result := []ExplainResult{
+			{Id: strPtr("1"), Table: strPtr("big"), Type: strPtr("ALL"), Rows: strPtr("10000000"), Extra: strPtr("Using filesort")},
+		}
+
+		assert.NoError(t, CheckExplainPolicies(result))
+	})
+
+	t.Run("full scan on small table", func(t *testing.T) {
+		reset()
+		ExplainMaxFullScanRows = 1000
+		result := []ExplainResult{
+			{Id: strPtr("1"), Table: strPtr("dict"), Type: strPtr("ALL"), Rows: strPtr("20")},
+		}
+
+		assert.NoError(t, CheckExplainPolicies(result))
+	})
+
+	t.Run("filesort on large table", func(t *testing.T) {
+		reset()
+		ExplainMaxFilesortRows = 1000
+		result := []ExplainResult{
+			{Id: strPtr("1"), Table: strPtr("orders"), Type: strPtr("range"), Rows: strPtr("5000"), Extra: strPtr("Using where; Using filesort")},
+		}
+
+		err := CheckExplainPolicies(result)
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "explain-max-filesort-rows")
+		assert.Contains(t, err.Error(), "orders")
+	})
+
+	t.Run("examined rows cap", func(t *testing.T) {
+		reset()
+		ExplainMaxExaminedRows = 10000
+		result := []ExplainResult{
+			{Id: strPtr("1"), Table: strPtr("a"), Type: strPtr("range"), Rows: strPtr("500")},
+			{Id: strPtr("1"), Table: strPtr("b"), Type: strPtr("ref"), Rows: strPtr("50")},
+		}
+
+		err := CheckExplainPolicies(result)
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "explain-max-examined-rows")
+		assert.Contains(t, err.Error(), "25000")
+	})
+}
+
+func TestEstimateExaminedRows(t *testing.T) {
+	t.Run("join and subquery", func(t *testing.T) {
+		result := []ExplainResult{
+			{Id: strPtr("1"), Rows: strPtr("100")},
+			{Id: strPtr("1"), Rows: strPtr("3")},
+			{Id: strPtr("2"), Rows: strPtr("40")},
+			{Id: strPtr("2"), Rows: nil},
+		}
+
+		assert.Equal(t, int64(340), EstimateExaminedRows(result))
+	})
+
+	t.Run("overflow saturates", func(t *testing.T) {
+		result := []ExplainResult{
+			{Id: strPtr("1"), Rows: strPtr("9223372036854775807")},
+			{Id: strPtr("1"), Rows: strPtr("2")},
+			{Id: strPtr("2"), Rows: strPtr("2")},
+		}
+
+		assert.Equal(t, int64(9223372036854775807), EstimateExaminedRows(result))
+	})
+}
@@ -0,0 +1,587 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/parquet-go/parquet-go"
+)
+
+const (
+	ExportFormatCSV     = "csv"
+	ExportFormatTSV     = "tsv"
+	ExportFormatJSONL   = "jsonl"
+	ExportFormatParquet = "parquet"
+	ExportFormatSQL     = "sql"
+
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+
+	exportPreviewRows     = 5
+	exportInsertBatchRows = 500
+	exportTimeLayout      = "2006-01-02 15:04:05.999999"
+)
+
+// exportWriter 以流的方式把结果集写成某种文件格式
+type exportWriter interface {
+	WriteHeader(cols []string, types []*sql.ColumnType) error
+	WriteRow(values []interface{}) error
+	Close() error
+}
+
+// HandleExportQuery 将 SELECT 的结果逐行写入数据目录中的文件，只返回文件信息和前几行预览
+func HandleExportQuery(query, format, fileName, compression, table string) (string, error) {
+	format = strings.ToLower(format)
+	if format == "" {
+		format = ExportFormatCSV
+	}
+	compression = strings.ToLower(compression)
+	if compression == "" {
+		compression = CompressionNone
+	}
+
+	if err := checkExportOptions(format, compression); err != nil {
+		return "", err
+	}
+	if err := checkExportQuery(query); err != nil {
+		return "", err
+	}
+	if err := CheckStatementPolicy(query); err != nil {
+		return "", err
+	}
+	if err := CheckQueryAccess(query); err != nil {
+		return "", err
+	}
+	if format == ExportFormatSQL && table == "" {
+		table = exportTableName(query)
+	}
+
+	if fileName == "" {
+		fileName = defaultExportFileName(format, compression)
+	}
+	path, err := ResolveDataPath(fileName)
+	if err != nil {
+		return "", err
+	}
+
+	db, err := GetDB()
+	if err != nil {
+		return "", err
+	}
+
+	if err := HandleExplain(db, query, StatementTypeSelect); err != nil {
+		return "", err
+	}
+
+	rows, err := db.Queryx(query)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return "", err
+	}
+
+	masker, err := NewResultMasker(query, cols)
+	if err != nil {
+		return "", err
+	}
+	for i := range types {
+		if masker.Masked(i) {
+			// 脱敏后的值都是字符串
+			types[i] = nil
+		}
+	}
+
+	file, err := CreateDataFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	count, preview, err := writeExport(file, rows.Rows, cols, types, masker, format, compression, table)
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("关闭导出文件失败: %v", closeErr)
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	previewCSV, err := MapToCSV(preview, cols)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("文件: %s\n行数: %d\n大小: %d 字节\n预览（前 %d 行）:\n%s", path, count, info.Size(), len(preview), previewCSV), nil
+}
+
+func writeExport(file io.Writer, rows *sql.Rows, cols []string, types []*sql.ColumnType, masker *ResultMasker, format, compression, table string) (int64, []map[string]interface{}, error) {
+	var w exportWriter
+	var compressor io.WriteCloser
+	if format == ExportFormatParquet {
+		w = newParquetExportWriter(file, compression)
+	} else {
+		var err error
+		if compressor, err = newCompressor(file, compression); err != nil {
+			return 0, nil, err
+		}
+		w = newTextExportWriter(compressor, format, table)
+	}
+
+	if err := w.WriteHeader(cols, types); err != nil {
+		return 0, nil, fmt.Errorf("写入表头失败: %v", err)
+	}
+
+	var count int64
+	preview := []map[string]interface{}{}
+	values := make([]interface{}, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return count, nil, err
+		}
+		masker.MaskRow(values)
+		if err := w.WriteRow(values); err != nil {
+			return count, nil, fmt.Errorf("写入第 %d 行失败: %v", count+1, err)
+		}
+
+		if len(preview) < exportPreviewRows {
+			row := map[string]interface{}{}
+			for i, col := range cols {
+				switch v := values[i].(type) {
+				case []byte:
+					row[col] = string(v)
+				default:
+					row[col] = v
+				}
+			}
+			preview = append(preview, row)
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return count, nil, fmt.Errorf("写入文件失败: %v", err)
+	}
+	if compressor != nil {
+		if err := compressor.Close(); err != nil {
+			return count, nil, fmt.Errorf("写入文件失败: %v", err)
+		}
+	}
+
+	return count, preview, nil
+}
+
+func checkExportOptions(format, compression string) error {
+	switch format {
+	case ExportFormatCSV, ExportFormatTSV, ExportFormatJSONL, ExportFormatParquet, ExportFormatSQL:
+	default:
+		return fmt.Errorf("不支持的导出格式: %s（可选 csv、tsv、jsonl、parquet、sql）", format)
+	}
+
+	switch compression {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+	default:
+		return fmt.Errorf("不支持的压缩方式: %s（可选 none、gzip、zstd）", compression)
+	}
+
+	return nil
+}
+
+// checkExportQuery 只允许单条 SELECT，且不能带 INTO 把结果写到服务器端
+func checkExportQuery(query string) error {
+	statements := SplitStatements(query)
+	if len(statements) != 1 {
+		return fmt.Errorf("只能导出单条 SELECT 语句")
+	}
+
+	tokens := TokenizeSQL(statements[0])
+	if len(tokens) == 0 || !(tokens[0].IsKeyword("SELECT", "WITH") || tokens[0].Is(TokenPunct, "(")) {
+		return fmt.Errorf("只能导出 SELECT 语句")
+	}
+	for _, tok := range tokens {
+		if tok.IsKeyword("INTO") {
+			return fmt.Errorf("导出语句不能包含 INTO")
+		}
+	}
+
+	return nil
+}
+
+// exportTableName 取查询中的第一张表作为 INSERT 语句的目标表
+func exportTableName(query string) string {
+	for _, scope := range AnalyzeQueryScopes(query) {
+		if len(scope.Tables) > 0 {
+			return scope.Tables[0].Name
+		}
+	}
+
+	return "export"
+}
+
+func defaultExportFileName(format, compression string) string {
+	name := fmt.Sprintf("export_%s.%s", time.Now().Format("20060102_150405"), format)
+	if format == ExportFormatParquet {
+		return name
+	}
+
+	switch compression {
+	case CompressionGzip:
+		name += ".gz"
+	case CompressionZstd:
+		name += ".zst"
+	}
+
+	return name
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func newCompressor(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+// exportText 把扫描出的值转换成文本，NULL 由各格式自行处理
+func exportText(value interface{}) string {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	case time.Time:
+		return v.Format(exportTimeLayout)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+type textExportWriter struct {
+	format string
+	table  string
+	verb   string
+	buf    *bufio.Writer
+	csv    *csv.Writer
+	cols   []string
+	types  []*sql.ColumnType
+	batch  int
+}
+
+func newTextExportWriter(w io.Writer, format, table string) *textExportWriter {
+	t := &textExportWriter{format: format, table: table, verb: "INSERT", buf: bufio.NewWriter(w)}
+	if format == ExportFormatCSV || format == ExportFormatTSV {
+		t.csv = csv.NewWriter(t.buf)
+		if format == ExportFormatTSV {
+			t.csv.Comma = '\t'
+		}
+	}
+
+	return t
+}
+
+func (t *textExportWriter) WriteHeader(cols []string, types []*sql.ColumnType) error {
+	t.cols = cols
+	t.types = types
+	if t.csv != nil {
+		return t.csv.Write(cols)
+	}
+
+	return nil
+}
+
+func (t *textExportWriter) WriteRow(values []interface{}) error {
+	switch t.format {
+	case ExportFormatCSV, ExportFormatTSV:
+		record := make([]string, len(values))
+		for i, v := range values {
+			if v == nil {
+				// 与 LOAD DATA 的约定一致，TSV 中的 NULL 写作 \N
+				if t.format == ExportFormatTSV {
+					record[i] = `\N`
+				}
+				continue
+			}
+			record[i] = exportText(v)
+		}
+		return t.csv.Write(record)
+	case ExportFormatJSONL:
+		return t.writeJSON(values)
+	default:
+		return t.writeInsert(values)
+	}
+}
+
+func (t *textExportWriter) writeJSON(values []interface{}) error {
+	t.buf.WriteByte('{')
+	for i, col := range t.cols {
+		if i > 0 {
+			t.buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(col)
+		t.buf.Write(key)
+		t.buf.WriteByte(':')
+
+		var value interface{}
+		if values[i] != nil {
+			if isNumericColumn(t.types[i]) {
+				value = json.Number(exportText(values[i]))
+			} else {
+				value = exportText(values[i])
+			}
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		t.buf.Write(encoded)
+	}
+	t.buf.WriteString("}\n")
+
+	return nil
+}
+
+func (t *textExportWriter) writeInsert(values []interface{}) error {
+	if t.batch == 0 {
+		cols := make([]string, len(t.cols))
+		for i, col := range t.cols {
+			cols[i] = QuoteIdentifier(col)
+		}
+		fmt.Fprintf(t.buf, "%s INTO %s (%s) VALUES\n", t.verb, QuoteIdentifier(t.table), strings.Join(cols, ", "))
+	} else {
+		t.buf.WriteString(",\n")
+	}
+
+	literals := make([]string, len(values))
+	for i, v := range values {
+		switch {
+		case v == nil:
+			literals[i] = "NULL"
+		case isNumericColumn(t.types[i]):
+			literals[i] = exportText(v)
+		case isBinaryColumn(t.types[i]) && exportText(v) != "":
+			literals[i] = fmt.Sprintf("0x%X", exportText(v))
+		default:
+			literals[i] = QuoteString(exportText(v))
+		}
+	}
+	t.buf.WriteString("(" + strings.Join(literals, ", ") + ")")
+
+	t.batch++
+	if t.batch == exportInsertBatchRows {
+		t.batch = 0
+		_, err := t.buf.WriteString(";\n")
+		return err
+	}
+
+	return nil
+}
+
+func (t *textExportWriter) Close() error {
+	if t.csv != nil {
+		t.csv.Flush()
+		if err := t.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if t.format == ExportFormatSQL && t.batch > 0 {
+		t.buf.WriteString(";\n")
+	}
+
+	return t.buf.Flush()
+}
+
+// isNumericColumn 判断列是否可以不加引号直接写出
+func isNumericColumn(typ *sql.ColumnType) bool {
+	if typ == nil {
+		return false
+	}
+
+	switch strings.TrimPrefix(typ.DatabaseTypeName(), "UNSIGNED ") {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "YEAR", "DECIMAL", "FLOAT", "DOUBLE":
+		return true
+	default:
+		return false
+	}
+}
+
+// isBinaryColumn 判断列是否需要以十六进制字面量写出，避免二进制数据受连接字符集影响
+func isBinaryColumn(typ *sql.ColumnType) bool {
+	if typ == nil {
+		return false
+	}
+
+	switch typ.DatabaseTypeName() {
+	case "BINARY", "VARBINARY", "TINYBLOB", "BLOB", "MEDIUMBLOB", "LONGBLOB", "BIT", "GEOMETRY":
+		return true
+	default:
+		return false
+	}
+}
+
+// parquetExportWriter 按 MySQL 列类型生成可空的 parquet 列：整数写为 INT64，浮点写为 DOUBLE，
+// DECIMAL 及其余类型写为字符串以避免精度损失
+type parquetExportWriter struct {
+	output      io.Writer
+	compression string
+	writer      *parquet.Writer
+	kinds       []parquet.Kind
+	columnIndex []int
+}
+
+func newParquetExportWriter(w io.Writer, compression string) *parquetExportWriter {
+	return &parquetExportWriter{output: w, compression: compression}
+}
+
+func (p *parquetExportWriter) WriteHeader(cols []string, types []*sql.ColumnType) error {
+	group := parquet.Group{}
+	names := make([]string, len(cols))
+	p.kinds = make([]parquet.Kind, len(cols))
+	for i, col := range cols {
+		name := col
+		for n := 2; group[name] != nil; n++ {
+			name = fmt.Sprintf("%s_%d", col, n)
+		}
+		names[i] = name
+
+		typeName := ""
+		if types[i] != nil {
+			typeName = types[i].DatabaseTypeName()
+		}
+
+		var node parquet.Node
+		switch {
+		case typeName == "UNSIGNED BIGINT":
+			// 超出 INT64 范围，按字符串保存
+			node = parquet.String()
+		case isIntegerType(typeName):
+			node = parquet.Int(64)
+		case typeName == "FLOAT" || typeName == "DOUBLE":
+			node = parquet.Leaf(parquet.DoubleType)
+		default:
+			node = parquet.String()
+		}
+		group[name] = parquet.Optional(node)
+		p.kinds[i] = node.Type().Kind()
+	}
+
+	schema := parquet.NewSchema("export", group)
+
+	// parquet.Group 按字段名排序，记录每个结果列在 schema 中的位置
+	position := map[string]int{}
+	for i, field := range schema.Fields() {
+		position[field.Name()] = i
+	}
+	p.columnIndex = make([]int, len(cols))
+	for i, name := range names {
+		p.columnIndex[i] = position[name]
+	}
+
+	codec := parquet.Compression(&parquet.Uncompressed)
+	switch p.compression {
+	case CompressionGzip:
+		codec = parquet.Compression(&parquet.Gzip)
+	case CompressionZstd:
+		codec = parquet.Compression(&parquet.Zstd)
+	}
+	p.writer = parquet.NewWriter(p.output, schema, codec)
+
+	return nil
+}
+
+func isIntegerType(typeName string) bool {
+	switch strings.TrimPrefix(typeName, "UNSIGNED ") {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "YEAR":
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *parquetExportWriter) WriteRow(values []interface{}) error {
+	row := make(parquet.Row, len(values))
+	for i, v := range values {
+		value, err := p.value(i, v)
+		if err != nil {
+			return err
+		}
+		row[p.columnIndex[i]] = value
+	}
+
+	_, err := p.writer.WriteRows([]parquet.Row{row})
+	return err
+}
+
+func (p *parquetExportWriter) value(i int, v interface{}) (parquet.Value, error) {
+	column := p.columnIndex[i]
+	if v == nil {
+		return parquet.NullValue().Level(0, 0, column), nil
+	}
+
+	var value parquet.Value
+	switch p.kinds[i] {
+	case parquet.Int64:
+		n, err := strconv.ParseInt(exportText(v), 10, 64)
+		if err != nil {
+			return value, fmt.Errorf("列 %d 不是整数: %v", i+1, err)
+		}
+		value = parquet.Int64Value(n)
+	case parquet.Double:
+		f, err := strconv.ParseFloat(exportText(v), 64)
+		if err != nil {
+			return value, fmt.Errorf("列 %d 不是浮点数: %v", i+1, err)
+		}
+		value = parquet.DoubleValue(f)
+	default:
+		value = parquet.ByteArrayValue([]byte(exportText(v)))
+	}
+
+	return value.Level(0, 1, column), nil
+}
+
+func (p *parquetExportWriter) Close() error {
+	return p.writer.Close()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func exportRows(mock sqlmock.Sqlmock) *sqlmock.Rows {
+	return mock.NewRowsWithColumnDefinition(
+		mock.NewColumn("id").OfType("BIGINT", int64(0)),
+		mock.NewColumn("name").OfType("VARCHAR", ""),
+		mock.NewColumn("amount").OfType("DECIMAL", ""),
+	).
+		AddRow([]byte("1"), []byte("alice"), []byte("9.50")).
+		AddRow([]byte("2"), []byte("o'brien, \"bob\""), nil).
+		AddRow([]byte("3"), nil, []byte("0.10"))
+}
+
+func TestHandleExportQuery(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+	dir := setupDataDir(t)
+
+	t.Run("csv", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("SELECT id, name, amount FROM users").WillReturnRows(exportRows(mock))
+
+		// 调用 HandleExportQuery
+		result, err := HandleExportQuery("SELECT id, name, amount FROM users", "csv", "users.csv", "", "")
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "文件: "+filepath.Join(dir, "users.csv"))
+		assert.Contains(t, result, "行数: 3")
+		assert.Contains(t, result, "1,alice,9.50")
+
+		content, _ := os.ReadFile(filepath.Join(dir, "users.csv"))
+		assert.Equal(t, "id,name,amount\n1,alice,9.50\n2,\"o'brien, \"\"bob\"\"\",\n3,,0.10\n", string(content))
+	})
+
+	t.Run("jsonl with gzip", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("SELECT").WillReturnRows(exportRows(mock))
+
+		// 调用 HandleExportQuery
+		_, err := HandleExportQuery("SELECT id, name, amount FROM users", "jsonl", "users.jsonl.gz", "gzip", "")
+
+		// 验证结果
+		assert.NoError(t, err)
+
+		file, _ := os.Open(filepath.Join(dir, "users.jsonl.gz"))
+		defer file.Close()
+		reader, err := gzip.NewReader(file)
+		assert.NoError(t, err)
+		content, _ := io.ReadAll(reader)
+		assert.Equal(t, "{\"id\":1,\"name\":\"alice\",\"amount\":9.50}\n{\"id\":2,\"name\":\"o'brien, \\\"bob\\\"\",\"amount\":null}\n{\"id\":3,\"name\":null,\"amount\":0.10}\n", string(content))
+	})
+
+	t.Run("sql inserts", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("SELECT").WillReturnRows(exportRows(mock))
+
+		// 调用 HandleExportQuery
+		_, err := HandleExportQuery("SELECT id, name, amount FROM shop.users u WHERE id > 0", "sql", "users.sql", "", "")
+
+		// 验证结果
+		assert.NoError(t, err)
+
+		content, _ := os.ReadFile(filepath.Join(dir, "users.sql"))
+		assert.Equal(t, "INSERT INTO `users` (`id`, `name`, `amount`) VALUES\n(1, 'alice', 9.50),\n(2, 'o''brien, \"bob\"', NULL),\n(3, NULL, 0.10);\n", string(content))
+	})
+
+	t.Run("parquet", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("SELECT").WillReturnRows(exportRows(mock))
+
+		// 调用 HandleExportQuery
+		_, err := HandleExportQuery("SELECT id, name, amount FROM users", "parquet", "users.parquet", "zstd", "")
+
+		// 验证结果
+		assert.NoError(t, err)
+
+		file, _ := os.Open(filepath.Join(dir, "users.parquet"))
+		defer file.Close()
+		info, _ := file.Stat()
+		pf, err := parquet.OpenFile(file, info.Size())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), pf.NumRows())
+
+		id, _ := pf.Schema().Lookup("id")
+		assert.Equal(t, parquet.Int64, id.Node.Type().Kind())
+	})
+
+	t.Run("masked columns", func(t *testing.T) {
+		setupMasking(t, `{"rules": [{"column": "users.id", "strategy": "redact"}, {"column": "name", "strategy": "partial"}]}`)
+
+		// 设置模拟预期
+		mock.ExpectQuery("SELECT").WillReturnRows(exportRows(mock))
+
+		// 调用 HandleExportQuery
+		result, err := HandleExportQuery("SELECT id, name, amount FROM users", "jsonl", "masked.jsonl", "", "")
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.NotContains(t, result, "alice")
+
+		content, _ := os.ReadFile(filepath.Join(dir, "masked.jsonl"))
+		assert.Equal(t, "{\"id\":\"[REDACTED]\",\"name\":\"a***e\",\"amount\":9.50}\n{\"id\":\"[REDACTED]\",\"name\":\"o'b********ob\\\"\",\"amount\":null}\n{\"id\":\"[REDACTED]\",\"name\":null,\"amount\":0.10}\n", string(content))
+	})
+
+	t.Run("existing file is not overwritten", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("SELECT").WillReturnRows(exportRows(mock))
+
+		// 调用 HandleExportQuery
+		_, err := HandleExportQuery("SELECT id, name, amount FROM users", "csv", "users.csv", "", "")
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "创建文件失败")
+	})
+
+	t.Run("rejects non select", func(t *testing.T) {
+		for _, query := range []string{
+			"DELETE FROM users",
+			"SELECT * FROM users INTO OUTFILE '/tmp/x'",
+			"SELECT 1; DROP TABLE users",
+		} {
+			_, err := HandleExportQuery(query, "csv", "", "", "")
+			assert.Error(t, err, query)
+		}
+	})
+
+	t.Run("unsupported format", func(t *testing.T) {
+		_, err := HandleExportQuery("SELECT 1", "xml", "", "", "")
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "不支持的导出格式")
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// maxIdentifierLength 是 MySQL 库名、表名和列名的最大长度（字符数）
+const maxIdentifierLength = 64
+
+// validateIdentifier 检查名称中 MySQL 不允许出现在库名和表名里的内容
+func validateIdentifier(name string) error {
+	switch {
+	case name == "":
+		return errors.New("名称不能为空")
+	case utf8.RuneCountInString(name) > maxIdentifierLength:
+		return fmt.Errorf("名称 %s 超过 %d 个字符", name, maxIdentifierLength)
+	case strings.ContainsRune(name, 0):
+		return fmt.Errorf("名称 %q 包含 NUL 字符", name)
+	case strings.HasSuffix(name, " "):
+		return fmt.Errorf("名称 %q 不能以空格结尾", name)
+	}
+
+	return nil
+}
+
+// parseQualifiedName 解析最多包含 maxParts 个部分的名称。未加反引号的部分不能是保留字，
+// 也不能包含空格、引号、分号、注释等特殊字符，首尾空白之外的整个输入必须恰好是一个名称
+func parseQualifiedName(name string, maxParts int) ([]string, bool) {
+	name = strings.TrimSpace(name)
+	tokens := TokenizeSQL(name)
+	// 分词时会丢弃注释和空白，要求各个记号首尾相接并覆盖整个输入，反引号必须闭合
+	pos := 0
+	for _, tok := range tokens {
+		if tok.Start != pos || (tok.Kind == TokenQuotedIdent && (len(tok.Text) < 2 || !strings.HasSuffix(tok.Text, "`"))) {
+			return nil, false
+		}
+		pos = tok.End
+	}
+	if pos != utf8.RuneCountInString(name) {
+		return nil, false
+	}
+
+	parts, next := readQualifiedName(tokens, 0)
+	if parts == nil || next != len(tokens) || len(parts) > maxParts || parts[len(parts)-1] == "*" {
+		return nil, false
+	}
+
+	return parts, true
+}
+
+// ParseTableName 解析 `table` 或 `db.table` 形式的表名，名称可以用反引号包围，
+// 反引号内的反引号写作两个反引号
+func ParseTableName(name string) (TableRef, error) {
+	parts, ok := parseQualifiedName(name, 2)
+	if !ok {
+		return TableRef{}, fmt.Errorf("无效的表名: %s（包含特殊字符或保留字的名称请用反引号包围）", name)
+	}
+	for _, part := range parts {
+		if err := validateIdentifier(part); err != nil {
+			return TableRef{}, fmt.Errorf("无效的表名: %v", err)
+		}
+	}
+
+	if len(parts) == 2 {
+		return TableRef{Schema: parts[0], Name: parts[1]}, nil
+	}
+
+	return TableRef{Name: parts[0]}, nil
+}
+
+// ParseSchemaName 解析数据库名，名称可以用反引号包围
+func ParseSchemaName(name string) (string, error) {
+	parts, ok := parseQualifiedName(name, 1)
+	if !ok {
+		return "", fmt.Errorf("无效的数据库名: %s（包含特殊字符或保留字的名称请用反引号包围）", name)
+	}
+	if err := validateIdentifier(parts[0]); err != nil {
+		return "", fmt.Errorf("无效的数据库名: %v", err)
+	}
+
+	return parts[0], nil
+}
+
+// LookupTable 在 information_schema 中查找表或视图，返回服务器上实际的库名和表名。
+// 未指定库名时使用当前数据库
+func LookupTable(db *sqlx.DB, ref TableRef) (TableRef, error) {
+	row := struct {
+		Schema string `db:"TABLE_SCHEMA"`
+		Name   string `db:"TABLE_NAME"`
+	}{}
+	err := db.Get(&row, "SELECT TABLE_SCHEMA, TABLE_NAME FROM information_schema.TABLES WHERE TABLE_SCHEMA = COALESCE(NULLIF(?, ''), DATABASE()) AND TABLE_NAME = ?", ref.Schema, ref.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return TableRef{}, fmt.Errorf("表 %s 不存在", ref.QuotedName())
+	}
+	if err != nil {
+		return TableRef{}, fmt.Errorf("查找表 %s 失败: %v", ref.QuotedName(), err)
+	}
+
+	return TableRef{Schema: row.Schema, Name: row.Name, Alias: ref.Alias}, nil
+}
+
+// LookupSchema 在 information_schema 中查找数据库，返回服务器上实际的库名
+func LookupSchema(db *sqlx.DB, name string) (string, error) {
+	var schema string
+	err := db.Get(&schema, "SELECT SCHEMA_NAME FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = ?", name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("数据库 %s 不存在", QuoteIdentifier(name))
+	}
+	if err != nil {
+		return "", fmt.Errorf("查找数据库 %s 失败: %v", QuoteIdentifier(name), err)
+	}
+
+	return schema, nil
+}
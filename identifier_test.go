@@ -0,0 +1,153 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// hostileNames 是试图借名称参数注入 SQL 的输入，都必须被拒绝
+var hostileNames = []string{
+	"x`; DROP TABLE y; -- ",
+	"users; DROP TABLE x",
+	"users WHERE 1",
+	"users -- comment",
+	"users/**/",
+	"users' OR '1'='1",
+	"`users`; DROP TABLE x",
+	"`a` `b`",
+	"`unterminated",
+	"order",
+	"",
+}
+
+func TestParseTableName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected TableRef
+	}{
+		{"users", TableRef{Name: "users"}},
+		{"shop.users", TableRef{Schema: "shop", Name: "users"}},
+		{"shop.`order items`", TableRef{Schema: "shop", Name: "order items"}},
+		{"`order`", TableRef{Name: "order"}},
+		{"`a.b`", TableRef{Name: "a.b"}},
+		{"`x``; DROP TABLE y; --`", TableRef{Name: "x`; DROP TABLE y; --"}},
+		{"$tmp_1", TableRef{Name: "$tmp_1"}},
+		{"订单", TableRef{Name: "订单"}},
+	}
+
+	for _, tt := range tests {
+		ref, err := ParseTableName(tt.name)
+		assert.NoError(t, err, tt.name)
+		assert.Equal(t, tt.expected, ref, tt.name)
+	}
+
+	invalid := append([]string{"a.b.c", "shop.*", "`a`.`b`.`c`", "`trailing `", "`" + strings.Repeat("x", 65) + "`"}, hostileNames...)
+	for _, name := range invalid {
+		_, err := ParseTableName(name)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestParseSchemaName(t *testing.T) {
+	schema, err := ParseSchemaName("`my-db`")
+	assert.NoError(t, err)
+	assert.Equal(t, "my-db", schema)
+
+	for _, name := range append([]string{"shop.users", "my-db"}, hostileNames...) {
+		_, err := ParseSchemaName(name)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestQuoteIdentifierRoundTrip(t *testing.T) {
+	for _, name := range []string{"users", "order items", "x`; DROP TABLE y; -- ", "``", "a.b"} {
+		ref, err := ParseTableName(QuoteIdentifier(strings.TrimRight(name, " ")))
+		assert.NoError(t, err, name)
+		assert.Equal(t, strings.TrimRight(name, " "), ref.Name)
+	}
+}
+
+func TestHandleDescTableHostileNames(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	t.Run("rejected before querying", func(t *testing.T) {
+		for _, name := range hostileNames {
+			// 调用 HandleDescTable
+			_, err := HandleDescTable(name, RouteAuto)
+
+			// 验证结果
+			assert.Error(t, err, name)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("escaped backtick", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("FROM information_schema.TABLES").
+			WithArgs("shop", "x`y").
+			WillReturnRows(sqlmock.NewRows([]string{"TABLE_SCHEMA", "TABLE_NAME"}).AddRow("shop", "x`y"))
+		mock.ExpectQuery(regexp.QuoteMeta("SHOW CREATE TABLE `shop`.`x``y`")).
+			WillReturnRows(sqlmock.NewRows([]string{"Table", "Create Table"}).AddRow("x`y", "CREATE TABLE `x``y` (`id` int)"))
+
+		// 调用 HandleDescTable
+		result, err := HandleDescTable("shop.`x``y`", RouteAuto)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "CREATE TABLE `x``y`")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestHandleUseDatabase(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+	defer SelectDatabase("")
+
+	t.Run("successful use", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("FROM information_schema.SCHEMATA").
+			WithArgs("my`db").
+			WillReturnRows(sqlmock.NewRows([]string{"SCHEMA_NAME"}).AddRow("my`db"))
+
+		// 调用 HandleUseDatabase
+		result, err := HandleUseDatabase("`my``db`")
+
+		// 验证结果：之后建立的连接使用新的数据库
+		assert.NoError(t, err)
+		assert.Contains(t, result, "my`db")
+		name, _ := SelectedDatabase()
+		assert.Equal(t, "my`db", name)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("database not found", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("FROM information_schema.SCHEMATA").
+			WithArgs("missing").
+			WillReturnRows(sqlmock.NewRows([]string{"SCHEMA_NAME"}))
+
+		// 调用 HandleUseDatabase
+		_, err := HandleUseDatabase("missing")
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "不存在")
+	})
+
+	t.Run("hostile names", func(t *testing.T) {
+		for _, name := range hostileNames {
+			// 调用 HandleUseDatabase
+			_, err := HandleUseDatabase(name)
+
+			// 验证结果
+			assert.Error(t, err, name)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
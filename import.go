@@ -0,0 +1,635 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	ImportFormatCSV   = "csv"
+	ImportFormatTSV   = "tsv"
+	ImportFormatJSONL = "jsonl"
+
+	defaultImportBatchRows = 500
+	maxImportPlaceholders  = 65535
+	maxReportedRejects     = 20
+)
+
+// ImportColumn 是目标表中一列的定义，用于在插入前校验数据
+type ImportColumn struct {
+	Name       string         `db:"COLUMN_NAME"`
+	DataType   string         `db:"DATA_TYPE"`
+	ColumnType string         `db:"COLUMN_TYPE"`
+	Nullable   string         `db:"IS_NULLABLE"`
+	Default    sql.NullString `db:"COLUMN_DEFAULT"`
+	Extra      string         `db:"EXTRA"`
+	MaxLength  sql.NullInt64  `db:"CHARACTER_MAXIMUM_LENGTH"`
+}
+
+// ImportRecord 是从文件中读取的一行，Fields 以文件中的列名为键
+type ImportRecord struct {
+	Line   int
+	Fields map[string]interface{}
+	Err    error
+}
+
+// ImportReject 记录被拒绝的行及原因
+type ImportReject struct {
+	Line   int
+	Reason string
+}
+
+// HandleImportData 读取数据目录中的 CSV/TSV/JSON Lines 文件，校验后在一个事务中批量插入目标表。
+// 校验失败的行会被跳过并在结果中列出；strict 为 true 时只要有被拒绝的行就不导入任何数据
+func HandleImportData(fileName, table, format, columns string, batchRows int, strict bool) (string, error) {
+	path, err := ResolveDataPath(fileName)
+	if err != nil {
+		return "", err
+	}
+
+	if format == "" {
+		format = importFormatFromName(fileName)
+	}
+	format = strings.ToLower(format)
+	if format != ImportFormatCSV && format != ImportFormatTSV && format != ImportFormatJSONL {
+		return "", fmt.Errorf("不支持的导入格式: %s（可选 csv、tsv、jsonl）", format)
+	}
+	if batchRows <= 0 {
+		batchRows = defaultImportBatchRows
+	}
+
+	mapping, err := ParseColumnMapping(columns)
+	if err != nil {
+		return "", err
+	}
+
+	ref, err := ParseTableName(table)
+	if err != nil {
+		return "", err
+	}
+	if err := CheckTableAccess(ref); err != nil {
+		return "", err
+	}
+	// 导入以多行 INSERT 写入，与 write_query 一样受语句策略限制
+	if err := CheckStatementPolicy("INSERT INTO " + ref.QuotedName() + " VALUES ()"); err != nil {
+		return "", err
+	}
+
+	tableColumns, err := GetImportColumns(ref.Schema, ref.Name)
+	if err != nil {
+		return "", err
+	}
+	if len(tableColumns) == 0 {
+		return "", fmt.Errorf("表 %s 不存在", table)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("打开导入文件失败: %v", err)
+	}
+	defer file.Close()
+
+	reader, err := newDecompressor(file, fileName)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	db, err := GetDB()
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	imp := &tableImport{tx: tx, table: ref, columns: tableColumns, mapping: mapping, explicit: len(mapping) > 0, batchRows: batchRows}
+	if err := ReadImportRecords(reader, format, imp.add); err != nil {
+		return "", err
+	}
+	if err := imp.flush(); err != nil {
+		return "", err
+	}
+
+	if strict && len(imp.rejects) > 0 {
+		return "", fmt.Errorf("有 %d 行未通过校验，已回滚，未导入任何数据\n%s", len(imp.rejects), formatImportRejects(imp.rejects))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("提交事务失败: %v", err)
+	}
+	Cache.Invalidate([]TableRef{ref})
+
+	result := fmt.Sprintf("已导入 %d 行到 %s，拒绝 %d 行", imp.inserted, ref.QuotedName(), len(imp.rejects))
+	if len(imp.rejects) > 0 {
+		result += "\n" + formatImportRejects(imp.rejects)
+	}
+
+	return result, nil
+}
+
+// tableImport 在同一个事务中把校验通过的记录攒成批次插入目标表
+type tableImport struct {
+	tx      *sqlx.Tx
+	table   TableRef
+	columns []ImportColumn
+	mapping [][2]string
+	// explicit 表示映射由调用方指定，此时文件中未映射的字段会被忽略
+	explicit  bool
+	targets   []ImportColumn
+	batchRows int
+	// pendingColumns 为当前批次写入的列。记录中缺少的字段不写入，使列的默认值生效，
+	// 因此列集合不同的记录分在不同的批次
+	pendingColumns []ImportColumn
+	pending        [][]interface{}
+	inserted       int
+	rejects        []ImportReject
+}
+
+func (imp *tableImport) add(header []string, record ImportRecord) error {
+	if record.Err != nil {
+		imp.rejects = append(imp.rejects, ImportReject{Line: record.Line, Reason: record.Err.Error()})
+		return nil
+	}
+
+	if imp.targets == nil {
+		if len(imp.mapping) == 0 {
+			for _, name := range header {
+				imp.mapping = append(imp.mapping, [2]string{name, name})
+			}
+		}
+		targets, err := resolveImportColumns(imp.mapping, imp.columns)
+		if err != nil {
+			return err
+		}
+		names := make([]string, len(targets))
+		for i, col := range targets {
+			names[i] = col.Name
+		}
+		if err := CheckTableAccess(imp.table, names...); err != nil {
+			return err
+		}
+		imp.targets = targets
+		imp.batchRows = min(imp.batchRows, maxImportPlaceholders/len(targets))
+	}
+
+	columns, values, err := convertImportRecord(record, imp.mapping, imp.targets, imp.explicit)
+	if err != nil {
+		imp.rejects = append(imp.rejects, ImportReject{Line: record.Line, Reason: err.Error()})
+		return nil
+	}
+
+	if len(imp.pending) > 0 && !sameImportColumns(imp.pendingColumns, columns) {
+		if err := imp.flush(); err != nil {
+			return err
+		}
+	}
+	imp.pendingColumns = columns
+	imp.pending = append(imp.pending, values)
+	if len(imp.pending) >= imp.batchRows {
+		return imp.flush()
+	}
+
+	return nil
+}
+
+// flush 以一条多行 INSERT 写入当前批次，失败时由调用方回滚整个事务
+func (imp *tableImport) flush() error {
+	if len(imp.pending) == 0 {
+		return nil
+	}
+
+	names := make([]string, len(imp.pendingColumns))
+	for i, col := range imp.pendingColumns {
+		names[i] = col.Name
+	}
+	placeholder := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ") + ")"
+
+	groups := make([]string, len(imp.pending))
+	args := make([]interface{}, 0, len(imp.pending)*len(names))
+	for i, row := range imp.pending {
+		groups[i] = placeholder
+		args = append(args, row...)
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", imp.table.QuotedName(), quoteColumns(names), strings.Join(groups, ", "))
+	if _, err := imp.tx.Exec(query, args...); err != nil {
+		return fmt.Errorf("插入第 %d-%d 条有效记录失败，已回滚全部导入: %v", imp.inserted+1, imp.inserted+len(imp.pending), err)
+	}
+
+	imp.inserted += len(imp.pending)
+	imp.pending = imp.pending[:0]
+
+	return nil
+}
+
+func sameImportColumns(a, b []ImportColumn) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ParseColumnMapping 解析 `file_col:table_col` 形式、以逗号分隔的列映射，只写一个名称时表示两边同名
+func ParseColumnMapping(columns string) ([][2]string, error) {
+	mapping := [][2]string{}
+	if strings.TrimSpace(columns) == "" {
+		return mapping, nil
+	}
+
+	for _, item := range strings.Split(columns, ",") {
+		source, target, found := strings.Cut(item, ":")
+		source = strings.TrimSpace(source)
+		target = strings.TrimSpace(target)
+		if !found {
+			target = source
+		}
+		if source == "" || target == "" {
+			return nil, fmt.Errorf("无效的列映射: %s", item)
+		}
+		mapping = append(mapping, [2]string{source, target})
+	}
+
+	return mapping, nil
+}
+
+func GetImportColumns(schema, table string) ([]ImportColumn, error) {
+	db, err := GetDB()
+	if err != nil {
+		return nil, err
+	}
+
+	return queryImportColumns(context.Background(), db, schema, table)
+}
+
+// queryImportColumns 通过 q 读取表的列定义，q 可以是连接池、事务或固定的连接
+func queryImportColumns(ctx context.Context, q sqlx.QueryerContext, schema, table string) ([]ImportColumn, error) {
+	columns := []ImportColumn{}
+	err := sqlx.SelectContext(ctx, q, &columns,
+		"SELECT COLUMN_NAME, DATA_TYPE, COLUMN_TYPE, IS_NULLABLE, COLUMN_DEFAULT, EXTRA, CHARACTER_MAXIMUM_LENGTH FROM information_schema.COLUMNS "+
+			"WHERE TABLE_SCHEMA = COALESCE(NULLIF(?, ''), DATABASE()) AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION",
+		schema, table,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("读取表结构失败: %v", err)
+	}
+
+	return columns, nil
+}
+
+// resolveImportColumns 按映射找到目标列，并检查没有遗漏必须提供值的列
+func resolveImportColumns(mapping [][2]string, columns []ImportColumn) ([]ImportColumn, error) {
+	byName := map[string]ImportColumn{}
+	for _, col := range columns {
+		byName[strings.ToLower(col.Name)] = col
+	}
+
+	targets := []ImportColumn{}
+	used := map[string]bool{}
+	for _, m := range mapping {
+		col, ok := byName[strings.ToLower(m[1])]
+		if !ok {
+			return nil, fmt.Errorf("列 %s 在目标表中不存在", m[1])
+		}
+		if used[strings.ToLower(col.Name)] {
+			return nil, fmt.Errorf("列 %s 被映射了多次", col.Name)
+		}
+		if isGeneratedColumn(col.Extra) {
+			return nil, fmt.Errorf("列 %s 是生成列，不能导入", col.Name)
+		}
+		used[strings.ToLower(col.Name)] = true
+		targets = append(targets, col)
+	}
+
+	missing := []string{}
+	for _, col := range columns {
+		if !used[strings.ToLower(col.Name)] && requiresImportValue(col) {
+			missing = append(missing, col.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("缺少必须提供值的列: %s", strings.Join(missing, ", "))
+	}
+
+	return targets, nil
+}
+
+// requiresImportValue 判断导入时是否必须为列提供值，即列不可为 NULL、没有默认值且不是自增列或生成列
+func requiresImportValue(col ImportColumn) bool {
+	if col.Nullable == "YES" || col.Default.Valid {
+		return false
+	}
+
+	return !strings.Contains(strings.ToLower(col.Extra), "auto_increment") && !isGeneratedColumn(col.Extra)
+}
+
+// isGeneratedColumn 判断 EXTRA 是否表示生成列。MySQL 8 中带表达式默认值的列为 DEFAULT_GENERATED，仍可写入
+func isGeneratedColumn(extra string) bool {
+	extra = strings.ToUpper(extra)
+	return strings.Contains(extra, "VIRTUAL GENERATED") || strings.Contains(extra, "STORED GENERATED")
+}
+
+func importFormatFromName(name string) string {
+	name = strings.TrimSuffix(strings.TrimSuffix(strings.ToLower(name), ".gz"), ".zst")
+	switch filepath.Ext(name) {
+	case ".tsv":
+		return ImportFormatTSV
+	case ".json", ".jsonl", ".ndjson":
+		return ImportFormatJSONL
+	default:
+		return ImportFormatCSV
+	}
+}
+
+func newDecompressor(r io.Reader, name string) (io.ReadCloser, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gz":
+		reader, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("读取 gzip 文件失败: %v", err)
+		}
+		return reader, nil
+	case ".zst":
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("读取 zstd 文件失败: %v", err)
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return io.NopCloser(r), nil
+	}
+}
+
+// ReadImportRecords 逐条读取记录并交给 fn 处理。CSV/TSV 以第一行为表头；
+// JSON Lines 以第一条有效记录的键（按名称排序）为列名。无法解析的行以 Err 传递而不是中止
+func ReadImportRecords(r io.Reader, format string, fn func(header []string, record ImportRecord) error) error {
+	if format == ImportFormatJSONL {
+		return readJSONLines(r, fn)
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	if format == ImportFormatTSV {
+		reader.Comma = '\t'
+		reader.LazyQuotes = true
+	}
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return fmt.Errorf("导入文件为空")
+	}
+	if err != nil {
+		return fmt.Errorf("读取表头失败: %v", err)
+	}
+	header = append([]string(nil), header...)
+
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+
+		record := ImportRecord{}
+		if err != nil {
+			parseErr, ok := err.(*csv.ParseError)
+			if !ok {
+				return fmt.Errorf("读取导入文件失败: %v", err)
+			}
+			record.Line = parseErr.StartLine
+			record.Err = fmt.Errorf("无法解析: %v", parseErr.Err)
+		} else {
+			record.Line, _ = reader.FieldPos(0)
+		}
+
+		switch {
+		case record.Err != nil:
+		case len(fields) != len(header):
+			record.Err = fmt.Errorf("有 %d 个字段，表头有 %d 列", len(fields), len(header))
+		default:
+			record.Fields = map[string]interface{}{}
+			for i, name := range header {
+				if fields[i] == `\N` {
+					record.Fields[name] = nil
+				} else {
+					record.Fields[name] = fields[i]
+				}
+			}
+		}
+
+		if err := fn(header, record); err != nil {
+			return err
+		}
+	}
+}
+
+func readJSONLines(r io.Reader, fn func(header []string, record ImportRecord) error) error {
+	reader := bufio.NewReader(r)
+	var header []string
+
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("读取导入文件失败: %v", err)
+		}
+
+		if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 {
+			decoder := json.NewDecoder(bytes.NewReader(trimmed))
+			decoder.UseNumber()
+
+			record := ImportRecord{Line: line}
+			if decodeErr := decoder.Decode(&record.Fields); decodeErr != nil || record.Fields == nil {
+				record.Fields = nil
+				record.Err = fmt.Errorf("不是有效的 JSON 对象")
+			} else if header == nil {
+				for name := range record.Fields {
+					header = append(header, name)
+				}
+				sort.Strings(header)
+			}
+
+			if err := fn(header, record); err != nil {
+				return err
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// convertImportRecord 按映射取出目标列的值并校验，返回记录中出现的目标列及对应的参数。
+// 记录中缺少的字段不写入，由列的默认值填充；ignoreUnmapped 为 false 时未映射的字段会导致记录被拒绝
+func convertImportRecord(record ImportRecord, mapping [][2]string, targets []ImportColumn, ignoreUnmapped bool) ([]ImportColumn, []interface{}, error) {
+	if !ignoreUnmapped {
+		known := map[string]bool{}
+		for _, m := range mapping {
+			known[m[0]] = true
+		}
+		for name := range record.Fields {
+			if !known[name] {
+				return nil, nil, fmt.Errorf("未映射的字段: %s", name)
+			}
+		}
+	}
+
+	columns := make([]ImportColumn, 0, len(targets))
+	values := make([]interface{}, 0, len(targets))
+	for i, col := range targets {
+		raw, ok := record.Fields[mapping[i][0]]
+		if !ok {
+			if requiresImportValue(col) {
+				return nil, nil, fmt.Errorf("缺少字段 %s，列 %s 必须提供值", mapping[i][0], col.Name)
+			}
+			continue
+		}
+		value, err := ConvertImportValue(raw, col)
+		if err != nil {
+			return nil, nil, fmt.Errorf("列 %s: %v", col.Name, err)
+		}
+		columns = append(columns, col)
+		values = append(values, value)
+	}
+
+	return columns, values, nil
+}
+
+// ConvertImportValue 将文件中的值转换为插入参数并按列定义校验。
+// CSV 中的空字符串对非字符串列视为 NULL，与 export_query 导出 NULL 的方式一致
+func ConvertImportValue(raw interface{}, col ImportColumn) (interface{}, error) {
+	var text string
+	switch v := raw.(type) {
+	case nil:
+	case string:
+		text = v
+	case json.Number:
+		text = v.String()
+	case bool:
+		text = "0"
+		if v {
+			text = "1"
+		}
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		text = string(encoded)
+	}
+
+	dataType := strings.ToLower(col.DataType)
+	if raw == nil || (text == "" && !isStringDataType(dataType)) {
+		if col.Nullable != "YES" {
+			return nil, fmt.Errorf("不能为 NULL")
+		}
+		return nil, nil
+	}
+
+	switch dataType {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint", "year":
+		var err error
+		if strings.Contains(strings.ToLower(col.ColumnType), "unsigned") {
+			_, err = strconv.ParseUint(text, 10, 64)
+		} else {
+			_, err = strconv.ParseInt(text, 10, 64)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%q 不是有效的整数", text)
+		}
+	case "decimal", "float", "double":
+		if _, err := strconv.ParseFloat(text, 64); err != nil {
+			return nil, fmt.Errorf("%q 不是有效的数字", text)
+		}
+	case "date", "datetime", "timestamp":
+		if !isImportTime(text) {
+			return nil, fmt.Errorf("%q 不是有效的日期时间", text)
+		}
+	case "json":
+		if !json.Valid([]byte(text)) {
+			return nil, fmt.Errorf("不是有效的 JSON")
+		}
+	case "enum":
+		if !containsFold(enumMembers(col.ColumnType), text) {
+			return nil, fmt.Errorf("%q 不是允许的枚举值", text)
+		}
+	}
+
+	if col.MaxLength.Valid && isStringDataType(dataType) {
+		length := int64(utf8.RuneCountInString(text))
+		if strings.Contains(dataType, "binary") || strings.Contains(dataType, "blob") {
+			length = int64(len(text))
+		}
+		if length > col.MaxLength.Int64 {
+			return nil, fmt.Errorf("长度 %d 超过上限 %d", length, col.MaxLength.Int64)
+		}
+	}
+
+	return text, nil
+}
+
+func isStringDataType(dataType string) bool {
+	return strings.Contains(dataType, "char") || strings.Contains(dataType, "text") ||
+		strings.Contains(dataType, "binary") || strings.Contains(dataType, "blob") ||
+		dataType == "enum" || dataType == "set"
+}
+
+func isImportTime(text string) bool {
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04:05", "2006-01-02T15:04:05", time.RFC3339Nano} {
+		if _, err := time.Parse(layout, text); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// enumMembers 从 `enum('a','b')` 形式的列类型中取出全部成员
+func enumMembers(columnType string) []string {
+	members := []string{}
+	for _, tok := range TokenizeSQL(columnType) {
+		if tok.Kind == TokenString {
+			members = append(members, tok.Value)
+		}
+	}
+
+	return members
+}
+
+func formatImportRejects(rejects []ImportReject) string {
+	lines := []string{"被拒绝的行:"}
+	for i, r := range rejects {
+		if i == maxReportedRejects {
+			lines = append(lines, fmt.Sprintf("... 其余 %d 行省略", len(rejects)-maxReportedRejects))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("第 %d 行: %s", r.Line, r.Reason))
+	}
+
+	return strings.Join(lines, "\n")
+}
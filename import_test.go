@@ -0,0 +1,224 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func importColumnRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"COLUMN_NAME", "DATA_TYPE", "COLUMN_TYPE", "IS_NULLABLE", "COLUMN_DEFAULT", "EXTRA", "CHARACTER_MAXIMUM_LENGTH"}).
+		AddRow("id", "int", "int", "NO", nil, "auto_increment", nil).
+		AddRow("name", "varchar", "varchar(5)", "NO", nil, "", 5).
+		AddRow("age", "int", "int unsigned", "YES", nil, "", nil).
+		AddRow("status", "enum", "enum('active','closed')", "NO", "active", "", 6).
+		AddRow("created_at", "datetime", "datetime", "NO", "CURRENT_TIMESTAMP", "DEFAULT_GENERATED", nil)
+}
+
+func writeDataFile(t *testing.T, dir, name, content string) {
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatalf("写入数据文件失败: %v", err)
+	}
+}
+
+func TestConvertImportValue(t *testing.T) {
+	name := ImportColumn{Name: "name", DataType: "varchar", Nullable: "NO", MaxLength: sql.NullInt64{Int64: 3, Valid: true}}
+	age := ImportColumn{Name: "age", DataType: "int", ColumnType: "int unsigned", Nullable: "YES"}
+
+	v, err := ConvertImportValue("张三丰", name)
+	assert.NoError(t, err)
+	assert.Equal(t, "张三丰", v)
+
+	_, err = ConvertImportValue("abcd", name)
+	assert.ErrorContains(t, err, "超过上限")
+
+	_, err = ConvertImportValue(nil, name)
+	assert.ErrorContains(t, err, "不能为 NULL")
+
+	v, err = ConvertImportValue("", age)
+	assert.NoError(t, err)
+	assert.Nil(t, v)
+
+	_, err = ConvertImportValue("-1", age)
+	assert.ErrorContains(t, err, "不是有效的整数")
+}
+
+func TestHandleImportData(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+	dir := setupDataDir(t)
+
+	t.Run("csv with rejects", func(t *testing.T) {
+		writeDataFile(t, dir, "users.csv", "name,age,status\nalice,30,active\nbob,x,active\n\"carol\",,closed\ndave,1\nerin,5,gone\n")
+
+		// 设置模拟预期
+		mock.ExpectQuery("FROM information_schema.COLUMNS").WithArgs("", "users").WillReturnRows(importColumnRows())
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO `users` \\(`name`, `age`, `status`\\) VALUES \\(\\?, \\?, \\?\\), \\(\\?, \\?, \\?\\)").
+			WithArgs("alice", "30", "active", "carol", nil, "closed").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+
+		// 调用 HandleImportData
+		result, err := HandleImportData("users.csv", "users", "", "", 0, false)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "已导入 2 行到 `users`，拒绝 3 行")
+		assert.Contains(t, result, "第 3 行: 列 age: \"x\" 不是有效的整数")
+		assert.Contains(t, result, "第 5 行: 有 2 个字段，表头有 3 列")
+		assert.Contains(t, result, "第 6 行: 列 status: \"gone\" 不是允许的枚举值")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("jsonl with mapping and batches", func(t *testing.T) {
+		writeDataFile(t, dir, "users.jsonl", "{\"full_name\":\"a\",\"years\":1}\n{\"full_name\":\"b\",\"years\":null}\nnot json\n{\"full_name\":\"c\",\"years\":3}\n")
+
+		// 设置模拟预期
+		mock.ExpectQuery("FROM information_schema.COLUMNS").WithArgs("shop", "users").WillReturnRows(importColumnRows())
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO `shop`.`users` \\(`name`, `age`\\) VALUES \\(\\?, \\?\\), \\(\\?, \\?\\)$").
+			WithArgs("a", "1", "b", nil).WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec("INSERT INTO `shop`.`users` \\(`name`, `age`\\) VALUES \\(\\?, \\?\\)$").
+			WithArgs("c", "3").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		// 调用 HandleImportData
+		result, err := HandleImportData("users.jsonl", "shop.users", "", "full_name:name, years:age", 2, false)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "已导入 3 行到 `shop`.`users`，拒绝 1 行")
+		assert.Contains(t, result, "第 3 行: 不是有效的 JSON 对象")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("jsonl missing and unmapped fields", func(t *testing.T) {
+		writeDataFile(t, dir, "sparse.jsonl", "{\"full_name\":\"a\",\"years\":1,\"note\":\"x\"}\n{\"full_name\":\"b\"}\n{\"full_name\":\"c\"}\n{\"years\":4}\n{\"full_name\":\"e\",\"state\":\"closed\"}\n")
+
+		// 设置模拟预期
+		mock.ExpectQuery("FROM information_schema.COLUMNS").WithArgs("", "users").WillReturnRows(importColumnRows())
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO `users` \\(`name`, `age`\\) VALUES \\(\\?, \\?\\)$").
+			WithArgs("a", "1").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO `users` \\(`name`\\) VALUES \\(\\?\\), \\(\\?\\)$").
+			WithArgs("b", "c").WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec("INSERT INTO `users` \\(`name`, `status`\\) VALUES \\(\\?, \\?\\)$").
+			WithArgs("e", "closed").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		// 调用 HandleImportData
+		result, err := HandleImportData("sparse.jsonl", "users", "", "full_name:name, years:age, state:status", 0, false)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "已导入 4 行到 `users`，拒绝 1 行")
+		assert.Contains(t, result, "第 4 行: 缺少字段 full_name，列 name 必须提供值")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("jsonl unmapped field without mapping", func(t *testing.T) {
+		writeDataFile(t, dir, "extra.jsonl", "{\"name\":\"a\"}\n{\"name\":\"b\",\"nickname\":\"bb\"}\n")
+
+		// 设置模拟预期
+		mock.ExpectQuery("FROM information_schema.COLUMNS").WithArgs("", "users").WillReturnRows(importColumnRows())
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO `users` \\(`name`\\) VALUES \\(\\?\\)$").
+			WithArgs("a").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		// 调用 HandleImportData
+		result, err := HandleImportData("extra.jsonl", "users", "", "", 0, false)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "第 2 行: 未映射的字段: nickname")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("strict mode rolls back", func(t *testing.T) {
+		writeDataFile(t, dir, "strict.csv", "name\nalice\ntoolongname\n")
+
+		// 设置模拟预期
+		mock.ExpectQuery("FROM information_schema.COLUMNS").WillReturnRows(importColumnRows())
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO `users`").WithArgs("alice").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectRollback()
+
+		// 调用 HandleImportData
+		_, err := HandleImportData("strict.csv", "users", "", "", 0, true)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "已回滚")
+		assert.Contains(t, err.Error(), "长度 11 超过上限 5")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("insert failure rolls back", func(t *testing.T) {
+		writeDataFile(t, dir, "dup.csv", "name\nalice\n")
+
+		// 设置模拟预期
+		mock.ExpectQuery("FROM information_schema.COLUMNS").WillReturnRows(importColumnRows())
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO `users`").WillReturnError(assert.AnError)
+		mock.ExpectRollback()
+
+		// 调用 HandleImportData
+		_, err := HandleImportData("dup.csv", "users", "", "", 0, false)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "已回滚全部导入")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("unknown and missing columns", func(t *testing.T) {
+		writeDataFile(t, dir, "bad.csv", "name,nickname\nalice,al\n")
+		writeDataFile(t, dir, "missing.csv", "age\n3\n")
+
+		for file, message := range map[string]string{
+			"bad.csv":     "列 nickname 在目标表中不存在",
+			"missing.csv": "缺少必须提供值的列: name",
+		} {
+			// 设置模拟预期
+			mock.ExpectQuery("FROM information_schema.COLUMNS").WillReturnRows(importColumnRows())
+			mock.ExpectBegin()
+			mock.ExpectRollback()
+
+			// 调用 HandleImportData
+			_, err := HandleImportData(file, "users", "", "", 0, false)
+
+			// 验证结果
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), message)
+		}
+	})
+
+	t.Run("file outside data dir", func(t *testing.T) {
+		_, err := HandleImportData("../users.csv", "users", "", "", 0, false)
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "数据目录之外")
+	})
+
+	t.Run("statement policy", func(t *testing.T) {
+		writeDataFile(t, dir, "users.csv", "name,age,status\nalice,30,active\n")
+		policy, _ := ParseStatementPolicy([]byte(`{"deny": ["INSERT"]}`))
+		original := Statements
+		Statements = policy
+		defer func() { Statements = original }()
+
+		// 调用 HandleImportData
+		_, err := HandleImportData("users.csv", "users", "", "", 0, false)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "语句策略禁止执行 INSERT 语句")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,701 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+type TableRef struct {
+	Schema string
+	Name   string
+	Alias  string
+}
+
+// Matches 判断限定名（别名或表名）是否指向该表
+func (t TableRef) Matches(qualifier string) bool {
+	if t.Alias != "" {
+		return strings.EqualFold(t.Alias, qualifier)
+	}
+
+	return strings.EqualFold(t.Name, qualifier)
+}
+
+func (t TableRef) QuotedName() string {
+	if t.Schema != "" {
+		return QuoteIdentifier(t.Schema) + "." + QuoteIdentifier(t.Name)
+	}
+
+	return QuoteIdentifier(t.Name)
+}
+
+type ColumnRef struct {
+	Qualifier string
+	Column    string
+}
+
+type ColumnPredicate struct {
+	ColumnRef
+	Equality bool
+}
+
+// QueryScope 描述一个 SELECT（或 UPDATE/DELETE）层级中引用的表和可用于索引的列
+type QueryScope struct {
+	Tables     []TableRef
+	Predicates []ColumnPredicate
+	OrderBy    []ColumnRef
+}
+
+type TableIndex struct {
+	Name    string
+	Unique  bool
+	Columns []string
+}
+
+type IndexStatisticsRow struct {
+	IndexName  string `db:"INDEX_NAME"`
+	NonUnique  int    `db:"NON_UNIQUE"`
+	SeqInIndex int    `db:"SEQ_IN_INDEX"`
+	ColumnName string `db:"COLUMN_NAME"`
+}
+
+type IndexSuggestion struct {
+	Table     TableRef
+	Columns   []string
+	Reason    string
+	Statement string
+	Expected  string
+	// Access 为使用该索引后预期的访问类型（ref、range 或 index）
+	Access string
+	// SortsRows 表示该索引同时满足 ORDER BY 的顺序
+	SortsRows bool
+}
+
+// AnalyzeQueryScopes 解析查询中各层级的表、WHERE/JOIN 条件列和 ORDER BY 列，子查询和 UNION 会生成独立的层级
+func AnalyzeQueryScopes(query string) []QueryScope {
+	return analyzeTokens(TokenizeSQL(query))
+}
+
+func analyzeTokens(tokens []SQLToken) []QueryScope {
+	scopes := []QueryScope{}
+	scope := QueryScope{}
+	clause := ""
+	expectTable := false
+
+	for i := 0; i < len(tokens); {
+		tok := tokens[i]
+
+		if tok.Is(TokenPunct, "(") {
+			if i+1 < len(tokens) && tokens[i+1].IsKeyword("SELECT", "WITH") {
+				end := matchParen(tokens, i)
+				scopes = append(scopes, analyzeTokens(tokens[i+1:min(end, len(tokens))])...)
+				expectTable = false
+				i = end + 1
+				if clause == "from" {
+					// 派生表的别名
+					if i < len(tokens) && tokens[i].IsKeyword("AS") {
+						i++
+					}
+					if i < len(tokens) && tokens[i].IsIdent() {
+						i++
+					}
+				}
+				continue
+			}
+			i++
+			continue
+		}
+
+		switch {
+		case tok.IsKeyword("UNION"):
+			scopes = append(scopes, scope)
+			scope = QueryScope{}
+			clause = ""
+			i++
+			continue
+		case tok.IsKeyword("SELECT"):
+			clause = "select"
+			i++
+			continue
+		case tok.IsKeyword("FROM"):
+			clause = "from"
+			expectTable = true
+			i++
+			continue
+		case tok.IsKeyword("UPDATE") && clause == "":
+			clause = "from"
+			expectTable = true
+			i++
+			continue
+		case tok.IsKeyword("JOIN", "STRAIGHT_JOIN"):
+			clause = "from"
+			expectTable = true
+			i++
+			continue
+		case tok.IsKeyword("ON"):
+			clause = "where"
+			i++
+			continue
+		case tok.IsKeyword("WHERE"):
+			clause = "where"
+			i++
+			continue
+		case tok.IsKeyword("SET"):
+			clause = "set"
+			i++
+			continue
+		case tok.IsKeyword("USING", "HAVING", "LIMIT", "WINDOW", "FOR", "LOCK", "INTO"):
+			clause = strings.ToLower(tok.Value)
+			i++
+			continue
+		case tok.IsKeyword("GROUP", "ORDER") && i+1 < len(tokens) && tokens[i+1].IsKeyword("BY"):
+			clause = strings.ToLower(tok.Value)
+			i += 2
+			continue
+		}
+
+		switch clause {
+		case "from":
+			if tok.Is(TokenPunct, ",") {
+				expectTable = true
+				i++
+				continue
+			}
+			if !expectTable {
+				i++
+				continue
+			}
+			parts, next := readQualifiedName(tokens, i)
+			if parts == nil {
+				i++
+				continue
+			}
+			ref := TableRef{Name: parts[len(parts)-1]}
+			if len(parts) > 1 {
+				ref.Schema = parts[0]
+			}
+			i = next
+			if i < len(tokens) && tokens[i].IsKeyword("AS") {
+				i++
+			}
+			if i < len(tokens) && tokens[i].IsIdent() {
+				ref.Alias = tokens[i].Value
+				i++
+			}
+			scope.Tables = append(scope.Tables, ref)
+			expectTable = false
+
+		case "where":
+			parts, next := readQualifiedName(tokens, i)
+			if parts == nil || (next < len(tokens) && tokens[next].Is(TokenPunct, "(")) {
+				i = max(next, i+1)
+				continue
+			}
+			ref := columnRefFromParts(parts)
+			if equality, ok := predicateKind(tokens, i, next); ok {
+				scope.Predicates = append(scope.Predicates, ColumnPredicate{ColumnRef: ref, Equality: equality})
+			}
+			i = next
+
+		case "order":
+			parts, next := readQualifiedName(tokens, i)
+			if parts == nil || (next < len(tokens) && tokens[next].Is(TokenPunct, "(")) {
+				i = max(next, i+1)
+				continue
+			}
+			scope.OrderBy = append(scope.OrderBy, columnRefFromParts(parts))
+			i = next
+
+		default:
+			i++
+		}
+	}
+
+	scopes = append(scopes, scope)
+
+	result := []QueryScope{}
+	for _, s := range scopes {
+		if len(s.Tables) > 0 {
+			result = append(result, s)
+		}
+	}
+
+	return result
+}
+
+func columnRefFromParts(parts []string) ColumnRef {
+	ref := ColumnRef{Column: parts[len(parts)-1]}
+	if len(parts) > 1 {
+		ref.Qualifier = parts[len(parts)-2]
+	}
+
+	return ref
+}
+
+// predicateKind 根据列引用前后的运算符判断该列是否可用于索引，以及是否为等值条件
+func predicateKind(tokens []SQLToken, start, end int) (equality bool, ok bool) {
+	if end < len(tokens) {
+		next := tokens[end]
+		switch {
+		case next.Is(TokenOperator, "="), next.Is(TokenOperator, "<=>"), next.IsKeyword("IN"):
+			return true, true
+		case next.IsKeyword("IS"):
+			if end+1 < len(tokens) && tokens[end+1].IsKeyword("NOT") {
+				return false, true
+			}
+			return true, true
+		case next.Is(TokenOperator, "<"), next.Is(TokenOperator, ">"), next.Is(TokenOperator, "<="),
+			next.Is(TokenOperator, ">="), next.IsKeyword("BETWEEN", "LIKE"):
+			return false, true
+		}
+	}
+
+	if start > 0 {
+		prev := tokens[start-1]
+		switch {
+		case prev.Is(TokenOperator, "="), prev.Is(TokenOperator, "<=>"):
+			return true, true
+		case prev.Is(TokenOperator, "<"), prev.Is(TokenOperator, ">"), prev.Is(TokenOperator, "<="), prev.Is(TokenOperator, ">="):
+			return false, true
+		}
+	}
+
+	return false, false
+}
+
+func GetTableIndexes(schema, table string) ([]TableIndex, error) {
+	db, err := GetDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Queryx(
+		"SELECT INDEX_NAME, NON_UNIQUE, SEQ_IN_INDEX, COALESCE(COLUMN_NAME, '') AS COLUMN_NAME FROM information_schema.STATISTICS "+
+			"WHERE TABLE_SCHEMA = COALESCE(NULLIF(?, ''), DATABASE()) AND TABLE_NAME = ? ORDER BY INDEX_NAME, SEQ_IN_INDEX",
+		schema, table,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []TableIndex{}
+	for rows.Next() {
+		var row IndexStatisticsRow
+		if err := rows.StructScan(&row); err != nil {
+			return nil, err
+		}
+
+		if len(result) == 0 || result[len(result)-1].Name != row.IndexName {
+			result = append(result, TableIndex{Name: row.IndexName, Unique: row.NonUnique == 0})
+		}
+		last := &result[len(result)-1]
+		last.Columns = append(last.Columns, row.ColumnName)
+	}
+
+	return result, nil
+}
+
+func GetTableColumns(schema, table string) ([]string, error) {
+	db, err := GetDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Queryx(
+		"SELECT COLUMN_NAME FROM information_schema.COLUMNS "+
+			"WHERE TABLE_SCHEMA = COALESCE(NULLIF(?, ''), DATABASE()) AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION",
+		schema, table,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		result = append(result, name)
+	}
+
+	return result, nil
+}
+
+// GetDigestQueries 从 performance_schema 中读取当前数据库耗时最高的 SELECT/UPDATE/DELETE 语句摘要
+func GetDigestQueries(limit int) ([]string, error) {
+	db, err := GetDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Queryx(
+		"SELECT DIGEST_TEXT FROM performance_schema.events_statements_summary_by_digest "+
+			"WHERE SCHEMA_NAME = DATABASE() AND DIGEST_TEXT IS NOT NULL ORDER BY SUM_TIMER_WAIT DESC LIMIT ?",
+		limit*4,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("读取 performance_schema 语句摘要失败: %v", err)
+	}
+	defer rows.Close()
+
+	result := []string{}
+	for rows.Next() && len(result) < limit {
+		var text string
+		if err := rows.Scan(&text); err != nil {
+			return nil, err
+		}
+
+		tokens := TokenizeSQL(text)
+		if len(tokens) > 0 && tokens[0].IsKeyword("SELECT", "UPDATE", "DELETE") {
+			result = append(result, text)
+		}
+	}
+
+	return result, nil
+}
+
+func HandleSuggestIndexes(query string, limit int) (string, error) {
+	queries := []string{query}
+	explain := true
+	if strings.TrimSpace(query) == "" {
+		digests, err := GetDigestQueries(limit)
+		if err != nil {
+			return "", err
+		}
+		if len(digests) == 0 {
+			return "", fmt.Errorf("performance_schema 中没有找到当前数据库的查询摘要")
+		}
+		queries = digests
+		// 摘要文本中的参数被替换为 ?，无法执行 EXPLAIN
+		explain = false
+	} else {
+		// 语句会拼接在 EXPLAIN 之后执行，ANALYZE 等其他语句会变成 EXPLAIN ANALYZE 而真正执行
+		if len(SplitStatements(query)) != 1 {
+			return "", fmt.Errorf("只能分析一条语句")
+		}
+		switch kind := StatementKind(TokenizeSQL(query)); kind {
+		case "SELECT", "UPDATE", "DELETE", "INSERT", "REPLACE":
+		default:
+			return "", fmt.Errorf("不支持分析 %s 语句，只支持 SELECT、UPDATE、DELETE、INSERT 和 REPLACE", kind)
+		}
+	}
+
+	for _, q := range queries {
+		if err := CheckStatementPolicy(q); err != nil {
+			return "", err
+		}
+		if err := CheckQueryAccess(q); err != nil {
+			return "", err
+		}
+	}
+
+	suggestions, err := SuggestIndexes(queries, explain)
+	if err != nil {
+		return "", err
+	}
+
+	if len(suggestions) == 0 {
+		return "现有索引已覆盖查询中的 WHERE/JOIN/ORDER BY 列，没有需要新增的索引", nil
+	}
+
+	var b strings.Builder
+	for i, s := range suggestions {
+		fmt.Fprintf(&b, "%d. %s\n", i+1, s.Statement)
+		fmt.Fprintf(&b, "   依据: %s\n", s.Reason)
+		fmt.Fprintf(&b, "   预期: %s\n", s.Expected)
+	}
+
+	return b.String(), nil
+}
+
+// SuggestIndexes 分析查询涉及的列并与现有索引对比，给出需要新增的索引
+func SuggestIndexes(queries []string, explain bool) ([]IndexSuggestion, error) {
+	indexCache := map[string][]TableIndex{}
+	columnCache := map[string][]string{}
+
+	loadIndexes := func(t TableRef) ([]TableIndex, error) {
+		key := strings.ToLower(t.Schema + "." + t.Name)
+		if idx, ok := indexCache[key]; ok {
+			return idx, nil
+		}
+		idx, err := GetTableIndexes(t.Schema, t.Name)
+		if err != nil {
+			return nil, err
+		}
+		indexCache[key] = idx
+		return idx, nil
+	}
+
+	loadColumns := func(t TableRef) ([]string, error) {
+		key := strings.ToLower(t.Schema + "." + t.Name)
+		if cols, ok := columnCache[key]; ok {
+			return cols, nil
+		}
+		cols, err := GetTableColumns(t.Schema, t.Name)
+		if err != nil {
+			return nil, err
+		}
+		columnCache[key] = cols
+		return cols, nil
+	}
+
+	suggestions := []IndexSuggestion{}
+	for _, query := range queries {
+		var plan []ExplainResult
+		if explain {
+			db, err := GetDB()
+			if err != nil {
+				return nil, err
+			}
+			result, err := DoExplain(db, query)
+			if err != nil {
+				return nil, fmt.Errorf("执行 EXPLAIN 失败: %v", err)
+			}
+			plan = result
+		}
+
+		for _, scope := range AnalyzeQueryScopes(query) {
+			candidates, err := scopeCandidates(scope, loadColumns)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, c := range candidates {
+				indexes, err := loadIndexes(c.Table)
+				if err != nil {
+					return nil, err
+				}
+				if indexCovers(indexes, c.Columns) {
+					continue
+				}
+
+				c.Statement = fmt.Sprintf("CREATE INDEX %s ON %s (%s);", QuoteIdentifier(indexName(c.Table.Name, c.Columns)), c.Table.QuotedName(), quoteColumns(c.Columns))
+				c.Expected = expectedPlanChange(c, plan)
+				suggestions = mergeSuggestion(suggestions, c)
+			}
+		}
+	}
+
+	return suggestions, nil
+}
+
+type indexCandidate struct {
+	IndexSuggestion
+	equality []string
+	ranges   []string
+	order    []string
+}
+
+func scopeCandidates(scope QueryScope, loadColumns func(TableRef) ([]string, error)) ([]IndexSuggestion, error) {
+	// resolve 返回列所属的表，无法确定或表中不存在该列（例如 CURRENT_DATE 之类的关键字）时返回 -1
+	resolve := func(ref ColumnRef) (int, error) {
+		found := -1
+		for i, t := range scope.Tables {
+			if ref.Qualifier != "" && !t.Matches(ref.Qualifier) {
+				continue
+			}
+
+			cols, err := loadColumns(t)
+			if err != nil {
+				return -1, err
+			}
+			if containsFold(cols, ref.Column) {
+				if found >= 0 {
+					return -1, nil
+				}
+				found = i
+			}
+		}
+		return found, nil
+	}
+
+	candidates := make([]indexCandidate, len(scope.Tables))
+	for i, t := range scope.Tables {
+		candidates[i].Table = t
+	}
+
+	for _, p := range scope.Predicates {
+		i, err := resolve(p.ColumnRef)
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 {
+			continue
+		}
+		if p.Equality {
+			candidates[i].equality = appendUniqueFold(candidates[i].equality, p.Column)
+		} else {
+			candidates[i].ranges = appendUniqueFold(candidates[i].ranges, p.Column)
+		}
+	}
+
+	orderTable := -1
+	for _, ref := range scope.OrderBy {
+		i, err := resolve(ref)
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || (orderTable >= 0 && orderTable != i) {
+			orderTable = -1
+			break
+		}
+		orderTable = i
+		candidates[i].order = appendUniqueFold(candidates[i].order, ref.Column)
+	}
+
+	result := []IndexSuggestion{}
+	for i, c := range candidates {
+		cols := append([]string{}, c.equality...)
+		reasons := []string{}
+		if len(c.equality) > 0 {
+			reasons = append(reasons, "等值条件 "+strings.Join(c.equality, ", "))
+		}
+
+		ranges := []string{}
+		for _, col := range c.ranges {
+			if !containsFold(cols, col) {
+				ranges = append(ranges, col)
+			}
+		}
+
+		c.Access = "ref"
+		switch {
+		case len(ranges) > 0:
+			// 范围条件之后的列无法继续使用索引，因此只取第一个
+			cols = append(cols, ranges[0])
+			reasons = append(reasons, "范围条件 "+ranges[0])
+			c.Access = "range"
+		case i == orderTable:
+			for _, col := range c.order {
+				cols = appendUniqueFold(cols, col)
+			}
+			reasons = append(reasons, "ORDER BY "+strings.Join(c.order, ", "))
+			c.SortsRows = true
+			if len(c.equality) == 0 {
+				c.Access = "index"
+			}
+		}
+
+		if len(cols) == 0 {
+			continue
+		}
+
+		c.Columns = cols
+		c.Reason = strings.Join(reasons, "；")
+		result = append(result, c.IndexSuggestion)
+	}
+
+	return result, nil
+}
+
+// indexCovers 判断建议的列是否已经是某个现有索引的最左前缀
+func indexCovers(indexes []TableIndex, cols []string) bool {
+	for _, idx := range indexes {
+		if len(idx.Columns) < len(cols) {
+			continue
+		}
+
+		covered := true
+		for i, col := range cols {
+			if !strings.EqualFold(idx.Columns[i], col) {
+				covered = false
+				break
+			}
+		}
+		if covered {
+			return true
+		}
+	}
+
+	return false
+}
+
+func expectedPlanChange(s IndexSuggestion, plan []ExplainResult) string {
+	name := indexName(s.Table.Name, s.Columns)
+
+	for _, row := range plan {
+		if row.Table == nil || !(strings.EqualFold(*row.Table, s.Table.Name) || strings.EqualFold(*row.Table, s.Table.Alias)) {
+			continue
+		}
+
+		current := "NULL"
+		if row.Key != nil {
+			current = *row.Key
+		}
+		typ := "NULL"
+		if row.Type != nil {
+			typ = *row.Type
+		}
+
+		expected := fmt.Sprintf("%s 的访问类型由 %s（key=%s，预计扫描 %d 行）变为 %s，使用索引 %s", row.tableName(), typ, current, row.ExplainRows(), s.Access, name)
+		if row.Extra != nil && strings.Contains(*row.Extra, "Using filesort") && s.SortsRows {
+			expected += "，并消除 Using filesort"
+		}
+		return expected
+	}
+
+	return fmt.Sprintf("%s 可通过索引 %s 以 %s 方式访问", s.Table.Name, name, s.Access)
+}
+
+// mergeSuggestion 合并重复的建议，同一张表上被更长索引覆盖的建议会被丢弃
+func mergeSuggestion(suggestions []IndexSuggestion, s IndexSuggestion) []IndexSuggestion {
+	for i, existing := range suggestions {
+		if !strings.EqualFold(existing.Table.Name, s.Table.Name) || !strings.EqualFold(existing.Table.Schema, s.Table.Schema) {
+			continue
+		}
+
+		existingIndex := []TableIndex{{Columns: existing.Columns}}
+		if indexCovers(existingIndex, s.Columns) {
+			return suggestions
+		}
+		if indexCovers([]TableIndex{{Columns: s.Columns}}, existing.Columns) {
+			suggestions[i] = s
+			return suggestions
+		}
+	}
+
+	return append(suggestions, s)
+}
+
+// indexName 生成建议的索引名。超过 MySQL 64 个字符的上限时按字符截断，
+// 并附加完整名称的哈希，避免截断后不同的建议重名
+func indexName(table string, cols []string) string {
+	name := []rune(strings.ToLower("idx_" + table + "_" + strings.Join(cols, "_")))
+	if len(name) <= 64 {
+		return string(name)
+	}
+
+	sum := sha256.Sum256([]byte(string(name)))
+	return string(name[:55]) + "_" + hex.EncodeToString(sum[:4])
+}
+
+func quoteColumns(cols []string) string {
+	quoted := make([]string, len(cols))
+	for i, col := range cols {
+		quoted[i] = QuoteIdentifier(col)
+	}
+
+	return strings.Join(quoted, ", ")
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func appendUniqueFold(list []string, s string) []string {
+	if containsFold(list, s) {
+		return list
+	}
+
+	return append(list, s)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyzeQueryScopes(t *testing.T) {
+	t.Run("join with aliases", func(t *testing.T) {
+		scopes := AnalyzeQueryScopes("SELECT o.id FROM orders o JOIN users AS u ON u.id = o.user_id WHERE o.status = 1 AND o.created_at > '2024-01-01' ORDER BY o.created_at")
+
+		assert.Len(t, scopes, 1)
+		assert.Equal(t, []TableRef{{Name: "orders", Alias: "o"}, {Name: "users", Alias: "u"}}, scopes[0].Tables)
+		assert.Equal(t, []ColumnPredicate{
+			{ColumnRef: ColumnRef{Qualifier: "u", Column: "id"}, Equality: true},
+			{ColumnRef: ColumnRef{Qualifier: "o", Column: "user_id"}, Equality: true},
+			{ColumnRef: ColumnRef{Qualifier: "o", Column: "status"}, Equality: true},
+			{ColumnRef: ColumnRef{Qualifier: "o", Column: "created_at"}, Equality: false},
+		}, scopes[0].Predicates)
+		assert.Equal(t, []ColumnRef{{Qualifier: "o", Column: "created_at"}}, scopes[0].OrderBy)
+	})
+
+	t.Run("subquery is a separate scope", func(t *testing.T) {
+		scopes := AnalyzeQueryScopes("SELECT * FROM users WHERE id IN (SELECT user_id FROM orders WHERE amount > 100)")
+
+		assert.Len(t, scopes, 2)
+		assert.Equal(t, "orders", scopes[0].Tables[0].Name)
+		assert.Equal(t, "amount", scopes[0].Predicates[0].Column)
+		assert.Equal(t, "users", scopes[1].Tables[0].Name)
+		assert.Equal(t, "id", scopes[1].Predicates[0].Column)
+	})
+
+	t.Run("update statement", func(t *testing.T) {
+		scopes := AnalyzeQueryScopes("UPDATE shop.orders SET status = 2 WHERE user_id = 3")
+
+		assert.Len(t, scopes, 1)
+		assert.Equal(t, TableRef{Schema: "shop", Name: "orders"}, scopes[0].Tables[0])
+		assert.Len(t, scopes[0].Predicates, 1)
+		assert.Equal(t, "user_id", scopes[0].Predicates[0].Column)
+	})
+
+	t.Run("functions are not columns", func(t *testing.T) {
+		scopes := AnalyzeQueryScopes("SELECT * FROM t WHERE DATE(created_at) = CURDATE()")
+
+		assert.Len(t, scopes, 1)
+		assert.Empty(t, scopes[0].Predicates)
+	})
+}
+
+func TestHandleSuggestIndexes(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	t.Run("suggests composite index", func(t *testing.T) {
+		// 设置模拟预期
+		explainRows := sqlmock.NewRows(explainColumns).
+			AddRow("1", "SIMPLE", "orders", nil, "ALL", nil, nil, nil, nil, "50000", "1.00", "Using where; Using filesort")
+		mock.ExpectQuery("EXPLAIN").WillReturnRows(explainRows)
+
+		mock.ExpectQuery("FROM information_schema.COLUMNS").
+			WithArgs("", "orders").
+			WillReturnRows(sqlmock.NewRows([]string{"COLUMN_NAME"}).AddRow("id").AddRow("user_id").AddRow("status").AddRow("created_at"))
+
+		mock.ExpectQuery("FROM information_schema.STATISTICS").
+			WithArgs("", "orders").
+			WillReturnRows(sqlmock.NewRows([]string{"INDEX_NAME", "NON_UNIQUE", "SEQ_IN_INDEX", "COLUMN_NAME"}).
+				AddRow("PRIMARY", 0, 1, "id").
+				AddRow("idx_user", 1, 1, "user_id"))
+
+		// 调用 HandleSuggestIndexes
+		result, err := HandleSuggestIndexes("SELECT * FROM orders WHERE user_id = 1 AND status = 2 ORDER BY created_at", 10)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "CREATE INDEX `idx_orders_user_id_status_created_at` ON `orders` (`user_id`, `status`, `created_at`);")
+		assert.Contains(t, result, "等值条件 user_id, status")
+		assert.Contains(t, result, "由 ALL")
+		assert.Contains(t, result, "消除 Using filesort")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("already covered", func(t *testing.T) {
+		// 设置模拟预期
+		explainRows := sqlmock.NewRows(explainColumns).
+			AddRow("1", "SIMPLE", "orders", nil, "ref", "idx_user", "idx_user", "4", "const", "3", "100.00", nil)
+		mock.ExpectQuery("EXPLAIN").WillReturnRows(explainRows)
+
+		mock.ExpectQuery("FROM information_schema.COLUMNS").
+			WillReturnRows(sqlmock.NewRows([]string{"COLUMN_NAME"}).AddRow("id").AddRow("user_id"))
+
+		mock.ExpectQuery("FROM information_schema.STATISTICS").
+			WillReturnRows(sqlmock.NewRows([]string{"INDEX_NAME", "NON_UNIQUE", "SEQ_IN_INDEX", "COLUMN_NAME"}).
+				AddRow("idx_user", 1, 1, "user_id"))
+
+		// 调用 HandleSuggestIndexes
+		result, err := HandleSuggestIndexes("SELECT * FROM orders WHERE user_id = 1", 10)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "没有需要新增的索引")
+	})
+
+	t.Run("from digest", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("FROM performance_schema.events_statements_summary_by_digest").
+			WithArgs(4).
+			WillReturnRows(sqlmock.NewRows([]string{"DIGEST_TEXT"}).
+				AddRow("SHOW TABLES").
+				AddRow("SELECT * FROM `users` WHERE `email` = ?"))
+
+		mock.ExpectQuery("FROM information_schema.COLUMNS").
+			WillReturnRows(sqlmock.NewRows([]string{"COLUMN_NAME"}).AddRow("id").AddRow("email"))
+
+		mock.ExpectQuery("FROM information_schema.STATISTICS").
+			WillReturnRows(sqlmock.NewRows([]string{"INDEX_NAME", "NON_UNIQUE", "SEQ_IN_INDEX", "COLUMN_NAME"}).
+				AddRow("PRIMARY", 0, 1, "id"))
+
+		// 调用 HandleSuggestIndexes
+		result, err := HandleSuggestIndexes("", 1)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "CREATE INDEX `idx_users_email` ON `users` (`email`);")
+	})
+
+	t.Run("digest error", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("FROM performance_schema").WillReturnError(fmt.Errorf("access denied"))
+
+		// 调用 HandleSuggestIndexes
+		_, err := HandleSuggestIndexes("", 10)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "access denied")
+	})
+
+	t.Run("rejects statements that would run", func(t *testing.T) {
+		for _, query := range []string{
+			"ANALYZE SELECT * FROM orders",
+			"EXPLAIN SELECT * FROM orders",
+			"DROP TABLE orders",
+			"SELECT * FROM orders; DELETE FROM orders",
+		} {
+			// 调用 HandleSuggestIndexes
+			_, err := HandleSuggestIndexes(query, 10)
+
+			// 验证结果
+			assert.Error(t, err, query)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("statement policy", func(t *testing.T) {
+		policy, _ := ParseStatementPolicy([]byte(`{"deny": ["DELETE"]}`))
+		original := Statements
+		Statements = policy
+		defer func() { Statements = original }()
+
+		// 调用 HandleSuggestIndexes
+		_, err := HandleSuggestIndexes("DELETE FROM orders WHERE user_id = 1", 10)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "语句策略禁止执行 DELETE 语句")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestIndexCovers(t *testing.T) {
+	indexes := []TableIndex{{Name: "idx_a_b", Columns: []string{"a", "b"}}}
+
+	assert.True(t, indexCovers(indexes, []string{"A"}))
+	assert.True(t, indexCovers(indexes, []string{"a", "b"}))
+	assert.False(t, indexCovers(indexes, []string{"b"}))
+	assert.False(t, indexCovers(indexes, []string{"a", "b", "c"}))
+}
+
+func TestIndexName(t *testing.T) {
+	t.Run("short name", func(t *testing.T) {
+		assert.Equal(t, "idx_orders_user_id_status", indexName("Orders", []string{"user_id", "Status"}))
+	})
+
+	t.Run("long name is truncated by characters", func(t *testing.T) {
+		cols := []string{strings.Repeat("订单", 30), "创建时间"}
+		name := indexName("用户订单", cols)
+		other := indexName("用户订单", []string{strings.Repeat("订单", 30), "更新时间"})
+
+		assert.Equal(t, 64, utf8.RuneCountInString(name))
+		assert.True(t, utf8.ValidString(name))
+		assert.NotEqual(t, name, other)
+		assert.Equal(t, name, indexName("用户订单", cols))
+	})
+}
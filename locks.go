@@ -0,0 +1,454 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// lockWait 是一条锁等待关系：waiting 会话在等待 blocking 会话持有的锁
+type lockWait struct {
+	WaitingPID       int64          `db:"waiting_pid"`
+	WaitingQuery     sql.NullString `db:"waiting_query"`
+	WaitSeconds      int64          `db:"wait_age_secs"`
+	Schema           sql.NullString `db:"locked_table_schema"`
+	Table            sql.NullString `db:"locked_table_name"`
+	Index            sql.NullString `db:"locked_index"`
+	LockType         string         `db:"locked_type"`
+	WaitingLockMode  string         `db:"waiting_lock_mode"`
+	BlockingPID      int64          `db:"blocking_pid"`
+	BlockingQuery    sql.NullString `db:"blocking_query"`
+	BlockingLockMode string         `db:"blocking_lock_mode"`
+	BlockingTrxAge   int64          `db:"blocking_trx_age_secs"`
+}
+
+// sys.innodb_lock_waits 已经把 data_lock_waits、data_locks 和 INNODB_TRX 连接好
+const sysLockWaitsQuery = `SELECT waiting_pid, waiting_query, wait_age_secs, locked_table_schema, locked_table_name,
+	locked_index, locked_type, waiting_lock_mode, blocking_pid, blocking_query, blocking_lock_mode,
+	TIME_TO_SEC(blocking_trx_age) AS blocking_trx_age_secs
+FROM sys.innodb_lock_waits`
+
+// 没有 sys 库或没有权限时直接连接 performance_schema 和 INNODB_TRX
+const dataLockWaitsQuery = `SELECT r.trx_mysql_thread_id AS waiting_pid, r.trx_query AS waiting_query,
+	TIMESTAMPDIFF(SECOND, r.trx_wait_started, NOW()) AS wait_age_secs,
+	wl.OBJECT_SCHEMA AS locked_table_schema, wl.OBJECT_NAME AS locked_table_name, wl.INDEX_NAME AS locked_index,
+	wl.LOCK_TYPE AS locked_type, wl.LOCK_MODE AS waiting_lock_mode,
+	b.trx_mysql_thread_id AS blocking_pid, b.trx_query AS blocking_query, bl.LOCK_MODE AS blocking_lock_mode,
+	TIMESTAMPDIFF(SECOND, b.trx_started, NOW()) AS blocking_trx_age_secs
+FROM performance_schema.data_lock_waits w
+JOIN information_schema.INNODB_TRX r ON r.trx_id = w.REQUESTING_ENGINE_TRANSACTION_ID
+JOIN information_schema.INNODB_TRX b ON b.trx_id = w.BLOCKING_ENGINE_TRANSACTION_ID
+JOIN performance_schema.data_locks wl ON wl.ENGINE_LOCK_ID = w.REQUESTING_ENGINE_LOCK_ID
+JOIN performance_schema.data_locks bl ON bl.ENGINE_LOCK_ID = w.BLOCKING_ENGINE_LOCK_ID`
+
+// HandleShowLocks 列出 InnoDB 锁等待，并按阻塞关系组织成树：根节点是没有在等待锁的阻塞者，
+// 子节点是被它阻塞的会话。启用访问控制时，锁所在的库不允许访问的等待关系不显示
+func HandleShowLocks() (string, error) {
+	db, err := GetDB()
+	if err != nil {
+		return "", err
+	}
+
+	waits := []lockWait{}
+	if err := db.Select(&waits, sysLockWaitsQuery); err != nil {
+		waits = waits[:0]
+		if err2 := db.Select(&waits, dataLockWaitsQuery); err2 != nil {
+			return "", fmt.Errorf("读取锁等待失败: %v（sys.innodb_lock_waits: %v）", err2, err)
+		}
+	}
+
+	visible := []lockWait{}
+	for _, w := range waits {
+		if w.Schema.Valid && !SchemaAccessible(w.Schema.String) {
+			continue
+		}
+		visible = append(visible, w)
+	}
+	if len(visible) == 0 {
+		return "当前没有锁等待", nil
+	}
+
+	table, err := lockWaitsCSV(visible)
+	if err != nil {
+		return "", err
+	}
+
+	return formatLockTree(visible) + "\n" + table, nil
+}
+
+// formatLockTree 把锁等待关系画成阻塞树
+func formatLockTree(waits []lockWait) string {
+	children := map[int64][]lockWait{}
+	waiting := map[int64]bool{}
+	blockers := map[int64]lockWait{}
+	for _, w := range waits {
+		children[w.BlockingPID] = append(children[w.BlockingPID], w)
+		waiting[w.WaitingPID] = true
+		if _, ok := blockers[w.BlockingPID]; !ok {
+			blockers[w.BlockingPID] = w
+		}
+	}
+
+	roots := []int64{}
+	for pid := range blockers {
+		if !waiting[pid] {
+			roots = append(roots, pid)
+		}
+	}
+	// 所有阻塞者都在等待时存在环（通常很快会被 InnoDB 当作死锁处理），从 PID 最小的会话开始
+	if len(roots) == 0 {
+		for pid := range blockers {
+			roots = append(roots, pid)
+		}
+		sort.Slice(roots, func(i, j int) bool { return roots[i] < roots[j] })
+		roots = roots[:1]
+	}
+	sort.Slice(roots, func(i, j int) bool { return roots[i] < roots[j] })
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d 个锁等待，%d 个阻塞源头:\n", len(waits), len(roots))
+	visited := map[int64]bool{}
+	var walk func(pid int64, indent string)
+	walk = func(pid int64, indent string) {
+		visited[pid] = true
+		list := children[pid]
+		sort.Slice(list, func(i, j int) bool { return list[i].WaitSeconds > list[j].WaitSeconds })
+		for _, w := range list {
+			fmt.Fprintf(&b, "%s└─ 会话 %d 等待 %d 秒，%s（请求 %s，持有 %s）: %s\n", indent, w.WaitingPID, w.WaitSeconds,
+				lockTarget(w), w.WaitingLockMode, w.BlockingLockMode, lockQuery(w.Schema.String, w.WaitingQuery))
+			if visited[w.WaitingPID] {
+				fmt.Fprintf(&b, "%s   （会话 %d 已在上方出现，存在循环等待）\n", indent, w.WaitingPID)
+				continue
+			}
+			walk(w.WaitingPID, indent+"   ")
+		}
+	}
+	for _, pid := range roots {
+		root := blockers[pid]
+		fmt.Fprintf(&b, "会话 %d（事务已运行 %d 秒）: %s\n", pid, root.BlockingTrxAge, lockQuery(root.Schema.String, root.BlockingQuery))
+		walk(pid, "  ")
+	}
+
+	return b.String()
+}
+
+func lockTarget(w lockWait) string {
+	target := w.Table.String
+	if w.Schema.String != "" {
+		target = w.Schema.String + "." + target
+	}
+	if w.Index.Valid {
+		return fmt.Sprintf("%s 索引 %s 上的 %s 锁", target, w.Index.String, w.LockType)
+	}
+
+	return fmt.Sprintf("%s 上的 %s 锁", target, w.LockType)
+}
+
+// lockQuery 返回会话正在执行的语句，未写库名的表按被锁表所在的数据库检查访问权限；
+// 阻塞者经常是没有执行语句的未提交事务
+func lockQuery(schema string, query sql.NullString) string {
+	if !query.Valid || strings.TrimSpace(query.String) == "" {
+		return "（没有正在执行的语句，可能是未提交的事务）"
+	}
+
+	return visibleQuery(schema, query.String, defaultProcessQueryLength)
+}
+
+func lockWaitsCSV(waits []lockWait) (string, error) {
+	rows := []map[string]interface{}{}
+	for _, w := range waits {
+		rows = append(rows, map[string]interface{}{
+			"waiting_pid":        w.WaitingPID,
+			"blocking_pid":       w.BlockingPID,
+			"wait_secs":          w.WaitSeconds,
+			"table":              strings.TrimPrefix(w.Schema.String+"."+w.Table.String, "."),
+			"index":              w.Index.String,
+			"lock_type":          w.LockType,
+			"waiting_lock_mode":  w.WaitingLockMode,
+			"blocking_lock_mode": w.BlockingLockMode,
+		})
+	}
+
+	return MapToCSV(rows, []string{"waiting_pid", "blocking_pid", "wait_secs", "table", "index", "lock_type", "waiting_lock_mode", "blocking_lock_mode"})
+}
+
+// DeadlockLock 是死锁信息中的一把锁
+type DeadlockLock struct {
+	Table string
+	Index string
+	Mode  string
+}
+
+// Schema 返回 `db`.`table` 形式的表名中的库名
+func (l DeadlockLock) Schema() string {
+	schema, _, ok := strings.Cut(l.Table, "`.`")
+	if !ok {
+		return ""
+	}
+
+	return strings.TrimPrefix(schema, "`")
+}
+
+func (l DeadlockLock) String() string {
+	if l.Index != "" {
+		return fmt.Sprintf("%s 索引 %s: %s", l.Table, l.Index, l.Mode)
+	}
+
+	return fmt.Sprintf("%s: %s", l.Table, l.Mode)
+}
+
+// DeadlockTransaction 是死锁中的一个事务
+type DeadlockTransaction struct {
+	Number     int
+	ID         string
+	Active     string
+	ThreadID   int64
+	Query      string
+	Holds      []DeadlockLock
+	Waits      []DeadlockLock
+	RolledBack bool
+}
+
+func (trx DeadlockTransaction) accessible() bool {
+	for _, lock := range append(append([]DeadlockLock{}, trx.Holds...), trx.Waits...) {
+		if schema := lock.Schema(); schema != "" && !SchemaAccessible(schema) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// schema 返回事务锁定的第一个表所在的数据库，作为语句中未写库名的表的默认数据库
+func (trx DeadlockTransaction) schema() string {
+	for _, lock := range append(append([]DeadlockLock{}, trx.Holds...), trx.Waits...) {
+		if schema := lock.Schema(); schema != "" {
+			return schema
+		}
+	}
+
+	return ""
+}
+
+// Deadlock 是 SHOW ENGINE INNODB STATUS 中 LATEST DETECTED DEADLOCK 部分的解析结果
+type Deadlock struct {
+	Time         string
+	Transactions []DeadlockTransaction
+}
+
+var (
+	deadlockTransactionHeader = regexp.MustCompile(`^\*\*\* \((\d+)\) TRANSACTION:`)
+	deadlockSection           = regexp.MustCompile(`^\*\*\* \((\d+)\) (HOLDS THE LOCK\(S\)|WAITING FOR THIS LOCK TO BE GRANTED):`)
+	deadlockRollback          = regexp.MustCompile(`^\*\*\* WE ROLL BACK TRANSACTION \((\d+)\)`)
+	deadlockTrx               = regexp.MustCompile(`^TRANSACTION (\d+), ACTIVE (\d+ sec)`)
+	deadlockThread            = regexp.MustCompile(`^MySQL thread id (\d+),`)
+	deadlockRecordLock        = regexp.MustCompile(`^RECORD LOCKS .* index (\S+) of table (\S+) trx id \d+ (.*)$`)
+	deadlockTableLock         = regexp.MustCompile(`^TABLE LOCK table (\S+) trx id \d+ (.*)$`)
+)
+
+// HandleLastDeadlock 读取 SHOW ENGINE INNODB STATUS，解析最近一次检测到的死锁
+func HandleLastDeadlock() (string, error) {
+	db, err := GetDB()
+	if err != nil {
+		return "", err
+	}
+
+	var engine struct {
+		Type   string `db:"Type"`
+		Name   string `db:"Name"`
+		Status string `db:"Status"`
+	}
+	if err := db.Get(&engine, "SHOW ENGINE INNODB STATUS"); err != nil {
+		return "", fmt.Errorf("执行 SHOW ENGINE INNODB STATUS 失败: %v", err)
+	}
+
+	deadlock, ok := ParseDeadlock(engine.Status)
+	if !ok {
+		return "服务器启动以来没有检测到死锁", nil
+	}
+
+	// 启用访问控制时，不显示涉及不允许访问的库的事务
+	transactions := []DeadlockTransaction{}
+	for _, trx := range deadlock.Transactions {
+		if trx.accessible() {
+			transactions = append(transactions, trx)
+		}
+	}
+	if len(transactions) == 0 {
+		return "", fmt.Errorf("最近一次死锁涉及的库不允许访问")
+	}
+	deadlock.Transactions = transactions
+
+	return formatDeadlock(deadlock)
+}
+
+// ParseDeadlock 从 InnoDB 状态文本中解析 LATEST DETECTED DEADLOCK 部分，没有该部分时返回 false
+func ParseDeadlock(status string) (Deadlock, bool) {
+	lines := strings.Split(status, "\n")
+	start := -1
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "LATEST DETECTED DEADLOCK" {
+			start = i + 1
+			break
+		}
+	}
+	if start < 0 {
+		return Deadlock{}, false
+	}
+	// 跳过标题下方的分隔线
+	if start < len(lines) && dashLine(lines[start]) {
+		start++
+	}
+
+	deadlock := Deadlock{}
+	var current *DeadlockTransaction
+	section := ""
+	for _, line := range lines[start:] {
+		line = strings.TrimRight(line, "\r")
+		if dashLine(line) {
+			break
+		}
+
+		if m := deadlockTransactionHeader.FindStringSubmatch(line); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			deadlock.Transactions = append(deadlock.Transactions, DeadlockTransaction{Number: n})
+			current = &deadlock.Transactions[len(deadlock.Transactions)-1]
+			section = "transaction"
+			continue
+		}
+		if m := deadlockSection.FindStringSubmatch(line); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			current = deadlockTransactionByNumber(&deadlock, n)
+			section = "holds"
+			if strings.HasPrefix(m[2], "WAITING") {
+				section = "waits"
+			}
+			continue
+		}
+		if m := deadlockRollback.FindStringSubmatch(line); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			if trx := deadlockTransactionByNumber(&deadlock, n); trx != nil {
+				trx.RolledBack = true
+			}
+			section = ""
+			continue
+		}
+		if current == nil {
+			if deadlock.Time == "" && strings.TrimSpace(line) != "" {
+				deadlock.Time = deadlockTime(line)
+			}
+			continue
+		}
+
+		switch section {
+		case "transaction":
+			if m := deadlockTrx.FindStringSubmatch(line); m != nil {
+				current.ID, current.Active = m[1], m[2]
+			} else if m := deadlockThread.FindStringSubmatch(line); m != nil {
+				current.ThreadID, _ = strconv.ParseInt(m[1], 10, 64)
+				// 线程信息之后到下一个 *** 之前是事务正在执行的语句，可能有多行
+				section = "query"
+			}
+		case "query":
+			if current.Query != "" {
+				current.Query += "\n"
+			}
+			current.Query += line
+		case "holds", "waits":
+			lock, ok := parseDeadlockLock(line)
+			if !ok {
+				continue
+			}
+			if section == "holds" {
+				current.Holds = append(current.Holds, lock)
+			} else {
+				current.Waits = append(current.Waits, lock)
+			}
+		}
+	}
+
+	return deadlock, len(deadlock.Transactions) > 0
+}
+
+func dashLine(line string) bool {
+	line = strings.TrimSpace(line)
+	return line != "" && strings.Trim(line, "-") == ""
+}
+
+// deadlockTime 从 "2024-01-15 10:23:45 0x7f..." 形式的行中取出时间
+func deadlockTime(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) >= 2 {
+		return fields[0] + " " + fields[1]
+	}
+
+	return strings.TrimSpace(line)
+}
+
+func deadlockTransactionByNumber(deadlock *Deadlock, n int) *DeadlockTransaction {
+	for i := range deadlock.Transactions {
+		if deadlock.Transactions[i].Number == n {
+			return &deadlock.Transactions[i]
+		}
+	}
+
+	return nil
+}
+
+func parseDeadlockLock(line string) (DeadlockLock, bool) {
+	if m := deadlockRecordLock.FindStringSubmatch(line); m != nil {
+		return DeadlockLock{Table: m[2], Index: m[1], Mode: strings.TrimSuffix(m[3], " waiting")}, true
+	}
+	if m := deadlockTableLock.FindStringSubmatch(line); m != nil {
+		return DeadlockLock{Table: m[1], Mode: strings.TrimSuffix(m[2], " waiting")}, true
+	}
+
+	return DeadlockLock{}, false
+}
+
+// formatDeadlock 生成死锁摘要，然后以 CSV 列出每个事务
+func formatDeadlock(deadlock Deadlock) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "最近一次死锁发生于 %s，涉及 %d 个事务\n", deadlock.Time, len(deadlock.Transactions))
+
+	rows := []map[string]interface{}{}
+	for _, trx := range deadlock.Transactions {
+		fmt.Fprintf(&b, "事务 (%d) %s，会话 %d，已活跃 %s", trx.Number, trx.ID, trx.ThreadID, trx.Active)
+		if trx.RolledBack {
+			b.WriteString("，已被回滚")
+		}
+		query := visibleQuery(trx.schema(), trx.Query, defaultProcessQueryLength)
+		fmt.Fprintf(&b, ": %s\n", query)
+
+		holds, waits := []string{}, []string{}
+		for _, lock := range trx.Holds {
+			holds = append(holds, lock.String())
+			fmt.Fprintf(&b, "  持有 %s\n", lock)
+		}
+		for _, lock := range trx.Waits {
+			waits = append(waits, lock.String())
+			fmt.Fprintf(&b, "  等待 %s\n", lock)
+		}
+
+		rows = append(rows, map[string]interface{}{
+			"transaction": trx.Number,
+			"trx_id":      trx.ID,
+			"thread_id":   trx.ThreadID,
+			"active":      trx.Active,
+			"rolled_back": trx.RolledBack,
+			"holds":       strings.Join(holds, "; "),
+			"waits":       strings.Join(waits, "; "),
+			"query":       query,
+		})
+	}
+
+	table, err := MapToCSV(rows, []string{"transaction", "trx_id", "thread_id", "active", "rolled_back", "holds", "waits", "query"})
+	if err != nil {
+		return "", err
+	}
+
+	return b.String() + "\n" + table, nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var lockWaitColumns = []string{"waiting_pid", "waiting_query", "wait_age_secs", "locked_table_schema", "locked_table_name",
+	"locked_index", "locked_type", "waiting_lock_mode", "blocking_pid", "blocking_query", "blocking_lock_mode", "blocking_trx_age_secs"}
+
+const deadlockStatus = `
+=====================================
+2024-01-15 10:25:01 0x7f4c2c0e1700 INNODB MONITOR OUTPUT
+=====================================
+------------------------
+LATEST DETECTED DEADLOCK
+------------------------
+2024-01-15 10:23:45 0x7f4c2c0e1700
+*** (1) TRANSACTION:
+TRANSACTION 12345, ACTIVE 5 sec starting index read
+mysql tables in use 1, locked 1
+LOCK WAIT 3 lock struct(s), heap size 1128, 2 row lock(s)
+MySQL thread id 12, OS thread handle 139965, query id 567 10.0.0.2 mcp updating
+UPDATE accounts
+  SET balance = balance - 10 WHERE id = 2
+*** (1) HOLDS THE LOCK(S):
+RECORD LOCKS space id 2 page no 4 n bits 72 index PRIMARY of table ` + "`shop`.`accounts`" + ` trx id 12345 lock_mode X locks rec but not gap
+Record lock, heap no 2 PHYSICAL RECORD: n_fields 4; compact format; info bits 0
+ 0: len 4; hex 80000001; asc     ;;
+*** (1) WAITING FOR THIS LOCK TO BE GRANTED:
+RECORD LOCKS space id 2 page no 4 n bits 72 index PRIMARY of table ` + "`shop`.`accounts`" + ` trx id 12345 lock_mode X locks rec but not gap waiting
+Record lock, heap no 3 PHYSICAL RECORD: n_fields 4; compact format; info bits 0
+*** (2) TRANSACTION:
+TRANSACTION 12346, ACTIVE 3 sec starting index read
+mysql tables in use 1, locked 1
+MySQL thread id 15, OS thread handle 139966, query id 570 10.0.0.3 app updating
+UPDATE accounts SET balance = balance + 10 WHERE id = 1
+*** (2) HOLDS THE LOCK(S):
+TABLE LOCK table ` + "`shop`.`accounts`" + ` trx id 12346 lock mode IX
+RECORD LOCKS space id 2 page no 4 n bits 72 index PRIMARY of table ` + "`shop`.`accounts`" + ` trx id 12346 lock_mode X locks rec but not gap
+*** (2) WAITING FOR THIS LOCK TO BE GRANTED:
+RECORD LOCKS space id 2 page no 4 n bits 72 index PRIMARY of table ` + "`shop`.`accounts`" + ` trx id 12346 lock_mode X locks rec but not gap waiting
+*** WE ROLL BACK TRANSACTION (2)
+------------
+TRANSACTIONS
+------------
+Trx id counter 12350
+`
+
+func TestHandleShowLocks(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	t.Run("blocking tree", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("FROM sys.innodb_lock_waits").
+			WillReturnRows(sqlmock.NewRows(lockWaitColumns).
+				AddRow(18, "ALTER TABLE orders ADD COLUMN note text", 12, "shop", "orders", nil, "TABLE", "X", 15, "UPDATE orders SET status = 'paid' WHERE id = 7", "IX", 41).
+				AddRow(15, "UPDATE orders SET status = 'paid' WHERE id = 7", 40, "shop", "orders", "PRIMARY", "RECORD", "X,REC_NOT_GAP", 12, nil, "X,REC_NOT_GAP", 95))
+
+		// 调用 HandleShowLocks
+		result, err := HandleShowLocks()
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, "2 个锁等待，1 个阻塞源头:\n"+
+			"会话 12（事务已运行 95 秒）: （没有正在执行的语句，可能是未提交的事务）\n"+
+			"  └─ 会话 15 等待 40 秒，shop.orders 索引 PRIMARY 上的 RECORD 锁（请求 X,REC_NOT_GAP，持有 X,REC_NOT_GAP）: UPDATE orders SET status = 'paid' WHERE id = 7\n"+
+			"     └─ 会话 18 等待 12 秒，shop.orders 上的 TABLE 锁（请求 X，持有 IX）: ALTER TABLE orders ADD COLUMN note text\n"+
+			"\n"+
+			"waiting_pid,blocking_pid,wait_secs,table,index,lock_type,waiting_lock_mode,blocking_lock_mode\n"+
+			"18,15,12,shop.orders,,TABLE,X,IX\n"+
+			"15,12,40,shop.orders,PRIMARY,RECORD,\"X,REC_NOT_GAP\",\"X,REC_NOT_GAP\"\n", result)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("falls back to performance_schema", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("FROM sys.innodb_lock_waits").WillReturnError(fmt.Errorf("Unknown database 'sys'"))
+		mock.ExpectQuery("FROM performance_schema.data_lock_waits").
+			WillReturnRows(sqlmock.NewRows(lockWaitColumns))
+
+		// 调用 HandleShowLocks
+		result, err := HandleShowLocks()
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, "当前没有锁等待", result)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("hides denied databases", func(t *testing.T) {
+		setupAccess(t, nil, []string{"secret.*"})
+		// 设置模拟预期
+		mock.ExpectQuery("FROM sys.innodb_lock_waits").
+			WillReturnRows(sqlmock.NewRows(lockWaitColumns).
+				AddRow(15, "UPDATE keys SET v = 1", 40, "secret", "keys", "PRIMARY", "RECORD", "X", 12, nil, "X", 95))
+
+		// 调用 HandleShowLocks
+		result, err := HandleShowLocks()
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, "当前没有锁等待", result)
+	})
+
+	t.Run("redacts statements", func(t *testing.T) {
+		setupAccess(t, nil, []string{"shop.secrets"})
+		// 设置模拟预期
+		mock.ExpectQuery("FROM sys.innodb_lock_waits").
+			WillReturnRows(sqlmock.NewRows(lockWaitColumns).
+				AddRow(15, "UPDATE orders SET status = 'paid' WHERE id = 7", 40, "shop", "orders", "PRIMARY", "RECORD", "X", 12,
+					"UPDATE orders o JOIN secrets s ON s.id = o.id SET o.status = s.token", "X", 95))
+
+		// 调用 HandleShowLocks
+		result, err := HandleShowLocks()
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "会话 12（事务已运行 95 秒）: （语句涉及不允许访问的表或列，已隐藏）\n")
+		assert.Contains(t, result, ": UPDATE orders SET status = 'paid' WHERE id = 7\n")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestParseDeadlock(t *testing.T) {
+	// 调用 ParseDeadlock
+	deadlock, ok := ParseDeadlock(deadlockStatus)
+
+	// 验证结果
+	assert.True(t, ok)
+	assert.Equal(t, "2024-01-15 10:23:45", deadlock.Time)
+	if assert.Len(t, deadlock.Transactions, 2) {
+		first := deadlock.Transactions[0]
+		assert.Equal(t, "12345", first.ID)
+		assert.Equal(t, "5 sec", first.Active)
+		assert.Equal(t, int64(12), first.ThreadID)
+		assert.Equal(t, "UPDATE accounts\n  SET balance = balance - 10 WHERE id = 2", first.Query)
+		assert.Equal(t, []DeadlockLock{{"`shop`.`accounts`", "PRIMARY", "lock_mode X locks rec but not gap"}}, first.Holds)
+		assert.Equal(t, []DeadlockLock{{"`shop`.`accounts`", "PRIMARY", "lock_mode X locks rec but not gap"}}, first.Waits)
+		assert.False(t, first.RolledBack)
+
+		second := deadlock.Transactions[1]
+		assert.Equal(t, int64(15), second.ThreadID)
+		assert.Len(t, second.Holds, 2)
+		assert.Equal(t, "`shop`.`accounts`: lock mode IX", second.Holds[0].String())
+		assert.Equal(t, "shop", second.Holds[0].Schema())
+		assert.True(t, second.RolledBack)
+	}
+
+	// 没有死锁时 InnoDB 状态中没有该部分
+	_, ok = ParseDeadlock("------------\nTRANSACTIONS\n------------\nTrx id counter 12350\n")
+	assert.False(t, ok)
+}
+
+func TestHandleLastDeadlock(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	expectStatus := func(status string) {
+		mock.ExpectQuery("SHOW ENGINE INNODB STATUS").
+			WillReturnRows(sqlmock.NewRows([]string{"Type", "Name", "Status"}).AddRow("InnoDB", "", status))
+	}
+
+	t.Run("deadlock", func(t *testing.T) {
+		// 设置模拟预期
+		expectStatus(deadlockStatus)
+
+		// 调用 HandleLastDeadlock
+		result, err := HandleLastDeadlock()
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "最近一次死锁发生于 2024-01-15 10:23:45，涉及 2 个事务\n"+
+			"事务 (1) 12345，会话 12，已活跃 5 sec: UPDATE accounts SET balance = balance - 10 WHERE id = 2\n"+
+			"  持有 `shop`.`accounts` 索引 PRIMARY: lock_mode X locks rec but not gap\n"+
+			"  等待 `shop`.`accounts` 索引 PRIMARY: lock_mode X locks rec but not gap\n"+
+			"事务 (2) 12346，会话 15，已活跃 3 sec，已被回滚: UPDATE accounts SET balance = balance + 10 WHERE id = 1\n")
+		assert.Contains(t, result, "transaction,trx_id,thread_id,active,rolled_back,holds,waits,query\n")
+		assert.Contains(t, result, "2,12346,15,3 sec,true,")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("no deadlock", func(t *testing.T) {
+		// 设置模拟预期
+		expectStatus("------------\nTRANSACTIONS\n------------\n")
+
+		// 调用 HandleLastDeadlock
+		result, err := HandleLastDeadlock()
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, "服务器启动以来没有检测到死锁", result)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("hides denied databases", func(t *testing.T) {
+		setupAccess(t, nil, []string{"shop.*"})
+		// 设置模拟预期
+		expectStatus(deadlockStatus)
+
+		// 调用 HandleLastDeadlock
+		_, err := HandleLastDeadlock()
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "不允许访问")
+	})
+}
@@ -2,13 +2,15 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"encoding/csv"
 	"flag"
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
@@ -65,13 +67,142 @@ func main() {
 	flag.StringVar(&Db, "db", "", "MySQL 数据库")
 
 	flag.StringVar(&DSN, "dsn", "", "MySQL DSN")
+	flag.StringVar(&Socket, "socket", "", "通过 Unix 套接字连接，指定后忽略 --host 和 --port")
+	flag.StringVar(&PassFile, "pass-file", "", "从文件读取 MySQL 密码，忽略末尾的换行符")
+	flag.StringVar(&PassCommand, "pass-command", "", "执行命令并使用其标准输出作为 MySQL 密码，例如从密钥管理工具读取")
+	flag.StringVar(&CredentialCommand, "credential-command", "", "获取短期密码的命令，建立新连接前执行，凭据快过期时重新执行")
+	flag.StringVar(&CredentialFile, "credential-file", "", "短期密码文件，文件变化后自动重新读取")
+	flag.DurationVar(&CredentialTTL, "credential-ttl", 10*time.Minute, "--credential-command 输出中没有过期时间时凭据的有效期（0 表示一直有效）")
+	flag.StringVar(&TLSMode, "tls-mode", "", "TLS 模式，可选 disabled、preferred、required、verify-ca、verify-identity")
+	flag.StringVar(&TLSCA, "tls-ca", "", "用于校验服务器证书的 CA 证书文件（PEM）")
+	flag.StringVar(&TLSCert, "tls-cert", "", "客户端证书文件（PEM）")
+	flag.StringVar(&TLSKey, "tls-key", "", "客户端私钥文件（PEM）")
+	flag.StringVar(&TLSServerName, "tls-server-name", "", "verify-identity 模式下校验的服务器名称，默认使用连接的主机名")
+	flag.StringVar(&SSHHost, "ssh-host", "", "通过 SSH 跳板机连接 MySQL，格式为 host 或 host:port，--host 为从跳板机访问 MySQL 的地址")
+	flag.StringVar(&SSHUser, "ssh-user", "", "SSH 用户名")
+	flag.StringVar(&SSHKey, "ssh-key", "", "SSH 私钥文件")
+	flag.StringVar(&SSHKeyPassphraseFile, "ssh-key-passphrase-file", "", "SSH 私钥密码文件，私钥加密时需要")
+	flag.StringVar(&SSHKnownHosts, "ssh-known-hosts", "", "校验跳板机主机密钥的 known_hosts 文件，默认 ~/.ssh/known_hosts")
+	flag.DurationVar(&SSHKeepalive, "ssh-keepalive", 30*time.Second, "SSH 保活请求的间隔，超过一个间隔没有响应时断开并在下次连接时重建隧道（0 表示不发送）")
+	flag.StringVar(&ServerPubKey, "server-public-key", "", "服务器 RSA 公钥文件（PEM），用于 caching_sha2_password 在未加密连接上传输密码")
+	flag.Var(Connections, "connection", "额外的命名连接，格式为 name=DSN，可重复指定")
 
 	flag.BoolVar(&ReadOnly, "read-only", false, "启用只读模式")
+	flag.Var(&AllowObjects, "allow", "允许访问的对象，格式为 table、schema.table 或 schema.table.column，支持通配符，可重复指定或用逗号分隔")
+	flag.Var(&DenyObjects, "deny", "禁止访问的对象，格式同 --allow，优先于 --allow")
+	flag.StringVar(&StatementPolicyFile, "statement-policy", "", "语句策略文件（JSON），按语句类型、函数和变量允许或拒绝执行")
+	flag.StringVar(&MaskPolicyFile, "mask-policy", "", "脱敏策略文件（JSON），查询和导出结果中匹配规则的列会被脱敏")
+	flag.Int64Var(&AlterMaxCopyRows, "alter-max-copy-rows", 0, "alter_table 需要复制整张表（或无法判断执行方式）且表行数超过该值时拒绝执行，除非调用时确认（0 表示不限制）")
+	flag.StringVar(&DataDir, "data-dir", "", "导入导出文件所在的沙箱目录，相关工具只能读写该目录内的文件")
+	flag.StringVar(&BeforeImage, "before-image", "", "执行 UPDATE/DELETE 前备份受影响的行，可选 table（保存到 _mcp_before_images 表）或 file（保存到数据目录）")
+	flag.StringVar(&BeforeImageSchema, "before-image-schema", "", "--before-image=table 时前镜像表所在的数据库，默认使用 DSN 中的数据库")
+	flag.Int64Var(&BeforeImageMaxRows, "before-image-max-rows", 10000, "启用前镜像时，拒绝影响行数超过该值的 UPDATE/DELETE（0 表示不限制）")
+	flag.StringVar(&MigrationsDir, "migrations-dir", "", "迁移文件目录，包含 `<版本>_<名称>.up.sql` 和 `<版本>_<名称>.down.sql` 文件")
 	flag.BoolVar(&WithExplainCheck, "with-explain-check", false, "执行前使用 `EXPLAIN` 检查查询计划")
+	flag.Int64Var(&ExplainMaxFullScanRows, "explain-max-full-scan-rows", 0, "启用 EXPLAIN 检查时，拒绝对超过该行数的表进行全表扫描（0 表示不限制）")
+	flag.Int64Var(&ExplainMaxExaminedRows, "explain-max-examined-rows", 0, "启用 EXPLAIN 检查时，拒绝预计检查行数超过该值的查询（0 表示不限制）")
+	flag.Int64Var(&ExplainMaxFilesortRows, "explain-max-filesort-rows", 0, "启用 EXPLAIN 检查时，拒绝对超过该行数的表使用文件排序（0 表示不限制）")
+	flag.Var(&ReplicaDSNs, "replica", "只读副本的 DSN，可重复指定。read_query、list_*、desc_table 默认在副本上执行")
+	flag.StringVar(&ReplicaStrategy, "replica-strategy", ReplicaStrategyRoundRobin, "选择副本的策略，可选 round-robin、least-lag")
+	flag.DurationVar(&ReplicaMaxLag, "replica-max-lag", 0, "复制延迟超过该值的副本不再接收读请求，例如 30s（0 表示不限制）")
+	flag.DurationVar(&ReplicaLagCheckInterval, "replica-check-interval", 5*time.Second, "副本可用性和复制延迟检查结果的缓存时间")
+	flag.IntVar(&MaxOpenConns, "max-open-conns", 0, "连接池最大连接数（0 表示不限制）")
+	flag.IntVar(&MaxIdleConns, "max-idle-conns", 2, "连接池最大空闲连接数")
+	flag.DurationVar(&ConnMaxLifetime, "conn-max-lifetime", 0, "连接的最长使用时间，例如 30m（0 表示不限制）")
+	flag.DurationVar(&ConnMaxIdleTime, "conn-max-idle-time", 0, "连接的最长空闲时间，例如 5m（0 表示不限制）")
+	flag.IntVar(&ConnectRetries, "connect-retries", 3, "建立连接失败时的重试次数")
+	flag.DurationVar(&ConnectRetryBackoff, "connect-retry-backoff", time.Second, "首次重试前的等待时间，之后每次翻倍，最长 30s")
+	flag.DurationVar(&HealthCheckInterval, "health-check-interval", 0, "定期检查连接健康状态的间隔，例如 1m（0 表示不检查）")
+	flag.BoolVar(&KillAnyUser, "kill-any-user", false, "允许 kill_query 终止其他 MySQL 用户的查询，默认只能终止本服务器使用的用户的查询")
+	flag.StringVar(&SlowQueryLog, "slow-query-log", "", "慢查询日志文件路径，performance_schema 不可用时 top_queries 从该文件汇总语句，默认使用服务器的 slow_query_log_file")
+	flag.DurationVar(&ProgressInterval, "progress-interval", 2*time.Second, "read_query 和 alter_table 执行期间发送进度通知的间隔，客户端请求进度时生效（0 表示不发送）")
+	flag.DurationVar(&QueryCacheTTL, "query-cache-ttl", 0, "read_query 和 desc_table 结果的缓存时间，例如 1m（0 表示不缓存）")
+	flag.IntVar(&QueryCacheEntries, "query-cache-entries", 1000, "查询缓存最多保存的结果数")
+	flag.Int64Var(&QueryCacheMaxBytes, "query-cache-max-bytes", 64<<20, "查询缓存结果的总大小上限（字节），超过时淘汰最久未使用的结果")
 	flag.Parse()
 
-	if len(DSN) == 0 {
-		DSN = fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true&loc=Local", User, Pass, Host, Port, Db)
+	switch BeforeImage {
+	case "", BeforeImageTable:
+	case BeforeImageFile:
+		if len(DataDir) == 0 {
+			log.Fatalf("--before-image=file 需要同时指定 --data-dir")
+		}
+	default:
+		log.Fatalf("无效的 --before-image: %s（可选 table、file）", BeforeImage)
+	}
+
+	if len(StatementPolicyFile) > 0 {
+		policy, err := LoadStatementPolicy(StatementPolicyFile)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		Statements = policy
+	}
+
+	if len(MaskPolicyFile) > 0 {
+		policy, err := LoadMaskPolicy(MaskPolicyFile)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		Masking = policy
+	}
+
+	if err := SetupSSHTunnel(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	provider, err := NewCredentialProvider()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	Credentials = provider
+
+	if len(ReplicaDSNs) > 0 {
+		replicas, err := NewReplicaSet(ReplicaDSNs, ReplicaStrategy, ReplicaMaxLag, ReplicaLagCheckInterval, Credentials)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		Replicas = replicas
+	}
+
+	dsn, err := BuildDSN()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	DSN = dsn
+
+	if BeforeImage == BeforeImageTable && len(BeforeImageSchema) == 0 {
+		cfg, err := mysql.ParseDSN(DSN)
+		if err != nil {
+			log.Fatalf("解析 DSN 失败: %v", err)
+		}
+		if len(cfg.DBName) == 0 {
+			log.Fatalf("--before-image=table 需要在连接参数中指定数据库，或使用 --before-image-schema 指定前镜像表所在的数据库")
+		}
+		BeforeImageSchema = cfg.DBName
+	}
+	if BeforeImage == BeforeImageTable {
+		DenyObjects = append(DenyObjects, beforeImageAccessRule())
+	}
+
+	if QueryCacheTTL > 0 {
+		cfg, err := mysql.ParseDSN(DSN)
+		if err != nil {
+			log.Fatalf("解析 DSN 失败: %v", err)
+		}
+		Cache = NewQueryCache(QueryCacheTTL, QueryCacheEntries, QueryCacheMaxBytes, cfg.DBName)
+	}
+
+	// 启动时先建立连接，失败时不退出，首次调用工具时会再次尝试
+	if db, err := GetDB(); err != nil {
+		log.Printf("%v", err)
+	} else if BeforeImage == BeforeImageTable && !ReadOnly {
+		if err := EnsureBeforeImageTable(db); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+	if HealthCheckInterval > 0 {
+		StartHealthCheck(context.Background(), HealthCheckInterval)
 	}
 
 	s := server.NewMCPServer(
@@ -83,11 +214,17 @@ func main() {
 	listDatabaseTool := mcp.NewTool(
 		"list_database",
 		mcp.WithDescription("列出 MySQL 服务器中的所有数据库"),
+		mcp.WithString("route",
+			mcp.Description("执行位置：primary 为主库，replica 为副本，留空时优先使用副本"),
+		),
 	)
 
 	listTableTool := mcp.NewTool(
 		"list_table",
 		mcp.WithDescription("列出 MySQL 服务器中的所有表"),
+		mcp.WithString("route",
+			mcp.Description("执行位置：primary 为主库，replica 为副本，留空时优先使用副本"),
+		),
 	)
 
 	createTableTool := mcp.NewTool(
@@ -101,11 +238,14 @@ func main() {
 
 	alterTableTool := mcp.NewTool(
 		"alter_table",
-		mcp.WithDescription("修改 MySQL 服务器中的现有表。确保为每个修改的列更新了注释。不要删除表或现有列！"),
+		mcp.WithDescription("修改 MySQL 服务器中的现有表。确保为每个修改的列更新了注释。不要删除表或现有列！执行前会分析能否以 INSTANT/INPLACE 方式在线执行，需要复制大表时会拒绝执行"),
 		mcp.WithString("query",
 			mcp.Required(),
 			mcp.Description("修改表的 SQL 查询"),
 		),
+		mcp.WithBoolean("confirm_copy",
+			mcp.Description("确认在需要复制整张大表（执行期间阻塞写入）时仍然执行"),
+		),
 	)
 
 	descTableTool := mcp.NewTool(
@@ -113,7 +253,25 @@ func main() {
 		mcp.WithDescription("描述表的结构"),
 		mcp.WithString("name",
 			mcp.Required(),
-			mcp.Description("要描述的表名"),
+			mcp.Description("要描述的表名，可以写作 db.table，包含特殊字符的名称用反引号包围"),
+		),
+		mcp.WithString("route",
+			mcp.Description("执行位置：primary 为主库，replica 为副本，留空时优先使用副本"),
+		),
+	)
+
+	profileTableTool := mcp.NewTool(
+		"profile_table",
+		mcp.WithDescription("抽样统计表中每列的 NULL 比例、不同值数量估计、最小/最大值、最常见的值、平均长度和常见格式（邮箱、UUID、JSON 等），用于了解数据后再编写 WHERE 条件或修改数据。抽样查询带 LIMIT，不会扫描整张表"),
+		mcp.WithString("name",
+			mcp.Required(),
+			mcp.Description("要统计的表名，可以写作 db.table，包含特殊字符的名称用反引号包围"),
+		),
+		mcp.WithNumber("sample_size",
+			mcp.Description("抽样行数，默认 10000，最大 100000"),
+		),
+		mcp.WithString("route",
+			mcp.Description("执行位置：primary 为主库，replica 为副本，留空时优先使用副本"),
 		),
 	)
 
@@ -122,7 +280,7 @@ func main() {
 		mcp.WithDescription("选择当前使用的数据库。执行 USE database 语句切换数据库"),
 		mcp.WithString("name",
 			mcp.Required(),
-			mcp.Description("要使用的数据库名"),
+			mcp.Description("要使用的数据库名，包含特殊字符的名称用反引号包围"),
 		),
 	)
 
@@ -134,6 +292,9 @@ func main() {
 			mcp.Required(),
 			mcp.Description("要执行的 SQL 查询"),
 		),
+		mcp.WithString("route",
+			mcp.Description("执行位置：primary 为主库，replica 为副本，留空时优先使用副本，带 FOR UPDATE 等锁定读的查询发往主库"),
+		),
 	)
 
 	writeQueryTool := mcp.NewTool(
@@ -163,8 +324,229 @@ func main() {
 		),
 	)
 
+	undoOperationTool := mcp.NewTool(
+		"undo_operation",
+		mcp.WithDescription("根据 update_query/delete_query 返回的操作 ID，用执行前备份的行撤销该操作：被删除的行重新插入，被更新的行恢复为原值"),
+		mcp.WithString("operation_id",
+			mcp.Required(),
+			mcp.Description("要撤销的操作 ID"),
+		),
+	)
+
+	exportQueryTool := mcp.NewTool(
+		"export_query",
+		mcp.WithDescription("将 SELECT 查询结果流式写入数据目录中的文件，只返回文件路径、行数、文件大小和前几行预览。适合导出大量数据，而 `read_query` 会把全部结果直接返回"),
+		mcp.WithString("query",
+			mcp.Required(),
+			mcp.Description("要导出的 SELECT 查询"),
+		),
+		mcp.WithString("format",
+			mcp.Description("文件格式：csv、tsv、jsonl、parquet 或 sql（INSERT 语句），默认 csv"),
+		),
+		mcp.WithString("file_name",
+			mcp.Description("数据目录内的相对文件名，留空则自动生成。不会覆盖已有文件"),
+		),
+		mcp.WithString("compression",
+			mcp.Description("压缩方式：none、gzip 或 zstd，默认 none。parquet 格式使用其内置的列压缩"),
+		),
+		mcp.WithString("table",
+			mcp.Description("sql 格式中 INSERT 语句的目标表名，默认取查询中的第一张表"),
+		),
+	)
+
+	importDataTool := mcp.NewTool(
+		"import_data",
+		mcp.WithDescription("从数据目录中的 CSV、TSV 或 JSON Lines 文件批量导入数据到表中。列会按 information_schema 中的定义校验，校验通过的行在一个事务中以多行 INSERT 分批插入，并报告被拒绝的行及原因"),
+		mcp.WithString("file_name",
+			mcp.Required(),
+			mcp.Description("数据目录内的相对文件名，支持 .gz 和 .zst 压缩文件"),
+		),
+		mcp.WithString("table",
+			mcp.Required(),
+			mcp.Description("目标表名，可以是 table 或 db.table"),
+		),
+		mcp.WithString("format",
+			mcp.Description("文件格式：csv、tsv 或 jsonl，默认按扩展名判断"),
+		),
+		mcp.WithString("columns",
+			mcp.Description("列映射，格式为 file_col:table_col，以逗号分隔；只写一个名称表示两边同名。留空则按文件表头（JSON 的键）同名映射"),
+		),
+		mcp.WithNumber("batch_size",
+			mcp.Description("每条 INSERT 语句插入的行数，默认 500"),
+		),
+		mcp.WithBoolean("strict",
+			mcp.Description("有任何行未通过校验时回滚，不导入任何数据"),
+		),
+	)
+
+	dumpTableTool := mcp.NewTool(
+		"dump_table",
+		mcp.WithDescription("将表结构和数据转储为与 mysqldump 兼容的 SQL 文件，保存在数据目录中。执行有风险的更新或删除前可以先备份受影响的行"),
+		mcp.WithString("table",
+			mcp.Required(),
+			mcp.Description("要转储的表名，可以是 table 或 db.table"),
+		),
+		mcp.WithString("where",
+			mcp.Description("只转储满足该条件的行。指定后文件中不包含表结构，并使用 REPLACE 语句，恢复时只覆盖这些行"),
+		),
+		mcp.WithString("file_name",
+			mcp.Description("数据目录内的相对文件名，留空则自动生成。不会覆盖已有文件"),
+		),
+		mcp.WithString("compression",
+			mcp.Description("压缩方式：none、gzip 或 zstd，默认 none"),
+		),
+	)
+
+	dumpDatabaseTool := mcp.NewTool(
+		"dump_database",
+		mcp.WithDescription("在一致性快照中转储数据库的全部表结构和数据，生成与 mysqldump 兼容的 SQL 文件，保存在数据目录中"),
+		mcp.WithString("database",
+			mcp.Description("要转储的数据库名，留空则使用当前数据库，包含特殊字符的名称用反引号包围"),
+		),
+		mcp.WithString("file_name",
+			mcp.Description("数据目录内的相对文件名，留空则自动生成。不会覆盖已有文件"),
+		),
+		mcp.WithString("compression",
+			mcp.Description("压缩方式：none、gzip 或 zstd，默认 none"),
+		),
+	)
+
+	restoreDumpTool := mcp.NewTool(
+		"restore_dump",
+		mcp.WithDescription("逐条执行数据目录中的 SQL 转储文件以恢复数据。完整转储会先删除并重建其中的表"),
+		mcp.WithString("file_name",
+			mcp.Required(),
+			mcp.Description("数据目录内的转储文件名，支持 .gz 和 .zst 压缩文件"),
+		),
+		mcp.WithString("database",
+			mcp.Description("恢复到的数据库，留空则使用当前数据库"),
+		),
+	)
+
+	// 优化工具
+	suggestIndexesTool := mcp.NewTool(
+		"suggest_indexes",
+		mcp.WithDescription("分析查询的 WHERE/JOIN/ORDER BY 列与现有索引，给出 CREATE INDEX 建议及预期的执行计划变化。未提供查询时分析 performance_schema 中耗时最高的语句摘要"),
+		mcp.WithString("query",
+			mcp.Description("要分析的一条 SELECT、UPDATE、DELETE、INSERT 或 REPLACE 语句，留空则分析最近的语句摘要"),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description("分析语句摘要时读取的语句数量，默认 10"),
+		),
+	)
+
+	topQueriesTool := mcp.NewTool(
+		"top_queries",
+		mcp.WithDescription("列出总耗时、检查行数或执行次数最高的语句摘要，数据来自 performance_schema，不可用时解析慢查询日志。可以把结果中的示例语句交给 suggest_indexes 分析"),
+		mcp.WithString("order_by",
+			mcp.Description("排序方式：latency（总耗时，默认）、rows_examined（检查行数）或 executions（执行次数）"),
+		),
+		mcp.WithString("database",
+			mcp.Description("只列出该库中的语句"),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description("返回的语句数量，默认 10"),
+		),
+	)
+
+	diffSchemaTool := mcp.NewTool(
+		"diff_schema",
+		mcp.WithDescription("比较两个数据库（同一服务器或两个命名连接）的表、列、索引和外键差异，可选生成使目标库与源库一致的 ALTER 语句"),
+		mcp.WithString("source",
+			mcp.Required(),
+			mcp.Description("作为基准的源数据库名"),
+		),
+		mcp.WithString("target",
+			mcp.Required(),
+			mcp.Description("要比较的目标数据库名"),
+		),
+		mcp.WithString("source_connection",
+			mcp.Description("源数据库使用的命名连接，留空使用默认连接"),
+		),
+		mcp.WithString("target_connection",
+			mcp.Description("目标数据库使用的命名连接，留空使用默认连接"),
+		),
+		mcp.WithBoolean("with_alter",
+			mcp.Description("是否生成迁移语句"),
+		),
+	)
+
+	// 状态工具
+	serverStatusTool := mcp.NewTool(
+		"server_status",
+		mcp.WithDescription("查看每个已建立连接的连接池统计（打开、使用中、空闲、等待次数等）和健康检查结果"),
+	)
+
+	showProcesslistTool := mcp.NewTool(
+		"show_processlist",
+		mcp.WithDescription("列出 MySQL 服务器上的会话及其正在执行的查询，按执行时间从长到短排序"),
+		mcp.WithString("user",
+			mcp.Description("只显示该 MySQL 用户的会话"),
+		),
+		mcp.WithString("database",
+			mcp.Description("只显示当前数据库为该库的会话"),
+		),
+		mcp.WithString("command",
+			mcp.Description("只显示该命令类型的会话，例如 Query、Sleep"),
+		),
+		mcp.WithNumber("min_time",
+			mcp.Description("只显示当前状态持续至少该秒数的会话"),
+		),
+		mcp.WithNumber("query_length",
+			mcp.Description("查询文本保留的字符数，默认 200"),
+		),
+	)
+
+	killQueryTool := mcp.NewTool(
+		"kill_query",
+		mcp.WithDescription("终止会话正在执行的查询（KILL QUERY），会话本身不会断开。默认只能终止与本服务器使用同一 MySQL 用户的会话"),
+		mcp.WithNumber("id",
+			mcp.Required(),
+			mcp.Description("会话 ID，即 show_processlist 中的 id"),
+		),
+	)
+
+	diagnoseServerTool := mcp.NewTool(
+		"diagnose_server",
+		mcp.WithDescription("生成服务器健康报告：版本、运行时间、连接数、运行中的线程、缓冲池命中率、慢查询、复制延迟和 InnoDB 行锁等待，并标出超过阈值的异常"),
+	)
+
+	showLocksTool := mcp.NewTool(
+		"show_locks",
+		mcp.WithDescription("查看 InnoDB 锁等待，按谁阻塞谁组织成阻塞树，根节点是阻塞源头。需要 MySQL 8.0 的 performance_schema.data_locks"),
+	)
+
+	lastDeadlockTool := mcp.NewTool(
+		"last_deadlock",
+		mcp.WithDescription("解析 SHOW ENGINE INNODB STATUS 中最近一次检测到的死锁，列出每个事务的语句、持有和等待的锁以及被回滚的事务"),
+	)
+
+	// 迁移工具
+	migrationStatusTool := mcp.NewTool(
+		"migration_status",
+		mcp.WithDescription("列出迁移目录中的迁移及其在 schema_migrations 表中的应用状态"),
+	)
+
+	applyMigrationsTool := mcp.NewTool(
+		"apply_migrations",
+		mcp.WithDescription("按版本顺序应用未执行的迁移。执行期间持有迁移锁，防止并发执行"),
+		mcp.WithNumber("target",
+			mcp.Description("只应用到该版本为止，留空则应用全部"),
+		),
+	)
+
+	rollbackMigrationTool := mcp.NewTool(
+		"rollback_migration",
+		mcp.WithDescription("执行 down 文件回滚最近应用的迁移"),
+		mcp.WithNumber("steps",
+			mcp.Description("回滚的迁移数量，默认 1"),
+		),
+	)
+
 	s.AddTool(listDatabaseTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		result, err := HandleQuery("SHOW DATABASES", StatementTypeNoExplainCheck)
+		route, _ := request.Params.Arguments["route"].(string)
+
+		result, err := HandleListDatabase(route)
 		if err != nil {
 			return mcp.NewToolResultError(err.Error()), nil
 		}
@@ -173,7 +555,9 @@ func main() {
 	})
 
 	s.AddTool(listTableTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		result, err := HandleQuery("SHOW TABLES", StatementTypeNoExplainCheck)
+		route, _ := request.Params.Arguments["route"].(string)
+
+		result, err := HandleListTable(route)
 		if err != nil {
 			return mcp.NewToolResultError(err.Error()), nil
 		}
@@ -194,7 +578,9 @@ func main() {
 
 	if !ReadOnly {
 		s.AddTool(alterTableTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-			result, err := HandleExec(request.Params.Arguments["query"].(string), StatementTypeNoExplainCheck)
+			confirmCopy, _ := request.Params.Arguments["confirm_copy"].(bool)
+
+			result, err := HandleAlterTable(request.Params.Arguments["query"].(string), confirmCopy, NewProgress(ctx, request))
 			if err != nil {
 				return mcp.NewToolResultError(err.Error()), nil
 			}
@@ -204,7 +590,24 @@ func main() {
 	}
 
 	s.AddTool(descTableTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		result, err := HandleDescTable(request.Params.Arguments["name"].(string))
+		route, _ := request.Params.Arguments["route"].(string)
+
+		result, err := HandleDescTable(request.Params.Arguments["name"].(string), route)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		return mcp.NewToolResultText(result), nil
+	})
+
+	s.AddTool(profileTableTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		route, _ := request.Params.Arguments["route"].(string)
+		sampleSize := 0
+		if v, ok := request.Params.Arguments["sample_size"].(float64); ok {
+			sampleSize = int(v)
+		}
+
+		result, err := HandleProfileTable(request.Params.Arguments["name"].(string), sampleSize, route)
 		if err != nil {
 			return mcp.NewToolResultError(err.Error()), nil
 		}
@@ -222,7 +625,9 @@ func main() {
 	})
 
 	s.AddTool(readQueryTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		result, err := HandleQuery(request.Params.Arguments["query"].(string), StatementTypeSelect)
+		route, _ := request.Params.Arguments["route"].(string)
+
+		result, err := HandleQuery(request.Params.Arguments["query"].(string), StatementTypeSelect, route, NewProgress(ctx, request))
 		if err != nil {
 			return mcp.NewToolResultError(err.Error()), nil
 		}
@@ -263,17 +668,251 @@ func main() {
 		})
 	}
 
+	if len(BeforeImage) > 0 && !ReadOnly {
+		s.AddTool(undoOperationTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			result, err := HandleUndoOperation(request.Params.Arguments["operation_id"].(string))
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+
+			return mcp.NewToolResultText(result), nil
+		})
+	}
+
+	if len(DataDir) > 0 {
+		s.AddTool(exportQueryTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			format, _ := request.Params.Arguments["format"].(string)
+			fileName, _ := request.Params.Arguments["file_name"].(string)
+			compression, _ := request.Params.Arguments["compression"].(string)
+			table, _ := request.Params.Arguments["table"].(string)
+
+			result, err := HandleExportQuery(request.Params.Arguments["query"].(string), format, fileName, compression, table)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+
+			return mcp.NewToolResultText(result), nil
+		})
+
+		s.AddTool(dumpTableTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			where, _ := request.Params.Arguments["where"].(string)
+			fileName, _ := request.Params.Arguments["file_name"].(string)
+			compression, _ := request.Params.Arguments["compression"].(string)
+
+			result, err := HandleDumpTable(request.Params.Arguments["table"].(string), where, fileName, compression)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+
+			return mcp.NewToolResultText(result), nil
+		})
+
+		s.AddTool(dumpDatabaseTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			database, _ := request.Params.Arguments["database"].(string)
+			fileName, _ := request.Params.Arguments["file_name"].(string)
+			compression, _ := request.Params.Arguments["compression"].(string)
+
+			result, err := HandleDumpDatabase(database, fileName, compression)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+
+			return mcp.NewToolResultText(result), nil
+		})
+	}
+
+	if len(DataDir) > 0 && !ReadOnly {
+		s.AddTool(importDataTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			format, _ := request.Params.Arguments["format"].(string)
+			columns, _ := request.Params.Arguments["columns"].(string)
+			batchSize, _ := request.Params.Arguments["batch_size"].(float64)
+			strict, _ := request.Params.Arguments["strict"].(bool)
+
+			result, err := HandleImportData(request.Params.Arguments["file_name"].(string), request.Params.Arguments["table"].(string), format, columns, int(batchSize), strict)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+
+			return mcp.NewToolResultText(result), nil
+		})
+
+		s.AddTool(restoreDumpTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			database, _ := request.Params.Arguments["database"].(string)
+
+			result, err := HandleRestoreDump(request.Params.Arguments["file_name"].(string), database)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+
+			return mcp.NewToolResultText(result), nil
+		})
+	}
+
+	s.AddTool(suggestIndexesTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		query, _ := request.Params.Arguments["query"].(string)
+		limit := 10
+		if v, ok := request.Params.Arguments["limit"].(float64); ok && v > 0 {
+			limit = int(v)
+		}
+
+		result, err := HandleSuggestIndexes(query, limit)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		return mcp.NewToolResultText(result), nil
+	})
+
+	s.AddTool(topQueriesTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		orderBy, _ := request.Params.Arguments["order_by"].(string)
+		database, _ := request.Params.Arguments["database"].(string)
+		limit := 10
+		if v, ok := request.Params.Arguments["limit"].(float64); ok && v > 0 {
+			limit = int(v)
+		}
+
+		result, err := HandleTopQueries(orderBy, database, limit)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		return mcp.NewToolResultText(result), nil
+	})
+
+	s.AddTool(diffSchemaTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		sourceConn, _ := request.Params.Arguments["source_connection"].(string)
+		targetConn, _ := request.Params.Arguments["target_connection"].(string)
+		withAlter, _ := request.Params.Arguments["with_alter"].(bool)
+
+		result, err := HandleDiffSchema(sourceConn, request.Params.Arguments["source"].(string), targetConn, request.Params.Arguments["target"].(string), withAlter)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		return mcp.NewToolResultText(result), nil
+	})
+
+	s.AddTool(serverStatusTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		result, err := HandleServerStatus()
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		return mcp.NewToolResultText(result), nil
+	})
+
+	s.AddTool(showProcesslistTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		filter := ProcessFilter{}
+		filter.User, _ = request.Params.Arguments["user"].(string)
+		filter.Database, _ = request.Params.Arguments["database"].(string)
+		filter.Command, _ = request.Params.Arguments["command"].(string)
+		if v, ok := request.Params.Arguments["min_time"].(float64); ok {
+			filter.MinTime = int64(v)
+		}
+		if v, ok := request.Params.Arguments["query_length"].(float64); ok {
+			filter.QueryLength = int(v)
+		}
+
+		result, err := HandleShowProcesslist(filter)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		return mcp.NewToolResultText(result), nil
+	})
+
+	if !ReadOnly {
+		s.AddTool(killQueryTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			id, _ := request.Params.Arguments["id"].(float64)
+
+			result, err := HandleKillQuery(int64(id))
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+
+			return mcp.NewToolResultText(result), nil
+		})
+	}
+
+	s.AddTool(diagnoseServerTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		result, err := HandleDiagnoseServer()
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		return mcp.NewToolResultText(result), nil
+	})
+
+	s.AddTool(showLocksTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		result, err := HandleShowLocks()
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		return mcp.NewToolResultText(result), nil
+	})
+
+	s.AddTool(lastDeadlockTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		result, err := HandleLastDeadlock()
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		return mcp.NewToolResultText(result), nil
+	})
+
+	if len(MigrationsDir) > 0 {
+		s.AddTool(migrationStatusTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			result, err := HandleMigrationStatus()
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+
+			return mcp.NewToolResultText(result), nil
+		})
+	}
+
+	if len(MigrationsDir) > 0 && !ReadOnly {
+		s.AddTool(applyMigrationsTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			target, _ := request.Params.Arguments["target"].(float64)
+
+			result, err := HandleApplyMigrations(int64(target))
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+
+			return mcp.NewToolResultText(result), nil
+		})
+
+		s.AddTool(rollbackMigrationTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			steps := 1
+			if v, ok := request.Params.Arguments["steps"].(float64); ok && v > 0 {
+				steps = int(v)
+			}
+
+			result, err := HandleRollbackMigration(steps)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+
+			return mcp.NewToolResultText(result), nil
+		})
+	}
+
 	if err := server.ServeStdio(s); err != nil {
 		log.Fatalf("服务器错误: %v", err)
 	}
 }
 
 func GetDB() (*sqlx.DB, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
 	if DB != nil {
 		return DB, nil
 	}
 
-	db, err := sqlx.Connect("mysql", DSN)
+	db, err := openDB(DSN, Credentials, true)
 	if err != nil {
 		return nil, fmt.Errorf("建立数据库连接失败: %v", err)
 	}
@@ -283,8 +922,17 @@ func GetDB() (*sqlx.DB, error) {
 	return DB, nil
 }
 
-func HandleQuery(query, expect string) (string, error) {
-	result, headers, err := DoQuery(query, expect)
+func HandleQuery(query, expect, route string, progress *Progress) (string, error) {
+	// 缓存的结果在写入时已经通过了访问控制、语句策略和 EXPLAIN 检查
+	normalized, tables, cacheable := cacheableQuery(query)
+	cacheable = cacheable && ValidateRoute(route) == nil
+	if cacheable {
+		if s, age, ok := Cache.Get(cacheConnection(route), "read_query", normalized); ok {
+			return s + cachedNote(age), nil
+		}
+	}
+
+	result, headers, err := DoQuery(query, expect, route, progress)
 	if err != nil {
 		return "", err
 	}
@@ -294,66 +942,125 @@ func HandleQuery(query, expect string) (string, error) {
 		return "", err
 	}
 
+	if cacheable {
+		Cache.Put(cacheConnection(route), "read_query", normalized, s, tables)
+	}
+
 	return s, nil
 }
 
-func DoQuery(query, expect string) ([]map[string]interface{}, []string, error) {
-	db, err := GetDB()
+func DoQuery(query, expect, route string, progress *Progress) ([]map[string]interface{}, []string, error) {
+	db, err := GetQueryDB(query, route)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	if len(expect) > 0 {
-		if err := HandleExplain(query, expect); err != nil {
-			return nil, nil, err
-		}
+	if err := CheckStatementPolicy(query); err != nil {
+		return nil, nil, err
 	}
-
-	rows, err := db.Queryx(query)
-	if err != nil {
+	if err := CheckQueryAccess(query); err != nil {
 		return nil, nil, err
 	}
 
-	cols, err := rows.Columns()
-	if err != nil {
-		return nil, nil, err
+	if len(expect) > 0 {
+		if err := HandleExplain(db, query, expect); err != nil {
+			return nil, nil, err
+		}
 	}
 
 	result := []map[string]interface{}{}
-	for rows.Next() {
-		row, err := rows.SliceScan()
+	var cols []string
+	err = progress.Track(db, func(conn progressConn) error {
+		rows, err := conn.QueryxContext(progress.Context(), query)
 		if err != nil {
-			return nil, nil, err
+			return err
+		}
+		defer rows.Close()
+
+		if cols, err = rows.Columns(); err != nil {
+			return err
+		}
+
+		masker, err := NewResultMasker(query, cols)
+		if err != nil {
+			return err
 		}
 
-		resultRow := map[string]interface{}{}
-		for i, col := range cols {
-			switch v := row[i].(type) {
-			case []byte:
-				resultRow[col] = string(v)
-			default:
-				resultRow[col] = v
+		for rows.Next() {
+			row, err := rows.SliceScan()
+			if err != nil {
+				return err
+			}
+			masker.MaskRow(row)
+
+			resultRow := map[string]interface{}{}
+			for i, col := range cols {
+				switch v := row[i].(type) {
+				case []byte:
+					resultRow[col] = string(v)
+				default:
+					resultRow[col] = v
+				}
 			}
+			result = append(result, resultRow)
+			progress.AddRows(1)
 		}
-		result = append(result, resultRow)
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, nil, err
 	}
 
 	return result, cols, nil
 }
 
 func HandleExec(query, expect string) (string, error) {
+	return handleExec(query, expect, nil)
+}
+
+// handleExec 与 HandleExec 相同，progress 不为 nil 时在执行期间报告进度
+func handleExec(query, expect string, progress *Progress) (string, error) {
 	db, err := GetDB()
 	if err != nil {
 		return "", err
 	}
 
+	if err := CheckStatementPolicy(query); err != nil {
+		return "", err
+	}
+	if err := CheckQueryAccess(query); err != nil {
+		return "", err
+	}
+
 	if len(expect) > 0 {
-		if err := HandleExplain(query, expect); err != nil {
+		if err := HandleExplain(db, query, expect); err != nil {
+			return "", err
+		}
+	}
+
+	// 语句失败时也可能已经修改了部分数据，因此无论成功与否都使缓存失效
+	defer Cache.InvalidateWrite(query)
+
+	if len(BeforeImage) > 0 && (expect == StatementTypeUpdate || expect == StatementTypeDelete) {
+		result, operationID, err := ExecWithBeforeImage(query)
+		if err != nil {
+			return "", err
+		}
+
+		ra, err := result.RowsAffected()
+		if err != nil {
 			return "", err
 		}
+
+		return fmt.Sprintf("%d rows affected, operation id: %s", ra, operationID), nil
 	}
 
-	result, err := db.Exec(query)
+	var result sql.Result
+	err = progress.Track(db, func(conn progressConn) error {
+		result, err = conn.ExecContext(progress.Context(), query)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
@@ -376,70 +1083,66 @@ func HandleExec(query, expect string) (string, error) {
 	}
 }
 
-func HandleExplain(query, expect string) error {
+func HandleExplain(db *sqlx.DB, query, expect string) error {
 	if !WithExplainCheck {
 		return nil
 	}
 
-	db, err := GetDB()
+	result, err := DoExplain(db, query)
 	if err != nil {
 		return err
 	}
 
+	if err := CheckExplainType(result, expect); err != nil {
+		return err
+	}
+
+	return CheckExplainPolicies(result)
+}
+
+func DoExplain(db *sqlx.DB, query string) ([]ExplainResult, error) {
 	rows, err := db.Queryx(fmt.Sprintf("EXPLAIN %s", query))
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
 	result := []ExplainResult{}
 	for rows.Next() {
 		var row ExplainResult
 		if err := rows.StructScan(&row); err != nil {
-			return err
+			return nil, err
 		}
 		result = append(result, row)
 	}
 
-	if len(result) != 1 {
-		return fmt.Errorf("无法检查查询计划，拒绝执行")
-	}
+	return result, nil
+}
 
-	match := false
-	switch expect {
-	case StatementTypeInsert:
-		fallthrough
-	case StatementTypeUpdate:
-		fallthrough
-	case StatementTypeDelete:
-		if *result[0].SelectType == expect {
-			match = true
-		}
-	default:
-		// for SELECT type query, the select_type will be multiple values
-		// here we check if it's not INSERT, UPDATE or DELETE
-		match = true
-		for _, typ := range []string{StatementTypeInsert, StatementTypeUpdate, StatementTypeDelete} {
-			if *result[0].SelectType == typ {
-				match = false
-				break
-			}
-		}
+func HandleDescTable(name, route string) (string, error) {
+	db, err := GetReadDB(route)
+	if err != nil {
+		return "", err
 	}
 
-	if !match {
-		return fmt.Errorf("查询计划不符合预期模式，拒绝执行")
+	ref, err := ParseTableName(name)
+	if err != nil {
+		return "", err
+	}
+	if err := CheckTableAccess(ref); err != nil {
+		return "", err
 	}
 
-	return nil
-}
+	connection, key := cacheConnection(route), ref.QuotedName()
+	if s, age, ok := Cache.Get(connection, "desc_table", key); ok {
+		return s + cachedNote(age), nil
+	}
 
-func HandleDescTable(name string) (string, error) {
-	db, err := GetDB()
-	if err != nil {
+	if ref, err = LookupTable(db, ref); err != nil {
 		return "", err
 	}
 
-	rows, err := db.Queryx(fmt.Sprintf("SHOW CREATE TABLE %s", name))
+	rows, err := db.Queryx("SHOW CREATE TABLE " + ref.QuotedName())
 	if err != nil {
 		return "", err
 	}
@@ -457,6 +1160,8 @@ func HandleDescTable(name string) (string, error) {
 		return "", fmt.Errorf("表 %s 不存在", name)
 	}
 
+	Cache.Put(connection, "desc_table", key, result[0].CreateTable, []TableRef{ref})
+
 	return result[0].CreateTable, nil
 }
 
@@ -466,12 +1171,22 @@ func HandleUseDatabase(name string) (string, error) {
 		return "", err
 	}
 
-	_, err = db.Exec(fmt.Sprintf("USE `%s`", name))
+	schema, err := ParseSchemaName(name)
 	if err != nil {
-		return "", fmt.Errorf("切换数据库失败: %v", err)
+		return "", err
+	}
+	if err := CheckSchemaAccess(schema); err != nil {
+		return "", err
 	}
+	if schema, err = LookupSchema(db, schema); err != nil {
+		return "", err
+	}
+
+	// 主库和副本之后建立的连接都以该数据库作为默认数据库，切换之前建立的连接会被丢弃
+	SelectDatabase(schema)
+	Cache.UseDatabase(schema)
 
-	return fmt.Sprintf("已成功切换到数据库: %s", name), nil
+	return fmt.Sprintf("已成功切换到数据库: %s", schema), nil
 }
 
 func MapToCSV(m []map[string]interface{}, headers []string) (string, error) {
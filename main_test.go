@@ -79,7 +79,7 @@ func TestHandleQuery(t *testing.T) {
 		mock.ExpectQuery("SELECT").WillReturnRows(rows)
 
 		// 调用 HandleQuery
-		result, err := HandleQuery("SELECT id, name FROM users", StatementTypeNoExplainCheck)
+		result, err := HandleQuery("SELECT id, name FROM users", StatementTypeNoExplainCheck, RouteAuto, nil)
 
 		// 验证结果
 		assert.NoError(t, err)
@@ -93,7 +93,7 @@ func TestHandleQuery(t *testing.T) {
 		mock.ExpectQuery("SELECT").WillReturnError(fmt.Errorf("查询错误"))
 
 		// 调用 HandleQuery
-		_, err := HandleQuery("SELECT id, name FROM users", StatementTypeNoExplainCheck)
+		_, err := HandleQuery("SELECT id, name FROM users", StatementTypeNoExplainCheck, RouteAuto, nil)
 
 		// 验证结果
 		assert.Error(t, err)
@@ -114,7 +114,7 @@ func TestDoQuery(t *testing.T) {
 		mock.ExpectQuery("SELECT").WillReturnRows(rows)
 
 		// 调用 DoQuery
-		result, headers, err := DoQuery("SELECT id, name FROM users", StatementTypeNoExplainCheck)
+		result, headers, err := DoQuery("SELECT id, name FROM users", StatementTypeNoExplainCheck, RouteAuto, nil)
 
 		// 验证结果
 		assert.NoError(t, err)
@@ -146,7 +146,7 @@ func TestDoQuery(t *testing.T) {
 		mock.ExpectQuery("SELECT").WillReturnRows(rows)
 
 		// 调用 DoQuery
-		result, headers, err := DoQuery("SELECT id, name FROM users", StatementTypeSelect)
+		result, headers, err := DoQuery("SELECT id, name FROM users", StatementTypeSelect, RouteAuto, nil)
 
 		// 验证结果
 		assert.NoError(t, err)
@@ -159,7 +159,7 @@ func TestDoQuery(t *testing.T) {
 		mock.ExpectQuery("SELECT").WillReturnError(fmt.Errorf("查询错误"))
 
 		// 调用 DoQuery
-		_, _, err := DoQuery("SELECT id, name FROM users", StatementTypeNoExplainCheck)
+		_, _, err := DoQuery("SELECT id, name FROM users", StatementTypeNoExplainCheck, RouteAuto, nil)
 
 		// 验证结果
 		assert.Error(t, err)
@@ -171,7 +171,7 @@ func TestDoQuery(t *testing.T) {
 		mock.ExpectQuery("SELECT").WillReturnError(fmt.Errorf("列错误"))
 
 		// 调用 DoQuery
-		_, _, err := DoQuery("SELECT id, name FROM users", StatementTypeNoExplainCheck)
+		_, _, err := DoQuery("SELECT id, name FROM users", StatementTypeNoExplainCheck, RouteAuto, nil)
 
 		// 验证结果
 		assert.Error(t, err)
@@ -183,7 +183,7 @@ func TestDoQuery(t *testing.T) {
 		mock.ExpectQuery("SELECT").WillReturnError(fmt.Errorf("扫描错误"))
 
 		// 调用 DoQuery
-		_, _, err := DoQuery("SELECT id, name FROM users", StatementTypeNoExplainCheck)
+		_, _, err := DoQuery("SELECT id, name FROM users", StatementTypeNoExplainCheck, RouteAuto, nil)
 
 		// 验证结果
 		assert.Error(t, err)
@@ -198,7 +198,7 @@ func TestDoQuery(t *testing.T) {
 		mock.ExpectQuery("SELECT").WillReturnRows(rows)
 
 		// 调用 DoQuery
-		result, headers, err := DoQuery("SELECT id, blob FROM users", StatementTypeNoExplainCheck)
+		result, headers, err := DoQuery("SELECT id, blob FROM users", StatementTypeNoExplainCheck, RouteAuto, nil)
 
 		// 验证结果
 		assert.NoError(t, err)
@@ -264,7 +264,7 @@ func TestHandleExplain(t *testing.T) {
 		WithExplainCheck = false
 
 		// 调用 HandleExplain - should return nil without querying
-		err := HandleExplain("SELECT * FROM users", StatementTypeSelect)
+		err := HandleExplain(DB, "SELECT * FROM users", StatementTypeSelect)
 
 		// 验证结果
 		assert.NoError(t, err)
@@ -281,7 +281,7 @@ func TestHandleExplain(t *testing.T) {
 		mock.ExpectQuery("EXPLAIN").WillReturnRows(explainRows)
 
 		// 调用 HandleExplain
-		err := HandleExplain("SELECT * FROM users", StatementTypeSelect)
+		err := HandleExplain(DB, "SELECT * FROM users", StatementTypeSelect)
 
 		// 验证结果
 		assert.NoError(t, err)
@@ -295,7 +295,7 @@ func TestHandleExplain(t *testing.T) {
 		mock.ExpectQuery("EXPLAIN").WillReturnRows(explainRows)
 
 		// 调用 HandleExplain
-		err := HandleExplain("INSERT INTO users (name) VALUES ('test')", StatementTypeInsert)
+		err := HandleExplain(DB, "INSERT INTO users (name) VALUES ('test')", StatementTypeInsert)
 
 		// 验证结果
 		assert.NoError(t, err)
@@ -309,7 +309,7 @@ func TestHandleExplain(t *testing.T) {
 		mock.ExpectQuery("EXPLAIN").WillReturnRows(explainRows)
 
 		// 调用 HandleExplain
-		err := HandleExplain("UPDATE users SET name = 'test' WHERE id = 1", StatementTypeUpdate)
+		err := HandleExplain(DB, "UPDATE users SET name = 'test' WHERE id = 1", StatementTypeUpdate)
 
 		// 验证结果
 		assert.NoError(t, err)
@@ -323,7 +323,7 @@ func TestHandleExplain(t *testing.T) {
 		mock.ExpectQuery("EXPLAIN").WillReturnRows(explainRows)
 
 		// 调用 HandleExplain
-		err := HandleExplain("DELETE FROM users WHERE id = 1", StatementTypeDelete)
+		err := HandleExplain(DB, "DELETE FROM users WHERE id = 1", StatementTypeDelete)
 
 		// 验证结果
 		assert.NoError(t, err)
@@ -334,7 +334,7 @@ func TestHandleExplain(t *testing.T) {
 		mock.ExpectQuery("EXPLAIN").WillReturnError(fmt.Errorf("解释错误"))
 
 		// 调用 HandleExplain
-		err := HandleExplain("SELECT * FROM users", StatementTypeSelect)
+		err := HandleExplain(DB, "SELECT * FROM users", StatementTypeSelect)
 
 		// 验证结果
 		assert.Error(t, err)
@@ -348,7 +348,7 @@ func TestHandleExplain(t *testing.T) {
 		mock.ExpectQuery("EXPLAIN").WillReturnRows(explainRows)
 
 		// 调用 HandleExplain
-		err := HandleExplain("SELECT * FROM users", StatementTypeSelect)
+		err := HandleExplain(DB, "SELECT * FROM users", StatementTypeSelect)
 
 		// 验证结果
 		assert.Error(t, err)
@@ -363,7 +363,7 @@ func TestHandleExplain(t *testing.T) {
 		mock.ExpectQuery("EXPLAIN").WillReturnRows(explainRows)
 
 		// 调用 HandleExplain
-		err := HandleExplain("INSERT INTO users (name) VALUES ('test')", StatementTypeUpdate)
+		err := HandleExplain(DB, "INSERT INTO users (name) VALUES ('test')", StatementTypeUpdate)
 
 		// 验证结果
 		assert.Error(t, err)
@@ -375,7 +375,7 @@ func TestHandleExplain(t *testing.T) {
 		mock.ExpectQuery("EXPLAIN").WillReturnError(fmt.Errorf("scan error"))
 
 		// 调用 HandleExplain
-		err := HandleExplain("SELECT * FROM users", StatementTypeSelect)
+		err := HandleExplain(DB, "SELECT * FROM users", StatementTypeSelect)
 
 		// 验证结果
 		assert.Error(t, err)
@@ -392,10 +392,13 @@ func TestHandleDescTable(t *testing.T) {
 		rows := sqlmock.NewRows([]string{"Table", "Create Table"}).
 			AddRow("users", "CREATE TABLE `users` (`id` int(11) NOT NULL AUTO_INCREMENT, `name` varchar(255) NOT NULL, PRIMARY KEY (`id`)) ENGINE=InnoDB")
 
-		mock.ExpectQuery("SHOW CREATE TABLE").WillReturnRows(rows)
+		mock.ExpectQuery("FROM information_schema.TABLES").
+			WithArgs("", "users").
+			WillReturnRows(sqlmock.NewRows([]string{"TABLE_SCHEMA", "TABLE_NAME"}).AddRow("shop", "users"))
+		mock.ExpectQuery("SHOW CREATE TABLE `shop`.`users`").WillReturnRows(rows)
 
 		// 调用 HandleDescTable
-		result, err := HandleDescTable("users")
+		result, err := HandleDescTable("users", RouteAuto)
 
 		// 验证结果
 		assert.NoError(t, err)
@@ -404,12 +407,12 @@ func TestHandleDescTable(t *testing.T) {
 
 	t.Run("table not found", func(t *testing.T) {
 		// 设置模拟预期
-		rows := sqlmock.NewRows([]string{"Table", "Create Table"})
-
-		mock.ExpectQuery("SHOW CREATE TABLE").WillReturnRows(rows)
+		mock.ExpectQuery("FROM information_schema.TABLES").
+			WithArgs("", "nonexistent").
+			WillReturnRows(sqlmock.NewRows([]string{"TABLE_SCHEMA", "TABLE_NAME"}))
 
 		// 调用 HandleDescTable
-		_, err := HandleDescTable("nonexistent")
+		_, err := HandleDescTable("nonexistent", RouteAuto)
 
 		// 验证结果
 		assert.Error(t, err)
@@ -418,10 +421,13 @@ func TestHandleDescTable(t *testing.T) {
 
 	t.Run("query error", func(t *testing.T) {
 		// 设置模拟预期
+		mock.ExpectQuery("FROM information_schema.TABLES").
+			WithArgs("", "users").
+			WillReturnRows(sqlmock.NewRows([]string{"TABLE_SCHEMA", "TABLE_NAME"}).AddRow("shop", "users"))
 		mock.ExpectQuery("SHOW CREATE TABLE").WillReturnError(fmt.Errorf("查询错误"))
 
 		// 调用 HandleDescTable
-		_, err := HandleDescTable("users")
+		_, err := HandleDescTable("users", RouteAuto)
 
 		// 验证结果
 		assert.Error(t, err)
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	MaskRedact  = "redact"
+	MaskHash    = "hash"
+	MaskPartial = "partial"
+	MaskNull    = "null"
+
+	maskRedactedText = "[REDACTED]"
+)
+
+// MaskPolicyFile 是脱敏策略文件的路径，Masking 为加载后的策略，未配置时为 nil
+var (
+	MaskPolicyFile string
+	Masking        *MaskPolicy
+)
+
+// MaskRule 描述一条脱敏规则，Column 和 Comment 二选一：
+//   - Column 不含点号时按列名匹配，例如 `*email*`；`table.column` 或 `schema.table.column` 只匹配指定表的列
+//   - Comment 按列注释匹配，例如 `*PII*`
+//
+// 各部分都支持 `*` 和 `?` 通配符，不区分大小写
+type MaskRule struct {
+	Column   string `json:"column"`
+	Comment  string `json:"comment"`
+	Strategy string `json:"strategy"`
+
+	schema  *regexp.Regexp
+	table   *regexp.Regexp
+	column  *regexp.Regexp
+	comment *regexp.Regexp
+}
+
+// MaskPolicy 为脱敏策略，规则按顺序匹配，第一条匹配的规则决定脱敏方式
+type MaskPolicy struct {
+	// HashSalt 参与 hash 策略的摘要计算，避免通过彩虹表还原短值
+	HashSalt string     `json:"hash_salt"`
+	Rules    []MaskRule `json:"rules"`
+}
+
+// LoadMaskPolicy 读取 JSON 格式的脱敏策略文件
+func LoadMaskPolicy(path string) (*MaskPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取脱敏策略失败: %v", err)
+	}
+
+	return ParseMaskPolicy(data)
+}
+
+// ParseMaskPolicy 解析并校验脱敏策略
+func ParseMaskPolicy(data []byte) (*MaskPolicy, error) {
+	policy := &MaskPolicy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("解析脱敏策略失败: %v", err)
+	}
+
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		switch rule.Strategy {
+		case MaskRedact, MaskHash, MaskPartial, MaskNull:
+		default:
+			return nil, fmt.Errorf("第 %d 条脱敏规则的策略无效: %q（可选 redact、hash、partial、null）", i+1, rule.Strategy)
+		}
+
+		switch {
+		case rule.Column != "" && rule.Comment == "":
+			parts := strings.Split(rule.Column, ".")
+			if len(parts) > 3 || slices.Contains(parts, "") {
+				return nil, fmt.Errorf("第 %d 条脱敏规则的列无效: %s", i+1, rule.Column)
+			}
+			rule.column = globPattern(parts[len(parts)-1])
+			if len(parts) > 1 {
+				rule.table = globPattern(parts[len(parts)-2])
+			}
+			if len(parts) > 2 {
+				rule.schema = globPattern(parts[0])
+			}
+		case rule.Comment != "" && rule.Column == "":
+			rule.comment = globPattern(rule.Comment)
+		default:
+			return nil, fmt.Errorf("第 %d 条脱敏规则必须且只能指定 column 或 comment 之一", i+1)
+		}
+	}
+
+	return policy, nil
+}
+
+// globPattern 将 `*`、`?` 通配符转换为不区分大小写的正则表达式
+func globPattern(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	for _, c := range glob {
+		switch c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+
+	return regexp.MustCompile(b.String())
+}
+
+func (p *MaskPolicy) hasCommentRules() bool {
+	for _, rule := range p.Rules {
+		if rule.comment != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maskColumnComment 为查询引用的表中某列的注释
+type maskColumnComment struct {
+	Schema  string `db:"TABLE_SCHEMA"`
+	Table   string `db:"TABLE_NAME"`
+	Column  string `db:"COLUMN_NAME"`
+	Comment string `db:"COLUMN_COMMENT"`
+}
+
+// maskSelectItem 为 SELECT 列表中的一项：结果列名和表达式引用的列
+type maskSelectItem struct {
+	name string
+	refs []ColumnRef
+	star bool
+}
+
+// maskBranch 为结果集的一个 UNION 分支，star 表示分支的列无法逐项确定（使用了 * 或 TABLE 语句）
+type maskBranch struct {
+	items []maskSelectItem
+	star  bool
+}
+
+// maskResolver 判断结果列是否来源于需要脱敏的列
+type maskResolver struct {
+	policy   *MaskPolicy
+	tables   []TableRef
+	comments []maskColumnComment
+}
+
+// ResultMasker 对一个结果集中需要脱敏的列应用策略，为 nil 时不做任何处理
+type ResultMasker struct {
+	salt       string
+	strategies []string
+}
+
+// NewResultMasker 根据当前脱敏策略分析查询，确定每个结果列的脱敏方式。
+// 列通过 SELECT 列表中的别名、表达式引用的列以及子查询/CTE 的输出列逐层追踪；
+// 任何引用了敏感列的表达式（包括聚合和函数）都会整体脱敏
+func NewResultMasker(query string, cols []string) (*ResultMasker, error) {
+	if Masking == nil || len(Masking.Rules) == 0 {
+		return nil, nil
+	}
+
+	resolver := &maskResolver{policy: Masking}
+	for _, scope := range AnalyzeQueryScopes(query) {
+		resolver.tables = append(resolver.tables, scope.Tables...)
+	}
+	resolver.tables = append(resolver.tables, tableStatementRefs(TokenizeSQL(query))...)
+
+	if Masking.hasCommentRules() {
+		comments, err := loadMaskColumnComments(resolver.tables)
+		if err != nil {
+			return nil, err
+		}
+		resolver.comments = comments
+	}
+
+	strategies, err := resolver.resolve(query, cols)
+	if err != nil {
+		return nil, err
+	}
+	for _, strategy := range strategies {
+		if strategy != "" {
+			return &ResultMasker{salt: Masking.HashSalt, strategies: strategies}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// loadMaskColumnComments 读取查询引用的各表的列注释，CTE 等不存在的表会被忽略
+func loadMaskColumnComments(tables []TableRef) ([]maskColumnComment, error) {
+	db, err := GetDB()
+	if err != nil {
+		return nil, err
+	}
+
+	comments := []maskColumnComment{}
+	seen := map[string]bool{}
+	for _, t := range tables {
+		key := strings.ToLower(t.Schema + "." + t.Name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		rows := []maskColumnComment{}
+		err := db.Select(&rows,
+			"SELECT TABLE_SCHEMA, TABLE_NAME, COLUMN_NAME, COLUMN_COMMENT FROM information_schema.COLUMNS "+
+				"WHERE TABLE_SCHEMA = COALESCE(NULLIF(?, ''), DATABASE()) AND TABLE_NAME = ? AND COLUMN_COMMENT <> ''",
+			t.Schema, t.Name,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("读取列注释失败: %v", err)
+		}
+		comments = append(comments, rows...)
+	}
+
+	return comments, nil
+}
+
+// resolve 返回每个结果列的脱敏策略。结果列名来自第一个 UNION 分支，其余分支的列按位置对应
+func (r *maskResolver) resolve(query string, cols []string) ([]string, error) {
+	items, branches := parseSelectItems(query)
+
+	// 派生表和 CTE 的输出列可能来自敏感列，按列名传递直到不再变化
+	tainted := map[string]string{}
+	for changed := true; changed; {
+		changed = false
+		for _, item := range items {
+			if item.star || tainted[strings.ToLower(item.name)] != "" {
+				continue
+			}
+			for _, ref := range item.refs {
+				strategy := r.sourceStrategy(ref)
+				if strategy == "" {
+					strategy = tainted[strings.ToLower(ref.Column)]
+				}
+				if strategy != "" {
+					tainted[strings.ToLower(item.name)] = strategy
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	strategies := make([]string, len(cols))
+	for i, col := range cols {
+		strategy := r.sourceStrategy(ColumnRef{Column: col})
+		if strategy == "" {
+			strategy = tainted[strings.ToLower(col)]
+		}
+		strategies[i] = strategy
+	}
+
+	for b := 1; b < len(branches); b++ {
+		if branches[b].star {
+			return nil, fmt.Errorf("配置了脱敏策略时，UNION 的后续分支不能使用 * 或 TABLE 语句，请明确列出需要的列")
+		}
+		for i, item := range branches[b].items {
+			if i >= len(strategies) || strategies[i] != "" {
+				continue
+			}
+			strategies[i] = tainted[strings.ToLower(item.name)]
+			for _, ref := range item.refs {
+				if strategies[i] != "" {
+					break
+				}
+				strategies[i] = r.sourceStrategy(ref)
+			}
+		}
+	}
+
+	return strategies, nil
+}
+
+// sourceStrategy 返回列引用匹配的第一条规则的策略，不匹配时返回空字符串。
+// 没有限定名的列可能来自查询中的任意一张表
+func (r *maskResolver) sourceStrategy(ref ColumnRef) string {
+	candidates := []TableRef{}
+	for _, t := range r.tables {
+		if ref.Qualifier == "" || t.Matches(ref.Qualifier) || strings.EqualFold(t.Name, ref.Qualifier) {
+			candidates = append(candidates, t)
+		}
+	}
+
+	for _, rule := range r.policy.Rules {
+		switch {
+		case rule.comment != nil:
+			for _, c := range r.comments {
+				if !strings.EqualFold(c.Column, ref.Column) || !rule.comment.MatchString(c.Comment) {
+					continue
+				}
+				for _, t := range candidates {
+					if strings.EqualFold(t.Name, c.Table) && (t.Schema == "" || strings.EqualFold(t.Schema, c.Schema)) {
+						return rule.Strategy
+					}
+				}
+			}
+		case !rule.column.MatchString(ref.Column):
+		case rule.table == nil:
+			return rule.Strategy
+		default:
+			for _, t := range candidates {
+				// 未写库名的表可能属于任意库，按匹配处理
+				if rule.table.MatchString(t.Name) && (rule.schema == nil || t.Schema == "" || rule.schema.MatchString(t.Schema)) {
+					return rule.Strategy
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// parseSelectItems 解析查询中所有 SELECT 列表（包括子查询和 CTE）的各项，
+// 并按顺序返回构成结果集的各个 UNION 分支
+func parseSelectItems(query string) ([]maskSelectItem, []maskBranch) {
+	runes := []rune(query)
+	tokens := TokenizeSQL(query)
+	items := []maskSelectItem{}
+	branches := []maskBranch{}
+
+	// operands 记录每层括号是否只是包裹 UNION 分支，只有所有外层括号都是时 SELECT 才输出结果集
+	operands := []bool{}
+	topLevel := func() bool {
+		return !slices.Contains(operands, false)
+	}
+
+	for i, tok := range tokens {
+		switch {
+		case tok.Is(TokenPunct, "("):
+			operands = append(operands, setOperandStart(tokens, i) && topLevel())
+			continue
+		case tok.Is(TokenPunct, ")"):
+			if len(operands) > 0 {
+				operands = operands[:len(operands)-1]
+			}
+			continue
+		case tableStatementAt(tokens, i, false) && topLevel():
+			branches = append(branches, maskBranch{star: true})
+			continue
+		case !tok.IsKeyword("SELECT"):
+			continue
+		}
+		first := len(items)
+
+		start := i + 1
+		for start < len(tokens) && (tokens[start].IsKeyword("DISTINCT", "ALL", "DISTINCTROW", "HIGH_PRIORITY", "STRAIGHT_JOIN") ||
+			(tokens[start].Kind == TokenWord && strings.HasPrefix(strings.ToUpper(tokens[start].Value), "SQL_"))) {
+			start++
+		}
+
+		depth := 0
+		itemStart := start
+		for j := start; j <= len(tokens); j++ {
+			end := j == len(tokens)
+			if !end {
+				t := tokens[j]
+				switch {
+				case t.Is(TokenPunct, "("):
+					depth++
+					continue
+				case t.Is(TokenPunct, ")"):
+					depth--
+					if depth >= 0 {
+						continue
+					}
+					end = true
+				case depth > 0:
+					continue
+				case t.Is(TokenPunct, ";"), t.IsKeyword("FROM", "INTO", "UNION", "WHERE", "GROUP", "HAVING", "ORDER", "LIMIT", "WINDOW", "FOR", "LOCK"):
+					end = true
+				case !t.Is(TokenPunct, ","):
+					continue
+				}
+			}
+
+			if j > itemStart {
+				items = append(items, parseSelectItem(runes, tokens[itemStart:j]))
+			}
+			if end {
+				break
+			}
+			itemStart = j + 1
+		}
+
+		if topLevel() {
+			branch := maskBranch{items: items[first:]}
+			for _, item := range branch.items {
+				branch.star = branch.star || item.star
+			}
+			branches = append(branches, branch)
+		}
+	}
+
+	return items, branches
+}
+
+// setOperandStart 判断 tokens[i] 处的左括号是否可能包裹一个 UNION 分支：位于查询开头、
+// 集合运算之后或另一个这样的括号之内
+func setOperandStart(tokens []SQLToken, i int) bool {
+	if i == 0 || tokens[i-1].Is(TokenPunct, "(") || tokens[i-1].IsKeyword("UNION", "INTERSECT", "EXCEPT") {
+		return true
+	}
+
+	return i >= 2 && tokens[i-1].IsKeyword("ALL", "DISTINCT") && tokens[i-2].IsKeyword("UNION", "INTERSECT", "EXCEPT")
+}
+
+// tableStatementRefs 返回查询中 `TABLE t` 语句读取的表
+func tableStatementRefs(tokens []SQLToken) []TableRef {
+	refs := []TableRef{}
+	for i := range tokens {
+		if !tableStatementAt(tokens, i, false) {
+			continue
+		}
+		if parts, _ := readQualifiedName(tokens, i+1); parts != nil {
+			ref := TableRef{Name: parts[len(parts)-1]}
+			if len(parts) > 1 {
+				ref.Schema = parts[len(parts)-2]
+			}
+			refs = append(refs, ref)
+		}
+	}
+
+	return refs
+}
+
+func parseSelectItem(runes []rune, tokens []SQLToken) maskSelectItem {
+	item := maskSelectItem{}
+	expr := tokens
+	n := len(tokens)
+	switch {
+	case n >= 2 && tokens[n-2].IsKeyword("AS"):
+		item.name = tokens[n-1].Value
+		expr = tokens[:n-2]
+	case n >= 2 && (tokens[n-1].IsIdent() || tokens[n-1].Kind == TokenString) &&
+		!tokens[n-2].Is(TokenPunct, ".") && tokens[n-2].Kind != TokenOperator:
+		item.name = tokens[n-1].Value
+		expr = tokens[:n-1]
+	}
+
+	for j := 0; j < len(expr); {
+		parts, next := readQualifiedName(expr, j)
+		if parts == nil {
+			j++
+			continue
+		}
+		switch {
+		case parts[len(parts)-1] == "*":
+			item.star = len(expr) == next
+		case next < len(expr) && expr[next].Is(TokenPunct, "("):
+			// 函数名
+		default:
+			item.refs = append(item.refs, columnRefFromParts(parts))
+		}
+		j = next
+	}
+	if len(expr) == 1 && expr[0].Is(TokenOperator, "*") {
+		item.star = true
+	}
+
+	if item.name == "" && len(expr) > 0 {
+		if parts, next := readQualifiedName(expr, 0); parts != nil && next == len(expr) {
+			item.name = parts[len(parts)-1]
+		} else {
+			// 没有别名的表达式，MySQL 以原始文本作为列名
+			item.name = string(runes[expr[0].Start:expr[len(expr)-1].End])
+		}
+	}
+
+	return item
+}
+
+// Masked 判断第 i 列是否需要脱敏
+func (m *ResultMasker) Masked(i int) bool {
+	return m != nil && m.strategies[i] != ""
+}
+
+// MaskRow 原地替换一行中需要脱敏的值，NULL 保持不变
+func (m *ResultMasker) MaskRow(values []interface{}) {
+	if m == nil {
+		return
+	}
+
+	for i, strategy := range m.strategies {
+		if strategy == "" || values[i] == nil {
+			continue
+		}
+
+		text := exportText(values[i])
+		switch strategy {
+		case MaskRedact:
+			values[i] = maskRedactedText
+		case MaskHash:
+			sum := sha256.Sum256([]byte(m.salt + text))
+			values[i] = "sha256:" + hex.EncodeToString(sum[:8])
+		case MaskPartial:
+			values[i] = MaskPartialText(text)
+		case MaskNull:
+			values[i] = nil
+		}
+	}
+}
+
+// MaskPartialText 保留值的首尾少量字符，其余替换为 `*`；邮箱保留首字符和域名
+func MaskPartialText(text string) string {
+	runes := []rune(text)
+	if at := strings.LastIndex(text, "@"); at > 0 {
+		local := []rune(text[:at])
+		return string(local[0]) + "***" + text[at:]
+	}
+
+	n := len(runes)
+	if n <= 4 {
+		return strings.Repeat("*", n)
+	}
+	keep := min(n/4, 4)
+
+	return string(runes[:keep]) + strings.Repeat("*", n-2*keep) + string(runes[n-keep:])
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupMasking(t *testing.T, policy string) {
+	parsed, err := ParseMaskPolicy([]byte(policy))
+	if err != nil {
+		t.Fatalf("解析脱敏策略失败: %v", err)
+	}
+
+	original := Masking
+	Masking = parsed
+	t.Cleanup(func() { Masking = original })
+}
+
+func TestParseMaskPolicy(t *testing.T) {
+	for _, policy := range []string{
+		`{"rules": [{"column": "email", "strategy": "shuffle"}]}`,
+		`{"rules": [{"column": "email", "comment": "*PII*", "strategy": "redact"}]}`,
+		`{"rules": [{"strategy": "redact"}]}`,
+		`{"rules": [{"column": "a.b.c.d", "strategy": "redact"}]}`,
+		`{"rules": [{"column": "users.", "strategy": "redact"}]}`,
+		`{"rules": `,
+	} {
+		_, err := ParseMaskPolicy([]byte(policy))
+		assert.Error(t, err, policy)
+	}
+}
+
+func TestMaskPartialText(t *testing.T) {
+	assert.Equal(t, "a***@example.com", MaskPartialText("alice@example.com"))
+	assert.Equal(t, "13*******78", MaskPartialText("13812345678"))
+	assert.Equal(t, "****", MaskPartialText("1234"))
+	assert.Equal(t, "张*****六", MaskPartialText("张三李四王五六"))
+}
+
+func TestNewResultMasker(t *testing.T) {
+	setupMasking(t, `{
+		"hash_salt": "s",
+		"rules": [
+			{"column": "*email*", "strategy": "partial"},
+			{"column": "users.password*", "strategy": "hash"},
+			{"column": "shop.users.phone", "strategy": "null"},
+			{"column": "token", "strategy": "redact"}
+		]
+	}`)
+
+	tests := []struct {
+		name     string
+		query    string
+		cols     []string
+		expected []string
+	}{
+		{"column name pattern", "SELECT id, email FROM users", []string{"id", "email"}, []string{"", MaskPartial}},
+		{"star", "SELECT * FROM users", []string{"id", "password_hash", "phone"}, []string{"", MaskHash, MaskNull}},
+		{"alias", "SELECT u.password_hash AS ph, u.id FROM users u", []string{"ph", "id"}, []string{MaskHash, ""}},
+		{"implicit alias", "SELECT t.token secret FROM sessions t", []string{"secret"}, []string{MaskRedact}},
+		{"expression", "SELECT CONCAT(first, email), COUNT(*) AS n FROM users", []string{"CONCAT(first, email)", "n"}, []string{MaskPartial, ""}},
+		{"other table", "SELECT password_hash FROM admins", []string{"password_hash"}, []string{""}},
+		{"qualified other table", "SELECT a.password_hash FROM admins a JOIN users u ON u.id = a.user_id", []string{"password_hash"}, []string{MaskHash}},
+		{"other schema", "SELECT phone FROM crm.users", []string{"phone"}, []string{""}},
+		{"derived table", "SELECT x.p FROM (SELECT password_hash AS p FROM users) x", []string{"p"}, []string{MaskHash}},
+		{"cte chain", "WITH a AS (SELECT phone AS c FROM users), b AS (SELECT UPPER(c) AS d FROM a) SELECT d AS result FROM b", []string{"result"}, []string{MaskNull}},
+		{"scalar subquery", "SELECT id, (SELECT token FROM sessions s WHERE s.user_id = u.id LIMIT 1) AS t FROM users u", []string{"id", "t"}, []string{"", MaskRedact}},
+		{"union by position", "SELECT 1 UNION SELECT email FROM users", []string{"1"}, []string{MaskPartial}},
+		{"parenthesized union", "(SELECT id, name FROM orders) UNION ALL (SELECT id, password_hash FROM users)", []string{"id", "name"}, []string{"", MaskHash}},
+		{"union with subquery branch", "SELECT id FROM orders WHERE id IN (SELECT token FROM sessions) UNION SELECT id FROM users", []string{"id"}, []string{""}},
+		{"table statement", "TABLE shop.users", []string{"id", "password_hash", "phone"}, []string{"", MaskHash, MaskNull}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			masker, err := NewResultMasker(tt.query, tt.cols)
+
+			assert.NoError(t, err)
+			for i, expected := range tt.expected {
+				if expected == "" {
+					assert.False(t, masker.Masked(i), tt.cols[i])
+				} else {
+					assert.Equal(t, expected, masker.strategies[i], tt.cols[i])
+				}
+			}
+		})
+	}
+
+	t.Run("star in later union branch", func(t *testing.T) {
+		_, err := NewResultMasker("SELECT id, name FROM orders UNION TABLE users", []string{"id", "name"})
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "UNION")
+	})
+
+	t.Run("nothing masked", func(t *testing.T) {
+		masker, err := NewResultMasker("SELECT id, name FROM orders", []string{"id", "name"})
+
+		assert.NoError(t, err)
+		assert.Nil(t, masker)
+	})
+}
+
+func TestDoQueryMasking(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	t.Run("applies strategies", func(t *testing.T) {
+		setupMasking(t, `{"hash_salt": "s", "rules": [
+			{"column": "email", "strategy": "partial"},
+			{"column": "password", "strategy": "hash"},
+			{"column": "token", "strategy": "redact"}
+		]}`)
+
+		// 设置模拟预期
+		rows := sqlmock.NewRows([]string{"id", "email", "password", "token"}).
+			AddRow(1, []byte("bob@example.com"), []byte("secret"), nil)
+		mock.ExpectQuery("SELECT").WillReturnRows(rows)
+
+		// 调用 DoQuery
+		result, _, err := DoQuery("SELECT id, email, password, token FROM users", StatementTypeNoExplainCheck, RouteAuto, nil)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), result[0]["id"])
+		assert.Equal(t, "b***@example.com", result[0]["email"])
+		assert.Equal(t, "sha256:6f5230739913f933", result[0]["password"])
+		assert.Nil(t, result[0]["token"])
+	})
+
+	t.Run("column comments", func(t *testing.T) {
+		setupMasking(t, `{"rules": [{"comment": "*[PII]*", "strategy": "redact"}]}`)
+
+		// 设置模拟预期
+		mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"full_name", "level"}).AddRow("张三", 3))
+		mock.ExpectQuery("FROM information_schema.COLUMNS").WithArgs("", "customers").
+			WillReturnRows(sqlmock.NewRows([]string{"TABLE_SCHEMA", "TABLE_NAME", "COLUMN_NAME", "COLUMN_COMMENT"}).
+				AddRow("shop", "customers", "full_name", "姓名 [PII]").
+				AddRow("shop", "customers", "level", "会员等级"))
+
+		// 调用 DoQuery
+		result, _, err := DoQuery("SELECT c.full_name, c.level FROM customers c", StatementTypeNoExplainCheck, RouteAuto, nil)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, "[REDACTED]", result[0]["full_name"])
+		assert.Equal(t, int64(3), result[0]["level"])
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	migrationLockName    = "go-mcp-mysql.schema_migrations"
+	migrationLockTimeout = 10
+)
+
+var MigrationsDir string
+
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version  int64
+	Name     string
+	UpPath   string
+	DownPath string
+	Checksum string
+}
+
+type AppliedMigration struct {
+	Version   int64  `db:"version"`
+	Name      string `db:"name"`
+	Checksum  string `db:"checksum"`
+	AppliedAt string `db:"applied_at"`
+}
+
+// LoadMigrations 读取迁移目录中形如 `0001_create_users.up.sql` / `0001_create_users.down.sql` 的文件
+func LoadMigrations(dir string) ([]Migration, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("未配置迁移目录，请使用 --migrations-dir 指定")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("读取迁移目录失败: %v", err)
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		m := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if m == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("无效的迁移版本号 %s: %v", m[1], err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		} else if migration.Name != m[2] {
+			return nil, fmt.Errorf("迁移版本 %d 存在多个名称: %s, %s", version, migration.Name, m[2])
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		if m[3] == "up" {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("读取迁移文件失败: %v", err)
+			}
+			sum := sha256.Sum256(content)
+			migration.UpPath = path
+			migration.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			migration.DownPath = path
+		}
+	}
+
+	result := []Migration{}
+	for _, migration := range byVersion {
+		if migration.UpPath == "" {
+			return nil, fmt.Errorf("迁移 %d_%s 缺少 up 文件", migration.Version, migration.Name)
+		}
+		result = append(result, *migration)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+
+	return result, nil
+}
+
+func migrationsTableExists(q sqlx.QueryerContext) (bool, error) {
+	var count int
+	if err := sqlx.GetContext(context.Background(), q, &count, "SELECT COUNT(*) FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'schema_migrations'"); err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func loadAppliedMigrations(q sqlx.QueryerContext) ([]AppliedMigration, error) {
+	applied := []AppliedMigration{}
+	if err := sqlx.SelectContext(context.Background(), q, &applied, "SELECT version, name, checksum, DATE_FORMAT(applied_at, '%Y-%m-%d %H:%i:%s') AS applied_at FROM schema_migrations ORDER BY version"); err != nil {
+		return nil, fmt.Errorf("读取已应用的迁移失败: %v", err)
+	}
+
+	return applied, nil
+}
+
+// withMigrationLock 在独占连接上获取 MySQL 命名锁后执行 fn，保证同一时间只有一个进程执行迁移
+func withMigrationLock(fn func(conn *sqlx.Conn) error) error {
+	db, err := GetDB()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowxContext(ctx, "SELECT GET_LOCK(?, ?)", migrationLockName, migrationLockTimeout).Scan(&locked); err != nil {
+		return fmt.Errorf("获取迁移锁失败: %v", err)
+	}
+	if !locked.Valid || locked.Int64 != 1 {
+		return fmt.Errorf("其他进程正在执行迁移，%d 秒内未能获取迁移锁", migrationLockTimeout)
+	}
+	defer func() {
+		conn.QueryRowxContext(ctx, "SELECT RELEASE_LOCK(?)", migrationLockName).Scan(&locked)
+	}()
+
+	if _, err := conn.ExecContext(ctx,
+		"CREATE TABLE IF NOT EXISTS schema_migrations ("+
+			"version BIGINT NOT NULL PRIMARY KEY COMMENT '迁移版本号', "+
+			"name VARCHAR(255) NOT NULL COMMENT '迁移名称', "+
+			"checksum CHAR(64) NOT NULL COMMENT 'up 文件的 SHA-256 校验和', "+
+			"applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '应用时间'"+
+			") COMMENT = '已应用的数据库迁移'"); err != nil {
+		return fmt.Errorf("创建 schema_migrations 表失败: %v", err)
+	}
+
+	return fn(conn)
+}
+
+// runMigrationFile 逐条执行迁移文件中的语句。MySQL 的 DDL 会隐式提交，失败时之前的语句无法回滚
+func runMigrationFile(conn *sqlx.Conn, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取迁移文件失败: %v", err)
+	}
+
+	for i, stmt := range SplitStatements(string(content)) {
+		if _, err := conn.ExecContext(context.Background(), stmt); err != nil {
+			return fmt.Errorf("%s 第 %d 条语句执行失败（之前的语句已生效）: %v", filepath.Base(path), i+1, err)
+		}
+	}
+
+	return nil
+}
+
+func HandleMigrationStatus() (string, error) {
+	migrations, err := LoadMigrations(MigrationsDir)
+	if err != nil {
+		return "", err
+	}
+
+	db, err := GetDB()
+	if err != nil {
+		return "", err
+	}
+
+	applied := []AppliedMigration{}
+	exists, err := migrationsTableExists(db)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		if applied, err = loadAppliedMigrations(db); err != nil {
+			return "", err
+		}
+	}
+
+	appliedByVersion := map[int64]AppliedMigration{}
+	for _, a := range applied {
+		appliedByVersion[a.Version] = a
+	}
+
+	result := []map[string]interface{}{}
+	for _, m := range migrations {
+		row := map[string]interface{}{"version": m.Version, "name": m.Name, "status": "pending", "applied_at": ""}
+		if a, ok := appliedByVersion[m.Version]; ok {
+			row["status"] = "applied"
+			row["applied_at"] = a.AppliedAt
+			if a.Checksum != m.Checksum {
+				row["status"] = "checksum_mismatch"
+			}
+			delete(appliedByVersion, m.Version)
+		}
+		result = append(result, row)
+	}
+	for _, a := range applied {
+		if _, ok := appliedByVersion[a.Version]; ok {
+			result = append(result, map[string]interface{}{"version": a.Version, "name": a.Name, "status": "missing_file", "applied_at": a.AppliedAt})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i]["version"].(int64) < result[j]["version"].(int64) })
+
+	return MapToCSV(result, []string{"version", "name", "status", "applied_at"})
+}
+
+// HandleApplyMigrations 按版本顺序应用未执行的迁移，target 大于 0 时只应用到该版本为止
+func HandleApplyMigrations(target int64) (string, error) {
+	migrations, err := LoadMigrations(MigrationsDir)
+	if err != nil {
+		return "", err
+	}
+
+	done := []string{}
+	defer Cache.Clear()
+	err = withMigrationLock(func(conn *sqlx.Conn) error {
+		applied, err := loadAppliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+
+		appliedByVersion := map[int64]AppliedMigration{}
+		var latest int64
+		for _, a := range applied {
+			appliedByVersion[a.Version] = a
+			latest = max(latest, a.Version)
+		}
+
+		pending := []Migration{}
+		for _, m := range migrations {
+			a, ok := appliedByVersion[m.Version]
+			if ok {
+				if a.Checksum != m.Checksum {
+					return fmt.Errorf("迁移 %d_%s 的文件在应用后被修改（校验和不一致），拒绝执行", m.Version, m.Name)
+				}
+				continue
+			}
+			if target > 0 && m.Version > target {
+				continue
+			}
+			if m.Version < latest {
+				return fmt.Errorf("迁移 %d_%s 早于已应用的最新版本 %d，拒绝乱序执行", m.Version, m.Name, latest)
+			}
+			pending = append(pending, m)
+		}
+
+		for _, m := range pending {
+			if err := runMigrationFile(conn, m.UpPath); err != nil {
+				return fmt.Errorf("应用迁移 %d_%s 失败: %v", m.Version, m.Name, err)
+			}
+			if _, err := conn.ExecContext(context.Background(), "INSERT INTO schema_migrations (version, name, checksum) VALUES (?, ?, ?)", m.Version, m.Name, m.Checksum); err != nil {
+				return fmt.Errorf("记录迁移 %d_%s 失败: %v", m.Version, m.Name, err)
+			}
+			done = append(done, fmt.Sprintf("%d_%s", m.Version, m.Name))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		if len(done) > 0 {
+			return "", fmt.Errorf("%v（已成功应用: %s）", err, strings.Join(done, ", "))
+		}
+		return "", err
+	}
+
+	if len(done) == 0 {
+		return "没有待应用的迁移", nil
+	}
+
+	return fmt.Sprintf("已应用 %d 个迁移: %s", len(done), strings.Join(done, ", ")), nil
+}
+
+// HandleRollbackMigration 按版本倒序回滚最近应用的 steps 个迁移
+func HandleRollbackMigration(steps int) (string, error) {
+	migrations, err := LoadMigrations(MigrationsDir)
+	if err != nil {
+		return "", err
+	}
+
+	byVersion := map[int64]Migration{}
+	for _, m := range migrations {
+		byVersion[m.Version] = m
+	}
+
+	done := []string{}
+	defer Cache.Clear()
+	err = withMigrationLock(func(conn *sqlx.Conn) error {
+		applied, err := loadAppliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			return fmt.Errorf("没有可回滚的迁移")
+		}
+
+		for i := len(applied) - 1; i >= 0 && len(done) < steps; i-- {
+			a := applied[i]
+			m, ok := byVersion[a.Version]
+			if !ok || m.DownPath == "" {
+				return fmt.Errorf("迁移 %d_%s 没有 down 文件，无法回滚", a.Version, a.Name)
+			}
+			if err := runMigrationFile(conn, m.DownPath); err != nil {
+				return fmt.Errorf("回滚迁移 %d_%s 失败: %v", a.Version, a.Name, err)
+			}
+			if _, err := conn.ExecContext(context.Background(), "DELETE FROM schema_migrations WHERE version = ?", a.Version); err != nil {
+				return fmt.Errorf("删除迁移记录 %d_%s 失败: %v", a.Version, a.Name, err)
+			}
+			done = append(done, fmt.Sprintf("%d_%s", a.Version, a.Name))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		if len(done) > 0 {
+			return "", fmt.Errorf("%v（已成功回滚: %s）", err, strings.Join(done, ", "))
+		}
+		return "", err
+	}
+
+	return fmt.Sprintf("已回滚 %d 个迁移: %s", len(done), strings.Join(done, ", ")), nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeMigration(t *testing.T, dir, name, content string) string {
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatalf("写入迁移文件失败: %v", err)
+	}
+
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func setupMigrationsDir(t *testing.T) (string, string, string) {
+	dir := t.TempDir()
+
+	originalDir := MigrationsDir
+	MigrationsDir = dir
+	t.Cleanup(func() { MigrationsDir = originalDir })
+
+	sum1 := writeMigration(t, dir, "0001_create_users.up.sql", "CREATE TABLE users (id INT PRIMARY KEY);\n-- seed; data\nINSERT INTO users VALUES (1);\n")
+	writeMigration(t, dir, "0001_create_users.down.sql", "DROP TABLE users;")
+	sum2 := writeMigration(t, dir, "0002_add_name.up.sql", "ALTER TABLE users ADD COLUMN name VARCHAR(20) COMMENT 'a;b';")
+	writeMigration(t, dir, "0002_add_name.down.sql", "ALTER TABLE users DROP COLUMN name;")
+	writeMigration(t, dir, "README.md", "not a migration")
+
+	return dir, sum1, sum2
+}
+
+func expectMigrationLock(mock sqlmock.Sqlmock, result int) {
+	mock.ExpectQuery("SELECT GET_LOCK").WithArgs(migrationLockName, migrationLockTimeout).
+		WillReturnRows(sqlmock.NewRows([]string{"GET_LOCK"}).AddRow(result))
+	if result == 1 {
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	t.Run("loads and sorts migrations", func(t *testing.T) {
+		dir, sum1, _ := setupMigrationsDir(t)
+
+		migrations, err := LoadMigrations(dir)
+
+		assert.NoError(t, err)
+		assert.Len(t, migrations, 2)
+		assert.Equal(t, int64(1), migrations[0].Version)
+		assert.Equal(t, "create_users", migrations[0].Name)
+		assert.Equal(t, sum1, migrations[0].Checksum)
+		assert.NotEmpty(t, migrations[0].DownPath)
+	})
+
+	t.Run("missing up file", func(t *testing.T) {
+		dir := t.TempDir()
+		writeMigration(t, dir, "0003_orphan.down.sql", "SELECT 1;")
+
+		_, err := LoadMigrations(dir)
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "缺少 up 文件")
+	})
+
+	t.Run("directory not configured", func(t *testing.T) {
+		_, err := LoadMigrations("")
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "--migrations-dir")
+	})
+}
+
+func TestHandleMigrationStatus(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+	_, sum1, _ := setupMigrationsDir(t)
+
+	// 设置模拟预期
+	mock.ExpectQuery("FROM information_schema.TABLES").WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1))
+	mock.ExpectQuery("FROM schema_migrations").WillReturnRows(sqlmock.NewRows([]string{"version", "name", "checksum", "applied_at"}).
+		AddRow(1, "create_users", sum1, "2024-01-01 00:00:00").
+		AddRow(5, "removed", "abc", "2024-01-02 00:00:00"))
+
+	// 调用 HandleMigrationStatus
+	result, err := HandleMigrationStatus()
+
+	// 验证结果
+	assert.NoError(t, err)
+	assert.Contains(t, result, "version,name,status,applied_at")
+	assert.Contains(t, result, "1,create_users,applied,2024-01-01 00:00:00")
+	assert.Contains(t, result, "2,add_name,pending,")
+	assert.Contains(t, result, "5,removed,missing_file,2024-01-02 00:00:00")
+}
+
+func TestHandleApplyMigrations(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	t.Run("applies pending migrations", func(t *testing.T) {
+		_, sum1, sum2 := setupMigrationsDir(t)
+
+		// 设置模拟预期
+		expectMigrationLock(mock, 1)
+		mock.ExpectQuery("FROM schema_migrations").WillReturnRows(sqlmock.NewRows([]string{"version", "name", "checksum", "applied_at"}))
+		mock.ExpectExec("CREATE TABLE users").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO users").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO schema_migrations").WithArgs(int64(1), "create_users", sum1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("ALTER TABLE users ADD COLUMN name").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO schema_migrations").WithArgs(int64(2), "add_name", sum2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("SELECT RELEASE_LOCK").WillReturnRows(sqlmock.NewRows([]string{"RELEASE_LOCK"}).AddRow(1))
+
+		// 调用 HandleApplyMigrations
+		result, err := HandleApplyMigrations(0)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, "已应用 2 个迁移: 1_create_users, 2_add_name", result)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("stored procedure body", func(t *testing.T) {
+		dir, sum1, sum2 := setupMigrationsDir(t)
+		sum3 := writeMigration(t, dir, "0003_add_proc.up.sql",
+			"DROP PROCEDURE IF EXISTS touch_users;\nCREATE PROCEDURE touch_users()\nBEGIN\n  UPDATE users SET name = 'x';\n  SELECT 1;\nEND;\n")
+
+		// 设置模拟预期
+		expectMigrationLock(mock, 1)
+		mock.ExpectQuery("FROM schema_migrations").WillReturnRows(sqlmock.NewRows([]string{"version", "name", "checksum", "applied_at"}).
+			AddRow(1, "create_users", sum1, "2024-01-01 00:00:00").
+			AddRow(2, "add_name", sum2, "2024-01-01 00:00:00"))
+		mock.ExpectExec("DROP PROCEDURE IF EXISTS touch_users").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("(?s)CREATE PROCEDURE touch_users.*UPDATE users.*SELECT 1;\\s+END$").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO schema_migrations").WithArgs(int64(3), "add_proc", sum3).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("SELECT RELEASE_LOCK").WillReturnRows(sqlmock.NewRows([]string{"RELEASE_LOCK"}).AddRow(1))
+
+		// 调用 HandleApplyMigrations
+		result, err := HandleApplyMigrations(0)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, "已应用 1 个迁移: 3_add_proc", result)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("lock held by another process", func(t *testing.T) {
+		setupMigrationsDir(t)
+
+		// 设置模拟预期
+		expectMigrationLock(mock, 0)
+
+		// 调用 HandleApplyMigrations
+		_, err := HandleApplyMigrations(0)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "未能获取迁移锁")
+	})
+
+	t.Run("checksum mismatch", func(t *testing.T) {
+		setupMigrationsDir(t)
+
+		// 设置模拟预期
+		expectMigrationLock(mock, 1)
+		mock.ExpectQuery("FROM schema_migrations").WillReturnRows(sqlmock.NewRows([]string{"version", "name", "checksum", "applied_at"}).
+			AddRow(1, "create_users", "0000", "2024-01-01 00:00:00"))
+		mock.ExpectQuery("SELECT RELEASE_LOCK").WillReturnRows(sqlmock.NewRows([]string{"RELEASE_LOCK"}).AddRow(1))
+
+		// 调用 HandleApplyMigrations
+		_, err := HandleApplyMigrations(0)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "校验和不一致")
+	})
+
+	t.Run("statement failure reports progress", func(t *testing.T) {
+		_, sum1, _ := setupMigrationsDir(t)
+
+		// 设置模拟预期
+		expectMigrationLock(mock, 1)
+		mock.ExpectQuery("FROM schema_migrations").WillReturnRows(sqlmock.NewRows([]string{"version", "name", "checksum", "applied_at"}))
+		mock.ExpectExec("CREATE TABLE users").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO users").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO schema_migrations").WithArgs(int64(1), "create_users", sum1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("ALTER TABLE users").WillReturnError(assert.AnError)
+		mock.ExpectQuery("SELECT RELEASE_LOCK").WillReturnRows(sqlmock.NewRows([]string{"RELEASE_LOCK"}).AddRow(1))
+
+		// 调用 HandleApplyMigrations
+		_, err := HandleApplyMigrations(0)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "0002_add_name.up.sql 第 1 条语句执行失败")
+		assert.Contains(t, err.Error(), "已成功应用: 1_create_users")
+	})
+}
+
+func TestHandleRollbackMigration(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+	_, sum1, sum2 := setupMigrationsDir(t)
+
+	// 设置模拟预期
+	expectMigrationLock(mock, 1)
+	mock.ExpectQuery("FROM schema_migrations").WillReturnRows(sqlmock.NewRows([]string{"version", "name", "checksum", "applied_at"}).
+		AddRow(1, "create_users", sum1, "2024-01-01 00:00:00").
+		AddRow(2, "add_name", sum2, "2024-01-02 00:00:00"))
+	mock.ExpectExec("ALTER TABLE users DROP COLUMN name").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM schema_migrations").WithArgs(int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT RELEASE_LOCK").WillReturnRows(sqlmock.NewRows([]string{"RELEASE_LOCK"}).AddRow(1))
+
+	// 调用 HandleRollbackMigration
+	result, err := HandleRollbackMigration(1)
+
+	// 验证结果
+	assert.NoError(t, err)
+	assert.Equal(t, "已回滚 1 个迁移: 2_add_name", result)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,583 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	DDLAlgorithmInstant = "INSTANT"
+	DDLAlgorithmInplace = "INPLACE"
+	DDLAlgorithmCopy    = "COPY"
+	DDLAlgorithmUnknown = ""
+
+	DDLLockNone   = "NONE"
+	DDLLockShared = "SHARED"
+)
+
+var AlterMaxCopyRows int64
+
+// ServerVersion 为解析后的 MySQL 版本号，MariaDB 的在线 DDL 行为与 MySQL 不同，单独标记
+type ServerVersion struct {
+	Major, Minor, Patch int
+	MariaDB             bool
+}
+
+func ParseServerVersion(version string) ServerVersion {
+	v := ServerVersion{MariaDB: strings.Contains(strings.ToLower(version), "mariadb")}
+	parts := strings.SplitN(strings.SplitN(version, "-", 2)[0], ".", 3)
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		n, _ := strconv.Atoi(part)
+		*nums[i] = n
+	}
+
+	return v
+}
+
+// AtLeast 判断是否为不低于给定版本的 MySQL
+func (v ServerVersion) AtLeast(major, minor, patch int) bool {
+	if v.MariaDB {
+		return false
+	}
+	if v.Major != major {
+		return v.Major > major
+	}
+	if v.Minor != minor {
+		return v.Minor > minor
+	}
+
+	return v.Patch >= patch
+}
+
+type AlterColumnInfo struct {
+	Name        string `db:"COLUMN_NAME"`
+	Type        string `db:"COLUMN_TYPE"`
+	MaxLength   int64  `db:"CHARACTER_MAXIMUM_LENGTH"`
+	OctetLength int64  `db:"CHARACTER_OCTET_LENGTH"`
+	Charset     string `db:"CHARACTER_SET_NAME"`
+	Collation   string `db:"COLLATION_NAME"`
+}
+
+// AlterStatement 为解析后的 ALTER TABLE 语句
+type AlterStatement struct {
+	Table TableRef
+	Specs [][]SQLToken
+	// UserAlgorithm 表示语句中已经指定了 ALGORITHM 或 LOCK
+	UserAlgorithm bool
+}
+
+// ParseAlterStatement 解析 ALTER TABLE 语句，不是 ALTER TABLE 时返回 false
+func ParseAlterStatement(query string) (*AlterStatement, bool) {
+	tokens := TokenizeSQL(query)
+	for len(tokens) > 0 && tokens[len(tokens)-1].Is(TokenPunct, ";") {
+		tokens = tokens[:len(tokens)-1]
+	}
+
+	i := 0
+	if i >= len(tokens) || !tokens[i].IsKeyword("ALTER") {
+		return nil, false
+	}
+	i++
+	for i < len(tokens) && tokens[i].IsKeyword("ONLINE", "IGNORE") {
+		i++
+	}
+	if i >= len(tokens) || !tokens[i].IsKeyword("TABLE") {
+		return nil, false
+	}
+	parts, next := readQualifiedName(tokens, i+1)
+	if parts == nil {
+		return nil, false
+	}
+
+	stmt := &AlterStatement{Table: TableRef{Name: parts[len(parts)-1]}}
+	if len(parts) > 1 {
+		stmt.Table.Schema = parts[0]
+	}
+
+	depth := 0
+	start := next
+	for j := next; j <= len(tokens); j++ {
+		if j < len(tokens) {
+			switch {
+			case tokens[j].Is(TokenPunct, "("):
+				depth++
+				continue
+			case tokens[j].Is(TokenPunct, ")"):
+				depth--
+				continue
+			case !tokens[j].Is(TokenPunct, ",") || depth > 0:
+				continue
+			}
+		}
+		if j > start {
+			spec := tokens[start:j]
+			if spec[0].IsKeyword("ALGORITHM", "LOCK") {
+				stmt.UserAlgorithm = true
+			} else {
+				stmt.Specs = append(stmt.Specs, spec)
+			}
+		}
+		start = j + 1
+	}
+
+	return stmt, true
+}
+
+// DDLPlan 为 ALTER 语句预计的在线 DDL 执行方式
+type DDLPlan struct {
+	Algorithm string
+	Lock      string
+	Reasons   []string
+	TableRows int64
+	TableSize int64
+}
+
+// Clause 返回在安全的情况下需要追加到语句末尾的 ALGORITHM/LOCK 子句
+func (p DDLPlan) Clause() string {
+	switch p.Algorithm {
+	case DDLAlgorithmInstant:
+		return "ALGORITHM=INSTANT"
+	case DDLAlgorithmInplace:
+		if p.Lock == DDLLockNone {
+			return "ALGORITHM=INPLACE, LOCK=NONE"
+		}
+		return "ALGORITHM=INPLACE, LOCK=" + p.Lock
+	}
+
+	return ""
+}
+
+func (p DDLPlan) Summary() string {
+	algorithm := p.Algorithm
+	if algorithm == DDLAlgorithmUnknown {
+		algorithm = "无法判断"
+	}
+
+	return fmt.Sprintf("在线 DDL: %s（表约 %d 行，%.1f MB；%s）", algorithm, p.TableRows, float64(p.TableSize)/1024/1024, strings.Join(p.Reasons, "；"))
+}
+
+var ddlAlgorithmRank = map[string]int{DDLAlgorithmInstant: 0, DDLAlgorithmInplace: 1, DDLAlgorithmCopy: 2, DDLAlgorithmUnknown: 3}
+
+// PlanAlterStatement 按照 MySQL 在线 DDL 支持矩阵判断每个修改项可用的算法，整体取最保守的一项
+func PlanAlterStatement(stmt *AlterStatement, version ServerVersion, lookupColumn func(name string) (*AlterColumnInfo, error)) (DDLPlan, error) {
+	plan := DDLPlan{Algorithm: DDLAlgorithmInstant, Lock: DDLLockNone}
+
+	for _, spec := range stmt.Specs {
+		algorithm, lock, reason, err := classifyAlterSpec(spec, version, lookupColumn)
+		if err != nil {
+			return plan, err
+		}
+
+		plan.Reasons = append(plan.Reasons, fmt.Sprintf("%s: %s", specText(spec), reason))
+		if ddlAlgorithmRank[algorithm] > ddlAlgorithmRank[plan.Algorithm] {
+			plan.Algorithm = algorithm
+		}
+		if lock == DDLLockShared {
+			plan.Lock = DDLLockShared
+		}
+	}
+
+	if plan.Algorithm == DDLAlgorithmCopy {
+		plan.Lock = DDLLockShared
+	}
+
+	return plan, nil
+}
+
+func specText(spec []SQLToken) string {
+	words := []string{}
+	for _, tok := range spec {
+		if len(words) == 3 || tok.Is(TokenPunct, "(") {
+			break
+		}
+		words = append(words, tok.Text)
+	}
+
+	return strings.Join(words, " ")
+}
+
+func classifyAlterSpec(spec []SQLToken, version ServerVersion, lookupColumn func(name string) (*AlterColumnInfo, error)) (string, string, string, error) {
+	mysql8 := version.AtLeast(8, 0, 0)
+	instantOr := func(minor, patch int) string {
+		if version.AtLeast(8, minor, patch) {
+			return DDLAlgorithmInstant
+		}
+		return DDLAlgorithmInplace
+	}
+
+	word := func(i int) SQLToken {
+		if i < len(spec) {
+			return spec[i]
+		}
+		return SQLToken{}
+	}
+
+	switch {
+	case word(0).IsKeyword("ADD"):
+		i := 1
+		switch {
+		case word(i).IsKeyword("INDEX", "KEY", "UNIQUE"):
+			return DDLAlgorithmInplace, DDLLockNone, "新增二级索引", nil
+		case word(i).IsKeyword("FULLTEXT", "SPATIAL"):
+			return DDLAlgorithmInplace, DDLLockShared, "新增全文或空间索引需要共享锁", nil
+		case word(i).IsKeyword("PRIMARY"):
+			return DDLAlgorithmInplace, DDLLockNone, "新增主键需要重建表", nil
+		case word(i).IsKeyword("FOREIGN"):
+			return DDLAlgorithmCopy, DDLLockShared, "启用外键检查时新增外键需要复制表", nil
+		case word(i).IsKeyword("CONSTRAINT"):
+			if containsKeyword(spec, "FOREIGN") {
+				return DDLAlgorithmCopy, DDLLockShared, "启用外键检查时新增外键需要复制表", nil
+			}
+			if containsKeyword(spec, "UNIQUE", "PRIMARY") {
+				return DDLAlgorithmInplace, DDLLockNone, "新增唯一约束", nil
+			}
+			return DDLAlgorithmCopy, DDLLockShared, "新增 CHECK 约束需要校验全部数据", nil
+		case word(i).IsKeyword("CHECK"):
+			return DDLAlgorithmCopy, DDLLockShared, "新增 CHECK 约束需要校验全部数据", nil
+		case word(i).IsKeyword("PARTITION"):
+			return DDLAlgorithmUnknown, "", "分区操作的算法取决于分区类型", nil
+		}
+
+		if containsKeyword(spec, "STORED") {
+			return DDLAlgorithmCopy, DDLLockShared, "新增 STORED 生成列需要复制表", nil
+		}
+		if containsKeyword(spec, "VIRTUAL") && !containsKeyword(spec, "STORED") && mysql8 {
+			return DDLAlgorithmInstant, DDLLockNone, "新增虚拟列", nil
+		}
+		if containsKeyword(spec, "AUTO_INCREMENT") {
+			return DDLAlgorithmInplace, DDLLockShared, "新增自增列需要重建表并持有共享锁", nil
+		}
+		if containsKeyword(spec, "FIRST", "AFTER") {
+			return instantOr(0, 29), DDLLockNone, "在指定位置新增列", nil
+		}
+		return instantOr(0, 12), DDLLockNone, "在末尾新增列", nil
+
+	case word(0).IsKeyword("DROP"):
+		switch {
+		case word(1).IsKeyword("INDEX", "KEY"):
+			return DDLAlgorithmInplace, DDLLockNone, "删除二级索引", nil
+		case word(1).IsKeyword("PRIMARY"):
+			return DDLAlgorithmCopy, DDLLockShared, "只删除主键而不新增主键需要复制表", nil
+		case word(1).IsKeyword("FOREIGN", "CHECK", "CONSTRAINT"):
+			return DDLAlgorithmInplace, DDLLockNone, "删除约束", nil
+		case word(1).IsKeyword("PARTITION"):
+			return DDLAlgorithmUnknown, "", "分区操作的算法取决于分区类型", nil
+		}
+		return instantOr(0, 29), DDLLockNone, "删除列", nil
+
+	case word(0).IsKeyword("RENAME"):
+		switch {
+		case word(1).IsKeyword("COLUMN"):
+			return instantOr(0, 28), DDLLockNone, "重命名列", nil
+		case word(1).IsKeyword("INDEX", "KEY"):
+			return DDLAlgorithmInplace, DDLLockNone, "重命名索引", nil
+		}
+		return instantOr(0, 0), DDLLockNone, "重命名表", nil
+
+	case word(0).IsKeyword("ALTER"):
+		if word(1).IsKeyword("INDEX") {
+			return instantOr(0, 0), DDLLockNone, "修改索引可见性", nil
+		}
+		if word(1).IsKeyword("CHECK", "CONSTRAINT") {
+			return DDLAlgorithmInplace, DDLLockNone, "修改约束", nil
+		}
+		return instantOr(0, 0), DDLLockNone, "修改列默认值或可见性", nil
+
+	case word(0).IsKeyword("MODIFY", "CHANGE"):
+		i := 1
+		if word(i).IsKeyword("COLUMN") {
+			i++
+		}
+		name := word(i).Value
+		defStart := i + 1
+		if word(0).IsKeyword("CHANGE") {
+			defStart++
+		}
+		current, err := lookupColumn(name)
+		if err != nil {
+			return "", "", "", err
+		}
+		if current == nil {
+			return DDLAlgorithmUnknown, "", fmt.Sprintf("未找到列 %s", name), nil
+		}
+		if defStart > len(spec) {
+			return DDLAlgorithmUnknown, "", "无法解析列定义", nil
+		}
+		if reason := columnCharsetChange(current, spec[defStart:]); reason != "" {
+			return DDLAlgorithmCopy, DDLLockShared, reason, nil
+		}
+		algorithm, reason := classifyColumnTypeChange(current, columnTypeText(spec[defStart:]))
+		if algorithm == DDLAlgorithmInplace && word(0).IsKeyword("CHANGE") && !strings.EqualFold(name, word(i+1).Value) {
+			reason += "并重命名列"
+		}
+		return algorithm, DDLLockNone, reason, nil
+
+	case word(0).IsKeyword("CONVERT"):
+		return DDLAlgorithmCopy, DDLLockShared, "转换字符集需要复制表", nil
+
+	case word(0).IsKeyword("ORDER"):
+		return DDLAlgorithmCopy, DDLLockShared, "ORDER BY 需要复制表", nil
+
+	case word(0).IsKeyword("ENGINE", "ROW_FORMAT", "KEY_BLOCK_SIZE", "FORCE"):
+		return DDLAlgorithmInplace, DDLLockNone, "重建表", nil
+
+	case word(0).IsKeyword("AUTO_INCREMENT", "COMMENT", "STATS_PERSISTENT", "STATS_AUTO_RECALC", "STATS_SAMPLE_PAGES"):
+		return DDLAlgorithmInplace, DDLLockNone, "只修改表属性", nil
+
+	case word(0).IsKeyword("DEFAULT", "CHARACTER", "CHARSET", "COLLATE"):
+		return DDLAlgorithmInplace, DDLLockNone, "只修改默认字符集", nil
+	}
+
+	return DDLAlgorithmUnknown, "", "不支持分析的修改项", nil
+}
+
+func containsKeyword(tokens []SQLToken, keywords ...string) bool {
+	for _, tok := range tokens {
+		if tok.IsKeyword(keywords...) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// columnTypeText 返回列定义中的类型部分，例如 `varchar(255)`、`enum('a','b')` 或 `int unsigned`
+func columnTypeText(def []SQLToken) string {
+	parts := []string{}
+	depth := 0
+	for _, tok := range def {
+		switch {
+		case tok.Is(TokenPunct, "("):
+			depth++
+		case tok.Is(TokenPunct, ")"):
+			depth--
+		case depth == 0 && len(parts) > 0 && !tok.IsKeyword("UNSIGNED", "ZEROFILL", "PRECISION", "VARYING"):
+			return strings.Join(parts, "")
+		}
+		text := tok.Text
+		if tok.Kind == TokenWord {
+			text = strings.ToLower(text)
+		}
+		if tok.IsKeyword("UNSIGNED", "ZEROFILL", "PRECISION", "VARYING") {
+			text = " " + text
+		}
+		parts = append(parts, text)
+	}
+
+	return strings.Join(parts, "")
+}
+
+// columnCharsetChange 判断列定义中的 CHARACTER SET 或 COLLATE 是否修改了列当前的字符集或排序规则，
+// 修改时返回原因。改变字符集或排序规则需要按新规则转换数据，只能复制表
+func columnCharsetChange(current *AlterColumnInfo, def []SQLToken) string {
+	depth := 0
+	for i := 0; i < len(def); i++ {
+		switch {
+		case def[i].Is(TokenPunct, "("):
+			depth++
+			continue
+		case def[i].Is(TokenPunct, ")"):
+			depth--
+			continue
+		case depth > 0:
+			continue
+		}
+
+		next := i + 1
+		if def[i].IsKeyword("CHARACTER") && next < len(def) && def[next].IsKeyword("SET") {
+			next++
+		} else if !def[i].IsKeyword("CHARSET", "COLLATE") {
+			continue
+		}
+		if next < len(def) && def[next].Is(TokenOperator, "=") {
+			next++
+		}
+		if next >= len(def) {
+			return ""
+		}
+
+		value := def[next].Value
+		if def[i].IsKeyword("COLLATE") {
+			if !strings.EqualFold(value, current.Collation) {
+				return fmt.Sprintf("排序规则由 %s 改为 %s 需要复制表", current.Collation, value)
+			}
+		} else if !strings.EqualFold(value, current.Charset) {
+			return fmt.Sprintf("字符集由 %s 改为 %s 需要复制表", current.Charset, value)
+		}
+		i = next
+	}
+
+	return ""
+}
+
+var (
+	intDisplayWidth = regexp.MustCompile(`^(tinyint|smallint|mediumint|int|integer|bigint)\(\d+\)`)
+	varcharType     = regexp.MustCompile(`^varchar\((\d+)\)$`)
+	enumType        = regexp.MustCompile(`^(enum|set)\((.*)\)$`)
+)
+
+func normalizeColumnType(t string) string {
+	t = strings.ToLower(strings.TrimSpace(t))
+	t = intDisplayWidth.ReplaceAllString(t, "$1")
+	t = strings.Replace(t, "integer", "int", 1)
+
+	return strings.Join(strings.Fields(t), " ")
+}
+
+func classifyColumnTypeChange(current *AlterColumnInfo, newType string) (string, string) {
+	from := normalizeColumnType(current.Type)
+	to := normalizeColumnType(newType)
+
+	if from == to {
+		return DDLAlgorithmInplace, "类型不变，只修改属性"
+	}
+
+	if m1, m2 := varcharType.FindStringSubmatch(from), varcharType.FindStringSubmatch(to); m1 != nil && m2 != nil {
+		oldLen, _ := strconv.ParseInt(m1[1], 10, 64)
+		newLen, _ := strconv.ParseInt(m2[1], 10, 64)
+		bytesPerChar := int64(1)
+		if current.MaxLength > 0 {
+			bytesPerChar = max(current.OctetLength/current.MaxLength, 1)
+		}
+		// VARCHAR 长度前缀为 1 字节（不超过 255 字节）或 2 字节，长度前缀不变时可以原地扩展
+		if newLen >= oldLen && (oldLen*bytesPerChar <= 255) == (newLen*bytesPerChar <= 255) {
+			return DDLAlgorithmInplace, "扩展 VARCHAR 长度且长度前缀不变"
+		}
+		return DDLAlgorithmCopy, "VARCHAR 长度变化需要复制表"
+	}
+
+	if m1, m2 := enumType.FindStringSubmatch(from), enumType.FindStringSubmatch(to); m1 != nil && m2 != nil && m1[1] == m2[1] {
+		if strings.HasPrefix(m2[2], m1[2]+",") {
+			return DDLAlgorithmInstant, "在 " + strings.ToUpper(m1[1]) + " 末尾追加成员"
+		}
+	}
+
+	return DDLAlgorithmCopy, fmt.Sprintf("列类型由 %s 改为 %s 需要复制表", from, to)
+}
+
+// PlanOnlineDDL 结合服务器版本、表大小和列信息生成 ALTER 语句的在线 DDL 执行计划
+func PlanOnlineDDL(stmt *AlterStatement) (DDLPlan, error) {
+	db, err := GetDB()
+	if err != nil {
+		return DDLPlan{}, err
+	}
+
+	var version string
+	if err := db.Get(&version, "SELECT VERSION()"); err != nil {
+		return DDLPlan{}, fmt.Errorf("读取服务器版本失败: %v", err)
+	}
+
+	var columns map[string]*AlterColumnInfo
+	lookupColumn := func(name string) (*AlterColumnInfo, error) {
+		if columns == nil {
+			rows := []AlterColumnInfo{}
+			if err := db.Select(&rows,
+				"SELECT COLUMN_NAME, COLUMN_TYPE, COALESCE(CHARACTER_MAXIMUM_LENGTH, 0) AS CHARACTER_MAXIMUM_LENGTH, COALESCE(CHARACTER_OCTET_LENGTH, 0) AS CHARACTER_OCTET_LENGTH, "+
+					"COALESCE(CHARACTER_SET_NAME, '') AS CHARACTER_SET_NAME, COALESCE(COLLATION_NAME, '') AS COLLATION_NAME "+
+					"FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = COALESCE(NULLIF(?, ''), DATABASE()) AND TABLE_NAME = ?",
+				stmt.Table.Schema, stmt.Table.Name); err != nil {
+				return nil, fmt.Errorf("读取表 %s 的列信息失败: %v", stmt.Table.Name, err)
+			}
+			columns = map[string]*AlterColumnInfo{}
+			for i := range rows {
+				columns[strings.ToLower(rows[i].Name)] = &rows[i]
+			}
+		}
+		return columns[strings.ToLower(name)], nil
+	}
+
+	plan, err := PlanAlterStatement(stmt, ParseServerVersion(version), lookupColumn)
+	if err != nil {
+		return plan, err
+	}
+
+	if err := db.QueryRowx(
+		"SELECT COALESCE(TABLE_ROWS, 0), COALESCE(DATA_LENGTH, 0) + COALESCE(INDEX_LENGTH, 0) FROM information_schema.TABLES "+
+			"WHERE TABLE_SCHEMA = COALESCE(NULLIF(?, ''), DATABASE()) AND TABLE_NAME = ?",
+		stmt.Table.Schema, stmt.Table.Name).Scan(&plan.TableRows, &plan.TableSize); err != nil {
+		return plan, fmt.Errorf("读取表 %s 的大小失败: %v", stmt.Table.Name, err)
+	}
+
+	return plan, nil
+}
+
+// HandleAlterTable 在执行 ALTER TABLE 前分析在线 DDL 方式：可以不锁表时追加 ALGORITHM/LOCK 子句，
+// 服务器拒绝追加的子句时按原语句重试一次；需要复制（或无法判断）的表超过 --alter-max-copy-rows 时
+// 除非 confirmCopy 为 true 否则拒绝执行
+func HandleAlterTable(query string, confirmCopy bool, progress *Progress) (string, error) {
+	if err := CheckQueryAccess(query); err != nil {
+		return "", err
+	}
+
+	stmt, ok := ParseAlterStatement(query)
+	if !ok {
+		return handleExec(query, StatementTypeNoExplainCheck, progress)
+	}
+
+	plan, err := PlanOnlineDDL(stmt)
+	if err != nil {
+		return "", err
+	}
+
+	// 无法判断算法时按最坏情况（复制整张表）处理
+	if (plan.Algorithm == DDLAlgorithmCopy || plan.Algorithm == DDLAlgorithmUnknown) && AlterMaxCopyRows > 0 && plan.TableRows > AlterMaxCopyRows && !confirmCopy {
+		need := "需要复制整张表"
+		if plan.Algorithm == DDLAlgorithmUnknown {
+			need = "无法判断执行方式，可能需要复制整张表"
+		}
+		return "", fmt.Errorf("该修改%s %s（约 %d 行，超过上限 %d），执行期间会阻塞写入，拒绝执行。%s。确认需要执行时请设置 confirm_copy=true 重新调用",
+			need, stmt.Table.Name, plan.TableRows, AlterMaxCopyRows, plan.Summary())
+	}
+
+	if stmt.UserAlgorithm || len(stmt.Specs) == 0 || plan.Clause() == "" {
+		result, err := handleExec(query, StatementTypeNoExplainCheck, progress)
+		if err != nil {
+			return "", fmt.Errorf("%v（%s）", err, plan.Summary())
+		}
+		return result + "\n" + plan.Summary(), nil
+	}
+
+	result, err := handleExec(strings.TrimRight(strings.TrimSpace(query), ";")+", "+plan.Clause(), StatementTypeNoExplainCheck, progress)
+	if err != nil && alterClauseRejected(err) {
+		// 分析结果与服务器实际支持的方式不一致时，服务器可能只能复制整张表，按 COPY 重新检查表的大小
+		if AlterMaxCopyRows > 0 && plan.TableRows > AlterMaxCopyRows && !confirmCopy {
+			return "", fmt.Errorf("服务器不支持 %s（%v），按原语句执行可能需要复制整张表 %s（约 %d 行，超过上限 %d），执行期间会阻塞写入，拒绝执行。%s。确认需要执行时请设置 confirm_copy=true 重新调用",
+				plan.Clause(), err, stmt.Table.Name, plan.TableRows, AlterMaxCopyRows, plan.Summary())
+		}
+		// 去掉追加的子句按原语句重试一次
+		result, err = handleExec(query, StatementTypeNoExplainCheck, progress)
+		if err == nil {
+			return result + "\n" + plan.Summary() + "\n服务器不支持 " + plan.Clause() + "，已按原语句执行", nil
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("%v（%s）", err, plan.Summary())
+	}
+
+	return result + "\n" + plan.Summary(), nil
+}
+
+// alterClauseRejected 判断错误是否为服务器不支持追加的 ALGORITHM/LOCK 子句
+func alterClauseRejected(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return false
+	}
+
+	switch mysqlErr.Number {
+	case 1064, // ER_PARSE_ERROR：旧版本不认识 INSTANT
+		1800, // ER_UNKNOWN_ALTER_ALGORITHM
+		1801, // ER_UNKNOWN_ALTER_LOCK
+		1845, // ER_ALTER_OPERATION_NOT_SUPPORTED
+		1846: // ER_ALTER_OPERATION_NOT_SUPPORTED_REASON
+		return true
+	}
+
+	return false
+}
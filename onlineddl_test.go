@@ -0,0 +1,244 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseServerVersion(t *testing.T) {
+	v := ParseServerVersion("8.0.36-0ubuntu0.22.04.1")
+	assert.Equal(t, ServerVersion{Major: 8, Minor: 0, Patch: 36}, v)
+	assert.True(t, v.AtLeast(8, 0, 29))
+	assert.False(t, v.AtLeast(8, 1, 0))
+
+	m := ParseServerVersion("10.11.2-MariaDB")
+	assert.True(t, m.MariaDB)
+	assert.False(t, m.AtLeast(5, 0, 0))
+}
+
+func TestParseAlterStatement(t *testing.T) {
+	t.Run("multiple specs", func(t *testing.T) {
+		stmt, ok := ParseAlterStatement("ALTER TABLE shop.orders ADD COLUMN note VARCHAR(20) DEFAULT 'a,b', ADD INDEX idx_a (a, b);")
+
+		assert.True(t, ok)
+		assert.Equal(t, TableRef{Schema: "shop", Name: "orders"}, stmt.Table)
+		assert.Len(t, stmt.Specs, 2)
+		assert.False(t, stmt.UserAlgorithm)
+	})
+
+	t.Run("user specified algorithm", func(t *testing.T) {
+		stmt, ok := ParseAlterStatement("ALTER TABLE t ADD INDEX (a), ALGORITHM=INPLACE, LOCK=NONE")
+
+		assert.True(t, ok)
+		assert.Len(t, stmt.Specs, 1)
+		assert.True(t, stmt.UserAlgorithm)
+	})
+
+	t.Run("not an alter table", func(t *testing.T) {
+		_, ok := ParseAlterStatement("ALTER USER 'a'@'%' IDENTIFIED BY 'x'")
+
+		assert.False(t, ok)
+	})
+}
+
+func TestPlanAlterStatement(t *testing.T) {
+	mysql8 := ParseServerVersion("8.0.35")
+	mysql57 := ParseServerVersion("5.7.44")
+	columns := map[string]*AlterColumnInfo{
+		"name":   {Name: "name", Type: "varchar(50)", MaxLength: 50, OctetLength: 200, Charset: "utf8mb4", Collation: "utf8mb4_0900_ai_ci"},
+		"code":   {Name: "code", Type: "varchar(20)", MaxLength: 20, OctetLength: 20},
+		"status": {Name: "status", Type: "enum('a','b')"},
+		"amount": {Name: "amount", Type: "int"},
+	}
+	lookup := func(name string) (*AlterColumnInfo, error) {
+		return columns[name], nil
+	}
+
+	cases := []struct {
+		name      string
+		query     string
+		version   ServerVersion
+		algorithm string
+		lock      string
+	}{
+		{"add column at end", "ALTER TABLE t ADD COLUMN c INT", mysql8, DDLAlgorithmInstant, DDLLockNone},
+		{"add column on 5.7", "ALTER TABLE t ADD COLUMN c INT", mysql57, DDLAlgorithmInplace, DDLLockNone},
+		{"add index", "ALTER TABLE t ADD INDEX idx_c (c)", mysql8, DDLAlgorithmInplace, DDLLockNone},
+		{"add column and index", "ALTER TABLE t ADD COLUMN c INT, ADD INDEX idx_c (c)", mysql8, DDLAlgorithmInplace, DDLLockNone},
+		{"fulltext index", "ALTER TABLE t ADD FULLTEXT INDEX ft (body)", mysql8, DDLAlgorithmInplace, DDLLockShared},
+		{"foreign key", "ALTER TABLE t ADD CONSTRAINT fk FOREIGN KEY (u) REFERENCES users (id)", mysql8, DDLAlgorithmCopy, DDLLockShared},
+		{"extend varchar within prefix", "ALTER TABLE t MODIFY COLUMN name VARCHAR(60) NOT NULL", mysql8, DDLAlgorithmInplace, DDLLockNone},
+		{"extend varchar across prefix", "ALTER TABLE t MODIFY name VARCHAR(70)", mysql8, DDLAlgorithmCopy, DDLLockShared},
+		{"extend single byte varchar", "ALTER TABLE t MODIFY code VARCHAR(255)", mysql8, DDLAlgorithmInplace, DDLLockNone},
+		{"append enum member", "ALTER TABLE t MODIFY status ENUM('a', 'b', 'c')", mysql8, DDLAlgorithmInstant, DDLLockNone},
+		{"change type", "ALTER TABLE t CHANGE amount amount BIGINT", mysql8, DDLAlgorithmCopy, DDLLockShared},
+		{"same charset", "ALTER TABLE t MODIFY name VARCHAR(60) CHARACTER SET utf8mb4 COLLATE utf8mb4_0900_ai_ci", mysql8, DDLAlgorithmInplace, DDLLockNone},
+		{"change charset", "ALTER TABLE t MODIFY name VARCHAR(50) CHARACTER SET latin1", mysql8, DDLAlgorithmCopy, DDLLockShared},
+		{"change collation", "ALTER TABLE t CHANGE name name VARCHAR(50) COLLATE utf8mb4_bin NOT NULL", mysql8, DDLAlgorithmCopy, DDLLockShared},
+		{"same type with display width", "ALTER TABLE t MODIFY amount INT(11) NOT NULL COMMENT 'x'", mysql8, DDLAlgorithmInplace, DDLLockNone},
+		{"convert charset", "ALTER TABLE t CONVERT TO CHARACTER SET utf8mb4", mysql8, DDLAlgorithmCopy, DDLLockShared},
+		{"partition", "ALTER TABLE t DROP PARTITION p0", mysql8, DDLAlgorithmUnknown, DDLLockNone},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			stmt, ok := ParseAlterStatement(c.query)
+			assert.True(t, ok)
+
+			plan, err := PlanAlterStatement(stmt, c.version, lookup)
+
+			assert.NoError(t, err)
+			assert.Equal(t, c.algorithm, plan.Algorithm)
+			if c.algorithm != DDLAlgorithmUnknown {
+				assert.Equal(t, c.lock, plan.Lock)
+			}
+		})
+	}
+}
+
+func TestHandleAlterTable(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	originalMax := AlterMaxCopyRows
+	AlterMaxCopyRows = 1000
+	defer func() { AlterMaxCopyRows = originalMax }()
+
+	expectTableSize := func(rows int64) {
+		mock.ExpectQuery("FROM information_schema.TABLES").WithArgs("", "orders").
+			WillReturnRows(sqlmock.NewRows([]string{"rows", "size"}).AddRow(rows, 1048576))
+	}
+
+	t.Run("appends online clauses", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("SELECT VERSION").WillReturnRows(sqlmock.NewRows([]string{"VERSION()"}).AddRow("8.0.35"))
+		expectTableSize(50000)
+		mock.ExpectExec("ALTER TABLE orders ADD INDEX idx_user \\(user_id\\), ALGORITHM=INPLACE, LOCK=NONE").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		// 调用 HandleAlterTable
+		result, err := HandleAlterTable("ALTER TABLE orders ADD INDEX idx_user (user_id);", false, nil)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "0 rows affected")
+		assert.Contains(t, result, "在线 DDL: INPLACE")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("refuses large table copy", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("SELECT VERSION").WillReturnRows(sqlmock.NewRows([]string{"VERSION()"}).AddRow("8.0.35"))
+		mock.ExpectQuery("FROM information_schema.COLUMNS").WithArgs("", "orders").
+			WillReturnRows(sqlmock.NewRows([]string{"COLUMN_NAME", "COLUMN_TYPE", "CHARACTER_MAXIMUM_LENGTH", "CHARACTER_OCTET_LENGTH"}).
+				AddRow("amount", "int", 0, 0))
+		expectTableSize(50000)
+
+		// 调用 HandleAlterTable
+		_, err := HandleAlterTable("ALTER TABLE orders MODIFY amount BIGINT", false, nil)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "需要复制整张表 orders")
+		assert.Contains(t, err.Error(), "confirm_copy=true")
+	})
+
+	t.Run("confirmed copy runs unchanged", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("SELECT VERSION").WillReturnRows(sqlmock.NewRows([]string{"VERSION()"}).AddRow("8.0.35"))
+		mock.ExpectQuery("FROM information_schema.COLUMNS").
+			WillReturnRows(sqlmock.NewRows([]string{"COLUMN_NAME", "COLUMN_TYPE", "CHARACTER_MAXIMUM_LENGTH", "CHARACTER_OCTET_LENGTH"}).
+				AddRow("amount", "int", 0, 0))
+		expectTableSize(50000)
+		mock.ExpectExec("^ALTER TABLE orders MODIFY amount BIGINT$").WillReturnResult(sqlmock.NewResult(0, 50000))
+
+		// 调用 HandleAlterTable
+		result, err := HandleAlterTable("ALTER TABLE orders MODIFY amount BIGINT", true, nil)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "在线 DDL: COPY")
+	})
+
+	t.Run("retries without rejected clause", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("SELECT VERSION").WillReturnRows(sqlmock.NewRows([]string{"VERSION()"}).AddRow("8.0.35"))
+		expectTableSize(500)
+		mock.ExpectExec("ALGORITHM=INPLACE, LOCK=NONE").
+			WillReturnError(&mysql.MySQLError{Number: 1846, Message: "LOCK=NONE is not supported. Reason: Fulltext index creation requires a lock. Try LOCK=SHARED."})
+		mock.ExpectExec("^ALTER TABLE orders ADD INDEX idx_user \\(user_id\\);$").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		// 调用 HandleAlterTable
+		result, err := HandleAlterTable("ALTER TABLE orders ADD INDEX idx_user (user_id);", false, nil)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "服务器不支持 ALGORITHM=INPLACE, LOCK=NONE，已按原语句执行")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("rejected clause on large table", func(t *testing.T) {
+		// 设置模拟预期：服务器拒绝追加的子句时原语句可能复制整张表，按 COPY 检查表的大小
+		mock.ExpectQuery("SELECT VERSION").WillReturnRows(sqlmock.NewRows([]string{"VERSION()"}).AddRow("8.0.35"))
+		expectTableSize(50000)
+		mock.ExpectExec("ALGORITHM=INPLACE, LOCK=NONE").
+			WillReturnError(&mysql.MySQLError{Number: 1846, Message: "ALGORITHM=INPLACE is not supported. Reason: Cannot change column type INPLACE. Try ALGORITHM=COPY."})
+
+		// 调用 HandleAlterTable
+		_, err := HandleAlterTable("ALTER TABLE orders ADD INDEX idx_user (user_id)", false, nil)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "可能需要复制整张表 orders")
+		assert.Contains(t, err.Error(), "confirm_copy=true")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("user algorithm is not retried", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("SELECT VERSION").WillReturnRows(sqlmock.NewRows([]string{"VERSION()"}).AddRow("8.0.35"))
+		expectTableSize(50000)
+		mock.ExpectExec("ALGORITHM=INSTANT").
+			WillReturnError(&mysql.MySQLError{Number: 1845, Message: "ALGORITHM=INSTANT is not supported for this operation. Try ALGORITHM=COPY/INPLACE."})
+
+		// 调用 HandleAlterTable
+		_, err := HandleAlterTable("ALTER TABLE orders ADD INDEX idx_user (user_id), ALGORITHM=INSTANT", false, nil)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "ALGORITHM=INSTANT is not supported")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("refuses large table with unknown algorithm", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("SELECT VERSION").WillReturnRows(sqlmock.NewRows([]string{"VERSION()"}).AddRow("8.0.35"))
+		mock.ExpectQuery("FROM information_schema.COLUMNS").WithArgs("", "orders").
+			WillReturnRows(sqlmock.NewRows([]string{"COLUMN_NAME", "COLUMN_TYPE", "CHARACTER_MAXIMUM_LENGTH", "CHARACTER_OCTET_LENGTH"}).
+				AddRow("amount", "int", 0, 0))
+		expectTableSize(50000)
+
+		// 调用 HandleAlterTable
+		_, err := HandleAlterTable("ALTER TABLE orders MODIFY total BIGINT", false, nil)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "无法判断执行方式，可能需要复制整张表 orders")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("non alter statement", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectExec("RENAME TABLE").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		// 调用 HandleAlterTable
+		result, err := HandleAlterTable("RENAME TABLE a TO b", false, nil)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, "0 rows affected", result)
+	})
+}
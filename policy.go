@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	PolicyAllow = "allow"
+	PolicyDeny  = "deny"
+)
+
+// StatementPolicyFile 是语句策略文件的路径，Statements 为加载后的策略，未配置时为 nil
+var (
+	StatementPolicyFile string
+	Statements          *StatementPolicy
+)
+
+// StatementPolicy 按语句类型和 SQL 特性决定是否允许执行，与调用的工具无关。
+// Allow 和 Deny 中的条目支持 `*` 和 `?` 通配符，不区分大小写，可以是：
+//   - 语句类型，例如 `SELECT`、`LOAD DATA`、`SET GLOBAL`、`CREATE USER`、`CALL`
+//   - 函数，以 `()` 结尾，例如 `SLEEP()`、`GET_LOCK()`
+//   - `@` 表示用户变量，`@@` 表示系统变量
+//   - `INTO OUTFILE` 和 `INTO DUMPFILE`
+//
+// Deny 优先。Default 为 deny 时只有 Allow 中列出的语句类型可以执行，函数、变量等特性只受 Deny 限制
+type StatementPolicy struct {
+	Default string   `json:"default"`
+	Allow   []string `json:"allow"`
+	Deny    []string `json:"deny"`
+
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+}
+
+// LoadStatementPolicy 读取 JSON 格式的语句策略文件
+func LoadStatementPolicy(path string) (*StatementPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取语句策略失败: %v", err)
+	}
+
+	return ParseStatementPolicy(data)
+}
+
+// ParseStatementPolicy 解析并校验语句策略
+func ParseStatementPolicy(data []byte) (*StatementPolicy, error) {
+	policy := &StatementPolicy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("解析语句策略失败: %v", err)
+	}
+
+	switch policy.Default {
+	case "":
+		policy.Default = PolicyAllow
+	case PolicyAllow, PolicyDeny:
+	default:
+		return nil, fmt.Errorf("语句策略的 default 无效: %q（可选 allow、deny）", policy.Default)
+	}
+
+	for _, pattern := range policy.Allow {
+		policy.allow = append(policy.allow, globPattern(normalizePolicyEntry(pattern)))
+	}
+	for _, pattern := range policy.Deny {
+		policy.deny = append(policy.deny, globPattern(normalizePolicyEntry(pattern)))
+	}
+
+	return policy, nil
+}
+
+// normalizePolicyEntry 合并多余的空白，使 `LOAD  DATA` 与 `LOAD DATA` 等价
+func normalizePolicyEntry(entry string) string {
+	return strings.Join(strings.Fields(entry), " ")
+}
+
+func matchesAny(patterns []*regexp.Regexp, feature string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(feature) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check 检查 query 中的每条语句，返回第一个被策略拒绝的语句类型或特性
+func (p *StatementPolicy) Check(query string) error {
+	for _, stmt := range SplitStatements(query) {
+		tokens := TokenizeSQL(stmt)
+		if len(tokens) == 0 {
+			continue
+		}
+
+		kind := StatementKind(tokens)
+		if matchesAny(p.deny, kind) {
+			return fmt.Errorf("语句策略禁止执行 %s 语句", kind)
+		}
+		if p.Default == PolicyDeny && !matchesAny(p.allow, kind) {
+			return fmt.Errorf("语句策略未允许执行 %s 语句", kind)
+		}
+
+		for _, feature := range StatementFeatures(tokens) {
+			if matchesAny(p.deny, feature) {
+				return fmt.Errorf("语句策略禁止使用 %s", feature)
+			}
+		}
+
+		// PREPARE 中的 SQL 之后通过 EXECUTE 执行，按同样的策略检查
+		if text, known, ok := PreparedSQL(tokens); ok {
+			if !known {
+				return fmt.Errorf("配置了语句策略时，PREPARE 只能使用字符串字面量作为语句")
+			}
+			if err := p.Check(text); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// CheckStatementPolicy 使用 --statement-policy 加载的策略检查 query，未配置策略时总是允许
+func CheckStatementPolicy(query string) error {
+	if Statements == nil {
+		return nil
+	}
+
+	return Statements.Check(query)
+}
+
+// ddlObjects 为 CREATE/DROP/ALTER 之后表示对象类型的关键字
+var ddlObjects = map[string]bool{
+	"TABLE": true, "DATABASE": true, "SCHEMA": true, "INDEX": true, "VIEW": true, "USER": true,
+	"ROLE": true, "PROCEDURE": true, "FUNCTION": true, "TRIGGER": true, "EVENT": true,
+	"SERVER": true, "TABLESPACE": true, "INSTANCE": true, "RESOURCE": true, "LOGFILE": true,
+}
+
+// StatementKind 返回语句类型，例如 SELECT、CREATE TABLE、SET GLOBAL、LOAD DATA
+func StatementKind(tokens []SQLToken) string {
+	i := 0
+	for i < len(tokens) && tokens[i].Is(TokenPunct, "(") {
+		i++
+	}
+	if i >= len(tokens) {
+		return ""
+	}
+
+	first := strings.ToUpper(tokens[i].Value)
+	next := ""
+	if i+1 < len(tokens) && tokens[i+1].Kind == TokenWord {
+		next = strings.ToUpper(tokens[i+1].Value)
+	}
+
+	switch first {
+	case "WITH":
+		// 公用表表达式定义在括号中，顶层第一个 DML 关键字才是实际执行的语句
+		depth := 0
+		for _, tok := range tokens[i+1:] {
+			switch {
+			case tok.Is(TokenPunct, "("):
+				depth++
+			case tok.Is(TokenPunct, ")"):
+				depth--
+			case depth == 0 && tok.IsKeyword("SELECT", "INSERT", "UPDATE", "DELETE", "REPLACE", "TABLE", "VALUES"):
+				return strings.ToUpper(tok.Value)
+			}
+		}
+		return first
+
+	case "CREATE", "DROP", "ALTER":
+		for j := i + 1; j < len(tokens); j++ {
+			tok := tokens[j]
+			if tok.IsKeyword("DEFINER") {
+				// 跳过 DEFINER = user@host 或 CURRENT_USER()，避免把账户名当作对象类型
+				if j+1 < len(tokens) && tokens[j+1].Is(TokenOperator, "=") {
+					j++
+				}
+				j++
+				if j+1 < len(tokens) && tokens[j+1].Kind == TokenVariable {
+					j++
+				}
+				if j+2 < len(tokens) && tokens[j+1].Is(TokenPunct, "(") && tokens[j+2].Is(TokenPunct, ")") {
+					j += 2
+				}
+				continue
+			}
+			if tok.Kind == TokenWord && ddlObjects[strings.ToUpper(tok.Value)] {
+				return first + " " + strings.ToUpper(tok.Value)
+			}
+		}
+		return first
+
+	case "SET":
+		for _, tok := range tokens[i+1:] {
+			if tok.IsKeyword("GLOBAL", "PERSIST", "PERSIST_ONLY") {
+				return "SET " + strings.ToUpper(tok.Value)
+			}
+			if tok.Kind == TokenVariable {
+				scope, _, found := strings.Cut(strings.ToUpper(strings.TrimPrefix(tok.Value, "@@")), ".")
+				if strings.HasPrefix(tok.Value, "@@") && found && (scope == "GLOBAL" || scope == "PERSIST" || scope == "PERSIST_ONLY") {
+					return "SET " + scope
+				}
+			}
+		}
+		switch next {
+		case "PASSWORD", "NAMES", "TRANSACTION", "ROLE", "DEFAULT", "CHARACTER", "CHARSET", "RESOURCE":
+			return "SET " + next
+		}
+		return first
+
+	case "LOAD", "START":
+		if next != "" {
+			return first + " " + next
+		}
+		return first
+
+	case "LOCK", "UNLOCK":
+		if next == "TABLE" {
+			next = "TABLES"
+		}
+		if next != "" {
+			return first + " " + next
+		}
+		return first
+	}
+
+	return first
+}
+
+// StatementFeatures 返回语句中用到的函数（`NAME()`）、用户变量（`@`）、系统变量（`@@`）
+// 以及 INTO OUTFILE/INTO DUMPFILE
+func StatementFeatures(tokens []SQLToken) []string {
+	features := []string{}
+	seen := map[string]bool{}
+	add := func(feature string) {
+		if !seen[feature] {
+			seen[feature] = true
+			features = append(features, feature)
+		}
+	}
+
+	for i, tok := range tokens {
+		switch {
+		case tok.Kind == TokenVariable:
+			// `user`@`host` 形式的账户名紧跟在用户名之后，不是变量
+			if i > 0 && tokens[i-1].End == tok.Start && tokens[i-1].Kind != TokenOperator && tokens[i-1].Kind != TokenPunct {
+				continue
+			}
+			if strings.HasPrefix(tok.Value, "@@") {
+				add("@@")
+			} else {
+				add("@")
+			}
+
+		case tok.IsKeyword("INTO") && i+1 < len(tokens) && tokens[i+1].IsKeyword("OUTFILE", "DUMPFILE"):
+			add("INTO " + strings.ToUpper(tokens[i+1].Value))
+
+		case (tok.Kind == TokenWord || tok.Kind == TokenQuotedIdent) && !reservedWords[strings.ToUpper(tok.Value)] &&
+			i+1 < len(tokens) && tokens[i+1].Is(TokenPunct, "("):
+			// 表名后的列清单不是函数调用
+			name := i
+			if i >= 2 && tokens[i-1].Is(TokenPunct, ".") {
+				name = i - 2
+			}
+			if name > 0 && tokens[name-1].IsKeyword("INTO", "TABLE", "REFERENCES", "JOIN", "FROM", "UPDATE") {
+				continue
+			}
+			add(strings.ToUpper(tok.Value) + "()")
+		}
+	}
+
+	return features
+}
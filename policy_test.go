@@ -0,0 +1,150 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatementKind(t *testing.T) {
+	tests := map[string]string{
+		"select 1":                    "SELECT",
+		"(SELECT 1) UNION (SELECT 2)": "SELECT",
+		"WITH x AS (SELECT 1) DELETE FROM t WHERE id IN (SELECT * FROM x)": "DELETE",
+		"CREATE TEMPORARY TABLE t (id INT)":                                "CREATE TABLE",
+		"CREATE UNIQUE INDEX i ON t (a)":                                   "CREATE INDEX",
+		"CREATE DEFINER = user@localhost PROCEDURE p() SELECT 1":           "CREATE PROCEDURE",
+		"CREATE DEFINER=CURRENT_USER() VIEW v AS SELECT 1":                 "CREATE VIEW",
+		"create user 'bob'@'%' identified by 'x'":                          "CREATE USER",
+		"DROP USER IF EXISTS bob":                                          "DROP USER",
+		"SET GLOBAL max_connections = 10":                                  "SET GLOBAL",
+		"SET @@persist.max_connections = 10":                               "SET PERSIST",
+		"SET @a = 1, @@global.sql_mode = ''":                               "SET GLOBAL",
+		"SET NAMES utf8mb4":                                                "SET NAMES",
+		"SET @a = 1":                                                       "SET",
+		"LOAD DATA LOCAL INFILE '/etc/passwd' INTO TABLE t":                "LOAD DATA",
+		"lock table t read":                                                "LOCK TABLES",
+		"START TRANSACTION":                                                "START TRANSACTION",
+		"CALL p(1)":                                                        "CALL",
+		"GRANT ALL ON *.* TO bob":                                          "GRANT",
+	}
+
+	for query, expected := range tests {
+		assert.Equal(t, expected, StatementKind(TokenizeSQL(query)), query)
+	}
+}
+
+func TestStatementFeatures(t *testing.T) {
+	tests := map[string][]string{
+		"SELECT SLEEP(1), sleep (2), benchmark(1000, MD5('a'))":     {"SLEEP()", "BENCHMARK()", "MD5()"},
+		"SELECT @a := id, @@version FROM t":                         {"@", "@@"},
+		"CREATE DEFINER=`root`@`localhost` VIEW v AS SELECT 1":      {},
+		"INSERT INTO t (a, b) VALUES (1, 2)":                        {},
+		"INSERT INTO db.t (a) SELECT COUNT(*) FROM u JOIN v (x)":    {"COUNT()"},
+		"SELECT * FROM t INTO OUTFILE '/tmp/t'":                     {"INTO OUTFILE"},
+		"SELECT /*!50000 GET_LOCK('a', 10) */":                      {"GET_LOCK()"},
+		"SELECT `mydb`.`myfunc`(1), 'SLEEP(1)' -- SLEEP(2)\nFROM t": {"MYFUNC()"},
+	}
+
+	for query, expected := range tests {
+		assert.ElementsMatch(t, expected, StatementFeatures(TokenizeSQL(query)), query)
+	}
+}
+
+func TestStatementPolicy(t *testing.T) {
+	t.Run("invalid default", func(t *testing.T) {
+		_, err := ParseStatementPolicy([]byte(`{"default": "maybe"}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("deny list", func(t *testing.T) {
+		policy, err := ParseStatementPolicy([]byte(`{
+			"deny": ["LOAD  DATA", "SET GLOBAL", "SET PERSIST*", "GRANT", "CREATE USER", "CALL", "SLEEP()", "BENCHMARK()", "*_LOCK()", "@", "INTO OUTFILE"]
+		}`))
+		assert.NoError(t, err)
+
+		allowed := []string{
+			"SELECT id FROM users WHERE name = 'SLEEP(1)'",
+			"UPDATE users SET name = 'a' WHERE id = 1",
+			"SET NAMES utf8mb4",
+			"SELECT @@version",
+			"CREATE TABLE t (id INT)",
+		}
+		for _, query := range allowed {
+			assert.NoError(t, policy.Check(query), query)
+		}
+
+		denied := map[string]string{
+			"LOAD DATA INFILE 'x' INTO TABLE t":           "禁止执行 LOAD DATA 语句",
+			"select sleep(10)":                            "禁止使用 SLEEP()",
+			"SELECT BENCHMARK(100000000, SHA2('a', 256))": "BENCHMARK()",
+			"SELECT GET_LOCK('x', 1)":                     "GET_LOCK()",
+			"SELECT IS_FREE_LOCK('x')":                    "IS_FREE_LOCK()",
+			"SELECT @x := 1":                              "禁止使用 @",
+			"SET GLOBAL general_log = 1":                  "SET GLOBAL",
+			"SET PERSIST_ONLY max_connections = 1":        "SET PERSIST_ONLY",
+			"GRANT ALL ON *.* TO bob":                     "GRANT",
+			"CREATE USER bob":                             "CREATE USER",
+			"CALL cleanup()":                              "CALL",
+			"SELECT * FROM t INTO OUTFILE '/tmp/x'":       "INTO OUTFILE",
+			"SELECT 1; SELECT SLEEP(1)":                   "SLEEP()",
+			"PREPARE s FROM 'SELECT SLEEP(1)'":            "SLEEP()",
+		}
+		for query, message := range denied {
+			err := policy.Check(query)
+			if assert.Error(t, err, query) {
+				assert.Contains(t, err.Error(), message, query)
+			}
+		}
+	})
+
+	t.Run("prepared statements", func(t *testing.T) {
+		policy, err := ParseStatementPolicy([]byte(`{"deny": ["SLEEP()"]}`))
+		assert.NoError(t, err)
+
+		assert.NoError(t, policy.Check("PREPARE s FROM 'SELECT id FROM users WHERE id = ?'"))
+
+		err = policy.Check("PREPARE s FROM 'SELECT SLEEP(1)'")
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "SLEEP()")
+
+		err = policy.Check("PREPARE s FROM @sql")
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "字符串字面量")
+	})
+
+	t.Run("default deny", func(t *testing.T) {
+		policy, err := ParseStatementPolicy([]byte(`{"default": "deny", "allow": ["SELECT", "SHOW", "INSERT"], "deny": ["SLEEP()"]}`))
+		assert.NoError(t, err)
+
+		assert.NoError(t, policy.Check("SELECT 1"))
+		assert.NoError(t, policy.Check("WITH x AS (SELECT 1) SELECT * FROM x"))
+		assert.NoError(t, policy.Check("SHOW TABLES"))
+
+		err = policy.Check("DELETE FROM t")
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "未允许执行 DELETE 语句")
+
+		err = policy.Check("INSERT INTO t VALUES (SLEEP(1))")
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "SLEEP()")
+	})
+}
+
+func TestDoQueryStatementPolicy(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	policy, _ := ParseStatementPolicy([]byte(`{"deny": ["SLEEP()"]}`))
+	original := Statements
+	Statements = policy
+	defer func() { Statements = original }()
+
+	// 调用 DoQuery
+	_, _, err := DoQuery("SELECT SLEEP(100)", StatementTypeNoExplainCheck, RouteAuto, nil)
+
+	// 验证结果：策略在访问数据库之前拒绝
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "语句策略禁止使用 SLEEP()")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+// maxConnectBackoff 是连接重试间隔的上限
+const maxConnectBackoff = 30 * time.Second
+
+// 连接池与健康检查参数，默认值与 database/sql 保持一致
+var (
+	MaxOpenConns        int
+	MaxIdleConns        int
+	ConnMaxLifetime     time.Duration
+	ConnMaxIdleTime     time.Duration
+	ConnectRetries      int
+	ConnectRetryBackoff time.Duration
+	HealthCheckInterval time.Duration
+
+	dbMu sync.Mutex
+)
+
+// use_database 选择的数据库。连接池中的每个连接都有自己的默认数据库，对其中一个连接执行 USE
+// 无法影响其他连接，因此主库和副本的新连接都以该数据库作为 DSN 中的默认数据库建立，
+// 切换之前建立的连接在归还或再次取出时被丢弃
+var (
+	selectedDatabase   string
+	databaseGeneration uint64
+	selectedDatabaseMu sync.Mutex
+)
+
+// SelectDatabase 记录 use_database 选择的数据库，之后主库和副本的新连接都使用该数据库
+func SelectDatabase(name string) {
+	selectedDatabaseMu.Lock()
+	defer selectedDatabaseMu.Unlock()
+
+	selectedDatabase = name
+	databaseGeneration++
+}
+
+// SelectedDatabase 返回 use_database 选择的数据库及其代数，尚未切换时数据库为空
+func SelectedDatabase() (string, uint64) {
+	selectedDatabaseMu.Lock()
+	defer selectedDatabaseMu.Unlock()
+
+	return selectedDatabase, databaseGeneration
+}
+
+// HealthStatus 记录一个连接最近一次健康检查的结果
+type HealthStatus struct {
+	CheckedAt time.Time
+	Latency   time.Duration
+	Err       error
+	// Failures 为连续失败的次数，检查成功后清零
+	Failures int
+}
+
+var (
+	health   = map[string]HealthStatus{}
+	healthMu sync.Mutex
+)
+
+// openDB 按连接池参数打开连接，并在 Ping 失败时按指数退避重试。
+// provider 不为 nil 时，每次建立新连接前从 provider 获取用户名和密码；
+// track 为 true 时连接跟随 use_database 选择的数据库
+func openDB(dsn string, provider CredentialProvider, track bool) (*sqlx.DB, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("解析 DSN 失败: %v", err)
+	}
+	if provider != nil || track {
+		err := cfg.Apply(mysql.BeforeConnect(func(ctx context.Context, cfg *mysql.Config) error {
+			if track {
+				if name, _ := SelectedDatabase(); name != "" {
+					cfg.DBName = name
+				}
+			}
+			if provider == nil {
+				return nil
+			}
+			cred, err := provider.Credential(ctx)
+			if err != nil {
+				return fmt.Errorf("获取数据库凭据失败: %v", err)
+			}
+			if len(cred.User) > 0 {
+				cfg.User = cred.User
+			}
+			cfg.Passwd = cred.Password
+			return nil
+		}))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var connector driver.Connector
+	connector, err = mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if track {
+		connector = trackingConnector{connector}
+	}
+	db := sqlx.NewDb(sql.OpenDB(connector), "mysql")
+	configurePool(db)
+
+	if err := pingWithRetry(db.PingContext, ConnectRetries, ConnectRetryBackoff); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// trackingConnector 记录每个新连接建立时 use_database 的代数
+type trackingConnector struct {
+	driver.Connector
+}
+
+func (c trackingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	_, generation := SelectedDatabase()
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &trackedConn{conn.(mysqlDriverConn), generation}, nil
+}
+
+// mysqlDriverConn 是 MySQL 驱动的连接实现的接口
+type mysqlDriverConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.Pinger
+	driver.NamedValueChecker
+	driver.SessionResetter
+	driver.Validator
+}
+
+// trackedConn 在 use_database 切换数据库之后失效：database/sql 归还连接时通过 IsValid、
+// 再次取出连接时通过 ResetSession 丢弃它，正在使用的连接不受影响，也不需要关闭整个连接池
+type trackedConn struct {
+	mysqlDriverConn
+	generation uint64
+}
+
+func (c *trackedConn) stale() bool {
+	_, generation := SelectedDatabase()
+	return generation != c.generation
+}
+
+func (c *trackedConn) ResetSession(ctx context.Context) error {
+	if c.stale() {
+		return driver.ErrBadConn
+	}
+
+	return c.mysqlDriverConn.ResetSession(ctx)
+}
+
+func (c *trackedConn) IsValid() bool {
+	return !c.stale() && c.mysqlDriverConn.IsValid()
+}
+
+func configurePool(db *sqlx.DB) {
+	db.SetMaxOpenConns(MaxOpenConns)
+	db.SetMaxIdleConns(MaxIdleConns)
+	db.SetConnMaxLifetime(ConnMaxLifetime)
+	db.SetConnMaxIdleTime(ConnMaxIdleTime)
+}
+
+// pingWithRetry 调用 ping，失败后最多重试 retries 次，每次等待的时间从 backoff 开始翻倍，
+// 不超过 maxConnectBackoff
+func pingWithRetry(ping func(ctx context.Context) error, retries int, backoff time.Duration) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = ping(context.Background()); err == nil {
+			return nil
+		}
+		if attempt >= retries {
+			return err
+		}
+
+		log.Printf("连接数据库失败（第 %d 次），%v 后重试: %v", attempt+1, backoff, err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxConnectBackoff)
+	}
+}
+
+// openConnections 返回默认连接、所有已建立的命名连接和副本连接，默认连接的名称为空
+func openConnections() map[string]*sqlx.DB {
+	conns := map[string]*sqlx.DB{}
+
+	dbMu.Lock()
+	if DB != nil {
+		conns[""] = DB
+	}
+	dbMu.Unlock()
+
+	namedDBsMu.Lock()
+	for name, db := range namedDBs {
+		conns[name] = db
+	}
+	namedDBsMu.Unlock()
+
+	if Replicas != nil {
+		for name, db := range Replicas.opened() {
+			conns["replica:"+name] = db
+		}
+	}
+
+	return conns
+}
+
+// CheckHealth 对所有已建立的连接执行一次 Ping 并记录结果。
+// Ping 失败时 database/sql 会丢弃失效的连接，之后的查询会自动建立新连接
+func CheckHealth(timeout time.Duration) {
+	for name, db := range openConnections() {
+		checkConnection(name, db, timeout)
+	}
+}
+
+func checkConnection(name string, db *sqlx.DB, timeout time.Duration) HealthStatus {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := db.PingContext(ctx)
+
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	status := HealthStatus{CheckedAt: start, Latency: time.Since(start), Err: err}
+	if err != nil {
+		status.Failures = health[name].Failures + 1
+		log.Printf("连接 %s 健康检查失败（连续 %d 次）: %v", connectionLabel(name), status.Failures, err)
+	}
+	health[name] = status
+
+	return status
+}
+
+// StartHealthCheck 每隔 interval 检查一次所有已建立的连接，直到 ctx 结束
+func StartHealthCheck(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				CheckHealth(interval)
+			}
+		}
+	}()
+}
+
+func connectionLabel(name string) string {
+	if name == "" {
+		return "default"
+	}
+
+	return name
+}
+
+// HandleServerStatus 返回每个已建立连接的连接池统计和健康状态。默认连接尚未建立时会先建立连接
+func HandleServerStatus() (string, error) {
+	if _, err := GetDB(); err != nil {
+		return "", err
+	}
+
+	conns := openConnections()
+	names := make([]string, 0, len(conns))
+	for name := range conns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := []map[string]interface{}{}
+	for _, name := range names {
+		db := conns[name]
+		status := checkConnection(name, db, 5*time.Second)
+		stats := db.Stats()
+
+		healthText := "ok"
+		if status.Err != nil {
+			healthText = fmt.Sprintf("error（连续 %d 次）: %v", status.Failures, status.Err)
+		}
+
+		result = append(result, map[string]interface{}{
+			"connection":           connectionLabel(name),
+			"health":               healthText,
+			"ping_ms":              fmt.Sprintf("%.1f", float64(status.Latency.Microseconds())/1000),
+			"max_open":             stats.MaxOpenConnections,
+			"open":                 stats.OpenConnections,
+			"in_use":               stats.InUse,
+			"idle":                 stats.Idle,
+			"wait_count":           stats.WaitCount,
+			"wait_duration":        stats.WaitDuration.String(),
+			"max_idle_closed":      stats.MaxIdleClosed,
+			"max_idle_time_closed": stats.MaxIdleTimeClosed,
+			"max_lifetime_closed":  stats.MaxLifetimeClosed,
+		})
+	}
+
+	return MapToCSV(result, []string{
+		"connection", "health", "ping_ms", "max_open", "open", "in_use", "idle",
+		"wait_count", "wait_duration", "max_idle_closed", "max_idle_time_closed", "max_lifetime_closed",
+	})
+}
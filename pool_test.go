@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupPingMockDB 与 setupMockDB 相同，但模拟连接会校验 Ping
+func setupPingMockDB(t *testing.T) (sqlmock.Sqlmock, func()) {
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("创建模拟数据库失败: %v", err)
+	}
+
+	originalDB := DB
+	DB = sqlx.NewDb(db, "sqlmock")
+	health = map[string]HealthStatus{}
+
+	return mock, func() {
+		db.Close()
+		DB = originalDB
+		health = map[string]HealthStatus{}
+	}
+}
+
+func TestPingWithRetry(t *testing.T) {
+	t.Run("succeeds after failures", func(t *testing.T) {
+		calls := 0
+		ping := func(ctx context.Context) error {
+			calls++
+			if calls < 3 {
+				return errors.New("connection refused")
+			}
+			return nil
+		}
+
+		// 调用 pingWithRetry
+		err := pingWithRetry(ping, 3, time.Millisecond)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, 3, calls)
+	})
+
+	t.Run("gives up after retries", func(t *testing.T) {
+		calls := 0
+		ping := func(ctx context.Context) error {
+			calls++
+			return errors.New("connection refused")
+		}
+
+		// 调用 pingWithRetry
+		err := pingWithRetry(ping, 2, time.Millisecond)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Equal(t, 3, calls)
+	})
+}
+
+func TestCheckHealth(t *testing.T) {
+	mock, cleanup := setupPingMockDB(t)
+	defer cleanup()
+
+	// 设置模拟预期
+	mock.ExpectPing().WillReturnError(errors.New("server has gone away"))
+	mock.ExpectPing().WillReturnError(errors.New("server has gone away"))
+	mock.ExpectPing()
+
+	// 调用 CheckHealth
+	CheckHealth(time.Second)
+	CheckHealth(time.Second)
+
+	// 验证结果
+	assert.Equal(t, 2, health[""].Failures)
+	assert.Error(t, health[""].Err)
+
+	CheckHealth(time.Second)
+	assert.Equal(t, 0, health[""].Failures)
+	assert.NoError(t, health[""].Err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleServerStatus(t *testing.T) {
+	mock, cleanup := setupPingMockDB(t)
+	defer cleanup()
+
+	t.Run("healthy", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectPing()
+
+		// 调用 HandleServerStatus
+		result, err := HandleServerStatus()
+
+		// 验证结果
+		assert.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(result), "\n")
+		assert.Len(t, lines, 2)
+		assert.True(t, strings.HasPrefix(lines[0], "connection,health,ping_ms,max_open,open,in_use,idle,wait_count"))
+		assert.True(t, strings.HasPrefix(lines[1], "default,ok,"))
+	})
+
+	t.Run("ping failed", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectPing().WillReturnError(errors.New("server has gone away"))
+
+		// 调用 HandleServerStatus
+		result, err := HandleServerStatus()
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "server has gone away")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+// fakeDriverConn 模拟 MySQL 驱动的连接，只实现 ResetSession 和 IsValid
+type fakeDriverConn struct {
+	mysqlDriverConn
+	resets int
+}
+
+func (c *fakeDriverConn) ResetSession(ctx context.Context) error {
+	c.resets++
+	return nil
+}
+
+func (c *fakeDriverConn) IsValid() bool {
+	return true
+}
+
+func TestTrackedConn(t *testing.T) {
+	defer SelectDatabase("")
+	_, generation := SelectedDatabase()
+	inner := &fakeDriverConn{}
+	conn := &trackedConn{inner, generation}
+
+	t.Run("current database", func(t *testing.T) {
+		// 调用 ResetSession 和 IsValid
+		err := conn.ResetSession(context.Background())
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.True(t, conn.IsValid())
+		assert.Equal(t, 1, inner.resets)
+	})
+
+	t.Run("database switched", func(t *testing.T) {
+		SelectDatabase("crm")
+
+		// 调用 ResetSession 和 IsValid
+		err := conn.ResetSession(context.Background())
+
+		// 验证结果：切换之前建立的连接被丢弃
+		assert.ErrorIs(t, err, driver.ErrBadConn)
+		assert.False(t, conn.IsValid())
+		assert.Equal(t, 1, inner.resets)
+	})
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// defaultProcessQueryLength 为 show_processlist 中查询文本默认保留的字符数
+	defaultProcessQueryLength = 200
+
+	hiddenProcessQuery = "（语句涉及不允许访问的表或列，已隐藏）"
+)
+
+// KillAnyUser 为 true 时 kill_query 可以终止其他 MySQL 用户的查询，默认只能终止本服务器使用的用户的查询
+var KillAnyUser bool
+
+// ProcessFilter 为 show_processlist 的过滤条件，空值表示不过滤
+type ProcessFilter struct {
+	User     string
+	Database string
+	Command  string
+	MinTime  int64
+	// QueryLength 为查询文本保留的字符数，0 表示使用默认值
+	QueryLength int
+}
+
+type processRow struct {
+	ID      int64          `db:"ID"`
+	User    string         `db:"USER"`
+	Host    string         `db:"HOST"`
+	DB      sql.NullString `db:"DB"`
+	Command string         `db:"COMMAND"`
+	Time    int64          `db:"TIME"`
+	State   sql.NullString `db:"STATE"`
+	Info    sql.NullString `db:"INFO"`
+}
+
+// HandleShowProcesslist 列出服务器上的会话，不包括本次查询所用的连接。
+// 启用访问控制时，当前数据库不允许访问的会话不显示，涉及不允许访问的表或列的语句隐藏
+func HandleShowProcesslist(filter ProcessFilter) (string, error) {
+	db, err := GetDB()
+	if err != nil {
+		return "", err
+	}
+
+	conditions := []string{"ID <> CONNECTION_ID()"}
+	args := []interface{}{}
+	if filter.User != "" {
+		conditions = append(conditions, "USER = ?")
+		args = append(args, filter.User)
+	}
+	if filter.Database != "" {
+		conditions = append(conditions, "DB = ?")
+		args = append(args, filter.Database)
+	}
+	if filter.Command != "" {
+		conditions = append(conditions, "COMMAND = ?")
+		args = append(args, filter.Command)
+	}
+	if filter.MinTime > 0 {
+		conditions = append(conditions, "TIME >= ?")
+		args = append(args, filter.MinTime)
+	}
+	if filter.QueryLength <= 0 {
+		filter.QueryLength = defaultProcessQueryLength
+	}
+
+	rows := []processRow{}
+	query := "SELECT ID, USER, HOST, DB, COMMAND, TIME, STATE, INFO FROM information_schema.PROCESSLIST WHERE " +
+		strings.Join(conditions, " AND ") + " ORDER BY TIME DESC, ID"
+	if err := db.Select(&rows, query, args...); err != nil {
+		return "", fmt.Errorf("读取会话列表失败: %v", err)
+	}
+
+	result := []map[string]interface{}{}
+	for _, row := range rows {
+		if row.DB.Valid && !SchemaAccessible(row.DB.String) {
+			continue
+		}
+		result = append(result, map[string]interface{}{
+			"id":      row.ID,
+			"user":    row.User,
+			"host":    row.Host,
+			"db":      row.DB.String,
+			"command": row.Command,
+			"time":    row.Time,
+			"state":   row.State.String,
+			"query":   visibleQuery(row.DB.String, row.Info.String, filter.QueryLength),
+		})
+	}
+
+	return MapToCSV(result, []string{"id", "user", "host", "db", "command", "time", "state", "query"})
+}
+
+// HandleKillQuery 终止会话 id 正在执行的查询（KILL QUERY），会话本身保持连接。
+// 默认只能终止与本服务器使用同一 MySQL 用户的会话，--kill-any-user 取消该限制；KILL 语句同样受语句策略限制
+func HandleKillQuery(id int64) (string, error) {
+	db, err := GetDB()
+	if err != nil {
+		return "", err
+	}
+
+	if id <= 0 {
+		return "", fmt.Errorf("无效的会话 ID: %d", id)
+	}
+
+	stmt := fmt.Sprintf("KILL QUERY %d", id)
+	if err := CheckStatementPolicy(stmt); err != nil {
+		return "", err
+	}
+
+	var row processRow
+	err = db.Get(&row, "SELECT ID, USER, HOST, DB, COMMAND, TIME, STATE, INFO FROM information_schema.PROCESSLIST WHERE ID = ?", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("会话 %d 不存在或没有权限查看", id)
+	}
+	if err != nil {
+		return "", fmt.Errorf("读取会话 %d 失败: %v", id, err)
+	}
+	if row.DB.Valid {
+		if err := CheckSchemaAccess(row.DB.String); err != nil {
+			return "", err
+		}
+	}
+
+	if !KillAnyUser {
+		var user string
+		if err := db.Get(&user, "SELECT SUBSTRING_INDEX(CURRENT_USER(), '@', 1)"); err != nil {
+			return "", fmt.Errorf("读取当前用户失败: %v", err)
+		}
+		if row.User != user {
+			return "", fmt.Errorf("会话 %d 属于用户 %s，只能终止用户 %s 的查询（使用 --kill-any-user 允许终止其他用户的查询）", id, row.User, user)
+		}
+	}
+
+	if row.Command == "Sleep" || !row.Info.Valid {
+		return "", fmt.Errorf("会话 %d 当前没有正在执行的查询", id)
+	}
+
+	if _, err := db.Exec(stmt); err != nil {
+		return "", fmt.Errorf("终止会话 %d 的查询失败: %v", id, err)
+	}
+
+	return fmt.Sprintf("已终止会话 %d 的查询（已执行 %d 秒）: %s", id, row.Time, visibleQuery(row.DB.String, row.Info.String, defaultProcessQueryLength)), nil
+}
+
+// visibleQuery 返回可以展示的会话语句，schema 为会话的当前数据库。语句涉及不允许访问的表或列时隐藏；
+// 配置了脱敏策略时只显示去掉字面量的指纹，避免语句中的取值泄露脱敏列的内容
+func visibleQuery(schema, info string, n int) string {
+	if strings.TrimSpace(info) == "" {
+		return ""
+	}
+	if checkQueryAccess(info, func() (string, error) { return schema, nil }) != nil {
+		return hiddenProcessQuery
+	}
+	if Masking != nil {
+		info = QueryFingerprint(info)
+	}
+
+	return processQuery(info, n)
+}
+
+// processQuery 把查询文本中的换行和连续空白合并为一个空格，并截断到 n 个字符
+func processQuery(info string, n int) string {
+	return abbreviate(strings.Join(strings.Fields(info), " "), n)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var processColumns = []string{"ID", "USER", "HOST", "DB", "COMMAND", "TIME", "STATE", "INFO"}
+
+func TestHandleShowProcesslist(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	t.Run("all sessions", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("FROM information_schema.PROCESSLIST WHERE ID <> CONNECTION_ID\\(\\) ORDER BY TIME DESC").
+			WillReturnRows(sqlmock.NewRows(processColumns).
+				AddRow(12, "mcp", "10.0.0.2:51234", "shop", "Query", 310, "Sending data", "SELECT *\n  FROM orders\n  WHERE note LIKE '%x%'").
+				AddRow(7, "app", "10.0.0.3:40000", nil, "Sleep", 5, "", nil))
+
+		// 调用 HandleShowProcesslist
+		result, err := HandleShowProcesslist(ProcessFilter{})
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, "id,user,host,db,command,time,state,query\n"+
+			"12,mcp,10.0.0.2:51234,shop,Query,310,Sending data,SELECT * FROM orders WHERE note LIKE '%x%'\n"+
+			"7,app,10.0.0.3:40000,,Sleep,5,,\n", result)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("WHERE ID <> CONNECTION_ID\\(\\) AND USER = \\? AND DB = \\? AND COMMAND = \\? AND TIME >= \\?").
+			WithArgs("mcp", "shop", "Query", int64(60)).
+			WillReturnRows(sqlmock.NewRows(processColumns).
+				AddRow(12, "mcp", "10.0.0.2:51234", "shop", "Query", 310, "Sending data", strings.Repeat("x", 50)))
+
+		// 调用 HandleShowProcesslist
+		result, err := HandleShowProcesslist(ProcessFilter{User: "mcp", Database: "shop", Command: "Query", MinTime: 60, QueryLength: 10})
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, ",xxxxxxxxxx...\n")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("hides denied databases", func(t *testing.T) {
+		setupAccess(t, nil, []string{"secret.*"})
+		// 设置模拟预期
+		mock.ExpectQuery("FROM information_schema.PROCESSLIST").
+			WillReturnRows(sqlmock.NewRows(processColumns).
+				AddRow(12, "mcp", "h", "shop", "Query", 3, "", "SELECT 1").
+				AddRow(13, "mcp", "h", "secret", "Query", 3, "", "SELECT * FROM keys"))
+
+		// 调用 HandleShowProcesslist
+		result, err := HandleShowProcesslist(ProcessFilter{})
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "12,mcp")
+		assert.NotContains(t, result, "secret")
+	})
+
+	t.Run("redacts statements", func(t *testing.T) {
+		setupAccess(t, nil, []string{"secret.*"})
+		setupMasking(t, `{"rules": [{"column": "*email*", "strategy": "partial"}]}`)
+		// 设置模拟预期
+		mock.ExpectQuery("FROM information_schema.PROCESSLIST").
+			WillReturnRows(sqlmock.NewRows(processColumns).
+				AddRow(12, "mcp", "h", nil, "Query", 3, "", "SELECT * FROM secret.keys WHERE id = 1").
+				AddRow(13, "mcp", "h", "shop", "Query", 3, "", "UPDATE users SET email = 'ann@example.com' WHERE id = 7"))
+
+		// 调用 HandleShowProcesslist
+		result, err := HandleShowProcesslist(ProcessFilter{})
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "12,mcp,h,,Query,3,,（语句涉及不允许访问的表或列，已隐藏）\n")
+		assert.Contains(t, result, "13,mcp,h,shop,Query,3,,UPDATE users SET email = ? WHERE id = ?\n")
+		assert.NotContains(t, result, "ann@")
+	})
+}
+
+func TestHandleKillQuery(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	expectProcess := func(id int64, user, command string, info interface{}) {
+		mock.ExpectQuery("FROM information_schema.PROCESSLIST WHERE ID = \\?").WithArgs(id).
+			WillReturnRows(sqlmock.NewRows(processColumns).AddRow(id, user, "h", "shop", command, 95, "executing", info))
+	}
+	expectUser := func(user string) {
+		mock.ExpectQuery("SELECT SUBSTRING_INDEX\\(CURRENT_USER\\(\\), '@', 1\\)").
+			WillReturnRows(sqlmock.NewRows([]string{"user"}).AddRow(user))
+	}
+
+	t.Run("own session", func(t *testing.T) {
+		// 设置模拟预期
+		expectProcess(12, "mcp", "Query", "SELECT * FROM orders")
+		expectUser("mcp")
+		mock.ExpectExec("KILL QUERY 12").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		// 调用 HandleKillQuery
+		result, err := HandleKillQuery(12)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, "已终止会话 12 的查询（已执行 95 秒）: SELECT * FROM orders", result)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("other user", func(t *testing.T) {
+		// 设置模拟预期
+		expectProcess(30, "app", "Query", "UPDATE accounts SET balance = 0")
+		expectUser("mcp")
+
+		// 调用 HandleKillQuery
+		_, err := HandleKillQuery(30)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "属于用户 app")
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		// --kill-any-user 允许终止其他用户的查询
+		KillAnyUser = true
+		defer func() { KillAnyUser = false }()
+		expectProcess(30, "app", "Query", "UPDATE accounts SET balance = 0")
+		mock.ExpectExec("KILL QUERY 30").WillReturnResult(sqlmock.NewResult(0, 0))
+		_, err = HandleKillQuery(30)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("idle session", func(t *testing.T) {
+		// 设置模拟预期
+		expectProcess(7, "mcp", "Sleep", nil)
+		expectUser("mcp")
+
+		// 调用 HandleKillQuery
+		_, err := HandleKillQuery(7)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "没有正在执行的查询")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("unknown session", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("FROM information_schema.PROCESSLIST WHERE ID = \\?").WithArgs(int64(99)).
+			WillReturnRows(sqlmock.NewRows(processColumns))
+
+		// 调用 HandleKillQuery
+		_, err := HandleKillQuery(99)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "会话 99 不存在")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("statement policy", func(t *testing.T) {
+		policy, _ := ParseStatementPolicy([]byte(`{"deny": ["KILL"]}`))
+		original := Statements
+		Statements = policy
+		defer func() { Statements = original }()
+
+		// 调用 HandleKillQuery
+		_, err := HandleKillQuery(12)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "语句策略禁止执行 KILL 语句")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
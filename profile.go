@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// profile_table 的抽样行数
+const (
+	defaultProfileSampleSize = 10000
+	maxProfileSampleSize     = 100000
+	// profileValueLength 为 TEXT/JSON 列参与统计的最大字符数，长度本身在 SQL 中计算
+	profileValueLength = 1024
+	profileTopValues   = 5
+	// profilePatternRatio 为报告一种格式所需的最小占比
+	profilePatternRatio = 0.5
+)
+
+var (
+	profileNumericTypes  = map[string]bool{"tinyint": true, "smallint": true, "mediumint": true, "int": true, "bigint": true, "decimal": true, "float": true, "double": true, "year": true}
+	profileTemporalTypes = map[string]bool{"date": true, "datetime": true, "timestamp": true, "time": true}
+	profileTextTypes     = map[string]bool{"tinytext": true, "text": true, "mediumtext": true, "longtext": true, "json": true}
+	profileStringTypes   = map[string]bool{"char": true, "varchar": true, "tinytext": true, "text": true, "mediumtext": true, "longtext": true, "json": true, "enum": true, "set": true}
+	// 二进制和空间类型只统计 NULL 比例和字节长度
+	profileBinaryTypes = map[string]bool{"binary": true, "varbinary": true, "tinyblob": true, "blob": true, "mediumblob": true, "longblob": true,
+		"geometry": true, "point": true, "linestring": true, "polygon": true, "multipoint": true, "multilinestring": true, "multipolygon": true, "geometrycollection": true}
+)
+
+// profilePatterns 为字符串列检测的格式，按顺序匹配，一个值只计入第一种匹配的格式
+var profilePatterns = []struct {
+	name  string
+	match func(string) bool
+}{
+	{"uuid", regexp.MustCompile(`^(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`).MatchString},
+	{"email", regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`).MatchString},
+	{"url", regexp.MustCompile(`^(?i)https?://\S+$`).MatchString},
+	{"ipv4", regexp.MustCompile(`^(\d{1,3}\.){3}\d{1,3}$`).MatchString},
+	{"json", func(s string) bool {
+		s = strings.TrimSpace(s)
+		return (strings.HasPrefix(s, "{") || strings.HasPrefix(s, "[")) && json.Valid([]byte(s))
+	}},
+	{"date", regexp.MustCompile(`^\d{4}-\d{2}-\d{2}([ T]\d{2}:\d{2}(:\d{2}(\.\d+)?)?)?$`).MatchString},
+	{"number", regexp.MustCompile(`^[+-]?\d+(\.\d+)?$`).MatchString},
+}
+
+type profileColumn struct {
+	Name     string `db:"COLUMN_NAME"`
+	DataType string `db:"DATA_TYPE"`
+	Type     string `db:"COLUMN_TYPE"`
+}
+
+// ColumnProfile 是一列在样本中的统计
+type ColumnProfile struct {
+	Column    string
+	Type      string
+	Nulls     int64
+	Sampled   int64
+	Distinct  int64
+	Estimate  string
+	Min       string
+	Max       string
+	AvgLength float64
+	TopValues []string
+	Patterns  []string
+	Note      string
+}
+
+// HandleProfileTable 抽样读取表的前 sampleSize 行，统计每列的 NULL 比例、不同值数量估计、最小/最大值、
+// 最常见的值、平均长度和常见格式（邮箱、UUID、JSON 等）。抽样查询带 LIMIT，不会扫描整张表
+func HandleProfileTable(name string, sampleSize int, route string) (string, error) {
+	db, err := GetReadDB(route)
+	if err != nil {
+		return "", err
+	}
+
+	ref, err := ParseTableName(name)
+	if err != nil {
+		return "", err
+	}
+	if err := CheckTableAccess(ref); err != nil {
+		return "", err
+	}
+	if ref, err = LookupTable(db, ref); err != nil {
+		return "", err
+	}
+
+	if sampleSize <= 0 {
+		sampleSize = defaultProfileSampleSize
+	}
+	if sampleSize > maxProfileSampleSize {
+		sampleSize = maxProfileSampleSize
+	}
+
+	columns := []profileColumn{}
+	if err := db.Select(&columns, "SELECT COLUMN_NAME, DATA_TYPE, COLUMN_TYPE FROM information_schema.COLUMNS "+
+		"WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION", ref.Schema, ref.Name); err != nil {
+		return "", fmt.Errorf("读取表 %s 的列失败: %v", ref.QuotedName(), err)
+	}
+
+	// 不允许访问的列不参与抽样
+	visible, hidden := []profileColumn{}, []string{}
+	for _, c := range columns {
+		if columnAccessible(ref.Schema, ref.Name, c.Name) {
+			visible = append(visible, c)
+		} else {
+			hidden = append(hidden, c.Name)
+		}
+	}
+	if len(visible) == 0 {
+		return "", fmt.Errorf("表 %s 没有允许访问的列", ref.QuotedName())
+	}
+
+	var estimatedRows int64
+	if err := db.Get(&estimatedRows, "SELECT IFNULL(TABLE_ROWS, 0) FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?", ref.Schema, ref.Name); err != nil {
+		return "", fmt.Errorf("读取表 %s 的行数估计失败: %v", ref.QuotedName(), err)
+	}
+	cardinality, err := indexCardinality(db, ref)
+	if err != nil {
+		return "", err
+	}
+
+	names, exprs := []string{}, []string{}
+	for _, c := range visible {
+		quoted := QuoteIdentifier(c.Name)
+		names = append(names, quoted)
+		switch {
+		case profileBinaryTypes[c.DataType]:
+			exprs = append(exprs, "NULL", "LENGTH("+quoted+")")
+		case profileTextTypes[c.DataType]:
+			exprs = append(exprs, fmt.Sprintf("LEFT(%s, %d)", quoted, profileValueLength), "CHAR_LENGTH("+quoted+")")
+		default:
+			exprs = append(exprs, quoted, "CHAR_LENGTH("+quoted+")")
+		}
+	}
+
+	masker, err := NewResultMasker("SELECT "+strings.Join(names, ", ")+" FROM "+ref.QuotedName(), columnNames(visible))
+	if err != nil {
+		return "", err
+	}
+
+	rows, err := db.Queryx(fmt.Sprintf("SELECT %s FROM %s LIMIT %d", strings.Join(exprs, ", "), ref.QuotedName(), sampleSize))
+	if err != nil {
+		return "", fmt.Errorf("抽样读取表 %s 失败: %v", ref.QuotedName(), err)
+	}
+	defer rows.Close()
+
+	stats := make([]*columnStats, len(visible))
+	for i, c := range visible {
+		stats[i] = &columnStats{column: c, counts: map[string]int64{}, patterns: map[string]int64{}}
+	}
+	var sampled int64
+	for rows.Next() {
+		values, err := rows.SliceScan()
+		if err != nil {
+			return "", fmt.Errorf("抽样读取表 %s 失败: %v", ref.QuotedName(), err)
+		}
+		sampled++
+		for i, s := range stats {
+			s.add(values[2*i], values[2*i+1])
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("抽样读取表 %s 失败: %v", ref.QuotedName(), err)
+	}
+
+	complete := sampled < int64(sampleSize)
+	if complete || estimatedRows < sampled {
+		estimatedRows = sampled
+	}
+	profiles := []ColumnProfile{}
+	for i, s := range stats {
+		profiles = append(profiles, s.profile(sampled, estimatedRows, complete, cardinality[strings.ToLower(s.column.Name)], masker.Masked(i)))
+	}
+
+	var b strings.Builder
+	if complete {
+		fmt.Fprintf(&b, "表 %s 共 %d 行，已全部读取\n", ref.QuotedName(), sampled)
+	} else {
+		fmt.Fprintf(&b, "表 %s 估计 %d 行，抽样前 %d 行（按存储顺序，不是随机样本）\n", ref.QuotedName(), estimatedRows, sampled)
+	}
+	if len(hidden) > 0 {
+		fmt.Fprintf(&b, "不允许访问的列未统计: %s\n", strings.Join(hidden, ", "))
+	}
+
+	table, err := profilesCSV(profiles)
+	if err != nil {
+		return "", err
+	}
+
+	return b.String() + "\n" + table, nil
+}
+
+func columnNames(columns []profileColumn) []string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.Name
+	}
+
+	return names
+}
+
+// indexCardinality 返回以各列开头的索引的基数统计，用于估计整张表中的不同值数量
+func indexCardinality(db *sqlx.DB, ref TableRef) (map[string]int64, error) {
+	rows := []struct {
+		Column      string `db:"COLUMN_NAME"`
+		Cardinality int64  `db:"CARDINALITY"`
+	}{}
+	if err := db.Select(&rows, "SELECT COLUMN_NAME, MAX(IFNULL(CARDINALITY, 0)) AS CARDINALITY FROM information_schema.STATISTICS "+
+		"WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? AND SEQ_IN_INDEX = 1 GROUP BY COLUMN_NAME", ref.Schema, ref.Name); err != nil {
+		return nil, fmt.Errorf("读取表 %s 的索引统计失败: %v", ref.QuotedName(), err)
+	}
+
+	result := map[string]int64{}
+	for _, row := range rows {
+		if row.Cardinality > 0 {
+			result[strings.ToLower(row.Column)] = row.Cardinality
+		}
+	}
+
+	return result, nil
+}
+
+// columnStats 累计一列在样本中的取值
+type columnStats struct {
+	column    profileColumn
+	nulls     int64
+	lengths   int64
+	counts    map[string]int64
+	patterns  map[string]int64
+	min, max  string
+	hasMinMax bool
+}
+
+func (s *columnStats) add(value, length interface{}) {
+	if length == nil {
+		s.nulls++
+		return
+	}
+	n, _ := strconv.ParseInt(exportText(length), 10, 64)
+	s.lengths += n
+	if profileBinaryTypes[s.column.DataType] {
+		return
+	}
+
+	text := exportText(value)
+	s.counts[text]++
+	if !s.hasMinMax || s.less(text, s.min) {
+		s.min = text
+	}
+	if !s.hasMinMax || s.less(s.max, text) {
+		s.max = text
+	}
+	s.hasMinMax = true
+
+	if profileStringTypes[s.column.DataType] && s.column.DataType != "json" {
+		for _, p := range profilePatterns {
+			if p.match(text) {
+				s.patterns[p.name]++
+				break
+			}
+		}
+	}
+}
+
+// less 按列类型比较两个值：数值按大小，日期时间按文本，字符串不区分大小写（接近默认排序规则）
+func (s *columnStats) less(a, b string) bool {
+	if profileNumericTypes[s.column.DataType] {
+		x, err1 := strconv.ParseFloat(a, 64)
+		y, err2 := strconv.ParseFloat(b, 64)
+		if err1 == nil && err2 == nil {
+			return x < y
+		}
+	}
+	if profileTemporalTypes[s.column.DataType] {
+		return a < b
+	}
+	if la, lb := strings.ToLower(a), strings.ToLower(b); la != lb {
+		return la < lb
+	}
+
+	return a < b
+}
+
+func (s *columnStats) profile(sampled, estimatedRows int64, complete bool, cardinality int64, masked bool) ColumnProfile {
+	p := ColumnProfile{Column: s.column.Name, Type: s.column.Type, Nulls: s.nulls, Sampled: sampled, Distinct: int64(len(s.counts))}
+	nonNull := sampled - s.nulls
+	if nonNull > 0 {
+		p.AvgLength = float64(s.lengths) / float64(nonNull)
+	}
+
+	notes := []string{}
+	switch {
+	case profileBinaryTypes[s.column.DataType]:
+		notes = append(notes, "二进制列只统计 NULL 比例和字节长度")
+	case complete:
+		p.Estimate = strconv.FormatInt(p.Distinct, 10)
+	case cardinality > 0:
+		p.Estimate = fmt.Sprintf("≈%d（索引统计）", cardinality)
+	case nonNull > 0 && p.Distinct == nonNull:
+		// 样本中的值各不相同，按非 NULL 比例推算到整张表
+		p.Estimate = fmt.Sprintf("≈%d", estimatedRows*nonNull/sampled)
+	default:
+		p.Estimate = fmt.Sprintf("≥%d", p.Distinct)
+	}
+	if profileTextTypes[s.column.DataType] {
+		notes = append(notes, fmt.Sprintf("只比较前 %d 个字符", profileValueLength))
+	}
+
+	for name, n := range s.patterns {
+		if ratio := float64(n) / float64(nonNull); ratio >= profilePatternRatio {
+			p.Patterns = append(p.Patterns, fmt.Sprintf("%s(%s)", name, percent(ratio)))
+		}
+	}
+	sort.Strings(p.Patterns)
+	if s.column.DataType == "json" {
+		p.Patterns = []string{"json"}
+	}
+
+	if masked {
+		notes = append(notes, "已脱敏，不显示取值")
+		p.Note = strings.Join(notes, "，")
+		return p
+	}
+	if s.hasMinMax {
+		p.Min, p.Max = s.min, s.max
+	}
+	// 值各不相同时最常见的值没有意义
+	if p.Distinct < nonNull {
+		values := make([]string, 0, len(s.counts))
+		for v := range s.counts {
+			values = append(values, v)
+		}
+		sort.Slice(values, func(i, j int) bool {
+			if s.counts[values[i]] != s.counts[values[j]] {
+				return s.counts[values[i]] > s.counts[values[j]]
+			}
+			return values[i] < values[j]
+		})
+		for _, v := range values[:min(len(values), profileTopValues)] {
+			p.TopValues = append(p.TopValues, fmt.Sprintf("%s×%d", processQuery(v, 40), s.counts[v]))
+		}
+	}
+	p.Note = strings.Join(notes, "，")
+
+	return p
+}
+
+func profilesCSV(profiles []ColumnProfile) (string, error) {
+	rows := []map[string]interface{}{}
+	for _, p := range profiles {
+		nullRatio := ""
+		if p.Sampled > 0 {
+			nullRatio = percent(float64(p.Nulls) / float64(p.Sampled))
+		}
+		rows = append(rows, map[string]interface{}{
+			"column":     p.Column,
+			"type":       p.Type,
+			"null_ratio": nullRatio,
+			"distinct":   p.Estimate,
+			"min":        processQuery(p.Min, 40),
+			"max":        processQuery(p.Max, 40),
+			"avg_length": strconv.FormatFloat(p.AvgLength, 'f', 1, 64),
+			"top_values": strings.Join(p.TopValues, "; "),
+			"patterns":   strings.Join(p.Patterns, "; "),
+			"note":       p.Note,
+		})
+	}
+
+	return MapToCSV(rows, []string{"column", "type", "null_ratio", "distinct", "min", "max", "avg_length", "top_values", "patterns", "note"})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func expectProfileTable(mock sqlmock.Sqlmock, schema string, columns [][]string, tableRows int64, cardinality map[string]int64) {
+	mock.ExpectQuery("FROM information_schema.TABLES").WithArgs(schema, "users").
+		WillReturnRows(sqlmock.NewRows([]string{"TABLE_SCHEMA", "TABLE_NAME"}).AddRow("shop", "users"))
+	rows := sqlmock.NewRows([]string{"COLUMN_NAME", "DATA_TYPE", "COLUMN_TYPE"})
+	for _, c := range columns {
+		rows.AddRow(c[0], c[1], c[2])
+	}
+	mock.ExpectQuery("FROM information_schema.COLUMNS").WithArgs("shop", "users").WillReturnRows(rows)
+	mock.ExpectQuery("SELECT IFNULL\\(TABLE_ROWS, 0\\) FROM information_schema.TABLES").WithArgs("shop", "users").
+		WillReturnRows(sqlmock.NewRows([]string{"TABLE_ROWS"}).AddRow(tableRows))
+	stats := sqlmock.NewRows([]string{"COLUMN_NAME", "CARDINALITY"})
+	for column, n := range cardinality {
+		stats.AddRow(column, n)
+	}
+	mock.ExpectQuery("FROM information_schema.STATISTICS").WithArgs("shop", "users").WillReturnRows(stats)
+}
+
+func TestHandleProfileTable(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	columns := [][]string{
+		{"id", "int", "int"},
+		{"email", "varchar", "varchar(255)"},
+		{"status", "enum", "enum('active','banned')"},
+		{"settings", "json", "json"},
+		{"avatar", "blob", "blob"},
+	}
+
+	t.Run("whole table", func(t *testing.T) {
+		// 设置模拟预期
+		expectProfileTable(mock, "", columns, 5, nil)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT `id`, CHAR_LENGTH(`id`), `email`, CHAR_LENGTH(`email`), `status`, CHAR_LENGTH(`status`), " +
+			"LEFT(`settings`, 1024), CHAR_LENGTH(`settings`), NULL, LENGTH(`avatar`) FROM `shop`.`users` LIMIT 10000")).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "l1", "email", "l2", "status", "l3", "settings", "l4", "avatar", "l5"}).
+				AddRow(1, 1, "ann@example.com", 15, "active", 6, `{"theme": "dark"}`, 17, nil, 2048).
+				AddRow(2, 1, "bob@example.com", 15, "active", 6, `{}`, 2, nil, nil).
+				AddRow(10, 2, "carol@example.org", 17, "banned", 6, nil, nil, nil, 1024).
+				AddRow(9, 1, "not an email", 12, "active", 6, nil, nil, nil, nil))
+
+		// 调用 HandleProfileTable
+		result, err := HandleProfileTable("users", 0, RouteAuto)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, "表 `shop`.`users` 共 4 行，已全部读取\n\n"+
+			"column,type,null_ratio,distinct,min,max,avg_length,top_values,patterns,note\n"+
+			"id,int,0.0%,4,1,10,1.2,,,\n"+
+			"email,varchar(255),0.0%,4,ann@example.com,not an email,14.8,,email(75.0%),\n"+
+			"status,\"enum('active','banned')\",0.0%,2,active,banned,6.0,active×3; banned×1,,\n"+
+			"settings,json,50.0%,2,\"{\"\"theme\"\": \"\"dark\"\"}\",{},9.5,,json,只比较前 1024 个字符\n"+
+			"avatar,blob,50.0%,,,,1536.0,,,二进制列只统计 NULL 比例和字节长度\n", result)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("sample of a large table", func(t *testing.T) {
+		// 设置模拟预期
+		expectProfileTable(mock, "", columns[:3], 1_000_000, map[string]int64{"status": 2})
+		mock.ExpectQuery("FROM `shop`.`users` LIMIT 3").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "l1", "email", "l2", "status", "l3"}).
+				AddRow(1, 1, "ann@example.com", 15, "active", 6).
+				AddRow(2, 1, nil, nil, "active", 6).
+				AddRow(3, 1, "c3c1d5a4-8f7e-4c0b-9a43-1f0e6b2f8c11", 36, "active", 6))
+
+		// 调用 HandleProfileTable
+		result, err := HandleProfileTable("users", 3, RouteAuto)
+
+		// 验证结果
+		assert.NoError(t, err)
+		lines := strings.Split(result, "\n")
+		assert.Equal(t, "表 `shop`.`users` 估计 1000000 行，抽样前 3 行（按存储顺序，不是随机样本）", lines[0])
+		assert.Equal(t, "id,int,0.0%,≈1000000,1,3,1.0,,,", lines[3])
+		assert.Equal(t, "email,varchar(255),33.3%,≈666666,ann@example.com,c3c1d5a4-8f7e-4c0b-9a43-1f0e6b2f8c11,25.5,,email(50.0%); uuid(50.0%),", lines[4])
+		assert.Equal(t, "status,\"enum('active','banned')\",0.0%,≈2（索引统计）,active,active,6.0,active×3,,", lines[5])
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("masked and denied columns", func(t *testing.T) {
+		setupMasking(t, `{"rules": [{"column": "*email*", "strategy": "partial"}]}`)
+		setupAccess(t, nil, []string{"shop.users.status"})
+		// 设置模拟预期
+		expectProfileTable(mock, "shop", columns[:3], 2, nil)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT `id`, CHAR_LENGTH(`id`), `email`, CHAR_LENGTH(`email`) FROM `shop`.`users` LIMIT 10000")).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "l1", "email", "l2"}).
+				AddRow(1, 1, "ann@example.com", 15).
+				AddRow(2, 1, "ann@example.com", 15))
+
+		// 调用 HandleProfileTable
+		result, err := HandleProfileTable("shop.users", 0, RouteAuto)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "不允许访问的列未统计: status\n")
+		assert.Contains(t, result, "email,varchar(255),0.0%,1,,,15.0,,email(100.0%),已脱敏，不显示取值\n")
+		assert.NotContains(t, result, "ann@")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("invalid name", func(t *testing.T) {
+		// 调用 HandleProfileTable
+		_, err := HandleProfileTable("users; DROP TABLE users", 0, RouteAuto)
+
+		// 验证结果
+		assert.Error(t, err)
+	})
+}
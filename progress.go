@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// ProgressInterval 为发送进度通知的间隔，0 表示不发送
+var ProgressInterval time.Duration
+
+// progressConn 是 *sqlx.DB 和 *sqlx.Conn 共有的方法
+type progressConn interface {
+	QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Progress 在长时间执行的工具调用期间向客户端发送 notifications/progress。
+// 只有客户端在请求中提供了 progressToken 时才会创建，nil 表示不报告进度
+type Progress struct {
+	ctx      context.Context
+	token    mcp.ProgressToken
+	interval time.Duration
+	send     func(params map[string]interface{}) error
+
+	rows     atomic.Int64
+	mu       sync.Mutex
+	last     float64
+	fallback bool
+}
+
+// NewProgress 根据请求中的 progressToken 创建进度报告，客户端没有请求进度或未启用时返回 nil
+func NewProgress(ctx context.Context, request mcp.CallToolRequest) *Progress {
+	if ProgressInterval <= 0 || request.Params.Meta == nil || request.Params.Meta.ProgressToken == nil {
+		return nil
+	}
+	srv := server.ServerFromContext(ctx)
+	if srv == nil {
+		return nil
+	}
+
+	return &Progress{
+		ctx:      ctx,
+		token:    request.Params.Meta.ProgressToken,
+		interval: ProgressInterval,
+		send: func(params map[string]interface{}) error {
+			return srv.SendNotificationToClient(ctx, "notifications/progress", params)
+		},
+	}
+}
+
+// Context 返回工具调用的上下文
+func (p *Progress) Context() context.Context {
+	if p == nil {
+		return context.Background()
+	}
+
+	return p.ctx
+}
+
+// AddRows 记录已经返回给客户端的行数
+func (p *Progress) AddRows(n int64) {
+	if p != nil {
+		p.rows.Add(n)
+	}
+}
+
+// Track 执行 fn，报告进度时 fn 在 db 的一个独占连接上执行，以便按连接 ID 查询语句的执行状态，
+// 执行期间每隔 interval 发送一次已执行时间、已扫描行数、已返回行数和当前阶段
+func (p *Progress) Track(db *sqlx.DB, fn func(conn progressConn) error) error {
+	if p == nil {
+		return fn(db)
+	}
+
+	conn, err := db.Connx(p.ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var connectionID int64
+	if err := conn.GetContext(p.ctx, &connectionID, "SELECT CONNECTION_ID()"); err != nil {
+		return fmt.Errorf("读取连接 ID 失败: %v", err)
+	}
+
+	ctx, stop := context.WithCancel(p.ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.watch(ctx, db, connectionID)
+	}()
+	defer func() {
+		stop()
+		<-done
+	}()
+
+	return fn(conn)
+}
+
+func (p *Progress) watch(ctx context.Context, db *sqlx.DB, connectionID int64) {
+	start := time.Now()
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			status := p.statementStatus(ctx, db, connectionID)
+			if ctx.Err() != nil {
+				return
+			}
+			p.report(time.Since(start), connectionID, status)
+		}
+	}
+}
+
+// statementStatus 是执行中的语句的状态，来自 performance_schema，不可用时只有 information_schema.PROCESSLIST 中的 State
+type statementStatus struct {
+	RowsExamined  int64  `db:"ROWS_EXAMINED"`
+	RowsSent      int64  `db:"ROWS_SENT"`
+	Stage         string `db:"STAGE"`
+	WorkCompleted int64  `db:"WORK_COMPLETED"`
+	WorkEstimated int64  `db:"WORK_ESTIMATED"`
+	Available     bool
+}
+
+// statementStatus 查询连接上正在执行的语句的状态。performance_schema 查询失败（未启用或没有权限）后
+// 改用 information_schema.PROCESSLIST，只能得到当前状态；两者都失败时返回空状态，只报告时间和返回行数
+func (p *Progress) statementStatus(ctx context.Context, db *sqlx.DB, connectionID int64) statementStatus {
+	var status statementStatus
+	if !p.fallback {
+		err := db.GetContext(ctx, &status, `SELECT s.ROWS_EXAMINED, s.ROWS_SENT,
+  IFNULL(g.EVENT_NAME, '') AS STAGE, IFNULL(g.WORK_COMPLETED, 0) AS WORK_COMPLETED, IFNULL(g.WORK_ESTIMATED, 0) AS WORK_ESTIMATED
+FROM performance_schema.threads t
+JOIN performance_schema.events_statements_current s ON s.THREAD_ID = t.THREAD_ID
+LEFT JOIN performance_schema.events_stages_current g ON g.THREAD_ID = t.THREAD_ID
+WHERE t.PROCESSLIST_ID = ?`, connectionID)
+		if err == nil {
+			status.Stage = strings.TrimPrefix(status.Stage, "stage/")
+			status.Available = true
+			return status
+		}
+		if ctx.Err() != nil {
+			return statementStatus{}
+		}
+		p.fallback = true
+	}
+
+	var state string
+	if err := db.GetContext(ctx, &state, "SELECT IFNULL(STATE, '') FROM information_schema.PROCESSLIST WHERE ID = ?", connectionID); err == nil {
+		return statementStatus{Stage: state}
+	}
+
+	return statementStatus{}
+}
+
+// report 发送一次进度通知。阶段有工作量估计时（例如 ALTER TABLE 复制数据）progress/total 为已完成和预计的工作量，
+// 否则 progress 为已执行的秒数；progress 只增不减
+func (p *Progress) report(elapsed time.Duration, connectionID int64, status statementStatus) {
+	parts := []string{fmt.Sprintf("已执行 %v", elapsed.Round(time.Second))}
+	if status.Available {
+		parts = append(parts, fmt.Sprintf("已扫描 %d 行", status.RowsExamined))
+	}
+	parts = append(parts, fmt.Sprintf("已返回 %d 行", max(p.rows.Load(), status.RowsSent)))
+	if status.Stage != "" {
+		stage := status.Stage
+		if status.WorkEstimated > 0 {
+			stage += fmt.Sprintf("（%d/%d）", status.WorkCompleted, status.WorkEstimated)
+		}
+		parts = append(parts, "阶段: "+stage)
+	}
+	parts = append(parts, fmt.Sprintf("连接 ID %d", connectionID))
+
+	p.mu.Lock()
+	value, total := elapsed.Seconds(), 0.0
+	if status.WorkEstimated > 0 {
+		value, total = float64(status.WorkCompleted), float64(status.WorkEstimated)
+	}
+	p.last = max(p.last, value)
+	params := map[string]interface{}{
+		"progressToken": p.token,
+		"progress":      p.last,
+		"message":       strings.Join(parts, "，"),
+	}
+	if total > 0 {
+		params["total"] = total
+	}
+	p.mu.Unlock()
+
+	// 通知发送失败（例如客户端已断开）不影响语句执行
+	p.send(params)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestProgress 创建把通知记录下来的进度报告
+func newTestProgress(interval time.Duration) (*Progress, func() []map[string]interface{}) {
+	var mu sync.Mutex
+	sent := []map[string]interface{}{}
+	p := &Progress{
+		ctx:      context.Background(),
+		token:    "token-1",
+		interval: interval,
+		send: func(params map[string]interface{}) error {
+			mu.Lock()
+			defer mu.Unlock()
+			sent = append(sent, params)
+			return nil
+		},
+	}
+
+	return p, func() []map[string]interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]map[string]interface{}{}, sent...)
+	}
+}
+
+func TestNewProgress(t *testing.T) {
+	original := ProgressInterval
+	defer func() { ProgressInterval = original }()
+	ProgressInterval = time.Second
+
+	// 客户端没有提供 progressToken
+	assert.Nil(t, NewProgress(context.Background(), mcp.CallToolRequest{}))
+
+	// 不在 MCP 服务器的请求上下文中
+	request := mcp.CallToolRequest{}
+	request.Params.Meta = &struct {
+		ProgressToken mcp.ProgressToken `json:"progressToken,omitempty"`
+	}{ProgressToken: "token-1"}
+	assert.Nil(t, NewProgress(context.Background(), request))
+
+	// nil 表示不报告进度，方法可以直接调用
+	var p *Progress
+	p.AddRows(1)
+	assert.NotNil(t, p.Context())
+}
+
+func TestProgressReport(t *testing.T) {
+	p, sent := newTestProgress(time.Second)
+	p.AddRows(5)
+
+	// 调用 report
+	p.report(3*time.Second, 42, statementStatus{RowsExamined: 1200, RowsSent: 2, Stage: "sql/executing", Available: true})
+	p.report(4*time.Second, 42, statementStatus{Stage: "innodb/alter table (read PK and internal sort)", WorkCompleted: 30, WorkEstimated: 100})
+	p.report(5*time.Second, 42, statementStatus{})
+	p.report(40*time.Second, 42, statementStatus{})
+
+	// 验证结果
+	notifications := sent()
+	assert.Len(t, notifications, 4)
+	assert.Equal(t, "token-1", notifications[0]["progressToken"])
+	assert.Equal(t, 3.0, notifications[0]["progress"])
+	assert.Equal(t, "已执行 3s，已扫描 1200 行，已返回 5 行，阶段: sql/executing，连接 ID 42", notifications[0]["message"])
+	assert.NotContains(t, notifications[0], "total")
+
+	assert.Equal(t, 30.0, notifications[1]["progress"])
+	assert.Equal(t, 100.0, notifications[1]["total"])
+	assert.Contains(t, notifications[1]["message"], "阶段: innodb/alter table (read PK and internal sort)（30/100）")
+
+	// progress 只增不减
+	assert.Equal(t, 30.0, notifications[2]["progress"])
+	assert.Equal(t, 40.0, notifications[3]["progress"])
+}
+
+func TestStatementStatus(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	sqlxDB := sqlx.NewDb(db, "sqlmock")
+	p, _ := newTestProgress(time.Second)
+
+	// 设置模拟预期
+	mock.ExpectQuery("FROM performance_schema.threads").WithArgs(int64(42)).
+		WillReturnRows(sqlmock.NewRows([]string{"ROWS_EXAMINED", "ROWS_SENT", "STAGE", "WORK_COMPLETED", "WORK_ESTIMATED"}).
+			AddRow(5000, 10, "stage/sql/executing", 0, 0))
+	mock.ExpectQuery("FROM performance_schema.threads").WithArgs(int64(42)).
+		WillReturnError(fmt.Errorf("SELECT command denied to user"))
+	mock.ExpectQuery("FROM information_schema.PROCESSLIST").WithArgs(int64(42)).
+		WillReturnRows(sqlmock.NewRows([]string{"STATE"}).AddRow("Sending data"))
+	mock.ExpectQuery("FROM information_schema.PROCESSLIST").WithArgs(int64(42)).
+		WillReturnRows(sqlmock.NewRows([]string{"STATE"}).AddRow("Sorting result"))
+
+	// 调用 statementStatus
+	status := p.statementStatus(context.Background(), sqlxDB, 42)
+	assert.Equal(t, statementStatus{RowsExamined: 5000, RowsSent: 10, Stage: "sql/executing", Available: true}, status)
+
+	// performance_schema 不可用时改用 PROCESSLIST，之后不再尝试 performance_schema
+	status = p.statementStatus(context.Background(), sqlxDB, 42)
+	assert.Equal(t, statementStatus{Stage: "Sending data"}, status)
+	status = p.statementStatus(context.Background(), sqlxDB, 42)
+	assert.Equal(t, statementStatus{Stage: "Sorting result"}, status)
+
+	// 验证结果
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDoQueryProgress(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+	mock.MatchExpectationsInOrder(false)
+	p, sent := newTestProgress(20 * time.Millisecond)
+
+	// 设置模拟预期
+	mock.ExpectQuery("SELECT CONNECTION_ID()").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+	mock.ExpectQuery("SELECT id FROM orders").WillDelayFor(150 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectQuery("FROM performance_schema.threads").WithArgs(int64(42)).
+		WillReturnRows(sqlmock.NewRows([]string{"ROWS_EXAMINED", "ROWS_SENT", "STAGE", "WORK_COMPLETED", "WORK_ESTIMATED"}).
+			AddRow(800, 0, "stage/sql/executing", 0, 0))
+
+	// 调用 DoQuery
+	result, _, err := DoQuery("SELECT id FROM orders", StatementTypeNoExplainCheck, RouteAuto, p)
+
+	// 验证结果
+	assert.NoError(t, err)
+	assert.Len(t, result, 2)
+	notifications := sent()
+	if assert.NotEmpty(t, notifications) {
+		assert.Contains(t, notifications[0]["message"], "已扫描 800 行")
+		assert.Contains(t, notifications[0]["message"], "连接 ID 42")
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
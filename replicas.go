@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	ReplicaStrategyRoundRobin = "round-robin"
+	ReplicaStrategyLeastLag   = "least-lag"
+
+	// RouteAuto 把读请求发往副本，没有可用副本时回退到主库；RoutePrimary 和 RouteReplica 为单次调用指定目标
+	RouteAuto    = ""
+	RoutePrimary = "primary"
+	RouteReplica = "replica"
+)
+
+// 副本参数，ReplicaDSNs 为空时所有请求都发往主库
+var (
+	ReplicaDSNs             ReplicaFlag
+	ReplicaStrategy         string
+	ReplicaMaxLag           time.Duration
+	ReplicaLagCheckInterval time.Duration
+
+	Replicas *ReplicaSet
+)
+
+var (
+	errNoReplication      = errors.New("未配置复制")
+	errReplicationStopped = errors.New("复制未运行")
+)
+
+// ReplicaFlag 解析可重复的 `--replica DSN` 参数
+type ReplicaFlag []string
+
+func (r *ReplicaFlag) String() string {
+	return fmt.Sprintf("%d", len(*r))
+}
+
+func (r *ReplicaFlag) Set(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return errors.New("副本 DSN 不能为空")
+	}
+	*r = append(*r, value)
+
+	return nil
+}
+
+// Replica 是一个只读副本。连接在第一次使用时建立，可用性和延迟检查的结果缓存 LagCheckInterval
+type Replica struct {
+	Name string
+	dsn  string
+
+	mu        sync.Mutex
+	db        *sqlx.DB
+	lag       time.Duration
+	err       error
+	checkedAt time.Time
+}
+
+// ReplicaSet 按策略为读请求选择副本
+type ReplicaSet struct {
+	Replicas         []*Replica
+	Strategy         string
+	MaxLag           time.Duration
+	LagCheckInterval time.Duration
+
+	next     atomic.Uint64
+	provider CredentialProvider
+	now      func() time.Time
+}
+
+// NewReplicaSet 创建副本集合，副本使用与主库相同的凭据提供者
+func NewReplicaSet(dsns []string, strategy string, maxLag, lagCheckInterval time.Duration, provider CredentialProvider) (*ReplicaSet, error) {
+	switch strategy {
+	case ReplicaStrategyRoundRobin, ReplicaStrategyLeastLag:
+	default:
+		return nil, fmt.Errorf("无效的 --replica-strategy: %s（可选 round-robin、least-lag）", strategy)
+	}
+
+	set := &ReplicaSet{Strategy: strategy, MaxLag: maxLag, LagCheckInterval: lagCheckInterval, provider: provider}
+	for _, dsn := range dsns {
+		cfg, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("解析副本 DSN 失败: %v", err)
+		}
+		set.Replicas = append(set.Replicas, &Replica{Name: cfg.Addr, dsn: dsn})
+	}
+
+	return set, nil
+}
+
+func (s *ReplicaSet) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+
+	return time.Now()
+}
+
+// needsLag 表示选择副本时是否需要知道复制延迟
+func (s *ReplicaSet) needsLag() bool {
+	return s.Strategy == ReplicaStrategyLeastLag || s.MaxLag > 0
+}
+
+// status 返回副本的连接和复制延迟，连接失败、Ping 失败或延迟检查失败时返回错误。
+// 结果在 LagCheckInterval 内被复用，避免每次查询都检查副本
+func (s *ReplicaSet) status(r *Replica) (*sqlx.DB, time.Duration, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := s.clock()
+	if !r.checkedAt.IsZero() && now.Sub(r.checkedAt) < s.LagCheckInterval {
+		return r.db, r.lag, r.err
+	}
+
+	r.checkedAt = now
+	if r.db == nil {
+		db, err := openDB(r.dsn, s.provider, true)
+		if err != nil {
+			r.err = fmt.Errorf("连接副本 %s 失败: %v", r.Name, err)
+			return nil, 0, r.err
+		}
+		r.db = db
+	}
+
+	r.err = nil
+	if s.needsLag() {
+		r.lag, r.err = ReplicationLag(r.db)
+		if r.err != nil {
+			r.err = fmt.Errorf("副本 %s: %v", r.Name, r.err)
+		}
+	} else if err := r.db.Ping(); err != nil {
+		r.err = fmt.Errorf("副本 %s 不可用: %v", r.Name, err)
+	}
+
+	return r.db, r.lag, r.err
+}
+
+// Pick 按策略选择一个可用的副本：连接失败、复制未运行或延迟超过 MaxLag 的副本会被跳过
+func (s *ReplicaSet) Pick() (*sqlx.DB, *Replica, error) {
+	type candidate struct {
+		replica *Replica
+		db      *sqlx.DB
+		lag     time.Duration
+	}
+
+	n := len(s.Replicas)
+	start := 0
+	if s.Strategy == ReplicaStrategyRoundRobin {
+		start = int((s.next.Add(1) - 1) % uint64(n))
+	}
+
+	candidates := []candidate{}
+	reasons := []string{}
+	for i := 0; i < n; i++ {
+		r := s.Replicas[(start+i)%n]
+		db, lag, err := s.status(r)
+		switch {
+		case err != nil:
+			reasons = append(reasons, err.Error())
+		case s.MaxLag > 0 && lag > s.MaxLag:
+			reasons = append(reasons, fmt.Sprintf("副本 %s 延迟 %v，超过 %v", r.Name, lag, s.MaxLag))
+		default:
+			candidates = append(candidates, candidate{r, db, lag})
+			if s.Strategy == ReplicaStrategyRoundRobin {
+				return db, r, nil
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, nil, fmt.Errorf("没有可用的副本: %s", strings.Join(reasons, "; "))
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].lag < candidates[j].lag })
+
+	return candidates[0].db, candidates[0].replica, nil
+}
+
+// opened 返回已经建立连接的副本
+func (s *ReplicaSet) opened() map[string]*sqlx.DB {
+	conns := map[string]*sqlx.DB{}
+	for _, r := range s.Replicas {
+		r.mu.Lock()
+		if r.db != nil {
+			conns[r.Name] = r.db
+		}
+		r.mu.Unlock()
+	}
+
+	return conns
+}
+
+// ReplicationLag 读取 SHOW REPLICA STATUS 中的 Seconds_Behind_Source，
+// MySQL 8.0.22 之前的版本使用 SHOW SLAVE STATUS 和 Seconds_Behind_Master
+func ReplicationLag(db *sqlx.DB) (time.Duration, error) {
+	rows, err := db.Queryx("SHOW REPLICA STATUS")
+	if err != nil {
+		if rows, err = db.Queryx("SHOW SLAVE STATUS"); err != nil {
+			return 0, fmt.Errorf("读取复制状态失败: %v", err)
+		}
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return 0, errNoReplication
+	}
+	status := map[string]interface{}{}
+	if err := rows.MapScan(status); err != nil {
+		return 0, fmt.Errorf("读取复制状态失败: %v", err)
+	}
+
+	for _, column := range []string{"Seconds_Behind_Source", "Seconds_Behind_Master"} {
+		value, ok := status[column]
+		if !ok {
+			continue
+		}
+		if value == nil {
+			return 0, errReplicationStopped
+		}
+		if b, ok := value.([]byte); ok {
+			value = string(b)
+		}
+		seconds, err := strconv.ParseInt(fmt.Sprint(value), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("无法解析复制延迟 %v", value)
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	return 0, errors.New("复制状态中没有延迟信息")
+}
+
+// ValidateRoute 检查工具参数中的 route
+func ValidateRoute(route string) error {
+	switch route {
+	case RouteAuto, RoutePrimary, RouteReplica:
+		return nil
+	}
+
+	return fmt.Errorf("无效的 route: %s（可选 primary、replica，留空自动选择）", route)
+}
+
+// lockingRead 判断查询是否带有 FOR UPDATE、FOR SHARE 或 LOCK IN SHARE MODE，
+// 这类查询属于事务的一部分，需要在主库上执行
+func lockingRead(query string) bool {
+	tokens := TokenizeSQL(query)
+	for i := 0; i+1 < len(tokens); i++ {
+		if tokens[i].IsKeyword("FOR") && tokens[i+1].IsKeyword("UPDATE", "SHARE") {
+			return true
+		}
+		if tokens[i].IsKeyword("LOCK") && tokens[i+1].IsKeyword("IN") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetReadDB 返回执行只读请求的连接。route 为空时优先使用副本，没有配置副本或没有可用副本时
+// 回退到主库；为 primary 时总是使用主库；为 replica 时必须使用副本
+func GetReadDB(route string) (*sqlx.DB, error) {
+	if err := ValidateRoute(route); err != nil {
+		return nil, err
+	}
+
+	if route == RoutePrimary || Replicas == nil || len(Replicas.Replicas) == 0 {
+		if route == RouteReplica {
+			return nil, errors.New("没有配置副本，请使用 --replica 指定")
+		}
+		return GetDB()
+	}
+
+	db, _, err := Replicas.Pick()
+	if err != nil {
+		if route == RouteReplica {
+			return nil, err
+		}
+		log.Printf("%v，改用主库", err)
+		return GetDB()
+	}
+
+	return db, nil
+}
+
+// GetQueryDB 为 read_query 选择连接，自动路由时带锁的读取发往主库
+func GetQueryDB(query, route string) (*sqlx.DB, error) {
+	if route == RouteAuto && lockingRead(query) {
+		route = RoutePrimary
+	}
+
+	return GetReadDB(route)
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupReplicas 创建 n 个使用模拟连接的副本并设置为全局副本集合
+func setupReplicas(t *testing.T, strategy string, maxLag time.Duration, n int) (*ReplicaSet, []sqlmock.Sqlmock) {
+	set := &ReplicaSet{Strategy: strategy, MaxLag: maxLag, LagCheckInterval: 5 * time.Second}
+	mocks := []sqlmock.Sqlmock{}
+	for i := 0; i < n; i++ {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("创建模拟数据库失败: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		set.Replicas = append(set.Replicas, &Replica{Name: fmt.Sprintf("replica%d:3306", i+1), db: sqlx.NewDb(db, "sqlmock")})
+		mocks = append(mocks, mock)
+	}
+
+	original := Replicas
+	Replicas = set
+	t.Cleanup(func() { Replicas = original })
+
+	return set, mocks
+}
+
+func expectLag(mock sqlmock.Sqlmock, lag interface{}) {
+	mock.ExpectQuery("SHOW REPLICA STATUS").
+		WillReturnRows(sqlmock.NewRows([]string{"Replica_IO_Running", "Seconds_Behind_Source"}).AddRow("Yes", lag))
+}
+
+func TestReplicationLag(t *testing.T) {
+	t.Run("seconds behind source", func(t *testing.T) {
+		set, mocks := setupReplicas(t, ReplicaStrategyLeastLag, 0, 1)
+		expectLag(mocks[0], "3")
+
+		// 调用 ReplicationLag
+		lag, err := ReplicationLag(set.Replicas[0].db)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, 3*time.Second, lag)
+	})
+
+	t.Run("falls back to SHOW SLAVE STATUS", func(t *testing.T) {
+		set, mocks := setupReplicas(t, ReplicaStrategyLeastLag, 0, 1)
+		mocks[0].ExpectQuery("SHOW REPLICA STATUS").WillReturnError(fmt.Errorf("You have an error in your SQL syntax"))
+		mocks[0].ExpectQuery("SHOW SLAVE STATUS").
+			WillReturnRows(sqlmock.NewRows([]string{"Seconds_Behind_Master"}).AddRow(int64(12)))
+
+		// 调用 ReplicationLag
+		lag, err := ReplicationLag(set.Replicas[0].db)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, 12*time.Second, lag)
+	})
+
+	t.Run("replication stopped", func(t *testing.T) {
+		set, mocks := setupReplicas(t, ReplicaStrategyLeastLag, 0, 1)
+		expectLag(mocks[0], nil)
+
+		// 调用 ReplicationLag
+		_, err := ReplicationLag(set.Replicas[0].db)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "复制未运行")
+	})
+
+	t.Run("not a replica", func(t *testing.T) {
+		set, mocks := setupReplicas(t, ReplicaStrategyLeastLag, 0, 1)
+		mocks[0].ExpectQuery("SHOW REPLICA STATUS").WillReturnRows(sqlmock.NewRows([]string{"Seconds_Behind_Source"}))
+
+		// 调用 ReplicationLag
+		_, err := ReplicationLag(set.Replicas[0].db)
+
+		// 验证结果
+		assert.Error(t, err)
+	})
+}
+
+func TestReplicaSetPick(t *testing.T) {
+	t.Run("round robin", func(t *testing.T) {
+		set, _ := setupReplicas(t, ReplicaStrategyRoundRobin, 0, 2)
+
+		picked := []string{}
+		for i := 0; i < 3; i++ {
+			// 调用 Pick
+			_, replica, err := set.Pick()
+			assert.NoError(t, err)
+			picked = append(picked, replica.Name)
+		}
+
+		// 验证结果
+		assert.Equal(t, []string{"replica1:3306", "replica2:3306", "replica1:3306"}, picked)
+	})
+
+	t.Run("least lag", func(t *testing.T) {
+		set, mocks := setupReplicas(t, ReplicaStrategyLeastLag, 0, 3)
+		expectLag(mocks[0], "30")
+		expectLag(mocks[1], "2")
+		expectLag(mocks[2], "9")
+
+		// 调用 Pick
+		db, replica, err := set.Pick()
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, "replica2:3306", replica.Name)
+		assert.Same(t, set.Replicas[1].db, db)
+
+		// 检查结果在 LagCheckInterval 内被复用，不会再次查询复制状态
+		_, replica, err = set.Pick()
+		assert.NoError(t, err)
+		assert.Equal(t, "replica2:3306", replica.Name)
+		for _, mock := range mocks {
+			assert.NoError(t, mock.ExpectationsWereMet())
+		}
+	})
+
+	t.Run("skips lagging and broken replicas", func(t *testing.T) {
+		set, mocks := setupReplicas(t, ReplicaStrategyRoundRobin, 10*time.Second, 3)
+		expectLag(mocks[0], "45")
+		expectLag(mocks[1], nil)
+		expectLag(mocks[2], "1")
+
+		// 调用 Pick
+		_, replica, err := set.Pick()
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, "replica3:3306", replica.Name)
+	})
+
+	t.Run("no healthy replica", func(t *testing.T) {
+		set, mocks := setupReplicas(t, ReplicaStrategyLeastLag, 10*time.Second, 2)
+		expectLag(mocks[0], "45")
+		mocks[1].ExpectQuery("SHOW REPLICA STATUS").WillReturnError(fmt.Errorf("access denied"))
+		mocks[1].ExpectQuery("SHOW SLAVE STATUS").WillReturnError(fmt.Errorf("access denied"))
+
+		// 调用 Pick
+		_, _, err := set.Pick()
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "replica1:3306 延迟 45s")
+		assert.Contains(t, err.Error(), "access denied")
+	})
+
+	t.Run("rechecks after interval", func(t *testing.T) {
+		set, mocks := setupReplicas(t, ReplicaStrategyLeastLag, 10*time.Second, 1)
+		now := time.Now()
+		set.now = func() time.Time { return now }
+		expectLag(mocks[0], "45")
+		expectLag(mocks[0], "0")
+
+		_, _, err := set.Pick()
+		assert.Error(t, err)
+
+		now = now.Add(6 * time.Second)
+		_, replica, err := set.Pick()
+		assert.NoError(t, err)
+		assert.Equal(t, "replica1:3306", replica.Name)
+	})
+}
+
+func TestDoQueryRouting(t *testing.T) {
+	_, primary, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	rows := func() *sqlmock.Rows { return sqlmock.NewRows([]string{"id"}).AddRow(1) }
+
+	t.Run("reads go to replica", func(t *testing.T) {
+		_, mocks := setupReplicas(t, ReplicaStrategyRoundRobin, 0, 1)
+		// 设置模拟预期
+		mocks[0].ExpectQuery("SELECT id FROM users").WillReturnRows(rows())
+
+		// 调用 HandleQuery
+		_, err := HandleQuery("SELECT id FROM users", StatementTypeNoExplainCheck, RouteAuto, nil)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.NoError(t, mocks[0].ExpectationsWereMet())
+		assert.NoError(t, primary.ExpectationsWereMet())
+	})
+
+	t.Run("locking read goes to primary", func(t *testing.T) {
+		_, mocks := setupReplicas(t, ReplicaStrategyRoundRobin, 0, 1)
+		// 设置模拟预期
+		primary.ExpectQuery("SELECT id FROM users WHERE id = 1 FOR UPDATE").WillReturnRows(rows())
+
+		// 调用 HandleQuery
+		_, err := HandleQuery("SELECT id FROM users WHERE id = 1 FOR UPDATE", StatementTypeNoExplainCheck, RouteAuto, nil)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.NoError(t, primary.ExpectationsWereMet())
+		assert.NoError(t, mocks[0].ExpectationsWereMet())
+	})
+
+	t.Run("route override", func(t *testing.T) {
+		_, mocks := setupReplicas(t, ReplicaStrategyRoundRobin, 0, 1)
+		// 设置模拟预期
+		primary.ExpectQuery("SHOW TABLES").WillReturnRows(sqlmock.NewRows([]string{"Tables_in_shop"}).AddRow("users"))
+
+		// 调用 HandleListTable
+		result, err := HandleListTable(RoutePrimary)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "users")
+		assert.NoError(t, primary.ExpectationsWereMet())
+		assert.NoError(t, mocks[0].ExpectationsWereMet())
+	})
+
+	t.Run("falls back to primary", func(t *testing.T) {
+		_, mocks := setupReplicas(t, ReplicaStrategyRoundRobin, time.Second, 1)
+		// 设置模拟预期
+		expectLag(mocks[0], nil)
+		primary.ExpectQuery("SELECT id FROM users").WillReturnRows(rows())
+
+		// 调用 HandleQuery
+		_, err := HandleQuery("SELECT id FROM users", StatementTypeNoExplainCheck, RouteAuto, nil)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.NoError(t, primary.ExpectationsWereMet())
+
+		// 强制使用副本时不回退
+		_, err = HandleQuery("SELECT id FROM users", StatementTypeNoExplainCheck, RouteReplica, nil)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "复制未运行")
+	})
+
+	t.Run("invalid route", func(t *testing.T) {
+		// 调用 HandleQuery
+		_, err := HandleQuery("SELECT id FROM users", StatementTypeNoExplainCheck, "nearest", nil)
+
+		// 验证结果
+		assert.Error(t, err)
+	})
+
+	t.Run("replica route without replicas", func(t *testing.T) {
+		// 调用 HandleQuery
+		_, err := HandleQuery("SELECT id FROM users", StatementTypeNoExplainCheck, RouteReplica, nil)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "--replica")
+	})
+}
+
+func TestLockingRead(t *testing.T) {
+	for query, expected := range map[string]bool{
+		"SELECT * FROM t WHERE id = 1 FOR UPDATE":         true,
+		"SELECT * FROM t FOR SHARE NOWAIT":                true,
+		"SELECT * FROM t LOCK IN SHARE MODE":              true,
+		"SELECT 'for update' FROM t":                      false,
+		"SELECT * FROM t /* FOR UPDATE */":                false,
+		"SELECT `for`, `update` FROM t ORDER BY `for`":    false,
+		"SELECT * FROM orders WHERE note = 'lock in' + 1": false,
+	} {
+		assert.Equal(t, expected, lockingRead(query), query)
+	}
+}
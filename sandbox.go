@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DataDir 是导入导出文件所在的沙箱目录，工具只能读写该目录下的文件
+var DataDir string
+
+// ResolveDataPath 将工具参数中的文件名解析为沙箱目录内的绝对路径。
+// 拒绝绝对路径、`..` 以及指向沙箱目录之外的符号链接
+func ResolveDataPath(name string) (string, error) {
+	if DataDir == "" {
+		return "", fmt.Errorf("未配置数据目录，请使用 --data-dir 指定")
+	}
+	if name == "" {
+		return "", fmt.Errorf("文件名不能为空")
+	}
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") || strings.HasPrefix(name, `\`) {
+		return "", fmt.Errorf("文件名必须是数据目录内的相对路径: %s", name)
+	}
+
+	root, err := filepath.Abs(DataDir)
+	if err != nil {
+		return "", fmt.Errorf("解析数据目录失败: %v", err)
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return "", fmt.Errorf("解析数据目录失败: %v", err)
+	}
+
+	path := filepath.Join(root, name)
+	if !withinDir(root, path) {
+		return "", fmt.Errorf("文件名不能指向数据目录之外: %s", name)
+	}
+
+	// 已存在的路径部分可能是符号链接，逐级解析后再检查一次
+	resolved, err := evalExistingSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("解析文件路径失败: %v", err)
+	}
+	if !withinDir(root, resolved) {
+		return "", fmt.Errorf("文件名不能指向数据目录之外: %s", name)
+	}
+
+	return resolved, nil
+}
+
+func withinDir(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// evalExistingSymlinks 解析路径中已存在的最长前缀，保留尚不存在的部分
+func evalExistingSymlinks(path string) (string, error) {
+	rest := ""
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+
+		parent := filepath.Dir(path)
+		if parent == path {
+			return filepath.Join(path, rest), nil
+		}
+		rest = filepath.Join(filepath.Base(path), rest)
+		path = parent
+	}
+}
+
+// CreateDataFile 创建 ResolveDataPath 解析出的文件。O_EXCL 防止覆盖已有文件，
+// 也防止跟随指向沙箱外的悬空符号链接
+func CreateDataFile(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("创建目录失败: %v", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("创建文件失败: %v", err)
+	}
+
+	return file, nil
+}
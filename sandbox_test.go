@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupDataDir(t *testing.T) string {
+	dir := t.TempDir()
+
+	originalDir := DataDir
+	DataDir = dir
+	t.Cleanup(func() { DataDir = originalDir })
+
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatalf("解析临时目录失败: %v", err)
+	}
+
+	return resolved
+}
+
+func TestResolveDataPath(t *testing.T) {
+	dir := setupDataDir(t)
+
+	t.Run("relative path", func(t *testing.T) {
+		path, err := ResolveDataPath("reports/orders.csv")
+
+		assert.NoError(t, err)
+		assert.Equal(t, filepath.Join(dir, "reports", "orders.csv"), path)
+	})
+
+	t.Run("rejects escapes", func(t *testing.T) {
+		for _, name := range []string{"../x.csv", "a/../../x.csv", "/etc/passwd", ""} {
+			_, err := ResolveDataPath(name)
+			assert.Error(t, err, name)
+		}
+	})
+
+	t.Run("rejects symlink out of sandbox", func(t *testing.T) {
+		outside := t.TempDir()
+		if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+			t.Skipf("无法创建符号链接: %v", err)
+		}
+
+		_, err := ResolveDataPath("link/x.csv")
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "数据目录之外")
+	})
+
+	t.Run("not configured", func(t *testing.T) {
+		DataDir = ""
+		defer func() { DataDir = dir }()
+
+		_, err := ResolveDataPath("x.csv")
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "--data-dir")
+	})
+}
@@ -0,0 +1,521 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type SchemaColumn struct {
+	TableName  string  `db:"TABLE_NAME"`
+	Name       string  `db:"COLUMN_NAME"`
+	Position   int     `db:"ORDINAL_POSITION"`
+	Type       string  `db:"COLUMN_TYPE"`
+	Nullable   string  `db:"IS_NULLABLE"`
+	Default    *string `db:"COLUMN_DEFAULT"`
+	Extra      string  `db:"EXTRA"`
+	Comment    string  `db:"COLUMN_COMMENT"`
+	Collation  *string `db:"COLLATION_NAME"`
+	Expression *string `db:"GENERATION_EXPRESSION"`
+}
+
+type SchemaIndexRow struct {
+	TableName string `db:"TABLE_NAME"`
+	IndexStatisticsRow
+}
+
+type SchemaForeignKeyRow struct {
+	TableName        string `db:"TABLE_NAME"`
+	Name             string `db:"CONSTRAINT_NAME"`
+	Column           string `db:"COLUMN_NAME"`
+	ReferencedSchema string `db:"REFERENCED_TABLE_SCHEMA"`
+	ReferencedTable  string `db:"REFERENCED_TABLE_NAME"`
+	ReferencedColumn string `db:"REFERENCED_COLUMN_NAME"`
+	UpdateRule       string `db:"UPDATE_RULE"`
+	DeleteRule       string `db:"DELETE_RULE"`
+}
+
+type SchemaForeignKey struct {
+	Name    string
+	Columns []string
+	// ReferencedSchema 仅在引用其他数据库的表时非空，引用同库的表时为空，
+	// 这样比较两个不同名的数据库时，同库内的外键不会被视为不同
+	ReferencedSchema  string
+	ReferencedTable   string
+	ReferencedColumns []string
+	UpdateRule        string
+	DeleteRule        string
+}
+
+type SchemaTable struct {
+	Name        string
+	Columns     []SchemaColumn
+	Indexes     []TableIndex
+	ForeignKeys []SchemaForeignKey
+}
+
+type SchemaSnapshot struct {
+	Schema string
+	Tables map[string]*SchemaTable
+}
+
+// LoadSchemaSnapshot 从 information_schema 读取数据库中所有表的列、索引和外键
+func LoadSchemaSnapshot(db *sqlx.DB, schema string) (*SchemaSnapshot, error) {
+	snapshot := &SchemaSnapshot{Schema: schema, Tables: map[string]*SchemaTable{}}
+
+	var exists int
+	if err := db.Get(&exists, "SELECT COUNT(*) FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = ?", schema); err != nil {
+		return nil, fmt.Errorf("读取数据库 %s 失败: %v", schema, err)
+	}
+	if exists == 0 {
+		return nil, fmt.Errorf("数据库 %s 不存在", schema)
+	}
+
+	tables := []string{}
+	if err := db.Select(&tables, "SELECT TABLE_NAME FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_TYPE = 'BASE TABLE'", schema); err != nil {
+		return nil, fmt.Errorf("读取 %s 的表失败: %v", schema, err)
+	}
+	for _, name := range tables {
+		snapshot.Tables[name] = &SchemaTable{Name: name}
+	}
+
+	columns := []SchemaColumn{}
+	if err := db.Select(&columns,
+		"SELECT TABLE_NAME, COLUMN_NAME, ORDINAL_POSITION, COLUMN_TYPE, IS_NULLABLE, COLUMN_DEFAULT, EXTRA, COLUMN_COMMENT, COLLATION_NAME, GENERATION_EXPRESSION "+
+			"FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = ? ORDER BY TABLE_NAME, ORDINAL_POSITION", schema); err != nil {
+		return nil, fmt.Errorf("读取 %s 的列失败: %v", schema, err)
+	}
+	for _, col := range columns {
+		if t, ok := snapshot.Tables[col.TableName]; ok {
+			t.Columns = append(t.Columns, col)
+		}
+	}
+
+	indexes := []SchemaIndexRow{}
+	if err := db.Select(&indexes,
+		"SELECT TABLE_NAME, INDEX_NAME, NON_UNIQUE, SEQ_IN_INDEX, COALESCE(COLUMN_NAME, '') AS COLUMN_NAME "+
+			"FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = ? ORDER BY TABLE_NAME, INDEX_NAME, SEQ_IN_INDEX", schema); err != nil {
+		return nil, fmt.Errorf("读取 %s 的索引失败: %v", schema, err)
+	}
+	for _, row := range indexes {
+		t, ok := snapshot.Tables[row.TableName]
+		if !ok {
+			continue
+		}
+		if len(t.Indexes) == 0 || t.Indexes[len(t.Indexes)-1].Name != row.IndexName {
+			t.Indexes = append(t.Indexes, TableIndex{Name: row.IndexName, Unique: row.NonUnique == 0})
+		}
+		last := &t.Indexes[len(t.Indexes)-1]
+		last.Columns = append(last.Columns, row.ColumnName)
+	}
+
+	foreignKeys := []SchemaForeignKeyRow{}
+	if err := db.Select(&foreignKeys,
+		"SELECT k.TABLE_NAME, k.CONSTRAINT_NAME, k.COLUMN_NAME, k.REFERENCED_TABLE_SCHEMA, k.REFERENCED_TABLE_NAME, k.REFERENCED_COLUMN_NAME, r.UPDATE_RULE, r.DELETE_RULE "+
+			"FROM information_schema.KEY_COLUMN_USAGE k JOIN information_schema.REFERENTIAL_CONSTRAINTS r "+
+			"ON r.CONSTRAINT_SCHEMA = k.CONSTRAINT_SCHEMA AND r.CONSTRAINT_NAME = k.CONSTRAINT_NAME "+
+			"WHERE k.TABLE_SCHEMA = ? AND k.REFERENCED_TABLE_NAME IS NOT NULL "+
+			"ORDER BY k.TABLE_NAME, k.CONSTRAINT_NAME, k.ORDINAL_POSITION", schema); err != nil {
+		return nil, fmt.Errorf("读取 %s 的外键失败: %v", schema, err)
+	}
+	for _, row := range foreignKeys {
+		t, ok := snapshot.Tables[row.TableName]
+		if !ok {
+			continue
+		}
+		if len(t.ForeignKeys) == 0 || t.ForeignKeys[len(t.ForeignKeys)-1].Name != row.Name {
+			fk := SchemaForeignKey{
+				Name:            row.Name,
+				ReferencedTable: row.ReferencedTable,
+				UpdateRule:      row.UpdateRule,
+				DeleteRule:      row.DeleteRule,
+			}
+			if row.ReferencedSchema != schema {
+				fk.ReferencedSchema = row.ReferencedSchema
+			}
+			t.ForeignKeys = append(t.ForeignKeys, fk)
+		}
+		last := &t.ForeignKeys[len(t.ForeignKeys)-1]
+		last.Columns = append(last.Columns, row.Column)
+		last.ReferencedColumns = append(last.ReferencedColumns, row.ReferencedColumn)
+	}
+
+	return snapshot, nil
+}
+
+// filterSnapshotAccess 去掉不允许访问的表和列，以及涉及这些对象的索引和外键，
+// 避免通过差异泄露其结构
+func filterSnapshotAccess(snapshot *SchemaSnapshot) {
+	for name, t := range snapshot.Tables {
+		if !TableAccessible(snapshot.Schema, name) {
+			delete(snapshot.Tables, name)
+			continue
+		}
+
+		columns := t.Columns[:0]
+		for _, col := range t.Columns {
+			if columnAccessible(snapshot.Schema, name, col.Name) {
+				columns = append(columns, col)
+			}
+		}
+		t.Columns = columns
+
+		indexes := t.Indexes[:0]
+		for _, idx := range t.Indexes {
+			if columnsAccessible(snapshot.Schema, name, idx.Columns) {
+				indexes = append(indexes, idx)
+			}
+		}
+		t.Indexes = indexes
+
+		foreignKeys := t.ForeignKeys[:0]
+		for _, fk := range t.ForeignKeys {
+			refSchema := fk.ReferencedSchema
+			if refSchema == "" {
+				refSchema = snapshot.Schema
+			}
+			if columnsAccessible(snapshot.Schema, name, fk.Columns) &&
+				TableAccessible(refSchema, fk.ReferencedTable) &&
+				columnsAccessible(refSchema, fk.ReferencedTable, fk.ReferencedColumns) {
+				foreignKeys = append(foreignKeys, fk)
+			}
+		}
+		t.ForeignKeys = foreignKeys
+	}
+}
+
+func columnsAccessible(schema, table string, columns []string) bool {
+	for _, col := range columns {
+		if !columnAccessible(schema, table, col) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type SchemaDiff struct {
+	Lines []string
+	// AddedTables 为目标库缺少的表，建表语句需要从源库的 SHOW CREATE TABLE 获取
+	AddedTables []string
+	// Statements 为使目标库与源库一致所需执行的其余语句
+	Statements []string
+}
+
+// DiffSchemas 比较两个数据库结构，以 source 为期望状态，报告 target 需要的变更
+func DiffSchemas(source, target *SchemaSnapshot) SchemaDiff {
+	diff := SchemaDiff{}
+
+	for _, name := range sortedTableNames(source) {
+		st := source.Tables[name]
+		tt, ok := target.Tables[name]
+		if !ok {
+			diff.Lines = append(diff.Lines, fmt.Sprintf("+ 表 %s", name))
+			diff.AddedTables = append(diff.AddedTables, name)
+			continue
+		}
+		diffTable(&diff, st, tt)
+	}
+
+	for _, name := range sortedTableNames(target) {
+		if _, ok := source.Tables[name]; !ok {
+			diff.Lines = append(diff.Lines, fmt.Sprintf("- 表 %s", name))
+			diff.Statements = append(diff.Statements, fmt.Sprintf("DROP TABLE %s;", QuoteIdentifier(name)))
+		}
+	}
+
+	return diff
+}
+
+func diffTable(diff *SchemaDiff, source, target *SchemaTable) {
+	table := QuoteIdentifier(source.Name)
+
+	targetColumns := map[string]SchemaColumn{}
+	for _, col := range target.Columns {
+		targetColumns[col.Name] = col
+	}
+	sourceColumns := map[string]bool{}
+	previous := ""
+	for _, col := range source.Columns {
+		sourceColumns[col.Name] = true
+		position := " FIRST"
+		if previous != "" {
+			position = " AFTER " + QuoteIdentifier(previous)
+		}
+		previous = col.Name
+
+		tc, ok := targetColumns[col.Name]
+		if !ok {
+			diff.Lines = append(diff.Lines, fmt.Sprintf("+ 列 %s.%s %s", source.Name, col.Name, col.Type))
+			diff.Statements = append(diff.Statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s%s;", table, ColumnDefinition(col), position))
+			continue
+		}
+		if changes := columnChanges(col, tc); len(changes) > 0 {
+			diff.Lines = append(diff.Lines, fmt.Sprintf("~ 列 %s.%s: %s", source.Name, col.Name, strings.Join(changes, "，")))
+			diff.Statements = append(diff.Statements, fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s;", table, ColumnDefinition(col)))
+		}
+	}
+	for _, col := range target.Columns {
+		if !sourceColumns[col.Name] {
+			diff.Lines = append(diff.Lines, fmt.Sprintf("- 列 %s.%s", source.Name, col.Name))
+			diff.Statements = append(diff.Statements, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", table, QuoteIdentifier(col.Name)))
+		}
+	}
+
+	targetIndexes := map[string]TableIndex{}
+	for _, idx := range target.Indexes {
+		targetIndexes[idx.Name] = idx
+	}
+	sourceIndexes := map[string]bool{}
+	for _, idx := range source.Indexes {
+		sourceIndexes[idx.Name] = true
+		ti, ok := targetIndexes[idx.Name]
+		switch {
+		case !ok:
+			diff.Lines = append(diff.Lines, fmt.Sprintf("+ 索引 %s.%s (%s)", source.Name, idx.Name, strings.Join(idx.Columns, ", ")))
+			diff.Statements = append(diff.Statements, fmt.Sprintf("ALTER TABLE %s ADD %s;", table, indexDefinition(idx)))
+		case ti.Unique != idx.Unique || strings.Join(ti.Columns, ",") != strings.Join(idx.Columns, ","):
+			diff.Lines = append(diff.Lines, fmt.Sprintf("~ 索引 %s.%s: (%s) -> (%s)", source.Name, idx.Name, strings.Join(ti.Columns, ", "), strings.Join(idx.Columns, ", ")))
+			diff.Statements = append(diff.Statements, fmt.Sprintf("ALTER TABLE %s %s, ADD %s;", table, dropIndex(idx.Name), indexDefinition(idx)))
+		}
+	}
+	for _, idx := range target.Indexes {
+		if !sourceIndexes[idx.Name] {
+			diff.Lines = append(diff.Lines, fmt.Sprintf("- 索引 %s.%s", source.Name, idx.Name))
+			diff.Statements = append(diff.Statements, fmt.Sprintf("ALTER TABLE %s %s;", table, dropIndex(idx.Name)))
+		}
+	}
+
+	targetForeignKeys := map[string]SchemaForeignKey{}
+	for _, fk := range target.ForeignKeys {
+		targetForeignKeys[fk.Name] = fk
+	}
+	sourceForeignKeys := map[string]bool{}
+	for _, fk := range source.ForeignKeys {
+		sourceForeignKeys[fk.Name] = true
+		tf, ok := targetForeignKeys[fk.Name]
+		switch {
+		case !ok:
+			diff.Lines = append(diff.Lines, fmt.Sprintf("+ 外键 %s.%s -> %s", source.Name, fk.Name, fk.ReferencedTable))
+			diff.Statements = append(diff.Statements, fmt.Sprintf("ALTER TABLE %s ADD %s;", table, foreignKeyDefinition(fk)))
+		case foreignKeyDefinition(tf) != foreignKeyDefinition(fk):
+			diff.Lines = append(diff.Lines, fmt.Sprintf("~ 外键 %s.%s", source.Name, fk.Name))
+			diff.Statements = append(diff.Statements,
+				fmt.Sprintf("ALTER TABLE %s DROP FOREIGN KEY %s;", table, QuoteIdentifier(fk.Name)),
+				fmt.Sprintf("ALTER TABLE %s ADD %s;", table, foreignKeyDefinition(fk)))
+		}
+	}
+	for _, fk := range target.ForeignKeys {
+		if !sourceForeignKeys[fk.Name] {
+			diff.Lines = append(diff.Lines, fmt.Sprintf("- 外键 %s.%s", source.Name, fk.Name))
+			diff.Statements = append(diff.Statements, fmt.Sprintf("ALTER TABLE %s DROP FOREIGN KEY %s;", table, QuoteIdentifier(fk.Name)))
+		}
+	}
+}
+
+func columnChanges(source, target SchemaColumn) []string {
+	changes := []string{}
+	if source.Type != target.Type {
+		changes = append(changes, fmt.Sprintf("类型 %s -> %s", target.Type, source.Type))
+	}
+	if source.Nullable != target.Nullable {
+		changes = append(changes, fmt.Sprintf("可空 %s -> %s", target.Nullable, source.Nullable))
+	}
+	if stringOrNull(source.Default) != stringOrNull(target.Default) {
+		changes = append(changes, fmt.Sprintf("默认值 %s -> %s", stringOrNull(target.Default), stringOrNull(source.Default)))
+	}
+	if source.Extra != target.Extra {
+		changes = append(changes, fmt.Sprintf("属性 %q -> %q", target.Extra, source.Extra))
+	}
+	if source.Comment != target.Comment {
+		changes = append(changes, "注释")
+	}
+	if stringOrNull(source.Collation) != stringOrNull(target.Collation) {
+		changes = append(changes, fmt.Sprintf("排序规则 %s -> %s", stringOrNull(target.Collation), stringOrNull(source.Collation)))
+	}
+
+	return changes
+}
+
+// ColumnDefinition 根据 information_schema 中的列信息生成列定义
+func ColumnDefinition(col SchemaColumn) string {
+	parts := []string{QuoteIdentifier(col.Name), col.Type}
+
+	if col.Collation != nil {
+		parts = append(parts, "COLLATE "+*col.Collation)
+	}
+
+	extra := strings.TrimSpace(strings.ReplaceAll(col.Extra, "DEFAULT_GENERATED", ""))
+	generated := strings.Contains(strings.ToUpper(extra), "GENERATED")
+	if generated && col.Expression != nil {
+		storage := "VIRTUAL"
+		if strings.Contains(strings.ToUpper(extra), "STORED") {
+			storage = "STORED"
+		}
+		parts = append(parts, fmt.Sprintf("GENERATED ALWAYS AS (%s) %s", *col.Expression, storage))
+		extra = ""
+	}
+
+	if col.Nullable == "NO" {
+		parts = append(parts, "NOT NULL")
+	} else {
+		parts = append(parts, "NULL")
+	}
+
+	if !generated {
+		switch {
+		case col.Default != nil && strings.Contains(col.Extra, "DEFAULT_GENERATED"):
+			parts = append(parts, fmt.Sprintf("DEFAULT (%s)", *col.Default))
+		case col.Default != nil && isTimestampDefault(*col.Default):
+			parts = append(parts, "DEFAULT "+*col.Default)
+		case col.Default != nil:
+			parts = append(parts, "DEFAULT "+QuoteString(*col.Default))
+		case col.Nullable == "YES":
+			parts = append(parts, "DEFAULT NULL")
+		}
+	}
+
+	if extra != "" {
+		parts = append(parts, extra)
+	}
+
+	if col.Comment != "" {
+		parts = append(parts, "COMMENT "+QuoteString(col.Comment))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func isTimestampDefault(value string) bool {
+	upper := strings.ToUpper(value)
+	return strings.HasPrefix(upper, "CURRENT_TIMESTAMP") || strings.HasPrefix(upper, "NOW(")
+}
+
+// QuoteString 将值转义为 SQL 字符串字面量
+func QuoteString(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `'`, `''`, "\x00", `\0`, "\n", `\n`, "\r", `\r`, "\x1a", `\Z`)
+	return "'" + replacer.Replace(value) + "'"
+}
+
+func indexDefinition(idx TableIndex) string {
+	if idx.Name == "PRIMARY" {
+		return fmt.Sprintf("PRIMARY KEY (%s)", quoteColumns(idx.Columns))
+	}
+	if idx.Unique {
+		return fmt.Sprintf("UNIQUE INDEX %s (%s)", QuoteIdentifier(idx.Name), quoteColumns(idx.Columns))
+	}
+
+	return fmt.Sprintf("INDEX %s (%s)", QuoteIdentifier(idx.Name), quoteColumns(idx.Columns))
+}
+
+func dropIndex(name string) string {
+	if name == "PRIMARY" {
+		return "DROP PRIMARY KEY"
+	}
+
+	return "DROP INDEX " + QuoteIdentifier(name)
+}
+
+func foreignKeyDefinition(fk SchemaForeignKey) string {
+	referenced := QuoteIdentifier(fk.ReferencedTable)
+	if fk.ReferencedSchema != "" {
+		referenced = QuoteIdentifier(fk.ReferencedSchema) + "." + referenced
+	}
+
+	return fmt.Sprintf("CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s) ON DELETE %s ON UPDATE %s",
+		QuoteIdentifier(fk.Name), quoteColumns(fk.Columns), referenced, quoteColumns(fk.ReferencedColumns), fk.DeleteRule, fk.UpdateRule)
+}
+
+func sortedTableNames(s *SchemaSnapshot) []string {
+	names := make([]string, 0, len(s.Tables))
+	for name := range s.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func stringOrNull(s *string) string {
+	if s == nil {
+		return "NULL"
+	}
+
+	return *s
+}
+
+func HandleDiffSchema(sourceConn, source, targetConn, target string, withAlter bool) (string, error) {
+	sourceDB, err := GetNamedDB(sourceConn)
+	if err != nil {
+		return "", err
+	}
+	targetDB, err := GetNamedDB(targetConn)
+	if err != nil {
+		return "", err
+	}
+
+	if source, err = ParseSchemaName(source); err != nil {
+		return "", err
+	}
+	if target, err = ParseSchemaName(target); err != nil {
+		return "", err
+	}
+	for _, schema := range []string{source, target} {
+		if err := CheckSchemaAccess(schema); err != nil {
+			return "", err
+		}
+	}
+
+	sourceSnapshot, err := LoadSchemaSnapshot(sourceDB, source)
+	if err != nil {
+		return "", err
+	}
+	targetSnapshot, err := LoadSchemaSnapshot(targetDB, target)
+	if err != nil {
+		return "", err
+	}
+
+	// 不允许访问的表和列不参与比较
+	if accessEnabled() {
+		filterSnapshotAccess(sourceSnapshot)
+		filterSnapshotAccess(targetSnapshot)
+	}
+
+	diff := DiffSchemas(sourceSnapshot, targetSnapshot)
+	if len(diff.Lines) == 0 {
+		return fmt.Sprintf("%s 与 %s 的结构一致", source, target), nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "以 %s 为准，%s 的结构差异（+ 缺少，- 多出，~ 不同）:\n", source, target)
+	for _, line := range diff.Lines {
+		b.WriteString(line + "\n")
+	}
+
+	if withAlter {
+		b.WriteString("\n使 " + target + " 与 " + source + " 一致的语句:\n")
+		for _, name := range diff.AddedTables {
+			ddl, err := ShowCreateTable(sourceDB, source, name)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(ddl + ";\n")
+		}
+		for _, stmt := range diff.Statements {
+			b.WriteString(stmt + "\n")
+		}
+	}
+
+	return b.String(), nil
+}
+
+func ShowCreateTable(db *sqlx.DB, schema, table string) (string, error) {
+	row := ShowCreateTableResult{}
+	if err := db.QueryRowx(fmt.Sprintf("SHOW CREATE TABLE %s.%s", QuoteIdentifier(schema), QuoteIdentifier(table))).StructScan(&row); err != nil {
+		return "", fmt.Errorf("读取表 %s 的建表语句失败: %v", table, err)
+	}
+
+	return row.CreateTable, nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffSchemas(t *testing.T) {
+	def := "0"
+	source := &SchemaSnapshot{Schema: "staging", Tables: map[string]*SchemaTable{
+		"users": {
+			Name: "users",
+			Columns: []SchemaColumn{
+				{Name: "id", Type: "int", Nullable: "NO", Extra: "auto_increment"},
+				{Name: "email", Type: "varchar(255)", Nullable: "NO"},
+				{Name: "score", Type: "int", Nullable: "NO", Default: &def},
+			},
+			Indexes: []TableIndex{
+				{Name: "PRIMARY", Unique: true, Columns: []string{"id"}},
+				{Name: "uk_email", Unique: true, Columns: []string{"email"}},
+			},
+		},
+		"orders": {
+			Name:    "orders",
+			Columns: []SchemaColumn{{Name: "id", Type: "int", Nullable: "NO"}, {Name: "user_id", Type: "int", Nullable: "NO"}},
+			ForeignKeys: []SchemaForeignKey{
+				{Name: "fk_user", Columns: []string{"user_id"}, ReferencedTable: "users", ReferencedColumns: []string{"id"}, UpdateRule: "RESTRICT", DeleteRule: "CASCADE"},
+			},
+		},
+	}}
+	target := &SchemaSnapshot{Schema: "prod", Tables: map[string]*SchemaTable{
+		"users": {
+			Name: "users",
+			Columns: []SchemaColumn{
+				{Name: "id", Type: "int", Nullable: "NO", Extra: "auto_increment"},
+				{Name: "email", Type: "varchar(100)", Nullable: "NO"},
+				{Name: "legacy", Type: "text", Nullable: "YES"},
+			},
+			Indexes: []TableIndex{
+				{Name: "PRIMARY", Unique: true, Columns: []string{"id"}},
+				{Name: "idx_email", Columns: []string{"email"}},
+			},
+		},
+		"logs": {Name: "logs"},
+	}}
+
+	diff := DiffSchemas(source, target)
+
+	assert.Equal(t, []string{"orders"}, diff.AddedTables)
+	assert.Contains(t, diff.Lines, "+ 表 orders")
+	assert.Contains(t, diff.Lines, "- 表 logs")
+	assert.Contains(t, diff.Lines, "~ 列 users.email: 类型 varchar(100) -> varchar(255)")
+	assert.Contains(t, diff.Lines, "+ 列 users.score int")
+	assert.Contains(t, diff.Lines, "- 列 users.legacy")
+	assert.Contains(t, diff.Lines, "+ 索引 users.uk_email (email)")
+	assert.Contains(t, diff.Lines, "- 索引 users.idx_email")
+
+	assert.Contains(t, diff.Statements, "ALTER TABLE `users` MODIFY COLUMN `email` varchar(255) NOT NULL;")
+	assert.Contains(t, diff.Statements, "ALTER TABLE `users` ADD COLUMN `score` int NOT NULL DEFAULT '0' AFTER `email`;")
+	assert.Contains(t, diff.Statements, "ALTER TABLE `users` DROP COLUMN `legacy`;")
+	assert.Contains(t, diff.Statements, "ALTER TABLE `users` ADD UNIQUE INDEX `uk_email` (`email`);")
+	assert.Contains(t, diff.Statements, "ALTER TABLE `users` DROP INDEX `idx_email`;")
+	assert.Contains(t, diff.Statements, "DROP TABLE `logs`;")
+}
+
+func TestDiffSchemasForeignKeySchema(t *testing.T) {
+	snapshot := func(schema, refSchema string) *SchemaSnapshot {
+		return &SchemaSnapshot{Schema: schema, Tables: map[string]*SchemaTable{
+			"orders": {
+				Name: "orders",
+				ForeignKeys: []SchemaForeignKey{
+					{Name: "fk_user", Columns: []string{"user_id"}, ReferencedSchema: refSchema, ReferencedTable: "users", ReferencedColumns: []string{"id"}, UpdateRule: "RESTRICT", DeleteRule: "CASCADE"},
+				},
+			},
+		}}
+	}
+
+	t.Run("same schema references", func(t *testing.T) {
+		diff := DiffSchemas(snapshot("staging", ""), snapshot("prod", ""))
+
+		assert.Empty(t, diff.Lines)
+	})
+
+	t.Run("different referenced schema", func(t *testing.T) {
+		diff := DiffSchemas(snapshot("staging", "accounts"), snapshot("prod", ""))
+
+		assert.Contains(t, diff.Lines, "~ 外键 orders.fk_user")
+		assert.Contains(t, diff.Statements, "ALTER TABLE `orders` ADD CONSTRAINT `fk_user` FOREIGN KEY (`user_id`) REFERENCES `accounts`.`users` (`id`) ON DELETE CASCADE ON UPDATE RESTRICT;")
+	})
+}
+
+func TestColumnDefinition(t *testing.T) {
+	t.Run("expression default", func(t *testing.T) {
+		def := "CURRENT_TIMESTAMP"
+		col := SchemaColumn{Name: "updated_at", Type: "timestamp", Nullable: "NO", Default: &def, Extra: "DEFAULT_GENERATED on update CURRENT_TIMESTAMP"}
+
+		assert.Equal(t, "`updated_at` timestamp NOT NULL DEFAULT (CURRENT_TIMESTAMP) on update CURRENT_TIMESTAMP", ColumnDefinition(col))
+	})
+
+	t.Run("nullable with comment", func(t *testing.T) {
+		col := SchemaColumn{Name: "note", Type: "varchar(20)", Nullable: "YES", Comment: "it's a note"}
+
+		assert.Equal(t, "`note` varchar(20) NULL DEFAULT NULL COMMENT 'it''s a note'", ColumnDefinition(col))
+	})
+
+	t.Run("generated column", func(t *testing.T) {
+		expr := "(`price` * `qty`)"
+		col := SchemaColumn{Name: "total", Type: "int", Nullable: "YES", Extra: "STORED GENERATED", Expression: &expr}
+
+		assert.Equal(t, "`total` int GENERATED ALWAYS AS ((`price` * `qty`)) STORED NULL", ColumnDefinition(col))
+	})
+}
+
+func TestHandleDiffSchema(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	expectSnapshot := func(schema string, columnType string) {
+		mock.ExpectQuery("FROM information_schema.SCHEMATA").WithArgs(schema).
+			WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1))
+		mock.ExpectQuery("FROM information_schema.TABLES").WithArgs(schema).
+			WillReturnRows(sqlmock.NewRows([]string{"TABLE_NAME"}).AddRow("users"))
+		mock.ExpectQuery("FROM information_schema.COLUMNS").WithArgs(schema).
+			WillReturnRows(sqlmock.NewRows([]string{"TABLE_NAME", "COLUMN_NAME", "ORDINAL_POSITION", "COLUMN_TYPE", "IS_NULLABLE", "COLUMN_DEFAULT", "EXTRA", "COLUMN_COMMENT", "COLLATION_NAME", "GENERATION_EXPRESSION"}).
+				AddRow("users", "id", 1, "int", "NO", nil, "", "", nil, nil).
+				AddRow("users", "name", 2, columnType, "YES", nil, "", "", nil, nil).
+				AddRow("users", "password", 3, "varchar(64)", "NO", nil, "", "", nil, nil))
+		mock.ExpectQuery("FROM information_schema.STATISTICS").WithArgs(schema).
+			WillReturnRows(sqlmock.NewRows([]string{"TABLE_NAME", "INDEX_NAME", "NON_UNIQUE", "SEQ_IN_INDEX", "COLUMN_NAME"}).
+				AddRow("users", "PRIMARY", 0, 1, "id").
+				AddRow("users", "idx_password", 1, 1, "password"))
+		mock.ExpectQuery("FROM information_schema.KEY_COLUMN_USAGE").WithArgs(schema).
+			WillReturnRows(sqlmock.NewRows([]string{"TABLE_NAME", "CONSTRAINT_NAME", "COLUMN_NAME", "REFERENCED_TABLE_SCHEMA", "REFERENCED_TABLE_NAME", "REFERENCED_COLUMN_NAME", "UPDATE_RULE", "DELETE_RULE"}))
+	}
+
+	t.Run("identical schemas", func(t *testing.T) {
+		// 设置模拟预期
+		expectSnapshot("staging", "varchar(50)")
+		expectSnapshot("prod", "varchar(50)")
+
+		// 调用 HandleDiffSchema
+		result, err := HandleDiffSchema("", "staging", "", "prod", true)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "结构一致")
+	})
+
+	t.Run("changed column with alter", func(t *testing.T) {
+		// 设置模拟预期
+		expectSnapshot("staging", "varchar(100)")
+		expectSnapshot("prod", "varchar(50)")
+
+		// 调用 HandleDiffSchema
+		result, err := HandleDiffSchema("", "staging", "", "prod", true)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "~ 列 users.name: 类型 varchar(50) -> varchar(100)")
+		assert.Contains(t, result, "ALTER TABLE `users` MODIFY COLUMN `name` varchar(100) NULL DEFAULT NULL;")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("restricted columns", func(t *testing.T) {
+		setupAccess(t, nil, []string{"*.users.password"})
+
+		// 设置模拟预期
+		expectSnapshot("staging", "varchar(50)")
+		mock.ExpectQuery("FROM information_schema.SCHEMATA").WithArgs("prod").
+			WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1))
+		mock.ExpectQuery("FROM information_schema.TABLES").WithArgs("prod").
+			WillReturnRows(sqlmock.NewRows([]string{"TABLE_NAME"}).AddRow("users"))
+		mock.ExpectQuery("FROM information_schema.COLUMNS").WithArgs("prod").
+			WillReturnRows(sqlmock.NewRows([]string{"TABLE_NAME", "COLUMN_NAME", "ORDINAL_POSITION", "COLUMN_TYPE", "IS_NULLABLE", "COLUMN_DEFAULT", "EXTRA", "COLUMN_COMMENT", "COLLATION_NAME", "GENERATION_EXPRESSION"}).
+				AddRow("users", "id", 1, "int", "NO", nil, "", "", nil, nil).
+				AddRow("users", "name", 2, "varchar(50)", "YES", nil, "", "", nil, nil).
+				AddRow("users", "password", 3, "char(60)", "NO", nil, "", "", nil, nil))
+		mock.ExpectQuery("FROM information_schema.STATISTICS").WithArgs("prod").
+			WillReturnRows(sqlmock.NewRows([]string{"TABLE_NAME", "INDEX_NAME", "NON_UNIQUE", "SEQ_IN_INDEX", "COLUMN_NAME"}).
+				AddRow("users", "PRIMARY", 0, 1, "id"))
+		mock.ExpectQuery("FROM information_schema.KEY_COLUMN_USAGE").WithArgs("prod").
+			WillReturnRows(sqlmock.NewRows([]string{"TABLE_NAME", "CONSTRAINT_NAME", "COLUMN_NAME", "REFERENCED_TABLE_SCHEMA", "REFERENCED_TABLE_NAME", "REFERENCED_COLUMN_NAME", "UPDATE_RULE", "DELETE_RULE"}))
+
+		// 调用 HandleDiffSchema
+		result, err := HandleDiffSchema("", "staging", "", "prod", true)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "结构一致")
+		assert.NotContains(t, result, "password")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("empty database", func(t *testing.T) {
+		// 设置模拟预期
+		expectSnapshot("staging", "varchar(50)")
+		mock.ExpectQuery("FROM information_schema.SCHEMATA").WithArgs("fresh").
+			WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1))
+		mock.ExpectQuery("FROM information_schema.TABLES").WithArgs("fresh").
+			WillReturnRows(sqlmock.NewRows([]string{"TABLE_NAME"}))
+		mock.ExpectQuery("FROM information_schema.COLUMNS").WithArgs("fresh").
+			WillReturnRows(sqlmock.NewRows([]string{"TABLE_NAME", "COLUMN_NAME"}))
+		mock.ExpectQuery("FROM information_schema.STATISTICS").WithArgs("fresh").
+			WillReturnRows(sqlmock.NewRows([]string{"TABLE_NAME", "INDEX_NAME"}))
+		mock.ExpectQuery("FROM information_schema.KEY_COLUMN_USAGE").WithArgs("fresh").
+			WillReturnRows(sqlmock.NewRows([]string{"TABLE_NAME", "CONSTRAINT_NAME"}))
+
+		// 调用 HandleDiffSchema
+		result, err := HandleDiffSchema("", "staging", "", "fresh", false)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "+ 表 users")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("missing database", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("FROM information_schema.SCHEMATA").WithArgs("nope").
+			WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(0))
+
+		// 调用 HandleDiffSchema
+		_, err := HandleDiffSchema("", "nope", "", "prod", false)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "数据库 nope 不存在")
+	})
+
+	t.Run("unknown connection", func(t *testing.T) {
+		// 调用 HandleDiffSchema
+		_, err := HandleDiffSchema("", "staging", "missing", "prod", false)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "未定义的连接: missing")
+	})
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"unicode"
+)
+
+type SQLTokenKind int
+
+const (
+	TokenWord SQLTokenKind = iota
+	TokenQuotedIdent
+	TokenString
+	TokenNumber
+	TokenVariable
+	TokenPlaceholder
+	TokenOperator
+	TokenPunct
+)
+
+type SQLToken struct {
+	Kind SQLTokenKind
+	// Text 为原始文本，Value 为去掉引号和转义后的值
+	Text  string
+	Value string
+	// Start 和 End 为 token 在原始 SQL 中的字符（rune）位置
+	Start int
+	End   int
+}
+
+// IsKeyword 判断 token 是否为给定关键字之一（不区分大小写）
+func (t SQLToken) IsKeyword(keywords ...string) bool {
+	if t.Kind != TokenWord {
+		return false
+	}
+
+	for _, kw := range keywords {
+		if strings.EqualFold(t.Value, kw) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsIdent 判断 token 是否可以作为标识符使用
+func (t SQLToken) IsIdent() bool {
+	return t.Kind == TokenQuotedIdent || (t.Kind == TokenWord && !reservedWords[strings.ToUpper(t.Value)])
+}
+
+func (t SQLToken) Is(kind SQLTokenKind, text string) bool {
+	return t.Kind == kind && t.Text == text
+}
+
+// reservedWords 包含解析时需要与标识符区分的常用关键字
+var reservedWords = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "AND": true, "OR": true, "NOT": true,
+	"JOIN": true, "INNER": true, "LEFT": true, "RIGHT": true, "CROSS": true, "OUTER": true,
+	"STRAIGHT_JOIN": true, "NATURAL": true, "ON": true, "USING": true, "AS": true,
+	"GROUP": true, "BY": true, "ORDER": true, "HAVING": true, "LIMIT": true, "OFFSET": true,
+	"UNION": true, "INSERT": true, "INTO": true, "VALUES": true, "VALUE": true, "UPDATE": true,
+	"SET": true, "DELETE": true, "IN": true, "IS": true, "NULL": true, "LIKE": true,
+	"BETWEEN": true, "EXISTS": true, "CASE": true, "WHEN": true, "THEN": true, "ELSE": true,
+	"END": true, "ASC": true, "DESC": true, "DISTINCT": true, "FOR": true, "WITH": true,
+	"WINDOW": true, "LOCK": true, "SHARE": true, "INTERVAL": true, "REGEXP": true, "RLIKE": true,
+	"DUAL": true, "FORCE": true, "IGNORE": true, "USE": true, "INDEX": true, "KEY": true,
+	"PARTITION": true, "LOW_PRIORITY": true, "QUICK": true, "HIGH_PRIORITY": true, "DUPLICATE": true,
+	"TRUE": true, "FALSE": true, "DIV": true, "MOD": true, "XOR": true, "ALL": true, "ANY": true,
+	"SOME": true, "ROLLUP": true, "REPLACE": true, "TABLE": true, "CREATE": true, "ALTER": true,
+	"DROP": true, "TRUNCATE": true, "RENAME": true, "CALL": true, "LOAD": true, "GRANT": true,
+	"REVOKE": true, "SHOW": true, "DESCRIBE": true, "EXPLAIN": true,
+}
+
+var multiCharOperators = []string{"<=>", "->>", "<=", ">=", "<>", "!=", ":=", "||", "&&", "<<", ">>", "->"}
+
+// TokenizeSQL 将 SQL 文本切分为 token 序列。注释会被丢弃，
+// 而 MySQL 可执行注释 `/*! ... */` 中的内容会作为普通 SQL 解析，避免借此绕过检查
+func TokenizeSQL(query string) []SQLToken {
+	tokens := []SQLToken{}
+	runes := []rune(query)
+	n := len(runes)
+	inExecComment := false
+
+	for i := 0; i < n; {
+		c := runes[i]
+		start, count := i, len(tokens)
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '-' && i+1 < n && runes[i+1] == '-' && (i+2 >= n || unicode.IsSpace(runes[i+2])):
+			for i < n && runes[i] != '\n' {
+				i++
+			}
+
+		case c == '#':
+			for i < n && runes[i] != '\n' {
+				i++
+			}
+
+		case c == '/' && i+2 < n && runes[i+1] == '*' && runes[i+2] == '!':
+			i += 3
+			for i < n && unicode.IsDigit(runes[i]) {
+				i++
+			}
+			inExecComment = true
+
+		case c == '/' && i+1 < n && runes[i+1] == '*':
+			i += 2
+			for i < n && !(runes[i] == '*' && i+1 < n && runes[i+1] == '/') {
+				i++
+			}
+			i += 2
+
+		case c == '*' && inExecComment && i+1 < n && runes[i+1] == '/':
+			i += 2
+			inExecComment = false
+
+		case c == '\'' || c == '"':
+			value, end := scanQuoted(runes, i, c, true)
+			tokens = append(tokens, SQLToken{Kind: TokenString, Text: string(runes[start:end]), Value: value})
+			i = end
+
+		case c == '`':
+			value, end := scanQuoted(runes, i, c, false)
+			tokens = append(tokens, SQLToken{Kind: TokenQuotedIdent, Text: string(runes[start:end]), Value: value})
+			i = end
+
+		case c == '@':
+			i++
+			if i < n && runes[i] == '@' {
+				i++
+			}
+			if i < n && (runes[i] == '`' || runes[i] == '\'' || runes[i] == '"') {
+				_, i = scanQuoted(runes, i, runes[i], runes[i] != '`')
+			} else {
+				for i < n && (isWordRune(runes[i]) || runes[i] == '.') {
+					i++
+				}
+			}
+			text := string(runes[start:i])
+			tokens = append(tokens, SQLToken{Kind: TokenVariable, Text: text, Value: text})
+
+		case c == '?':
+			tokens = append(tokens, SQLToken{Kind: TokenPlaceholder, Text: "?", Value: "?"})
+			i++
+
+		case unicode.IsDigit(c) || (c == '.' && i+1 < n && unicode.IsDigit(runes[i+1]) && !precededByIdent(tokens)):
+			for i < n && (isWordRune(runes[i]) || runes[i] == '.' ||
+				((runes[i] == '+' || runes[i] == '-') && (runes[i-1] == 'e' || runes[i-1] == 'E'))) {
+				i++
+			}
+			text := string(runes[start:i])
+			kind := TokenNumber
+			if strings.IndexFunc(text, unicode.IsLetter) >= 0 && !isNumericLiteral(text) {
+				kind = TokenWord
+			}
+			tokens = append(tokens, SQLToken{Kind: kind, Text: text, Value: text})
+
+		case isWordRune(c):
+			for i < n && isWordRune(runes[i]) {
+				i++
+			}
+			text := string(runes[start:i])
+			tokens = append(tokens, SQLToken{Kind: TokenWord, Text: text, Value: text})
+
+		case strings.ContainsRune("(),;.", c):
+			tokens = append(tokens, SQLToken{Kind: TokenPunct, Text: string(c), Value: string(c)})
+			i++
+
+		default:
+			op := string(c)
+			for _, candidate := range multiCharOperators {
+				if strings.HasPrefix(string(runes[i:min(i+len(candidate), n)]), candidate) {
+					op = candidate
+					break
+				}
+			}
+			tokens = append(tokens, SQLToken{Kind: TokenOperator, Text: op, Value: op})
+			i += len([]rune(op))
+		}
+
+		if len(tokens) > count {
+			tokens[len(tokens)-1].Start = start
+			tokens[len(tokens)-1].End = i
+		}
+	}
+
+	return tokens
+}
+
+// SplitStatements 按顶层分隔符切分多条语句，返回去除首尾空白后的语句文本，
+// 分隔符位于字符串、标识符或注释中时不会被切分。存储程序 BEGIN ... END 中的分号不切分，
+// 也支持 mysql 客户端的 DELIMITER 命令
+func SplitStatements(query string) []string {
+	statements, rest, _ := splitStatements(query, ";")
+	if stmt := trimStatement(rest); stmt != "" {
+		statements = append(statements, stmt)
+	}
+
+	return statements
+}
+
+// splitStatements 以 delimiter 为初始分隔符切分 query，返回完整的语句、
+// 末尾尚未结束的文本（没有分隔符结尾或 BEGIN ... END 尚未闭合）以及最后生效的分隔符
+func splitStatements(query, delimiter string) ([]string, string, string) {
+	runes := []rune(query)
+	tokens := TokenizeSQL(query)
+	statements := []string{}
+
+	start := 0
+	// first 为当前语句第一个 token 的下标，depth 为存储程序中尚未闭合的 BEGIN 和 CASE 数量
+	first, depth, routine := -1, 0, false
+	for i, tok := range tokens {
+		if tok.Start < start {
+			// 属于 DELIMITER 命令或多字符分隔符的一部分
+			continue
+		}
+
+		if first < 0 && tok.IsKeyword("DELIMITER") {
+			end := tok.End
+			for end < len(runes) && runes[end] != '\n' {
+				end++
+			}
+			if d := strings.TrimSpace(string(runes[tok.End:end])); d != "" {
+				delimiter = d
+				start = end
+				continue
+			}
+		}
+
+		if first < 0 {
+			first, depth, routine = i, 0, false
+		}
+		if delimiter == ";" {
+			switch {
+			case depth == 0 && tokens[first].IsKeyword("CREATE") && tok.IsKeyword("PROCEDURE", "FUNCTION", "TRIGGER", "EVENT"):
+				routine = true
+			case routine && tok.IsKeyword("BEGIN", "CASE"):
+				depth++
+			case routine && depth > 0 && tok.IsKeyword("END") &&
+				!(i+1 < len(tokens) && tokens[i+1].IsKeyword("IF", "LOOP", "WHILE", "REPEAT")):
+				depth--
+			}
+		}
+
+		if pos := delimiterAt(runes, tok, delimiter); pos >= 0 && (delimiter != ";" || depth == 0) {
+			if stmt := trimStatement(string(runes[start:pos])); stmt != "" {
+				statements = append(statements, stmt)
+			}
+			start = pos + len([]rune(delimiter))
+			first = -1
+		}
+	}
+
+	return statements, string(runes[start:]), delimiter
+}
+
+// delimiterAt 返回分隔符在 token 中出现的位置，字符串和带引号的标识符中的内容不算
+func delimiterAt(runes []rune, tok SQLToken, delimiter string) int {
+	if tok.Kind == TokenString || tok.Kind == TokenQuotedIdent {
+		return -1
+	}
+
+	d := []rune(delimiter)
+	for p := tok.Start; p < tok.End && p+len(d) <= len(runes); p++ {
+		if string(runes[p:p+len(d)]) == delimiter {
+			return p
+		}
+	}
+
+	return -1
+}
+
+func trimStatement(text string) string {
+	// 只包含注释的片段不是语句
+	if stmt := strings.TrimSpace(text); len(TokenizeSQL(stmt)) > 0 {
+		return stmt
+	}
+
+	return ""
+}
+
+// ReadStatements 从 r 中逐条读取语句并交给 fn，适合回放体积较大的 SQL 文件。
+// 只在行尾是当前分隔符时才尝试切分，未结束的语句留到后续行，因此不必把整个文件读入内存
+func ReadStatements(r io.Reader, fn func(stmt string) error) error {
+	reader := bufio.NewReader(r)
+	var buf strings.Builder
+	delimiter := ";"
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		buf.WriteString(line)
+
+		if err == io.EOF || strings.HasSuffix(strings.TrimSpace(line), delimiter) {
+			statements, rest, next := splitStatements(buf.String(), delimiter)
+			delimiter = next
+			if err == io.EOF {
+				if stmt := trimStatement(rest); stmt != "" {
+					statements = append(statements, stmt)
+				}
+			}
+			for _, stmt := range statements {
+				if err := fn(stmt); err != nil {
+					return err
+				}
+			}
+			buf.Reset()
+			buf.WriteString(rest)
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+func isWordRune(c rune) bool {
+	return c == '_' || c == '$' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+func isNumericLiteral(text string) bool {
+	lower := strings.ToLower(text)
+	if strings.HasPrefix(lower, "0x") || strings.HasPrefix(lower, "0b") {
+		return true
+	}
+
+	for _, c := range lower {
+		if !unicode.IsDigit(c) && c != '.' && c != 'e' && c != '+' && c != '-' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func precededByIdent(tokens []SQLToken) bool {
+	if len(tokens) == 0 {
+		return false
+	}
+
+	last := tokens[len(tokens)-1]
+	return last.Kind == TokenWord || last.Kind == TokenQuotedIdent
+}
+
+// scanQuoted 读取以 quote 开头的字符串或标识符，返回去除引号后的值和结束位置
+func scanQuoted(runes []rune, start int, quote rune, backslash bool) (string, int) {
+	var b strings.Builder
+	i := start + 1
+	for i < len(runes) {
+		c := runes[i]
+		if backslash && c == '\\' && i+1 < len(runes) {
+			b.WriteRune(unescapeRune(runes[i+1]))
+			i += 2
+			continue
+		}
+		if c == quote {
+			if i+1 < len(runes) && runes[i+1] == quote {
+				b.WriteRune(quote)
+				i += 2
+				continue
+			}
+			return b.String(), i + 1
+		}
+		b.WriteRune(c)
+		i++
+	}
+
+	return b.String(), i
+}
+
+func unescapeRune(c rune) rune {
+	switch c {
+	case 'n':
+		return '\n'
+	case 't':
+		return '\t'
+	case 'r':
+		return '\r'
+	case '0':
+		return 0
+	default:
+		return c
+	}
+}
+
+// QuoteIdentifier 使用反引号包裹标识符，并转义其中的反引号
+func QuoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// matchParen 返回与 tokens[open] 处左括号匹配的右括号位置，找不到时返回 len(tokens)
+func matchParen(tokens []SQLToken, open int) int {
+	depth := 0
+	for i := open; i < len(tokens); i++ {
+		switch {
+		case tokens[i].Is(TokenPunct, "("):
+			depth++
+		case tokens[i].Is(TokenPunct, ")"):
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return len(tokens)
+}
+
+// readQualifiedName 从 tokens[i] 开始读取 `a` 或 `a.b` 形式的名称，返回各部分和下一个位置
+func readQualifiedName(tokens []SQLToken, i int) ([]string, int) {
+	if i >= len(tokens) || !tokens[i].IsIdent() {
+		return nil, i
+	}
+
+	parts := []string{tokens[i].Value}
+	i++
+	for i+1 < len(tokens) && tokens[i].Is(TokenPunct, ".") && (tokens[i+1].IsIdent() || tokens[i+1].Kind == TokenWord || tokens[i+1].Is(TokenOperator, "*")) {
+		parts = append(parts, tokens[i+1].Value)
+		i += 2
+	}
+
+	return parts, i
+}
+
+// PreparedSQL 判断语句是否为 `PREPARE name FROM ...`，是时 ok 为 true 并返回被预处理的 SQL。
+// 预处理的 SQL 来自用户变量等无法静态确定的表达式时 known 为 false
+func PreparedSQL(tokens []SQLToken) (text string, known bool, ok bool) {
+	if len(tokens) < 3 || !tokens[0].IsKeyword("PREPARE") || !tokens[2].IsKeyword("FROM") {
+		return "", false, false
+	}
+
+	// 相邻的字符串字面量会被 MySQL 连接为一个字符串
+	rest := tokens[3:]
+	if len(rest) > 0 && rest[len(rest)-1].Is(TokenPunct, ";") {
+		rest = rest[:len(rest)-1]
+	}
+	if len(rest) == 0 {
+		return "", false, true
+	}
+	var sb strings.Builder
+	for _, tok := range rest {
+		if tok.Kind != TokenString {
+			return "", false, true
+		}
+		sb.WriteString(tok.Value)
+	}
+
+	return sb.String(), true, true
+}
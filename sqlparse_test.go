@@ -0,0 +1,140 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenizeSQL(t *testing.T) {
+	t.Run("basic select", func(t *testing.T) {
+		tokens := TokenizeSQL("SELECT `id`, name FROM users WHERE name = 'it''s' AND age >= 18")
+
+		texts := []string{}
+		for _, tok := range tokens {
+			texts = append(texts, tok.Value)
+		}
+
+		assert.Equal(t, []string{"SELECT", "id", ",", "name", "FROM", "users", "WHERE", "name", "=", "it's", "AND", "age", ">=", "18"}, texts)
+		assert.Equal(t, TokenQuotedIdent, tokens[1].Kind)
+		assert.Equal(t, TokenString, tokens[9].Kind)
+		assert.Equal(t, TokenNumber, tokens[13].Kind)
+	})
+
+	t.Run("comments are dropped", func(t *testing.T) {
+		tokens := TokenizeSQL("SELECT 1 -- trailing\n# hash\n/* block */ FROM dual")
+
+		assert.Len(t, tokens, 4)
+		assert.True(t, tokens[2].IsKeyword("from"))
+	})
+
+	t.Run("executable comments are parsed", func(t *testing.T) {
+		tokens := TokenizeSQL("SELECT /*!50000 SLEEP(1) */ 1")
+
+		assert.True(t, tokens[1].IsKeyword("SLEEP"))
+		assert.Equal(t, "1", tokens[len(tokens)-1].Value)
+	})
+
+	t.Run("escaped backtick", func(t *testing.T) {
+		tokens := TokenizeSQL("SHOW CREATE TABLE `a``b`")
+
+		assert.Equal(t, "a`b", tokens[3].Value)
+	})
+
+	t.Run("variables and placeholders", func(t *testing.T) {
+		tokens := TokenizeSQL("SET @a := @@global.max_connections + ?")
+
+		assert.Equal(t, TokenVariable, tokens[1].Kind)
+		assert.Equal(t, ":=", tokens[2].Text)
+		assert.Equal(t, "@@global.max_connections", tokens[3].Text)
+		assert.Equal(t, TokenPlaceholder, tokens[5].Kind)
+	})
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	assert.Equal(t, "`users`", QuoteIdentifier("users"))
+	assert.Equal(t, "`x``; DROP TABLE y; -- `", QuoteIdentifier("x`; DROP TABLE y; -- "))
+}
+
+func TestSplitStatements(t *testing.T) {
+	t.Run("ignores separators in literals and comments", func(t *testing.T) {
+		statements := SplitStatements("CREATE TABLE a (x INT COMMENT 'a;b');\n-- note; here\nINSERT INTO `we;ird` VALUES (1);\n/* trailing; */")
+
+		assert.Equal(t, []string{
+			"CREATE TABLE a (x INT COMMENT 'a;b')",
+			"-- note; here\nINSERT INTO `we;ird` VALUES (1)",
+		}, statements)
+	})
+
+	t.Run("no trailing semicolon", func(t *testing.T) {
+		assert.Equal(t, []string{"SELECT 1", "SELECT 2"}, SplitStatements("SELECT 1; SELECT 2"))
+	})
+
+	t.Run("stored program body", func(t *testing.T) {
+		body := "CREATE TRIGGER trg BEFORE INSERT ON t FOR EACH ROW\nBEGIN\n  IF NEW.x < 0 THEN\n    SET NEW.x = 0;\n  END IF;\n" +
+			"  SET NEW.y = CASE WHEN NEW.x > 10 THEN 1 ELSE 0 END;\n  loop1: BEGIN\n    SET NEW.z = 1;\n  END loop1;\nEND"
+		statements := SplitStatements("DROP TRIGGER IF EXISTS trg;\n" + body + ";\nSELECT 1;")
+
+		assert.Equal(t, []string{"DROP TRIGGER IF EXISTS trg", body, "SELECT 1"}, statements)
+	})
+
+	t.Run("begin outside stored program", func(t *testing.T) {
+		assert.Equal(t, []string{"BEGIN", "UPDATE t SET x = 1", "COMMIT"}, SplitStatements("BEGIN; UPDATE t SET x = 1; COMMIT;"))
+	})
+
+	t.Run("delimiter command", func(t *testing.T) {
+		statements := SplitStatements("DELIMITER $$\nCREATE PROCEDURE p()\nBEGIN\n  SELECT 1;\nEND$$\nDELIMITER ;\nCALL p();")
+
+		assert.Equal(t, []string{"CREATE PROCEDURE p()\nBEGIN\n  SELECT 1;\nEND", "CALL p()"}, statements)
+	})
+}
+
+func TestPreparedSQL(t *testing.T) {
+	text, known, ok := PreparedSQL(TokenizeSQL("PREPARE s FROM 'SELECT * FROM t WHERE name = ''a'''"))
+	assert.True(t, ok)
+	assert.True(t, known)
+	assert.Equal(t, "SELECT * FROM t WHERE name = 'a'", text)
+
+	_, known, ok = PreparedSQL(TokenizeSQL("PREPARE s FROM @sql"))
+	assert.True(t, ok)
+	assert.False(t, known)
+
+	_, _, ok = PreparedSQL(TokenizeSQL("EXECUTE s USING @a"))
+	assert.False(t, ok)
+}
+
+func TestReadStatements(t *testing.T) {
+	input := "/*!40101 SET NAMES utf8mb4 */;\nINSERT INTO t VALUES\n(1, 'a;\nb'),\n(2, 'c');\n-- done;\nDROP TABLE x"
+
+	statements := []string{}
+	err := ReadStatements(strings.NewReader(input), func(stmt string) error {
+		statements = append(statements, stmt)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"/*!40101 SET NAMES utf8mb4 */",
+		"INSERT INTO t VALUES\n(1, 'a;\nb'),\n(2, 'c')",
+		"-- done;\nDROP TABLE x",
+	}, statements)
+
+	t.Run("stored program across lines", func(t *testing.T) {
+		input := "DELIMITER ;;\nCREATE TRIGGER trg BEFORE INSERT ON t FOR EACH ROW BEGIN\nSET NEW.x = 1;\nEND ;;\nDELIMITER ;\n" +
+			"CREATE PROCEDURE p()\nBEGIN\n  SELECT 1;\nEND;\nSELECT 2;\n"
+
+		statements := []string{}
+		err := ReadStatements(strings.NewReader(input), func(stmt string) error {
+			statements = append(statements, stmt)
+			return nil
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{
+			"CREATE TRIGGER trg BEFORE INSERT ON t FOR EACH ROW BEGIN\nSET NEW.x = 1;\nEND",
+			"CREATE PROCEDURE p()\nBEGIN\n  SELECT 1;\nEND",
+			"SELECT 2",
+		}, statements)
+	})
+}
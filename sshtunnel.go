@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshNetwork 是通过 SSH 隧道连接时 DSN 中使用的网络类型，例如 `user@ssh(10.0.0.5:3306)/db`
+const sshNetwork = "ssh"
+
+// SSH 隧道参数，SSHHost 为空时不使用隧道
+var (
+	SSHHost              string
+	SSHUser              string
+	SSHKey               string
+	SSHKeyPassphraseFile string
+	SSHKnownHosts        string
+	SSHKeepalive         time.Duration
+)
+
+// SSHTunnel 通过跳板机转发到 MySQL 的连接。SSH 连接在首次拨号时建立，
+// 断开或保活失败后会在下一次拨号时重新建立
+type SSHTunnel struct {
+	addr      string
+	config    *ssh.ClientConfig
+	keepalive time.Duration
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// NewSSHTunnel 读取私钥和 known_hosts 并创建隧道，不会立即连接跳板机
+func NewSSHTunnel(addr, user, keyFile, passphraseFile, knownHostsFile string, keepalive time.Duration) (*SSHTunnel, error) {
+	if len(user) == 0 {
+		return nil, errors.New("使用 SSH 隧道时需要指定 --ssh-user")
+	}
+	if len(keyFile) == 0 {
+		return nil, errors.New("使用 SSH 隧道时需要指定 --ssh-key")
+	}
+
+	signer, err := loadSSHKey(keyFile, passphraseFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(knownHostsFile) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("无法确定 known_hosts 的位置，请指定 --ssh-known-hosts: %v", err)
+		}
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取 known_hosts 失败: %v", err)
+	}
+
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+
+	return &SSHTunnel{
+		addr: addr,
+		config: &ssh.ClientConfig{
+			User:            user,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         10 * time.Second,
+		},
+		keepalive: keepalive,
+	}, nil
+}
+
+func loadSSHKey(keyFile, passphraseFile string) (ssh.Signer, error) {
+	pemBytes, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取 SSH 私钥失败: %v", err)
+	}
+
+	if len(passphraseFile) > 0 {
+		passphrase, err := os.ReadFile(passphraseFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取 SSH 私钥密码失败: %v", err)
+		}
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(strings.TrimRight(string(passphrase), "\r\n")))
+		if err != nil {
+			return nil, fmt.Errorf("解析 SSH 私钥失败: %v", err)
+		}
+		return signer, nil
+	}
+
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, errors.New("SSH 私钥已加密，请通过 --ssh-key-passphrase-file 指定密码")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析 SSH 私钥失败: %v", err)
+	}
+
+	return signer, nil
+}
+
+// DialContext 通过跳板机连接 addr。转发失败时丢弃当前的 SSH 连接并重新建立一次
+func (t *SSHTunnel) DialContext(ctx context.Context, addr string) (net.Conn, error) {
+	client, err := t.getClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := client.DialContext(ctx, "tcp", addr)
+	if err == nil {
+		return conn, nil
+	}
+	if ctx.Err() != nil {
+		return nil, err
+	}
+
+	log.Printf("通过 SSH 隧道连接 %s 失败，重新建立隧道: %v", addr, err)
+	t.reset(client)
+	if client, err = t.getClient(ctx); err != nil {
+		return nil, err
+	}
+	if conn, err = client.DialContext(ctx, "tcp", addr); err != nil {
+		return nil, fmt.Errorf("通过 SSH 隧道连接 %s 失败: %v", addr, err)
+	}
+
+	return conn, nil
+}
+
+func (t *SSHTunnel) getClient(ctx context.Context) (*ssh.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.client != nil {
+		return t.client, nil
+	}
+
+	dialer := net.Dialer{Timeout: t.config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", t.addr)
+	if err != nil {
+		return nil, fmt.Errorf("连接跳板机 %s 失败: %v", t.addr, err)
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, t.addr, t.config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SSH 握手失败: %v", err)
+	}
+	client := ssh.NewClient(c, chans, reqs)
+	t.client = client
+
+	go func() {
+		client.Wait()
+		t.reset(client)
+	}()
+	if t.keepalive > 0 {
+		go t.keepAlive(client)
+	}
+
+	return client, nil
+}
+
+// keepAlive 定期发送 keepalive@openssh.com 请求，超过一个周期没有响应时关闭连接
+func (t *SSHTunnel) keepAlive(client *ssh.Client) {
+	ticker := time.NewTicker(t.keepalive)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		done := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			done <- err
+		}()
+
+		var err error
+		select {
+		case err = <-done:
+		case <-time.After(t.keepalive):
+			err = errors.New("超时")
+		}
+		if err != nil {
+			log.Printf("SSH 隧道保活失败，关闭连接: %v", err)
+			t.reset(client)
+			return
+		}
+	}
+}
+
+// reset 关闭 client，如果它仍是当前连接则清除，下一次拨号时重新建立
+func (t *SSHTunnel) reset(client *ssh.Client) {
+	t.mu.Lock()
+	if t.client == client {
+		t.client = nil
+	}
+	t.mu.Unlock()
+
+	client.Close()
+}
+
+// Close 关闭当前的 SSH 连接
+func (t *SSHTunnel) Close() error {
+	t.mu.Lock()
+	client := t.client
+	t.client = nil
+	t.mu.Unlock()
+
+	if client == nil {
+		return nil
+	}
+
+	return client.Close()
+}
+
+// SetupSSHTunnel 按 --ssh-* 参数创建隧道并注册为驱动的 ssh 网络类型
+func SetupSSHTunnel() error {
+	if len(SSHHost) == 0 {
+		return nil
+	}
+
+	tunnel, err := NewSSHTunnel(SSHHost, SSHUser, SSHKey, SSHKeyPassphraseFile, SSHKnownHosts, SSHKeepalive)
+	if err != nil {
+		return err
+	}
+	mysql.RegisterDialContext(sshNetwork, tunnel.DialContext)
+
+	return nil
+}
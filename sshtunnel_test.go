@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testSSHServer 是只支持公钥认证和 direct-tcpip 转发的进程内 SSH 服务器
+type testSSHServer struct {
+	addr    string
+	hostKey ssh.Signer
+
+	listener net.Listener
+	mu       sync.Mutex
+	conns    []*ssh.ServerConn
+	requests []string
+}
+
+func startTestSSHServer(t *testing.T, clientKey ssh.PublicKey) *testSSHServer {
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("生成主机密钥失败: %v", err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatalf("创建主机密钥失败: %v", err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "jump" && string(key.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, io.EOF
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+
+	server := &testSSHServer{addr: listener.Addr().String(), hostKey: hostKey, listener: listener}
+	t.Cleanup(func() {
+		listener.Close()
+		server.dropConnections()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn, config)
+		}
+	}()
+
+	return server
+}
+
+func (s *testSSHServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	serverConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	s.mu.Lock()
+	s.conns = append(s.conns, serverConn)
+	s.mu.Unlock()
+
+	go func() {
+		for req := range reqs {
+			s.mu.Lock()
+			s.requests = append(s.requests, req.Type)
+			s.mu.Unlock()
+			req.Reply(req.Type == "keepalive@openssh.com", nil)
+		}
+	}()
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "direct-tcpip" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported")
+			continue
+		}
+
+		var payload struct {
+			Host       string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}
+		if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+			newChannel.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+
+		target, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.FormatUint(uint64(payload.Port), 10)))
+		if err != nil {
+			newChannel.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			target.Close()
+			continue
+		}
+		go ssh.DiscardRequests(requests)
+		go func() {
+			defer channel.Close()
+			defer target.Close()
+			go io.Copy(target, channel)
+			io.Copy(channel, target)
+		}()
+	}
+}
+
+// dropConnections 断开所有已建立的 SSH 连接，模拟网络中断
+func (s *testSSHServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testSSHServer) connectionCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.conns)
+}
+
+func (s *testSSHServer) sawRequest(requestType string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.requests {
+		if r == requestType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// startEchoServer 启动按行回显的 TCP 服务器，代表只能从跳板机访问的 MySQL
+func startEchoServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					conn.Write([]byte("echo: " + scanner.Text() + "\n"))
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// writeSSHKey 生成一个新的客户端私钥并返回文件路径
+func writeSSHKey(t *testing.T) string {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("生成客户端密钥失败: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "test")
+	if err != nil {
+		t.Fatalf("序列化客户端密钥失败: %v", err)
+	}
+
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("写入私钥失败: %v", err)
+	}
+
+	return keyFile
+}
+
+// roundTrip 通过隧道连接 addr 并确认数据被原样转发
+func roundTrip(t *testing.T, tunnel *SSHTunnel, addr string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := tunnel.DialContext(ctx, addr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ping\n"))
+	assert.NoError(t, err)
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "echo: ping\n", line)
+}
+
+func TestSSHTunnel(t *testing.T) {
+	echoAddr := startEchoServer(t)
+
+	// 先生成客户端密钥再启动服务器，最后写入服务器的主机密钥
+	dir := t.TempDir()
+	_, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(clientPriv, "test")
+	assert.NoError(t, err)
+	keyFile := filepath.Join(dir, "id_ed25519")
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600))
+	clientSigner, err := ssh.NewSignerFromKey(clientPriv)
+	assert.NoError(t, err)
+
+	server := startTestSSHServer(t, clientSigner.PublicKey())
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	assert.NoError(t, os.WriteFile(knownHostsFile, []byte(knownhosts.Line([]string{knownhosts.Normalize(server.addr)}, server.hostKey.PublicKey())+"\n"), 0600))
+
+	t.Run("forwards connections", func(t *testing.T) {
+		tunnel, err := NewSSHTunnel(server.addr, "jump", keyFile, "", knownHostsFile, 0)
+		assert.NoError(t, err)
+		defer tunnel.Close()
+
+		// 调用 DialContext
+		roundTrip(t, tunnel, echoAddr)
+		roundTrip(t, tunnel, echoAddr)
+
+		// 验证结果：两个连接复用同一条 SSH 连接
+		assert.Equal(t, 1, server.connectionCount())
+	})
+
+	t.Run("re-establishes after disconnect", func(t *testing.T) {
+		tunnel, err := NewSSHTunnel(server.addr, "jump", keyFile, "", knownHostsFile, 0)
+		assert.NoError(t, err)
+		defer tunnel.Close()
+
+		roundTrip(t, tunnel, echoAddr)
+		server.dropConnections()
+
+		// 调用 DialContext
+		roundTrip(t, tunnel, echoAddr)
+
+		// 验证结果
+		assert.Equal(t, 1, server.connectionCount())
+	})
+
+	t.Run("sends keepalive", func(t *testing.T) {
+		tunnel, err := NewSSHTunnel(server.addr, "jump", keyFile, "", knownHostsFile, 20*time.Millisecond)
+		assert.NoError(t, err)
+		defer tunnel.Close()
+
+		roundTrip(t, tunnel, echoAddr)
+
+		// 验证结果
+		assert.Eventually(t, func() bool { return server.sawRequest("keepalive@openssh.com") }, 2*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("rejects unknown host key", func(t *testing.T) {
+		other := startTestSSHServer(t, clientSigner.PublicKey())
+		otherKnownHosts := filepath.Join(t.TempDir(), "known_hosts")
+		// known_hosts 中登记的是另一台服务器的主机密钥
+		assert.NoError(t, os.WriteFile(otherKnownHosts, []byte(knownhosts.Line([]string{knownhosts.Normalize(other.addr)}, server.hostKey.PublicKey())+"\n"), 0600))
+
+		tunnel, err := NewSSHTunnel(other.addr, "jump", keyFile, "", otherKnownHosts, 0)
+		assert.NoError(t, err)
+		defer tunnel.Close()
+
+		// 调用 DialContext
+		_, err = tunnel.DialContext(context.Background(), echoAddr)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "SSH 握手失败")
+	})
+
+	t.Run("rejects unauthorized key", func(t *testing.T) {
+		otherKey := writeSSHKey(t)
+
+		tunnel, err := NewSSHTunnel(server.addr, "jump", otherKey, "", knownHostsFile, 0)
+		assert.NoError(t, err)
+		defer tunnel.Close()
+
+		// 调用 DialContext
+		_, err = tunnel.DialContext(context.Background(), echoAddr)
+
+		// 验证结果
+		assert.Error(t, err)
+	})
+
+	t.Run("encrypted key needs passphrase", func(t *testing.T) {
+		block, err := ssh.MarshalPrivateKeyWithPassphrase(clientPriv, "test", []byte("hunter2"))
+		assert.NoError(t, err)
+		encrypted := filepath.Join(dir, "id_encrypted")
+		assert.NoError(t, os.WriteFile(encrypted, pem.EncodeToMemory(block), 0600))
+
+		_, err = NewSSHTunnel(server.addr, "jump", encrypted, "", knownHostsFile, 0)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "--ssh-key-passphrase-file")
+
+		passphraseFile := filepath.Join(dir, "passphrase")
+		assert.NoError(t, os.WriteFile(passphraseFile, []byte("hunter2\n"), 0600))
+		tunnel, err := NewSSHTunnel(server.addr, "jump", encrypted, passphraseFile, knownHostsFile, 0)
+		assert.NoError(t, err)
+		defer tunnel.Close()
+
+		roundTrip(t, tunnel, echoAddr)
+	})
+}
+
+func TestBuildDSNWithSSH(t *testing.T) {
+	resetConnectionOptions(t)
+	SSHHost = "bastion.example.com"
+	defer func() { SSHHost = "" }()
+	Host = "10.0.0.5"
+
+	// 调用 BuildDSN
+	dsn, err := BuildDSN()
+
+	// 验证结果
+	assert.NoError(t, err)
+	cfg, err := mysql.ParseDSN(dsn)
+	assert.NoError(t, err)
+	assert.Equal(t, sshNetwork, cfg.Net)
+	assert.Equal(t, "10.0.0.5:3306", cfg.Addr)
+
+	Socket = "/tmp/mysql.sock"
+	_, err = BuildDSN()
+	assert.Error(t, err)
+}
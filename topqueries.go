@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// top_queries 的排序方式
+const (
+	TopQueriesByLatency      = "latency"
+	TopQueriesByRowsExamined = "rows_examined"
+	TopQueriesByExecutions   = "executions"
+)
+
+// slowLogMaxBytes 为解析慢查询日志时读取的最大字节数，超过时只读取文件末尾
+const slowLogMaxBytes = 64 << 20
+
+// SlowQueryLog 为慢查询日志文件路径，performance_schema 不可用时 top_queries 从该文件中汇总语句
+var SlowQueryLog string
+
+var topQueriesColumns = map[string]string{
+	TopQueriesByLatency:      "SUM_TIMER_WAIT",
+	TopQueriesByRowsExamined: "SUM_ROWS_EXAMINED",
+	TopQueriesByExecutions:   "COUNT_STAR",
+}
+
+// QueryDigest 是一类语句（参数替换为 ? 后相同）的执行统计
+type QueryDigest struct {
+	Schema       string
+	Digest       string
+	Text         string
+	Sample       string
+	Executions   int64
+	TotalLatency time.Duration
+	MaxLatency   time.Duration
+	RowsExamined int64
+	RowsSent     int64
+	NoIndexUsed  int64
+	LastSeen     string
+}
+
+type digestRow struct {
+	Schema       sql.NullString `db:"SCHEMA_NAME"`
+	Digest       sql.NullString `db:"DIGEST"`
+	Text         string         `db:"DIGEST_TEXT"`
+	Sample       sql.NullString `db:"QUERY_SAMPLE_TEXT"`
+	Executions   int64          `db:"COUNT_STAR"`
+	TotalLatency int64          `db:"SUM_TIMER_WAIT"`
+	MaxLatency   int64          `db:"MAX_TIMER_WAIT"`
+	RowsExamined int64          `db:"SUM_ROWS_EXAMINED"`
+	RowsSent     int64          `db:"SUM_ROWS_SENT"`
+	NoIndexUsed  int64          `db:"SUM_NO_INDEX_USED"`
+	LastSeen     sql.NullString `db:"LAST_SEEN"`
+}
+
+// HandleTopQueries 返回总耗时、检查行数或执行次数最高的语句摘要。
+// 优先读取 performance_schema.events_statements_summary_by_digest，不可用时解析慢查询日志
+func HandleTopQueries(orderBy, schema string, limit int) (string, error) {
+	if orderBy == "" {
+		orderBy = TopQueriesByLatency
+	}
+	if _, ok := topQueriesColumns[orderBy]; !ok {
+		return "", fmt.Errorf("无效的排序方式: %s，可选值为 latency、rows_examined、executions", orderBy)
+	}
+	if limit <= 0 {
+		limit = 10
+	}
+	if schema != "" {
+		if err := CheckSchemaAccess(schema); err != nil {
+			return "", err
+		}
+	}
+
+	source := "performance_schema.events_statements_summary_by_digest"
+	digests, err := DigestSummary(orderBy, schema, limit)
+	if err != nil {
+		path, pathErr := slowQueryLogPath()
+		if pathErr != nil {
+			return "", fmt.Errorf("%v；%v", err, pathErr)
+		}
+		digests, err = SlowLogSummary(path, orderBy, schema, limit)
+		if err != nil {
+			return "", err
+		}
+		source = "慢查询日志 " + path
+	}
+	if len(digests) == 0 {
+		return fmt.Sprintf("%s 中没有找到语句", source), nil
+	}
+
+	return formatTopQueries(source, orderBy, digests)
+}
+
+// DigestSummary 从 performance_schema 中读取语句摘要。MySQL 8.0 之前没有 QUERY_SAMPLE_TEXT 列，此时不返回示例语句。
+// 不允许访问的语句被过滤掉后结果不足 limit 条时继续读取下一页，直到凑够或读完
+func DigestSummary(orderBy, schema string, limit int) ([]QueryDigest, error) {
+	db, err := GetDB()
+	if err != nil {
+		return nil, err
+	}
+
+	where := "DIGEST_TEXT IS NOT NULL"
+	args := []interface{}{}
+	if schema != "" {
+		where += " AND SCHEMA_NAME = ?"
+		args = append(args, schema)
+	}
+	// 每页多读取一些，大多数情况下过滤后一页就足够
+	pageSize := limit * 4
+
+	samples := []string{"QUERY_SAMPLE_TEXT", "NULL AS QUERY_SAMPLE_TEXT"}
+	result := []QueryDigest{}
+	seen := map[string]bool{}
+	for offset := 0; ; offset += pageSize {
+		var rows []digestRow
+		for len(samples) > 0 {
+			rows = []digestRow{}
+			query := "SELECT SCHEMA_NAME, DIGEST, DIGEST_TEXT, " + samples[0] + ", COUNT_STAR, SUM_TIMER_WAIT, MAX_TIMER_WAIT, " +
+				"SUM_ROWS_EXAMINED, SUM_ROWS_SENT, SUM_NO_INDEX_USED, CAST(LAST_SEEN AS CHAR) AS LAST_SEEN " +
+				"FROM performance_schema.events_statements_summary_by_digest WHERE " + where +
+				" ORDER BY " + topQueriesColumns[orderBy] + " DESC LIMIT ? OFFSET ?"
+			if err = db.Select(&rows, query, append(args, pageSize, offset)...); err == nil || offset > 0 {
+				break
+			}
+			// 第一页确定了可用的列，之后的页不再回退
+			samples = samples[1:]
+		}
+		if err != nil {
+			return nil, fmt.Errorf("读取 performance_schema 语句摘要失败: %v", err)
+		}
+
+		for _, row := range rows {
+			// 翻页期间统计值可能变化导致排序移动，跳过已经读到的摘要
+			key := row.Schema.String + "." + row.Digest.String
+			if len(result) >= limit || seen[key] || !digestAccessible(row.Schema.String, row.Text) {
+				continue
+			}
+			seen[key] = true
+			result = append(result, digestFromRow(row))
+		}
+		if len(result) >= limit || len(rows) < pageSize {
+			return result, nil
+		}
+	}
+}
+
+func digestFromRow(row digestRow) QueryDigest {
+	return QueryDigest{
+		Schema:       row.Schema.String,
+		Digest:       row.Digest.String,
+		Text:         row.Text,
+		Sample:       row.Sample.String,
+		Executions:   row.Executions,
+		TotalLatency: picoseconds(row.TotalLatency),
+		MaxLatency:   picoseconds(row.MaxLatency),
+		RowsExamined: row.RowsExamined,
+		RowsSent:     row.RowsSent,
+		NoIndexUsed:  row.NoIndexUsed,
+		LastSeen:     row.LastSeen.String,
+	}
+}
+
+// performance_schema 的计时单位为皮秒
+func picoseconds(ps int64) time.Duration {
+	return time.Duration(ps / 1000)
+}
+
+// digestAccessible 判断语句摘要所在的库和引用的表是否允许访问，未写库名的表按摘要所在的库检查
+func digestAccessible(schema, text string) bool {
+	if schema != "" && !SchemaAccessible(schema) {
+		return false
+	}
+
+	return checkQueryAccess(text, func() (string, error) { return schema, nil }) == nil
+}
+
+// slowQueryLogPath 返回 --slow-query-log 指定的文件，未指定时使用服务器的 slow_query_log_file（需要与 MySQL 在同一台机器上）
+func slowQueryLogPath() (string, error) {
+	if SlowQueryLog != "" {
+		return SlowQueryLog, nil
+	}
+
+	db, err := GetDB()
+	if err != nil {
+		return "", err
+	}
+	var path sql.NullString
+	if err := db.Get(&path, "SELECT @@slow_query_log_file"); err != nil || !path.Valid || path.String == "" {
+		return "", fmt.Errorf("没有可用的慢查询日志，使用 --slow-query-log 指定日志文件")
+	}
+
+	return path.String, nil
+}
+
+var (
+	slowLogStats = regexp.MustCompile(`^# Query_time: ([\d.]+)\s+Lock_time: [\d.]+\s+Rows_sent: (\d+)\s+Rows_examined: (\d+)`)
+	slowLogUse   = regexp.MustCompile("(?i)^use\\s+`?([^`;\\s]+)`?;$")
+)
+
+// SlowLogSummary 解析慢查询日志，把参数替换为 ? 后相同的语句合并统计，示例语句取耗时最长的一次
+func SlowLogSummary(path, orderBy, schema string, limit int) ([]QueryDigest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("打开慢查询日志失败: %v", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("读取慢查询日志失败: %v", err)
+	}
+	if info.Size() > slowLogMaxBytes {
+		if _, err := f.Seek(info.Size()-slowLogMaxBytes, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("读取慢查询日志失败: %v", err)
+		}
+	}
+
+	digests, err := ParseSlowLog(f)
+	if err != nil {
+		return nil, fmt.Errorf("解析慢查询日志失败: %v", err)
+	}
+
+	result := []QueryDigest{}
+	for _, d := range digests {
+		if schema != "" && d.Schema != schema {
+			continue
+		}
+		if digestAccessible(d.Schema, d.Sample) {
+			result = append(result, d)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		switch orderBy {
+		case TopQueriesByRowsExamined:
+			return result[i].RowsExamined > result[j].RowsExamined
+		case TopQueriesByExecutions:
+			return result[i].Executions > result[j].Executions
+		}
+		return result[i].TotalLatency > result[j].TotalLatency
+	})
+	if len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result, nil
+}
+
+// ParseSlowLog 解析慢查询日志中的语句并按摘要合并。读取从文件中间开始时，第一条不完整的记录会被跳过
+func ParseSlowLog(r io.Reader) ([]QueryDigest, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+
+	digests := map[string]*QueryDigest{}
+	order := []string{}
+	schema, lastSeen := "", ""
+	var latency time.Duration
+	var sent, examined int64
+	inEntry := false
+	statement := []string{}
+
+	flush := func() {
+		text := strings.TrimSpace(strings.Join(statement, "\n"))
+		statement = statement[:0]
+		if !inEntry || text == "" {
+			return
+		}
+		inEntry = false
+
+		key := QueryFingerprint(text)
+		d, ok := digests[schema+"\x00"+key]
+		if !ok {
+			d = &QueryDigest{Schema: schema, Text: key}
+			digests[schema+"\x00"+key] = d
+			order = append(order, schema+"\x00"+key)
+		}
+		d.Executions++
+		d.TotalLatency += latency
+		d.RowsExamined += examined
+		d.RowsSent += sent
+		d.LastSeen = lastSeen
+		if latency >= d.MaxLatency || d.Sample == "" {
+			d.MaxLatency = latency
+			d.Sample = strings.TrimSuffix(text, ";")
+		}
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case strings.HasPrefix(line, "# Time:"):
+			flush()
+			lastSeen = strings.TrimSpace(strings.TrimPrefix(line, "# Time:"))
+		case strings.HasPrefix(line, "# User@Host:"):
+			flush()
+		case strings.HasPrefix(line, "# Query_time:"):
+			flush()
+			m := slowLogStats.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			seconds, _ := strconv.ParseFloat(m[1], 64)
+			latency = time.Duration(seconds * float64(time.Second))
+			sent, _ = strconv.ParseInt(m[2], 10, 64)
+			examined, _ = strconv.ParseInt(m[3], 10, 64)
+			inEntry = true
+		case strings.HasPrefix(line, "#"):
+			// 其他注释行，例如 # administrator command 或 Percona 的扩展统计
+		case !inEntry:
+			// 日志文件头（mysqld 启动信息）或不完整的记录
+		case strings.HasPrefix(line, "SET timestamp="):
+		case slowLogUse.MatchString(line):
+			schema = slowLogUse.FindStringSubmatch(line)[1]
+		default:
+			statement = append(statement, line)
+		}
+	}
+	flush()
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]QueryDigest, 0, len(order))
+	for _, key := range order {
+		result = append(result, *digests[key])
+	}
+
+	return result, nil
+}
+
+// QueryFingerprint 把语句中的字符串和数字替换为 ?，IN 列表合并为 (...)，得到与 performance_schema 摘要类似的文本
+func QueryFingerprint(query string) string {
+	tokens := TokenizeSQL(query)
+	parts := make([]string, 0, len(tokens))
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch {
+		case tok.Kind == TokenString || tok.Kind == TokenNumber:
+			parts = append(parts, "?")
+		case tok.IsKeyword("IN") && i+1 < len(tokens) && tokens[i+1].Is(TokenPunct, "("):
+			end := matchParen(tokens, i+1)
+			if end > 0 && literalList(tokens[i+2:end]) {
+				parts = append(parts, "IN", "(...)")
+				i = end
+				continue
+			}
+			parts = append(parts, "IN")
+		case tok.Kind == TokenWord && reservedWords[strings.ToUpper(tok.Text)]:
+			parts = append(parts, strings.ToUpper(tok.Text))
+		default:
+			parts = append(parts, tok.Text)
+		}
+	}
+
+	return strings.TrimSuffix(strings.Join(parts, " "), " ;")
+}
+
+// literalList 判断 tokens 是否为逗号分隔的字面量列表
+func literalList(tokens []SQLToken) bool {
+	if len(tokens) == 0 {
+		return false
+	}
+	for i, tok := range tokens {
+		if i%2 == 1 {
+			if !tok.Is(TokenPunct, ",") {
+				return false
+			}
+			continue
+		}
+		if tok.Kind != TokenString && tok.Kind != TokenNumber && tok.Kind != TokenPlaceholder {
+			return false
+		}
+	}
+
+	return len(tokens)%2 == 1
+}
+
+func formatTopQueries(source, orderBy string, digests []QueryDigest) (string, error) {
+	rows := []map[string]interface{}{}
+	for i, d := range digests {
+		// 示例语句中的字面量可能是脱敏列的取值，配置了脱敏策略时只显示指纹
+		sample := d.Sample
+		if Masking != nil && sample != "" {
+			sample = QueryFingerprint(sample)
+		}
+		avg, avgRows := time.Duration(0), int64(0)
+		if d.Executions > 0 {
+			avg = d.TotalLatency / time.Duration(d.Executions)
+			avgRows = d.RowsExamined / d.Executions
+		}
+		rows = append(rows, map[string]interface{}{
+			"rank":              i + 1,
+			"schema":            d.Schema,
+			"executions":        d.Executions,
+			"total_latency":     d.TotalLatency.Round(time.Microsecond).String(),
+			"avg_latency":       avg.Round(time.Microsecond).String(),
+			"max_latency":       d.MaxLatency.Round(time.Microsecond).String(),
+			"rows_examined":     d.RowsExamined,
+			"rows_examined_avg": avgRows,
+			"rows_sent":         d.RowsSent,
+			"no_index_used":     d.NoIndexUsed,
+			"last_seen":         d.LastSeen,
+			"digest":            d.Digest,
+			"query":             processQuery(d.Text, defaultProcessQueryLength),
+			"sample":            processQuery(sample, defaultProcessQueryLength),
+		})
+	}
+
+	table, err := MapToCSV(rows, []string{"rank", "schema", "executions", "total_latency", "avg_latency", "max_latency",
+		"rows_examined", "rows_examined_avg", "rows_sent", "no_index_used", "last_seen", "digest", "query", "sample"})
+	if err != nil {
+		return "", err
+	}
+
+	next := "\n下一步: 把 sample 列的语句传给 suggest_indexes 的 query 参数，会执行 EXPLAIN 并给出索引建议；" +
+		"没有 sample 时先把 query 列摘要中的 ? 替换为实际的值\n"
+	if Masking != nil {
+		next = "\n已配置脱敏策略，sample 列中的字面量已替换为 ?。下一步: 把其中的 ? 替换为实际的值后传给 suggest_indexes 的 query 参数\n"
+	}
+
+	return fmt.Sprintf("来源: %s，按 %s 排序\n", source, orderBy) + table + next, nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var digestColumns = []string{"SCHEMA_NAME", "DIGEST", "DIGEST_TEXT", "QUERY_SAMPLE_TEXT", "COUNT_STAR", "SUM_TIMER_WAIT", "MAX_TIMER_WAIT",
+	"SUM_ROWS_EXAMINED", "SUM_ROWS_SENT", "SUM_NO_INDEX_USED", "LAST_SEEN"}
+
+const slowLog = `/usr/sbin/mysqld, Version: 8.0.36 (MySQL Community Server - GPL). started with:
+Tcp port: 3306  Unix socket: /var/run/mysqld/mysqld.sock
+Time                 Id Command    Argument
+# Time: 2024-01-15T10:23:45.123456Z
+# User@Host: app[app] @  [10.0.0.3]  Id:    12
+# Query_time: 2.500000  Lock_time: 0.000045 Rows_sent: 1  Rows_examined: 100000
+use shop;
+SET timestamp=1705314225;
+SELECT * FROM orders WHERE note LIKE '%gift%';
+# Time: 2024-01-15T10:24:00.000000Z
+# User@Host: app[app] @  [10.0.0.3]  Id:    12
+# Query_time: 1.000000  Lock_time: 0.000010 Rows_sent: 3  Rows_examined: 90000
+SET timestamp=1705314240;
+SELECT * FROM orders
+  WHERE note LIKE '%rush%';
+# Time: 2024-01-15T10:25:00.000000Z
+# User@Host: app[app] @  [10.0.0.3]  Id:    15
+# Query_time: 0.500000  Lock_time: 0.000010 Rows_sent: 2  Rows_examined: 400000
+SET timestamp=1705314300;
+SELECT id FROM customers WHERE id IN (1, 2, 3);
+# Time: 2024-01-15T10:26:00.000000Z
+# User@Host: app[app] @  [10.0.0.3]  Id:    15
+# Query_time: 0.700000  Lock_time: 0.000010 Rows_sent: 2  Rows_examined: 10
+SET timestamp=1705314360;
+SELECT id FROM customers WHERE id IN (4, 5);
+`
+
+func TestQueryFingerprint(t *testing.T) {
+	for query, expected := range map[string]string{
+		"SELECT * FROM orders WHERE note LIKE '%gift%';":      "SELECT * FROM orders WHERE note LIKE ?",
+		"select id from customers where id in (1, 2, 3)":      "SELECT id FROM customers WHERE id IN (...)",
+		"SELECT id FROM t WHERE id IN (SELECT id FROM u)":     "SELECT id FROM t WHERE id IN ( SELECT id FROM u )",
+		"UPDATE accounts SET balance = 10.5 WHERE id = 42":    "UPDATE accounts SET balance = ? WHERE id = ?",
+		"SELECT `note` FROM `orders` WHERE `id` = \"a\" \n ;": "SELECT `note` FROM `orders` WHERE `id` = ?",
+	} {
+		assert.Equal(t, expected, QueryFingerprint(query), query)
+	}
+}
+
+func TestParseSlowLog(t *testing.T) {
+	// 调用 ParseSlowLog
+	digests, err := ParseSlowLog(strings.NewReader(slowLog))
+
+	// 验证结果
+	assert.NoError(t, err)
+	if assert.Len(t, digests, 2) {
+		assert.Equal(t, QueryDigest{
+			Schema:       "shop",
+			Text:         "SELECT * FROM orders WHERE note LIKE ?",
+			Sample:       "SELECT * FROM orders WHERE note LIKE '%gift%'",
+			Executions:   2,
+			TotalLatency: 3500 * time.Millisecond,
+			MaxLatency:   2500 * time.Millisecond,
+			RowsExamined: 190000,
+			RowsSent:     4,
+			LastSeen:     "2024-01-15T10:24:00.000000Z",
+		}, digests[0])
+		assert.Equal(t, "SELECT id FROM customers WHERE id IN (...)", digests[1].Text)
+		assert.Equal(t, "SELECT id FROM customers WHERE id IN (4, 5)", digests[1].Sample)
+		assert.Equal(t, int64(2), digests[1].Executions)
+	}
+
+	// 从文件中间开始读取时跳过不完整的记录
+	digests, err = ParseSlowLog(strings.NewReader("WHERE id = 1;\n" + slowLog[strings.Index(slowLog, "# Time: 2024-01-15T10:25"):]))
+	assert.NoError(t, err)
+	assert.Len(t, digests, 1)
+}
+
+func TestHandleTopQueries(t *testing.T) {
+	_, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	t.Run("performance_schema", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("SELECT SCHEMA_NAME, DIGEST, DIGEST_TEXT, QUERY_SAMPLE_TEXT, .* FROM performance_schema.events_statements_summary_by_digest "+
+			"WHERE DIGEST_TEXT IS NOT NULL ORDER BY SUM_TIMER_WAIT DESC LIMIT \\? OFFSET \\?").WithArgs(8, 0).
+			WillReturnRows(sqlmock.NewRows(digestColumns).
+				AddRow("shop", "3f2a", "SELECT * FROM `orders` WHERE `note` LIKE ?", "SELECT * FROM orders WHERE note LIKE '%gift%'",
+					40, int64(12_000_000_000_000), int64(900_000_000_000), 4_000_000, 120, 40, "2024-01-15 10:23:45.123456").
+				AddRow("shop", "9bc1", "SELECT `id` FROM `customers` WHERE `id` = ?", nil,
+					1000, int64(500_000_000_000), int64(2_000_000_000), 1000, 1000, 0, "2024-01-15 10:24:00.000000"))
+
+		// 调用 HandleTopQueries
+		result, err := HandleTopQueries("", "", 2)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(result, "来源: performance_schema.events_statements_summary_by_digest，按 latency 排序\n"+
+			"rank,schema,executions,total_latency,avg_latency,max_latency,rows_examined,rows_examined_avg,rows_sent,no_index_used,last_seen,digest,query,sample\n"+
+			"1,shop,40,12s,300ms,900ms,4000000,100000,120,40,2024-01-15 10:23:45.123456,3f2a,SELECT * FROM `orders` WHERE `note` LIKE ?,SELECT * FROM orders WHERE note LIKE '%gift%'\n"+
+			"2,shop,1000,500ms,500µs,2ms,1000,1,1000,0,2024-01-15 10:24:00.000000,9bc1,SELECT `id` FROM `customers` WHERE `id` = ?,\n"), result)
+		assert.Contains(t, result, "suggest_indexes")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("without QUERY_SAMPLE_TEXT", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("QUERY_SAMPLE_TEXT, COUNT_STAR").WillReturnError(fmt.Errorf("Unknown column 'QUERY_SAMPLE_TEXT' in 'field list'"))
+		mock.ExpectQuery("NULL AS QUERY_SAMPLE_TEXT.* WHERE DIGEST_TEXT IS NOT NULL AND SCHEMA_NAME = \\? ORDER BY COUNT_STAR DESC").
+			WithArgs("shop", 40, 0).
+			WillReturnRows(sqlmock.NewRows(digestColumns).
+				AddRow("shop", "9bc1", "SELECT `id` FROM `customers` WHERE `id` = ?", nil, 1000, 0, 0, 1000, 1000, 0, nil))
+
+		// 调用 HandleTopQueries
+		result, err := HandleTopQueries(TopQueriesByExecutions, "shop", 10)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "按 executions 排序")
+		assert.Contains(t, result, "1,shop,1000,")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("falls back to slow query log", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "slow.log")
+		assert.NoError(t, os.WriteFile(path, []byte(slowLog), 0o600))
+		SlowQueryLog = path
+		defer func() { SlowQueryLog = "" }()
+
+		// 设置模拟预期
+		mock.ExpectQuery("FROM performance_schema.events_statements_summary_by_digest").WillReturnError(fmt.Errorf("SELECT command denied"))
+		mock.ExpectQuery("FROM performance_schema.events_statements_summary_by_digest").WillReturnError(fmt.Errorf("SELECT command denied"))
+
+		// 调用 HandleTopQueries
+		result, err := HandleTopQueries(TopQueriesByRowsExamined, "", 10)
+
+		// 验证结果
+		assert.NoError(t, err)
+		lines := strings.Split(result, "\n")
+		assert.Equal(t, "来源: 慢查询日志 "+path+"，按 rows_examined 排序", lines[0])
+		assert.True(t, strings.HasPrefix(lines[2], "1,shop,2,1.2s,600ms,700ms,400010,200005,4,0,"), lines[2])
+		assert.True(t, strings.HasPrefix(lines[3], "2,shop,2,3.5s,1.75s,2.5s,190000,95000,4,0,"), lines[3])
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("no slow query log", func(t *testing.T) {
+		// 设置模拟预期
+		mock.ExpectQuery("FROM performance_schema.events_statements_summary_by_digest").WillReturnError(fmt.Errorf("SELECT command denied"))
+		mock.ExpectQuery("FROM performance_schema.events_statements_summary_by_digest").WillReturnError(fmt.Errorf("SELECT command denied"))
+		mock.ExpectQuery("SELECT @@slow_query_log_file").WillReturnRows(sqlmock.NewRows([]string{"@@slow_query_log_file"}).AddRow(nil))
+
+		// 调用 HandleTopQueries
+		_, err := HandleTopQueries("", "", 10)
+
+		// 验证结果
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "--slow-query-log")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("hides denied databases", func(t *testing.T) {
+		setupAccess(t, nil, []string{"secret.*"})
+		// 设置模拟预期
+		mock.ExpectQuery("FROM performance_schema.events_statements_summary_by_digest").
+			WillReturnRows(sqlmock.NewRows(digestColumns).
+				AddRow("secret", "1", "SELECT * FROM `keys`", nil, 1, 0, 0, 0, 0, 0, nil).
+				AddRow("shop", "2", "SELECT * FROM `secret` . `keys`", nil, 1, 0, 0, 0, 0, 0, nil))
+
+		// 调用 HandleTopQueries
+		result, err := HandleTopQueries("", "", 10)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Equal(t, "performance_schema.events_statements_summary_by_digest 中没有找到语句", result)
+
+		_, err = HandleTopQueries("", "secret", 10)
+		assert.Error(t, err)
+	})
+
+	t.Run("reads more pages after filtering", func(t *testing.T) {
+		setupAccess(t, nil, []string{"secret.*"})
+		denied := sqlmock.NewRows(digestColumns)
+		for i := 0; i < 4; i++ {
+			denied.AddRow("secret", fmt.Sprint(i), "SELECT * FROM `keys`", nil, 1, 0, 0, 0, 0, 0, nil)
+		}
+		// 设置模拟预期
+		mock.ExpectQuery("FROM performance_schema.events_statements_summary_by_digest").WithArgs(4, 0).WillReturnRows(denied)
+		mock.ExpectQuery("FROM performance_schema.events_statements_summary_by_digest").WithArgs(4, 4).
+			WillReturnRows(sqlmock.NewRows(digestColumns).
+				AddRow("secret", "4", "SELECT * FROM `keys`", nil, 1, 0, 0, 0, 0, 0, nil).
+				AddRow("shop", "5", "SELECT * FROM `orders`", nil, 1, 0, 0, 0, 0, 0, nil))
+
+		// 调用 HandleTopQueries
+		result, err := HandleTopQueries("", "", 1)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, "1,shop,1,")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("masks samples", func(t *testing.T) {
+		setupMasking(t, `{"rules": [{"column": "*email*", "strategy": "partial"}]}`)
+		// 设置模拟预期
+		mock.ExpectQuery("FROM performance_schema.events_statements_summary_by_digest").
+			WillReturnRows(sqlmock.NewRows(digestColumns).
+				AddRow("shop", "7", "SELECT * FROM `users` WHERE `email` = ?", "SELECT * FROM users WHERE email = 'ann@example.com'", 1, 0, 0, 0, 0, 0, nil))
+
+		// 调用 HandleTopQueries
+		result, err := HandleTopQueries("", "", 10)
+
+		// 验证结果
+		assert.NoError(t, err)
+		assert.Contains(t, result, ",SELECT * FROM users WHERE email = ?\n")
+		assert.NotContains(t, result, "ann@")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("invalid order", func(t *testing.T) {
+		// 调用 HandleTopQueries
+		_, err := HandleTopQueries("cpu", "", 10)
+
+		// 验证结果
+		assert.Error(t, err)
+	})
+}